@@ -0,0 +1,324 @@
+// Package aof implements the append only file, which persists a dataset as
+// the log of the commands that built it.
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"redisGo/resp"
+)
+
+type Aof struct {
+	path string
+	file *os.File
+	rd   *bufio.Reader
+	mu   sync.Mutex
+
+	// latency, if set, is told how long each write and fsync took.
+	latency func(event string, duration time.Duration)
+
+	lastWriteErr error
+	stats        Stats
+
+	// stop is closed by Close to end the fsync goroutine.
+	stop   chan struct{}
+	closed bool
+}
+
+// Stats count the fsyncs and rewrites of the file.
+type Stats struct {
+	// Fsyncs is the number of fsyncs, which took FsyncTime in all.
+	Fsyncs    int64
+	FsyncTime time.Duration
+
+	// Rewrites is the number of rewrites, which took RewriteTime in all
+	// and LastRewrite for the last one. RewriteError is the error the last
+	// one failed with, if any.
+	Rewrites     int64
+	RewriteTime  time.Duration
+	LastRewrite  time.Duration
+	RewriteError error
+}
+
+// ErrCorrupt is returned by Read for a file holding anything but commands.
+var ErrCorrupt = errors.New("aof: bad file format")
+
+// openFile opens the file at path for reading, and for writes that always
+// go to its end.
+func openFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+}
+
+// New opens the file at path, creating it if needed. latency, which may be
+// nil, is called with the duration of every write and fsync.
+func New(path string, latency func(event string, duration time.Duration)) (*Aof, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	aof := &Aof{
+		path:    path,
+		file:    f,
+		rd:      bufio.NewReader(f),
+		latency: latency,
+		stop:    make(chan struct{}),
+	}
+
+	// start go routine to sync aof to disk every 1 second, until Close
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-aof.stop:
+				return
+			}
+
+			aof.mu.Lock()
+			if !aof.closed {
+				aof.sync()
+			}
+			aof.mu.Unlock()
+		}
+	}()
+
+	return aof, nil
+}
+
+// Close stops the fsyncs and closes the file. Closing it again fails.
+func (aof *Aof) Close() error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	if aof.closed {
+		return os.ErrClosed
+	}
+	aof.closed = true
+	close(aof.stop)
+
+	return aof.file.Close()
+}
+
+// Sync flushes the file to disk.
+func (aof *Aof) Sync() error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	return aof.sync()
+}
+
+func (aof *Aof) sync() error {
+	start := time.Now()
+	err := aof.file.Sync()
+	duration := time.Since(start)
+	aof.observe("aof-fsync", duration)
+
+	aof.stats.Fsyncs++
+	aof.stats.FsyncTime += duration
+	return err
+}
+
+// Rewrite replaces the file with the shortest list of commands rebuilding
+// the current dataset, which dataset writes. The new file is written aside
+// and renamed over the old one, so a failure leaves the old file in place.
+// The caller must keep the dataset from changing meanwhile.
+func (aof *Aof) Rewrite(dataset func(w io.Writer)) (err error) {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	start := time.Now()
+	defer func() {
+		aof.stats.Rewrites++
+		aof.stats.LastRewrite = time.Since(start)
+		aof.stats.RewriteTime += aof.stats.LastRewrite
+		aof.stats.RewriteError = err
+	}()
+
+	tmp := aof.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	dataset(w)
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	// The new handle is opened before the rename, which it survives, so
+	// that failing to open it leaves the old file in use.
+	var live *os.File
+	if err == nil {
+		live, err = openFile(tmp)
+	}
+	if err == nil {
+		if err = os.Rename(tmp, aof.path); err != nil {
+			live.Close()
+		}
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(aof.path)
+
+	aof.file.Close()
+	aof.file = live
+	aof.rd = bufio.NewReader(live)
+
+	return nil
+}
+
+// syncDir makes the rename of the file at path durable.
+func syncDir(path string) {
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return
+	}
+	dir.Sync()
+	dir.Close()
+}
+
+func (aof *Aof) observe(event string, duration time.Duration) {
+	if aof.latency != nil {
+		aof.latency(event, duration)
+	}
+}
+
+func (aof *Aof) Write(value resp.Value) error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	start := time.Now()
+	_, err := aof.file.Write(value.Marshal())
+	aof.observe("aof-write", time.Since(start))
+
+	aof.lastWriteErr = err
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// WriteStatus reports whether the last write to the file succeeded.
+func (aof *Aof) WriteStatus() string {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	if aof.lastWriteErr != nil {
+		return "err"
+	}
+
+	return "ok"
+}
+
+// Stats returns the counters of the fsyncs and rewrites so far.
+func (aof *Aof) Stats() Stats {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	return aof.stats
+}
+
+// Size returns the current size of the append only file in bytes.
+func (aof *Aof) Size() int64 {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	info, err := aof.file.Stat()
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
+
+// Read calls fn with each command in the file, an array of one or more
+// bulk strings. A command cut short at the end of the file, as a crash
+// while writing leaves it, is dropped by truncating the file there; any
+// other content fails with ErrCorrupt. fn is not called for commands after
+// an error it returns, which Read returns.
+func (aof *Aof) Read(fn func(value resp.Value) error) error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	info, err := aof.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	if _, err := aof.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader := resp.NewResp(aof.file)
+
+	// end is the offset past the last whole command. The commands were
+	// written with Marshal, so that is their size.
+	var end int64
+	for {
+		value, err := reader.Read()
+		if errors.Is(err, io.EOF) && value.Type == "" {
+			break
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return aof.truncate(end)
+		}
+		if err != nil {
+			return fmt.Errorf("%w at offset %d: %v", ErrCorrupt, end, err)
+		}
+		if !isCommand(value) {
+			return fmt.Errorf("%w at offset %d", ErrCorrupt, end)
+		}
+
+		next := end + int64(len(value.Marshal()))
+		if next > size {
+			// The final CRLF is missing.
+			return aof.truncate(end)
+		}
+		end = next
+
+		if err := fn(value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// truncate drops whatever follows offset, the end of the last whole
+// command in the file.
+func (aof *Aof) truncate(offset int64) error {
+	fmt.Printf("The AOF ends with a partial command, truncating it to %d bytes.\n", offset)
+	return aof.file.Truncate(offset)
+}
+
+// isCommand reports whether value is a command: a non-empty array of bulk
+// strings.
+func isCommand(value resp.Value) bool {
+	if value.Type != "array" || len(value.Array) == 0 {
+		return false
+	}
+	for _, arg := range value.Array {
+		if arg.Type != "bulk" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,303 @@
+// Package client talks to redisGo, or any Redis server, over RESP2 using
+// the Value codec of package resp. A Client keeps a pool of connections,
+// retries commands on fresh ones with a backoff when a connection fails,
+// and supports pipelines, transactions and Pub/Sub.
+package client
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"redisGo/resp"
+)
+
+// Options configure a Client. The zero value of each field selects its
+// default.
+type Options struct {
+	// Network is "tcp", the default, or "unix" to reach a server on the
+	// same host through its Unix domain socket.
+	Network string
+
+	// Addr is the host:port of the server, localhost:6379 by default, or
+	// the path of its socket.
+	Addr string
+
+	// PoolSize is the most connections open at once, 10 by default.
+	// Commands wait for a free connection beyond that.
+	PoolSize int
+
+	// DialTimeout bounds connecting to the server and waiting for it to
+	// confirm a subscription, 5 seconds by default.
+	DialTimeout time.Duration
+
+	// ReadTimeout bounds waiting for the replies to commands, 3 seconds by
+	// default, or no bound if -1. A timeout is a network error, so the
+	// command is retried; commands that block on the server, such as BLPOP,
+	// need a longer timeout than they block for.
+	ReadTimeout time.Duration
+
+	// MaxRetries is how many times a command is retried on a new
+	// connection after a network error, 3 by default, or none if -1. A
+	// retried write may run twice if the first reply was lost.
+	MaxRetries int
+
+	// The delay before the nth retry, or reconnect of a PubSub, doubles
+	// from MinRetryBackoff, 8ms by default, up to MaxRetryBackoff, 512ms
+	// by default.
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+}
+
+func (o *Options) init() {
+	if o.Network == "" {
+		o.Network = "tcp"
+	}
+	if o.Addr == "" {
+		o.Addr = "localhost:6379"
+	}
+	if o.PoolSize == 0 {
+		o.PoolSize = 10
+	}
+	if o.DialTimeout == 0 {
+		o.DialTimeout = 5 * time.Second
+	}
+	if o.ReadTimeout == 0 {
+		o.ReadTimeout = 3 * time.Second
+	} else if o.ReadTimeout < 0 {
+		o.ReadTimeout = 0
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = 3
+	} else if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.MinRetryBackoff == 0 {
+		o.MinRetryBackoff = 8 * time.Millisecond
+	}
+	if o.MaxRetryBackoff == 0 {
+		o.MaxRetryBackoff = 512 * time.Millisecond
+	}
+}
+
+// backoff returns the delay before the given attempt, counting from 1.
+func (o *Options) backoff(attempt int) time.Duration {
+	d := o.MinRetryBackoff << (attempt - 1)
+	if d > o.MaxRetryBackoff || d <= 0 {
+		d = o.MaxRetryBackoff
+	}
+	return d
+}
+
+// Error is an error reply from the server, such as
+// "WRONGTYPE Operation against a key holding the wrong kind of value".
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// ErrNil is returned by the typed commands when the key or field is
+// missing.
+var ErrNil = errors.New("redisGo: nil")
+
+// ErrClosed is returned once the client or the PubSub is closed.
+var ErrClosed = errors.New("redisGo: client is closed")
+
+// Client is a pool of connections to a server. It is safe for concurrent
+// use.
+type Client struct {
+	opts Options
+
+	// slots holds a token for each connection handed out, so that no more
+	// than PoolSize are open at once.
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// New returns a client for the server at opts.Addr. Connections are made
+// on demand.
+func New(opts Options) *Client {
+	opts.init()
+
+	return &Client{
+		opts:  opts,
+		slots: make(chan struct{}, opts.PoolSize),
+	}
+}
+
+// Close closes the idle connections, and the others as they are returned.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+	for _, cn := range c.idle {
+		cn.close()
+	}
+	c.idle = nil
+
+	return nil
+}
+
+// conn is a connection to the server.
+type conn struct {
+	netConn net.Conn
+	reader  *resp.Resp
+	writer  *resp.Writer
+
+	// readTimeout, unless 0, bounds waiting for the replies of roundTrip.
+	readTimeout time.Duration
+}
+
+func (c *Client) dial() (*conn, error) {
+	netConn, err := net.DialTimeout(c.opts.Network, c.opts.Addr, c.opts.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{
+		netConn:     netConn,
+		reader:      resp.NewResp(netConn),
+		writer:      resp.NewWriter(netConn),
+		readTimeout: c.opts.ReadTimeout,
+	}, nil
+}
+
+func (cn *conn) close() error {
+	return cn.netConn.Close()
+}
+
+// roundTrip sends commands in a single write and reads their replies.
+func (cn *conn) roundTrip(commands []resp.Value) ([]resp.Value, error) {
+	for _, command := range commands {
+		if err := cn.writer.Write(command); err != nil {
+			return nil, err
+		}
+	}
+	if err := cn.writer.Flush(); err != nil {
+		return nil, err
+	}
+	if cn.readTimeout > 0 {
+		cn.netConn.SetReadDeadline(time.Now().Add(cn.readTimeout))
+	}
+
+	replies := make([]resp.Value, 0, len(commands))
+	for range commands {
+		reply, err := cn.reader.Read()
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+
+	return replies, nil
+}
+
+// get takes a connection from the pool, dialing one if none is idle.
+func (c *Client) get() (*conn, error) {
+	c.slots <- struct{}{}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		<-c.slots
+		return nil, ErrClosed
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	cn, err := c.dial()
+	if err != nil {
+		<-c.slots
+		return nil, err
+	}
+
+	return cn, nil
+}
+
+// put returns a connection to the pool, closing it instead if it failed
+// with err, which leaves it in an unknown state.
+func (c *Client) put(cn *conn, err error) {
+	c.mu.Lock()
+	if err != nil || c.closed {
+		cn.close()
+	} else {
+		c.idle = append(c.idle, cn)
+	}
+	c.mu.Unlock()
+
+	<-c.slots
+}
+
+// process sends commands on a pooled connection and returns their
+// replies, retrying on a new connection after a network error.
+func (c *Client) process(commands []resp.Value) ([]resp.Value, error) {
+	var lastErr error
+	for attempt := 0; attempt <= c.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(c.opts.backoff(attempt))
+		}
+
+		cn, err := c.get()
+		if errors.Is(err, ErrClosed) {
+			return nil, err
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		replies, err := cn.roundTrip(commands)
+		c.put(cn, err)
+		if err == nil {
+			return replies, nil
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// command encodes a command as an array of bulk strings.
+func command(args ...string) resp.Value {
+	v := resp.Value{Type: "array"}
+	for _, arg := range args {
+		v.Array = append(v.Array, resp.Value{Type: "bulk", Bulk: arg})
+	}
+	return v
+}
+
+// replyError returns the error held by an error reply, or nil.
+func replyError(v resp.Value) error {
+	if v.Type == "error" {
+		return Error(v.Str)
+	}
+	return nil
+}
+
+// Do runs a command and returns its reply. Error replies are returned as
+// an Error.
+func (c *Client) Do(args ...string) (resp.Value, error) {
+	replies, err := c.process([]resp.Value{command(args...)})
+	if err != nil {
+		return resp.Value{}, err
+	}
+	if err := replyError(replies[0]); err != nil {
+		return resp.Value{}, err
+	}
+
+	return replies[0], nil
+}
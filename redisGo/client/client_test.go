@@ -0,0 +1,294 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"redisGo/server"
+)
+
+var srv *server.Server
+var addr string
+
+// TestMain serves an in-process server for the tests to talk to.
+func TestMain(m *testing.M) {
+	var err error
+	srv, err = server.New(server.Options{})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	addr = l.Addr().String()
+	go srv.Serve(l)
+
+	os.Exit(m.Run())
+}
+
+func newTestClient(t *testing.T) *Client {
+	c := New(Options{Addr: addr, PoolSize: 4})
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestCommands(t *testing.T) {
+	c := newTestClient(t)
+	c.Del("client:string", "client:hash", "client:list")
+
+	if _, err := c.Get("client:string"); err != ErrNil {
+		t.Errorf("Expected ErrNil for a missing key, got %v", err)
+	}
+	if err := c.Set("client:string", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := c.Get("client:string"); err != nil || value != "v" {
+		t.Errorf("Expected v, got %q, %v", value, err)
+	}
+
+	c.HSet("client:hash", "f", "1")
+	if hash, _ := c.HGetAll("client:hash"); !reflect.DeepEqual(hash, map[string]string{"f": "1"}) {
+		t.Errorf("Expected map[f:1], got %v", hash)
+	}
+
+	c.RPush("client:list", "a", "b")
+	if list, _ := c.LRange("client:list", 0, -1); !reflect.DeepEqual(list, []string{"a", "b"}) {
+		t.Errorf("Expected [a b], got %v", list)
+	}
+
+	var replyErr Error
+	if _, err := c.LRange("client:string", 0, -1); !errors.As(err, &replyErr) {
+		t.Errorf("Expected an error reply, got %v", err)
+	}
+}
+
+func TestPool(t *testing.T) {
+	c := newTestClient(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("client:pool:%d", i)
+			c.Set(key, key)
+			if value, err := c.Get(key); err != nil || value != key {
+				t.Errorf("Expected %s, got %q, %v", key, value, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(c.idle) > 4 {
+		t.Errorf("Expected at most 4 connections, got %d", len(c.idle))
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	c := newTestClient(t)
+
+	p := c.Pipeline()
+	for i := 0; i < 100; i++ {
+		p.Do("SET", fmt.Sprintf("client:pipeline:%d", i), "v")
+	}
+	p.Do("GET", "client:pipeline:99")
+	replies, err := p.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 101 || replies[100].Bulk != "v" {
+		t.Errorf("Expected 101 replies ending with v, got %d", len(replies))
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	c := newTestClient(t)
+	c.Set("client:tx", "1")
+
+	p := c.TxPipeline()
+	p.Do("SET", "client:tx", "2")
+	p.Do("GET", "client:tx")
+	replies, err := p.Exec()
+	if err != nil || len(replies) != 2 || replies[1].Bulk != "2" {
+		t.Errorf("Expected [OK 2], got %v, %v", replies, err)
+	}
+
+	// A write from another client between the read and the transaction
+	// fails it.
+	err = c.Watch(func(tx *Tx) error {
+		if _, err := tx.Do("GET", "client:tx"); err != nil {
+			return err
+		}
+		c.Set("client:tx", "other")
+
+		p := tx.TxPipeline()
+		p.Do("SET", "client:tx", "3")
+		_, err := p.Exec()
+		return err
+	}, "client:tx")
+	if err != ErrTxFailed {
+		t.Errorf("Expected ErrTxFailed, got %v", err)
+	}
+	if value, _ := c.Get("client:tx"); value != "other" {
+		t.Errorf("Expected other, got %q", value)
+	}
+}
+
+func TestWatchPoolSize1(t *testing.T) {
+	c := New(Options{Addr: addr, PoolSize: 1})
+	defer c.Close()
+	c.Set("client:watch", "1")
+
+	// fn runs its commands on the connection of tx; a command on the client
+	// waits for that connection until Watch returns.
+	got := make(chan string, 1)
+	err := c.Watch(func(tx *Tx) error {
+		go func() {
+			value, _ := c.Get("client:watch")
+			got <- value
+		}()
+
+		reply, err := tx.Do("GET", "client:watch")
+		if err != nil {
+			return err
+		}
+		p := tx.TxPipeline()
+		p.Do("SET", "client:watch", reply.Bulk+"2")
+		if _, err := p.Exec(); err != nil {
+			return err
+		}
+
+		select {
+		case value := <-got:
+			t.Errorf("Expected the client to wait for the connection, got %q", value)
+		case <-time.After(50 * time.Millisecond):
+		}
+		return nil
+	}, "client:watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case value := <-got:
+		if value != "12" {
+			t.Errorf("Expected 12, got %q", value)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the client once Watch returned")
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	// A server that accepts connections and never replies.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	c := New(Options{Addr: l.Addr().String(), ReadTimeout: 50 * time.Millisecond, MaxRetries: -1})
+	defer c.Close()
+
+	start := time.Now()
+	_, err = c.Do("PING")
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the read to time out after 50ms, took %v", elapsed)
+	}
+}
+
+func receive(t *testing.T, ps *PubSub) *Message {
+	t.Helper()
+
+	select {
+	case m := <-ps.Channel():
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a message")
+		return nil
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	c := newTestClient(t)
+
+	ps, err := c.Subscribe("client:channel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+	if err := ps.PSubscribe("client:p*"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := c.Publish("client:channel", "hello"); n != 1 {
+		t.Errorf("Expected 1 receiver, got %d", n)
+	}
+	if m := receive(t, ps); m.Channel != "client:channel" || m.Payload != "hello" {
+		t.Errorf("Expected hello on client:channel, got %+v", m)
+	}
+
+	c.Publish("client:pattern", "matched")
+	if m := receive(t, ps); m.Pattern != "client:p*" || m.Payload != "matched" {
+		t.Errorf("Expected a message matching client:p*, got %+v", m)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	c := newTestClient(t)
+	c.Set("client:reconnect", "v")
+
+	ps, err := c.Subscribe("client:reconnect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	// Drop every connection: the pooled ones and the subscription.
+	if _, err := srv.DB().Do("CLIENT", "KILL", "SKIPME", "no"); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := c.Get("client:reconnect"); err != nil || value != "v" {
+		t.Errorf("Expected the command to be retried, got %q, %v", value, err)
+	}
+
+	// The subscription is restored once the PubSub reconnects.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if n, _ := c.Publish("client:reconnect", "again"); n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the subscription to be restored")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if m := receive(t, ps); m.Payload != "again" {
+		t.Errorf("Expected again, got %+v", m)
+	}
+}
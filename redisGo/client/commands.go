@@ -0,0 +1,132 @@
+package client
+
+import "strconv"
+
+// doInt runs a command replying with an integer.
+func (c *Client) doInt(args ...string) (int, error) {
+	reply, err := c.Do(args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return reply.Num, nil
+}
+
+// doStrings runs a command replying with an array of bulk strings.
+func (c *Client) doStrings(args ...string) ([]string, error) {
+	reply, err := c.Do(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, 0, len(reply.Array))
+	for _, v := range reply.Array {
+		values = append(values, v.Bulk)
+	}
+
+	return values, nil
+}
+
+// doBulk runs a command replying with a bulk string, or ErrNil for a null.
+func (c *Client) doBulk(args ...string) (string, error) {
+	reply, err := c.Do(args...)
+	if err != nil {
+		return "", err
+	}
+	if reply.Type == "null" {
+		return "", ErrNil
+	}
+
+	return reply.Bulk, nil
+}
+
+func (c *Client) Ping() error {
+	_, err := c.Do("PING")
+	return err
+}
+
+func (c *Client) Get(key string) (string, error) {
+	return c.doBulk("GET", key)
+}
+
+func (c *Client) Set(key, value string) error {
+	_, err := c.Do("SET", key, value)
+	return err
+}
+
+func (c *Client) Del(keys ...string) (int, error) {
+	return c.doInt(append([]string{"DEL"}, keys...)...)
+}
+
+func (c *Client) Exists(keys ...string) (int, error) {
+	return c.doInt(append([]string{"EXISTS"}, keys...)...)
+}
+
+// Expire sets a timeout of seconds on key, returning whether it exists.
+func (c *Client) Expire(key string, seconds int) (bool, error) {
+	n, err := c.doInt("EXPIRE", key, strconv.Itoa(seconds))
+	return n == 1, err
+}
+
+func (c *Client) HSet(key, field, value string) error {
+	_, err := c.Do("HSET", key, field, value)
+	return err
+}
+
+func (c *Client) HGet(key, field string) (string, error) {
+	return c.doBulk("HGET", key, field)
+}
+
+func (c *Client) HGetAll(key string) (map[string]string, error) {
+	values, err := c.doStrings("HGETALL", key)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		hash[values[i]] = values[i+1]
+	}
+
+	return hash, nil
+}
+
+// LPush and RPush return the length of the list after the push.
+func (c *Client) LPush(key string, values ...string) (int, error) {
+	return c.doInt(append([]string{"LPUSH", key}, values...)...)
+}
+
+func (c *Client) RPush(key string, values ...string) (int, error) {
+	return c.doInt(append([]string{"RPUSH", key}, values...)...)
+}
+
+func (c *Client) LRange(key string, start, stop int) ([]string, error) {
+	return c.doStrings("LRANGE", key, strconv.Itoa(start), strconv.Itoa(stop))
+}
+
+func (c *Client) SAdd(key string, members ...string) (int, error) {
+	return c.doInt(append([]string{"SADD", key}, members...)...)
+}
+
+func (c *Client) SMembers(key string) ([]string, error) {
+	return c.doStrings("SMEMBERS", key)
+}
+
+func (c *Client) ZAdd(key string, score float64, member string) (int, error) {
+	return c.doInt("ZADD", key, strconv.FormatFloat(score, 'g', -1, 64), member)
+}
+
+func (c *Client) ZScore(key, member string) (float64, error) {
+	score, err := c.doBulk("ZSCORE", key, member)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(score, 64)
+}
+
+// Publish sends message to the subscribers of channel, returning how many
+// received it.
+func (c *Client) Publish(channel, message string) (int, error) {
+	return c.doInt("PUBLISH", channel, message)
+}
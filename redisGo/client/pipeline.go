@@ -0,0 +1,170 @@
+package client
+
+import (
+	"errors"
+
+	"redisGo/resp"
+)
+
+// ErrTxFailed is returned by a transaction that did not run because a
+// watched key changed.
+var ErrTxFailed = errors.New("redisGo: transaction failed")
+
+// Pipeline queues commands to send them in a single write, saving a round
+// trip per command. A transactional pipeline wraps them in MULTI and EXEC,
+// so that they run atomically.
+type Pipeline struct {
+	c        *Client
+	tx       *Tx
+	multi    bool
+	commands []resp.Value
+}
+
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{c: c}
+}
+
+func (c *Client) TxPipeline() *Pipeline {
+	return &Pipeline{c: c, multi: true}
+}
+
+// Do queues a command.
+func (p *Pipeline) Do(args ...string) {
+	p.commands = append(p.commands, command(args...))
+}
+
+// Len returns the number of commands queued.
+func (p *Pipeline) Len() int {
+	return len(p.commands)
+}
+
+// Exec sends the queued commands and returns their replies in order. The
+// error is a network error, ErrTxFailed, or else the first error reply,
+// in which case the replies to the other commands are returned too.
+func (p *Pipeline) Exec() ([]resp.Value, error) {
+	commands := p.commands
+	p.commands = nil
+	if len(commands) == 0 {
+		return nil, nil
+	}
+
+	if p.multi {
+		wrapped := make([]resp.Value, 0, len(commands)+2)
+		wrapped = append(wrapped, command("MULTI"))
+		wrapped = append(wrapped, commands...)
+		commands = append(wrapped, command("EXEC"))
+	}
+
+	var replies []resp.Value
+	var err error
+	if p.tx != nil {
+		replies, err = p.tx.roundTrip(commands)
+	} else {
+		replies, err = p.c.process(commands)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if p.multi {
+		return execReplies(replies)
+	}
+	return replies, firstError(replies)
+}
+
+// execReplies unwraps the replies to MULTI, the queued commands and EXEC.
+func execReplies(replies []resp.Value) ([]resp.Value, error) {
+	exec := replies[len(replies)-1]
+	if exec.Type == "null" {
+		return nil, ErrTxFailed
+	}
+
+	// A command refused while queueing makes EXEC fail with EXECABORT;
+	// its own error says why.
+	if err := replyError(exec); err != nil {
+		if err := firstError(replies[:len(replies)-1]); err != nil {
+			return nil, err
+		}
+		return nil, err
+	}
+
+	return exec.Array, firstError(exec.Array)
+}
+
+func firstError(replies []resp.Value) error {
+	for _, reply := range replies {
+		if err := replyError(reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tx is a connection Watch holds for a transaction, so that the values
+// read on it before the transaction are checked against by EXEC.
+type Tx struct {
+	cn *conn
+
+	// err is the network error the connection failed with.
+	err error
+}
+
+func (tx *Tx) roundTrip(commands []resp.Value) ([]resp.Value, error) {
+	if tx.err != nil {
+		return nil, tx.err
+	}
+
+	replies, err := tx.cn.roundTrip(commands)
+	tx.err = err
+	return replies, err
+}
+
+// Do runs a command on the connection of the transaction.
+func (tx *Tx) Do(args ...string) (resp.Value, error) {
+	replies, err := tx.roundTrip([]resp.Value{command(args...)})
+	if err != nil {
+		return resp.Value{}, err
+	}
+	if err := replyError(replies[0]); err != nil {
+		return resp.Value{}, err
+	}
+
+	return replies[0], nil
+}
+
+// TxPipeline returns a transactional pipeline on the connection of the
+// transaction, which fails with ErrTxFailed if a watched key changed.
+func (tx *Tx) TxPipeline() *Pipeline {
+	return &Pipeline{tx: tx, multi: true}
+}
+
+// Watch runs fn on a connection watching keys, for optimistic locking: fn
+// reads the keys with Tx.Do and writes them back with Tx.TxPipeline, which
+// fails with ErrTxFailed if any of them changed in between. Watch does not
+// retry; callers loop on ErrTxFailed.
+//
+// The connection of tx is taken from the pool until Watch returns, so fn
+// must run its commands through tx: with a PoolSize of 1, a command on c
+// waits for the connection fn holds and never runs.
+func (c *Client) Watch(fn func(tx *Tx) error, keys ...string) error {
+	cn, err := c.get()
+	if err != nil {
+		return err
+	}
+
+	tx := &Tx{cn: cn}
+	if _, err := tx.Do(append([]string{"WATCH"}, keys...)...); err != nil {
+		c.put(cn, tx.err)
+		return err
+	}
+
+	err = fn(tx)
+
+	// EXEC forgets the watched keys, but fn may have returned before it.
+	if tx.err == nil {
+		tx.Do("UNWATCH")
+	}
+	c.put(cn, tx.err)
+
+	return err
+}
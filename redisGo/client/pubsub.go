@@ -0,0 +1,322 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"redisGo/resp"
+)
+
+// Message is a message received on a subscription. Pattern is set for the
+// messages matching a pattern subscription.
+type Message struct {
+	Channel string
+	Pattern string
+	Payload string
+}
+
+// PubSub is a subscription on a connection of its own, outside the pool.
+// If the connection fails it reconnects with the retry backoff and
+// subscribes again, losing the messages published in between.
+type PubSub struct {
+	c *Client
+
+	// subMu serializes the subscribe calls, which wait for their
+	// confirmations on acks.
+	subMu sync.Mutex
+	acks  chan struct{}
+
+	// mu guards the connection and the subscriptions to restore on it.
+	mu       sync.Mutex
+	cn       *conn
+	channels map[string]bool
+	patterns map[string]bool
+
+	messages  chan *Message
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Subscribe returns a PubSub subscribed to channels, once the server
+// confirmed the subscriptions.
+func (c *Client) Subscribe(channels ...string) (*PubSub, error) {
+	ps, err := c.newPubSub()
+	if err != nil {
+		return nil, err
+	}
+	if err := ps.Subscribe(channels...); err != nil {
+		ps.Close()
+		return nil, err
+	}
+
+	return ps, nil
+}
+
+// PSubscribe returns a PubSub subscribed to the channels matching
+// patterns.
+func (c *Client) PSubscribe(patterns ...string) (*PubSub, error) {
+	ps, err := c.newPubSub()
+	if err != nil {
+		return nil, err
+	}
+	if err := ps.PSubscribe(patterns...); err != nil {
+		ps.Close()
+		return nil, err
+	}
+
+	return ps, nil
+}
+
+func (c *Client) newPubSub() (*PubSub, error) {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	cn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	ps := &PubSub{
+		c:        c,
+		acks:     make(chan struct{}, 1024),
+		cn:       cn,
+		channels: map[string]bool{},
+		patterns: map[string]bool{},
+		messages: make(chan *Message, 100),
+		done:     make(chan struct{}),
+	}
+	go ps.run()
+
+	return ps, nil
+}
+
+// Channel returns the channel messages are delivered on. It is closed by
+// Close.
+func (ps *PubSub) Channel() <-chan *Message {
+	return ps.messages
+}
+
+func (ps *PubSub) Subscribe(channels ...string) error {
+	return ps.subscribe("SUBSCRIBE", ps.channels, channels)
+}
+
+func (ps *PubSub) PSubscribe(patterns ...string) error {
+	return ps.subscribe("PSUBSCRIBE", ps.patterns, patterns)
+}
+
+func (ps *PubSub) Unsubscribe(channels ...string) error {
+	return ps.unsubscribe("UNSUBSCRIBE", ps.channels, channels)
+}
+
+func (ps *PubSub) PUnsubscribe(patterns ...string) error {
+	return ps.unsubscribe("PUNSUBSCRIBE", ps.patterns, patterns)
+}
+
+// subscribe adds names to the subscriptions and waits for the server to
+// confirm each, which it also does when they are restored after a
+// reconnect.
+func (ps *PubSub) subscribe(cmd string, subscriptions map[string]bool, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	ps.subMu.Lock()
+	defer ps.subMu.Unlock()
+
+	for len(ps.acks) > 0 {
+		<-ps.acks
+	}
+
+	ps.mu.Lock()
+	for _, name := range names {
+		subscriptions[name] = true
+	}
+	cn := ps.cn
+	ps.mu.Unlock()
+
+	// Should the write fail, the reconnect subscribes again.
+	ps.write(cn, append([]string{cmd}, names...))
+
+	timeout := time.NewTimer(ps.c.opts.DialTimeout)
+	defer timeout.Stop()
+	for range names {
+		select {
+		case <-ps.acks:
+		case <-timeout.C:
+			return errors.New("redisGo: timed out waiting for the subscription")
+		case <-ps.done:
+			return ErrClosed
+		}
+	}
+
+	return nil
+}
+
+func (ps *PubSub) unsubscribe(cmd string, subscriptions map[string]bool, names []string) error {
+	ps.mu.Lock()
+	for _, name := range names {
+		delete(subscriptions, name)
+	}
+	if len(names) == 0 {
+		clear(subscriptions)
+	}
+	cn := ps.cn
+	ps.mu.Unlock()
+
+	return ps.write(cn, append([]string{cmd}, names...))
+}
+
+// write sends a command on cn. Only the reading goroutine reads from it.
+func (ps *PubSub) write(cn *conn, args []string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if err := cn.writer.Write(command(args...)); err != nil {
+		return err
+	}
+	return cn.writer.Flush()
+}
+
+// Close unsubscribes by closing the connection.
+func (ps *PubSub) Close() error {
+	err := ErrClosed
+	ps.closeOnce.Do(func() {
+		close(ps.done)
+
+		ps.mu.Lock()
+		err = ps.cn.close()
+		ps.mu.Unlock()
+	})
+
+	return err
+}
+
+func (ps *PubSub) closed() bool {
+	select {
+	case <-ps.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// run reads the messages and the confirmations of the subscriptions,
+// reconnecting whenever the connection fails.
+func (ps *PubSub) run() {
+	defer close(ps.messages)
+
+	for {
+		ps.mu.Lock()
+		cn := ps.cn
+		ps.mu.Unlock()
+
+		v, err := cn.reader.Read()
+		if err != nil {
+			if !ps.reconnect() {
+				return
+			}
+			continue
+		}
+
+		message, ack := parseMessage(v)
+		if ack {
+			select {
+			case ps.acks <- struct{}{}:
+			default:
+			}
+		}
+		if message == nil {
+			continue
+		}
+
+		select {
+		case ps.messages <- message:
+		case <-ps.done:
+			return
+		}
+	}
+}
+
+// reconnect replaces the connection and restores the subscriptions on
+// it, retrying with the backoff until it succeeds. It returns false once
+// the PubSub is closed.
+func (ps *PubSub) reconnect() bool {
+	for attempt := 1; ; attempt++ {
+		if ps.closed() {
+			return false
+		}
+
+		select {
+		case <-time.After(ps.c.opts.backoff(attempt)):
+		case <-ps.done:
+			return false
+		}
+
+		cn, err := ps.c.dial()
+		if err != nil {
+			continue
+		}
+
+		ps.mu.Lock()
+		if ps.closed() {
+			ps.mu.Unlock()
+			cn.close()
+			return false
+		}
+		ps.cn.close()
+		ps.cn = cn
+		commands := []resp.Value{}
+		if len(ps.channels) > 0 {
+			commands = append(commands, command(append([]string{"SUBSCRIBE"}, keys(ps.channels)...)...))
+		}
+		if len(ps.patterns) > 0 {
+			commands = append(commands, command(append([]string{"PSUBSCRIBE"}, keys(ps.patterns)...)...))
+		}
+		for _, c := range commands {
+			err = cn.writer.Write(c)
+		}
+		if err == nil {
+			err = cn.writer.Flush()
+		}
+		ps.mu.Unlock()
+
+		if err == nil {
+			return true
+		}
+	}
+}
+
+func keys(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	return names
+}
+
+// parseMessage reads a message pushed on the subscription. ack is set for
+// the confirmation of a subscription.
+func parseMessage(v resp.Value) (message *Message, ack bool) {
+	if v.Type != "array" || len(v.Array) < 3 {
+		return nil, false
+	}
+
+	switch v.Array[0].Bulk {
+	case "message":
+		return &Message{Channel: v.Array[1].Bulk, Payload: v.Array[2].Bulk}, false
+	case "pmessage":
+		if len(v.Array) < 4 {
+			return nil, false
+		}
+		return &Message{Pattern: v.Array[1].Bulk, Channel: v.Array[2].Bulk, Payload: v.Array[3].Bulk}, false
+	case "subscribe", "psubscribe":
+		return nil, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"redisGo/resp"
+)
+
+// result is the outcome of a test.
+type result struct {
+	name     string
+	requests int
+	errors   int
+	elapsed  time.Duration
+
+	// latencies holds the latency of every request, sorted. A request
+	// sent in a pipeline is timed from the write of the whole pipeline.
+	latencies []time.Duration
+}
+
+// run sends the requests of a test from the parallel clients, each
+// connected beforehand, and collects their latencies.
+func run(cfg *config, t test) (*result, error) {
+	if err := setup(cfg, t.setup); err != nil {
+		return nil, err
+	}
+
+	conns := make([]net.Conn, 0, cfg.clients)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < cfg.clients; i++ {
+		conn, err := net.Dial(cfg.network, cfg.addr)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+
+	// issued counts the requests taken by the clients, which each take a
+	// pipeline at a time until there are none left.
+	var issued atomic.Int64
+	latencies := make([][]time.Duration, len(conns))
+	errors := make([]int, len(conns))
+	failures := make([]error, len(conns))
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(i int, conn net.Conn) {
+			defer wg.Done()
+
+			rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))
+			reader := resp.NewResp(conn)
+			writer := resp.NewWriter(conn)
+			for {
+				first := int(issued.Add(int64(cfg.pipeline))) - cfg.pipeline
+				if first >= cfg.requests {
+					return
+				}
+				batch := min(cfg.pipeline, cfg.requests-first)
+
+				sent := time.Now()
+				for j := 0; j < batch; j++ {
+					writer.Write(command(t.command(cfg, rng)...))
+				}
+				if err := writer.Flush(); err != nil {
+					failures[i] = err
+					return
+				}
+
+				for j := 0; j < batch; j++ {
+					reply, err := reader.Read()
+					if err != nil {
+						failures[i] = err
+						return
+					}
+					if reply.Type == "error" {
+						errors[i]++
+					}
+					latencies[i] = append(latencies[i], time.Since(sent))
+				}
+			}
+		}(i, conn)
+	}
+	wg.Wait()
+
+	r := &result{name: t.name, elapsed: time.Since(start)}
+	for i := range conns {
+		if failures[i] != nil {
+			return nil, failures[i]
+		}
+		r.errors += errors[i]
+		r.latencies = append(r.latencies, latencies[i]...)
+	}
+	r.requests = len(r.latencies)
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+
+	return r, nil
+}
+
+// setup runs the commands a test needs first.
+func setup(cfg *config, commands [][]string) error {
+	if len(commands) == 0 {
+		return nil
+	}
+
+	conn, err := net.Dial(cfg.network, cfg.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	reader := resp.NewResp(conn)
+	for _, args := range commands {
+		if _, err := conn.Write(command(args...).Marshal()); err != nil {
+			return err
+		}
+		reply, err := reader.Read()
+		if err != nil {
+			return err
+		}
+		if reply.Type == "error" {
+			return fmt.Errorf("setup failed: %s", reply.Str)
+		}
+	}
+	return nil
+}
+
+func (r *result) rps() float64 {
+	return float64(r.requests) / r.elapsed.Seconds()
+}
+
+// percentile returns the latency below which p percent of the requests
+// completed.
+func (r *result) percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(r.latencies)))) - 1
+	return r.latencies[max(i, 0)]
+}
+
+func (r *result) average() time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range r.latencies {
+		total += d
+	}
+	return total / time.Duration(len(r.latencies))
+}
+
+func (r *result) print(cfg *config) {
+	fmt.Printf("====== %s ======\n", r.name)
+	fmt.Printf("  %d requests completed in %.2f seconds\n", r.requests, r.elapsed.Seconds())
+	fmt.Printf("  %d parallel clients\n", cfg.clients)
+	if cfg.dataMax > cfg.dataMin {
+		fmt.Printf("  %d to %d bytes payload\n", cfg.dataMin, cfg.dataMax)
+	} else {
+		fmt.Printf("  %d bytes payload\n", cfg.dataMin)
+	}
+	fmt.Printf("  pipeline of %d requests\n", cfg.pipeline)
+	if r.errors > 0 {
+		fmt.Printf("  %d requests failed\n", r.errors)
+	}
+	fmt.Println()
+
+	fmt.Println("Latency by percentile distribution:")
+	for _, p := range []float64{0, 50, 75, 90, 95, 99, 99.9, 100} {
+		fmt.Printf("%7.3f%% <= %.3f milliseconds\n", p, ms(r.percentile(p)))
+	}
+	fmt.Println()
+
+	fmt.Println("Summary:")
+	fmt.Printf("  throughput summary: %.2f requests per second\n", r.rps())
+	fmt.Println("  latency summary (msec):")
+	fmt.Printf("  %9s %9s %9s %9s %9s %9s\n", "avg", "min", "p50", "p95", "p99", "max")
+	fmt.Printf("  %9.3f %9.3f %9.3f %9.3f %9.3f %9.3f\n\n",
+		ms(r.average()), ms(r.percentile(0)), ms(r.percentile(50)), ms(r.percentile(95)), ms(r.percentile(99)), ms(r.percentile(100)))
+}
+
+func (r *result) printQuiet() {
+	fmt.Printf("%s: %.2f requests per second, p50=%.3f msec\n", r.name, r.rps(), ms(r.percentile(50)))
+}
+
+func (r *result) printCSV() {
+	fmt.Printf("\"%s\",\"%.2f\",\"%.3f\",\"%.3f\",\"%.3f\",\"%.3f\",\"%.3f\",\"%.3f\"\n",
+		r.name, r.rps(), ms(r.average()), ms(r.percentile(0)), ms(r.percentile(50)), ms(r.percentile(95)), ms(r.percentile(99)), ms(r.percentile(100)))
+}
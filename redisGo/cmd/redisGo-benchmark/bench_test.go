@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"redisGo/server"
+)
+
+func TestParseRange(t *testing.T) {
+	if min, max, err := parseRange("3"); err != nil || min != 3 || max != 3 {
+		t.Errorf("Expected 3-3, got %d-%d, %v", min, max, err)
+	}
+	if min, max, err := parseRange("10-100"); err != nil || min != 10 || max != 100 {
+		t.Errorf("Expected 10-100, got %d-%d, %v", min, max, err)
+	}
+	for _, s := range []string{"", "x", "10-5", "-1"} {
+		if _, _, err := parseRange(s); err == nil {
+			t.Errorf("Expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	r := &result{}
+	for i := 1; i <= 100; i++ {
+		r.latencies = append(r.latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	for p, want := range map[float64]time.Duration{0: 1, 50: 50, 99: 99, 99.9: 100, 100: 100} {
+		if got := r.percentile(p); got != want*time.Millisecond {
+			t.Errorf("Expected p%v to be %dms, got %v", p, want, got)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	srv, err := server.New(server.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+
+	cfg := &config{network: "tcp", addr: l.Addr().String(), clients: 4, requests: 1001, pipeline: 16, dataMin: 1, dataMax: 10, keyspace: 100}
+	selected, err := selectTests("set,lrange_100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range selected {
+		r, err := run(cfg, test)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.requests != 1001 || r.errors != 0 {
+			t.Errorf("Expected 1001 successful %s requests, got %d with %d errors", test.name, r.requests, r.errors)
+		}
+	}
+
+	if keys, _ := srv.DB().Do("EXISTS", "key:000000000000", "key:000000000099", "key:000000000100"); keys.Num > 2 {
+		t.Errorf("Expected the keys to stay within the keyspace, got %d", keys.Num)
+	}
+}
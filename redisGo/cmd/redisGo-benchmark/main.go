@@ -0,0 +1,222 @@
+// redisGo-benchmark measures the throughput and latency of redisGo, after
+// redis-benchmark:
+//
+//	redisGo-benchmark [-h host] [-p port] [-s socket] [-c clients] [-n requests]
+//	                  [-P pipeline] [-d size|min-max] [-r keyspace] [-t tests]
+//	                  [-q] [--csv]
+//
+// Each test sends its requests from the parallel clients, in pipelines of
+// -P requests, and reports the requests per second and the latency
+// percentiles.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"redisGo/resp"
+)
+
+// config is the load the tests generate.
+type config struct {
+	network  string
+	addr     string
+	clients  int
+	requests int
+	pipeline int
+
+	// Values are between dataMin and dataMax bytes long.
+	dataMin, dataMax int
+
+	// keyspace is the number of distinct keys, drawn at random, or 0 to use
+	// a single key per test.
+	keyspace int
+}
+
+// test builds the commands of a benchmark. setup, if set, runs once
+// before it on a connection of its own.
+type test struct {
+	name    string
+	command func(cfg *config, rng *rand.Rand) []string
+	setup   [][]string
+}
+
+// key returns the key of a request: prefix followed by a random number
+// below the keyspace, zero padded to twelve digits as redis-benchmark
+// does.
+func (cfg *config) key(prefix string, rng *rand.Rand) string {
+	n := 0
+	if cfg.keyspace > 0 {
+		n = rng.Intn(cfg.keyspace)
+	}
+	return fmt.Sprintf("%s%012d", prefix, n)
+}
+
+// value returns a value of a random size within the configured range.
+func (cfg *config) value(rng *rand.Rand) string {
+	size := cfg.dataMin
+	if cfg.dataMax > cfg.dataMin {
+		size += rng.Intn(cfg.dataMax - cfg.dataMin + 1)
+	}
+	return strings.Repeat("x", size)
+}
+
+// lrangeSetup fills the list the LRANGE tests read.
+var lrangeSetup = [][]string{append([]string{"RPUSH", "mylist"}, strings.Fields(strings.Repeat("x ", 600))...)}
+
+var tests = []test{
+	{name: "PING", command: func(cfg *config, rng *rand.Rand) []string {
+		return []string{"PING"}
+	}},
+	{name: "SET", command: func(cfg *config, rng *rand.Rand) []string {
+		return []string{"SET", cfg.key("key:", rng), cfg.value(rng)}
+	}},
+	{name: "GET", command: func(cfg *config, rng *rand.Rand) []string {
+		return []string{"GET", cfg.key("key:", rng)}
+	}},
+	{name: "HSET", command: func(cfg *config, rng *rand.Rand) []string {
+		return []string{"HSET", "myhash", cfg.key("element:", rng), cfg.value(rng)}
+	}},
+	{name: "LPUSH", command: func(cfg *config, rng *rand.Rand) []string {
+		return []string{"LPUSH", "mylist", cfg.value(rng)}
+	}},
+	{name: "RPUSH", command: func(cfg *config, rng *rand.Rand) []string {
+		return []string{"RPUSH", "mylist", cfg.value(rng)}
+	}},
+	{name: "LPOP", command: func(cfg *config, rng *rand.Rand) []string {
+		return []string{"LPOP", "mylist"}
+	}},
+	{name: "RPOP", command: func(cfg *config, rng *rand.Rand) []string {
+		return []string{"RPOP", "mylist"}
+	}},
+	{name: "SADD", command: func(cfg *config, rng *rand.Rand) []string {
+		return []string{"SADD", "myset", cfg.key("element:", rng)}
+	}},
+	{name: "ZADD", command: func(cfg *config, rng *rand.Rand) []string {
+		return []string{"ZADD", "myzset", strconv.Itoa(rng.Intn(1000000)), cfg.key("element:", rng)}
+	}},
+	{name: "LRANGE_100", setup: lrangeSetup, command: func(cfg *config, rng *rand.Rand) []string {
+		return []string{"LRANGE", "mylist", "0", "99"}
+	}},
+	{name: "LRANGE_600", setup: lrangeSetup, command: func(cfg *config, rng *rand.Rand) []string {
+		return []string{"LRANGE", "mylist", "0", "599"}
+	}},
+}
+
+// parseRange parses a size, or a range of sizes as min-max.
+func parseRange(s string) (min, max int, err error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if min, err = strconv.Atoi(lo); err != nil || min < 0 {
+		return 0, 0, fmt.Errorf("invalid size %q", s)
+	}
+	if !isRange {
+		return min, min, nil
+	}
+	if max, err = strconv.Atoi(hi); err != nil || max < min {
+		return 0, 0, fmt.Errorf("invalid size range %q", s)
+	}
+	return min, max, nil
+}
+
+// selectTests returns the tests named in a comma separated list, all of
+// them if it is empty.
+func selectTests(list string) ([]test, error) {
+	if list == "" {
+		return tests, nil
+	}
+
+	selected := []test{}
+	for _, name := range strings.Split(list, ",") {
+		found := false
+		for _, t := range tests {
+			if strings.EqualFold(t.name, strings.TrimSpace(name)) {
+				selected = append(selected, t)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown test %q", name)
+		}
+	}
+	return selected, nil
+}
+
+func main() {
+	host := flag.String("h", "127.0.0.1", "server hostname")
+	port := flag.Int("p", 6379, "server port")
+	socket := flag.String("s", "", "server socket, overriding hostname and port")
+	clients := flag.Int("c", 50, "number of parallel connections")
+	requests := flag.Int("n", 100000, "total number of requests of each test")
+	pipeline := flag.Int("P", 1, "pipeline `numreq` requests")
+	data := flag.String("d", "3", "data size of the values in bytes, or a `range` min-max of random sizes")
+	keyspace := flag.Int("r", 0, "use random keys among `keyspacelen` keys, instead of a single one")
+	testList := flag.String("t", "", "only run the comma separated list of tests")
+	quiet := flag.Bool("q", false, "quiet: just show the requests per second and the median latency")
+	csv := flag.Bool("csv", false, "output in CSV format")
+	flag.Parse()
+
+	cfg := &config{
+		network:  "tcp",
+		addr:     net.JoinHostPort(*host, strconv.Itoa(*port)),
+		clients:  *clients,
+		requests: *requests,
+		pipeline: *pipeline,
+		keyspace: *keyspace,
+	}
+	if *socket != "" {
+		cfg.network, cfg.addr = "unix", *socket
+	}
+	var err error
+	if cfg.dataMin, cfg.dataMax, err = parseRange(*data); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if cfg.clients < 1 || cfg.requests < 1 || cfg.pipeline < 1 {
+		fmt.Fprintln(os.Stderr, "-c, -n and -P must be positive")
+		os.Exit(1)
+	}
+	selected, err := selectTests(*testList)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *csv {
+		fmt.Println(`"test","rps","avg_latency_ms","min_latency_ms","p50_latency_ms","p95_latency_ms","p99_latency_ms","max_latency_ms"`)
+	}
+	for _, t := range selected {
+		result, err := run(cfg, t)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", t.name, err)
+			os.Exit(1)
+		}
+
+		switch {
+		case *csv:
+			result.printCSV()
+		case *quiet:
+			result.printQuiet()
+		default:
+			result.print(cfg)
+		}
+	}
+}
+
+// command encodes arguments as the array of bulk strings of a request.
+func command(args ...string) resp.Value {
+	v := resp.Value{Type: "array"}
+	for _, arg := range args {
+		v.Array = append(v.Array, resp.Value{Type: "bulk", Bulk: arg})
+	}
+	return v
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
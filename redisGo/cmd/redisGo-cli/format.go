@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"redisGo/resp"
+)
+
+// splitArgs splits a command line into arguments the way redis-cli does:
+// on spaces, except within double quotes, which take the escapes \n, \r,
+// \t, \b, \a, \\, \" and \xHH, or single quotes, which only take \'. A
+// closing quote must be followed by a space or the end of the line.
+func splitArgs(line string) ([]string, error) {
+	args := []string{}
+
+	for i := 0; ; {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg strings.Builder
+		inDouble, inSingle := false, false
+		for done := false; !done; {
+			if i == len(line) {
+				if inDouble || inSingle {
+					return nil, errors.New("unbalanced quotes in request")
+				}
+				break
+			}
+
+			c := line[i]
+			switch {
+			case inDouble:
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					arg.WriteByte(byte(b))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						arg.WriteByte('\n')
+					case 'r':
+						arg.WriteByte('\r')
+					case 't':
+						arg.WriteByte('\t')
+					case 'b':
+						arg.WriteByte('\b')
+					case 'a':
+						arg.WriteByte('\a')
+					default:
+						arg.WriteByte(line[i])
+					}
+				case c == '"':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errors.New("closing quote must be followed by a space")
+					}
+					done = true
+				default:
+					arg.WriteByte(c)
+				}
+			case inSingle:
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					arg.WriteByte('\'')
+					i++
+				case c == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errors.New("closing quote must be followed by a space")
+					}
+					done = true
+				default:
+					arg.WriteByte(c)
+				}
+			default:
+				switch {
+				case isSpace(c):
+					done = true
+				case c == '"':
+					inDouble = true
+				case c == '\'':
+					inSingle = true
+				default:
+					arg.WriteByte(c)
+				}
+			}
+			i++
+		}
+
+		args = append(args, arg.String())
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// repr quotes a bulk string, escaping the bytes that are not printable.
+func repr(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		default:
+			if c < 0x20 || c > 0x7e {
+				fmt.Fprintf(&b, `\x%02x`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// formatReply renders a reply the way redis-cli does on a terminal,
+// numbering the elements of arrays and indenting nested ones.
+func formatReply(v resp.Value) string {
+	var b strings.Builder
+	writeReply(&b, v, "")
+	return b.String()
+}
+
+func writeReply(b *strings.Builder, v resp.Value, indent string) {
+	switch v.Type {
+	case "string":
+		b.WriteString(v.Str)
+	case "error":
+		b.WriteString("(error) " + v.Str)
+	case "integer":
+		fmt.Fprintf(b, "(integer) %d", v.Num)
+	case "bulk":
+		b.WriteString(repr(v.Bulk))
+	case "null":
+		b.WriteString("(nil)")
+	case "array", "push":
+		if len(v.Array) == 0 {
+			b.WriteString("(empty array)")
+			return
+		}
+		writeElements(b, v.Array, indent, ")", 1)
+	case "map":
+		if len(v.Array) == 0 {
+			b.WriteString("(empty hash)")
+			return
+		}
+		writeElements(b, v.Array, indent, "#", 2)
+	default:
+		fmt.Fprintf(b, "(unknown reply type %q)", v.Type)
+	}
+}
+
+// writeElements numbers the elements of an array, or the pairs of a map,
+// aligning the nested replies under the first line of their element.
+func writeElements(b *strings.Builder, elements []resp.Value, indent, suffix string, step int) {
+	count := len(elements) / step
+	width := len(strconv.Itoa(count))
+
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			b.WriteString("\n" + indent)
+		}
+		label := fmt.Sprintf("%*d%s ", width, i+1, suffix)
+		b.WriteString(label)
+		nested := indent + strings.Repeat(" ", len(label))
+
+		if step == 2 {
+			writeReply(b, elements[2*i], nested)
+			b.WriteString(" => ")
+			writeReply(b, elements[2*i+1], nested)
+			continue
+		}
+		writeReply(b, elements[i], nested)
+	}
+}
+
+// formatRaw renders a reply without decoration, for output that is not a
+// terminal: strings as they are, one array element per line.
+func formatRaw(v resp.Value) string {
+	switch v.Type {
+	case "string", "error":
+		return v.Str
+	case "integer":
+		return strconv.Itoa(v.Num)
+	case "bulk":
+		return v.Bulk
+	case "null":
+		return ""
+	case "array", "map", "push":
+		lines := make([]string, 0, len(v.Array))
+		for _, elem := range v.Array {
+			lines = append(lines, formatRaw(elem))
+		}
+		return strings.Join(lines, "\n")
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"redisGo/resp"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{`set key value`, []string{"set", "key", "value"}},
+		{`  set   "hello world"  'it''s' `, nil},
+		{`set "a\"b\n\x41" 'it\'s'`, []string{"set", "a\"b\nA", "it's"}},
+		{`set "" x`, []string{"set", "", "x"}},
+		{`set "unbalanced`, nil},
+	}
+
+	for _, test := range tests {
+		got, err := splitArgs(test.line)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("Expected an error splitting %q, got %q", test.line, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Expected %q splitting %q, got %q, %v", test.want, test.line, got, err)
+		}
+	}
+}
+
+func TestFormatReply(t *testing.T) {
+	bulk := func(s string) resp.Value { return resp.Value{Type: "bulk", Bulk: s} }
+
+	tests := []struct {
+		reply resp.Value
+		want  string
+	}{
+		{resp.Value{Type: "string", Str: "OK"}, "OK"},
+		{resp.Value{Type: "error", Str: "ERR oops"}, "(error) ERR oops"},
+		{resp.Value{Type: "integer", Num: 42}, "(integer) 42"},
+		{bulk("a\"b\x01"), `"a\"b\x01"`},
+		{resp.Value{Type: "null"}, "(nil)"},
+		{resp.Value{Type: "array"}, "(empty array)"},
+		{
+			resp.Value{Type: "array", Array: []resp.Value{
+				bulk("a"),
+				{Type: "array", Array: []resp.Value{bulk("b"), {Type: "null"}}},
+				{Type: "integer", Num: 1},
+			}},
+			"1) \"a\"\n2) 1) \"b\"\n   2) (nil)\n3) (integer) 1",
+		},
+	}
+
+	for _, test := range tests {
+		if got := formatReply(test.reply); got != test.want {
+			t.Errorf("Expected\n%s\ngot\n%s", test.want, got)
+		}
+	}
+}
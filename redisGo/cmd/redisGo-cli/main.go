@@ -0,0 +1,373 @@
+// redisGo-cli is the command line interface of redisGo, after redis-cli:
+//
+//	redisGo-cli [-h host] [-p port] [-s socket] [-r count [-i interval]] [command [arg ...]]
+//	redisGo-cli --pipe < commands.txt
+//	redisGo-cli --latency
+//
+// Without a command it starts a REPL.
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"redisGo/resp"
+)
+
+// cli holds the connection to the server, made on first use and again
+// after it is lost.
+type cli struct {
+	network string
+	addr    string
+	raw     bool
+	conn    net.Conn
+	reader  *resp.Resp
+	writer  *resp.Writer
+}
+
+func main() {
+	host := flag.String("h", "127.0.0.1", "server hostname")
+	port := flag.Int("p", 6379, "server port")
+	socket := flag.String("s", "", "server socket, overriding hostname and port")
+	repeat := flag.Int("r", 1, "execute the command `count` times")
+	interval := flag.Float64("i", 0, "wait `seconds` between the commands run with -r")
+	raw := flag.Bool("raw", false, "print replies without formatting, the default unless the output is a terminal")
+	pipe := flag.Bool("pipe", false, "mass insertion of the commands read from stdin, in RESP or one per line")
+	latency := flag.Bool("latency", false, "sample the latency of PING continuously")
+	flag.Parse()
+
+	c := &cli{
+		network: "tcp",
+		addr:    net.JoinHostPort(*host, strconv.Itoa(*port)),
+		raw:     *raw || !isTerminal(os.Stdout),
+	}
+	if *socket != "" {
+		c.network, c.addr = "unix", *socket
+	}
+
+	switch {
+	case *pipe:
+		os.Exit(c.pipeMode(os.Stdin))
+	case *latency:
+		c.latencyMode()
+	case flag.NArg() > 0:
+		if !c.repeat(flag.Args(), *repeat, time.Duration(*interval*float64(time.Second))) {
+			os.Exit(1)
+		}
+	default:
+		c.repl()
+	}
+}
+
+func (c *cli) connect() error {
+	conn, err := net.Dial(c.network, c.addr)
+	if err != nil {
+		return fmt.Errorf("Could not connect to Redis at %s: %v", c.addr, err)
+	}
+
+	c.conn = conn
+	c.reader = resp.NewResp(conn)
+	c.writer = resp.NewWriter(conn)
+	return nil
+}
+
+func (c *cli) disconnect() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// command encodes arguments as the array of bulk strings of a request.
+func command(args ...string) resp.Value {
+	v := resp.Value{Type: "array"}
+	for _, arg := range args {
+		v.Array = append(v.Array, resp.Value{Type: "bulk", Bulk: arg})
+	}
+	return v
+}
+
+// send runs a command, connecting first if needed.
+func (c *cli) send(args []string) (resp.Value, error) {
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return resp.Value{}, err
+		}
+	}
+
+	if err := c.writer.Write(command(args...)); err != nil {
+		c.disconnect()
+		return resp.Value{}, err
+	}
+	if err := c.writer.Flush(); err != nil {
+		c.disconnect()
+		return resp.Value{}, err
+	}
+
+	reply, err := c.reader.Read()
+	if err != nil {
+		c.disconnect()
+		return resp.Value{}, fmt.Errorf("Error: Server closed the connection")
+	}
+
+	return reply, nil
+}
+
+func (c *cli) print(v resp.Value) {
+	if c.raw {
+		fmt.Println(formatRaw(v))
+		return
+	}
+	fmt.Println(formatReply(v))
+}
+
+// repeat runs a command count times, interval apart. It reports whether
+// every run got a reply that was not an error.
+func (c *cli) repeat(args []string, count int, interval time.Duration) bool {
+	ok := true
+	for i := 0; i < count; i++ {
+		if i > 0 && interval > 0 {
+			time.Sleep(interval)
+		}
+
+		reply, err := c.send(args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return false
+		}
+		c.print(reply)
+		ok = ok && reply.Type != "error"
+
+		// Subscribers and monitors are sent messages until they quit.
+		switch strings.ToUpper(args[0]) {
+		case "SUBSCRIBE", "PSUBSCRIBE", "MONITOR":
+			if reply.Type != "error" {
+				c.follow()
+			}
+		}
+	}
+
+	return ok
+}
+
+// follow prints what the server pushes until the connection is closed.
+func (c *cli) follow() {
+	if !c.raw {
+		fmt.Println("Reading messages... (press Ctrl-C to quit)")
+	}
+	for {
+		reply, err := c.reader.Read()
+		if err != nil {
+			c.disconnect()
+			return
+		}
+		c.print(reply)
+	}
+}
+
+func historyFile() string {
+	if path := os.Getenv("REDISGOCLI_HISTFILE"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".redisgocli_history")
+}
+
+// repl reads commands from the terminal until quit, exit or Ctrl-D. A
+// command prefixed with a number is repeated that many times.
+func (c *cli) repl() {
+	if err := c.connect(); err != nil {
+		fmt.Println(err)
+	}
+	lines := newLineReader(historyFile())
+
+	for {
+		prompt := c.addr + "> "
+		if c.network == "unix" {
+			prompt = "redisGo " + prompt
+		}
+		if c.conn == nil {
+			prompt = "not connected> "
+		}
+
+		line, err := lines.readLine(prompt)
+		if err == errInterrupted {
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		args, err := splitArgs(line)
+		if err != nil {
+			fmt.Println("Invalid argument(s)")
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if isTerminal(os.Stdin) {
+			lines.addHistory(line)
+		}
+
+		switch strings.ToLower(args[0]) {
+		case "quit", "exit":
+			return
+		case "clear":
+			fmt.Print("\x1b[H\x1b[2J")
+			continue
+		}
+
+		count := 1
+		if n, err := strconv.Atoi(args[0]); err == nil && len(args) > 1 {
+			count = n
+			args = args[1:]
+		}
+		c.repeat(args, count, 0)
+	}
+}
+
+// latencySampleInterval is the wait between two PINGs of --latency.
+const latencySampleInterval = 10 * time.Millisecond
+
+// latencyMode sends PING in a loop, printing the minimum, maximum and
+// average round trip times in milliseconds on a single line.
+func (c *cli) latencyMode() {
+	var min, max, total time.Duration
+	samples := 0
+
+	for {
+		start := time.Now()
+		if _, err := c.send([]string{"PING"}); err != nil {
+			fmt.Fprintf(os.Stderr, "\n%v\n", err)
+			os.Exit(1)
+		}
+		d := time.Since(start)
+
+		if samples == 0 || d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+		total += d
+		samples++
+
+		avg := float64(total) / float64(samples) / float64(time.Millisecond)
+		fmt.Printf("\x1b[0G\x1b[2Kmin: %d, max: %d, avg: %.2f (%d samples)", min.Milliseconds(), max.Milliseconds(), avg, samples)
+		time.Sleep(latencySampleInterval)
+	}
+}
+
+// pipeMode sends the commands read from in as fast as the connection
+// allows, reading the replies concurrently, then PINGs with a random
+// marker to know when the last reply has arrived. Input in RESP is sent as
+// it is, any other is taken as one command per line, up to a line that
+// does not parse. It returns the exit status: 1 if any command failed or
+// the commands could not all be sent.
+func (c *cli) pipeMode(in io.Reader) int {
+	if err := c.connect(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	buf := make([]byte, 20)
+	rand.Read(buf)
+	marker := hex.EncodeToString(buf)
+
+	input := bufio.NewReader(in)
+	writeErr := make(chan error, 1)
+	go func() {
+		var err error
+		if first, _ := input.Peek(1); len(first) == 1 && first[0] == '*' {
+			_, err = io.Copy(c.conn, input)
+		} else {
+			err = writeInline(c.conn, input)
+		}
+		if err == nil {
+			_, err = c.conn.Write(command("PING", marker).Marshal())
+		}
+		if err == nil {
+			fmt.Println("All data transferred. Waiting for the last reply...")
+		}
+		writeErr <- err
+
+		// Without the marker the replies never end: closing the connection
+		// fails the read instead, which reports err.
+		if err != nil {
+			c.conn.Close()
+		}
+	}()
+
+	errors, replies := 0, 0
+	for {
+		reply, err := c.reader.Read()
+		if err != nil {
+			// A failed write explains a failed read better.
+			select {
+			case werr := <-writeErr:
+				if werr != nil {
+					fmt.Fprintf(os.Stderr, "Error writing the commands: %v\n", werr)
+					return 1
+				}
+			default:
+			}
+			fmt.Fprintf(os.Stderr, "Error reading the replies: %v\n", err)
+			return 1
+		}
+		if reply.Str == marker || reply.Bulk == marker {
+			break
+		}
+
+		replies++
+		if reply.Type == "error" {
+			errors++
+			fmt.Println(reply.Str)
+		}
+	}
+
+	fmt.Println("Last reply received from server.")
+	fmt.Printf("errors: %d, replies: %d\n", errors, replies)
+	if errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+// writeInline encodes the lines read from in as commands on w.
+func writeInline(w io.Writer, in *bufio.Reader) error {
+	out := bufio.NewWriter(w)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 512*1024*1024)
+
+	for scanner.Scan() {
+		args, err := splitArgs(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, scanner.Text())
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if _, err := out.Write(command(args...).Marshal()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return out.Flush()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"redisGo/server"
+)
+
+func TestPipeMode(t *testing.T) {
+	srv, err := server.New(server.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+
+	tests := []struct {
+		input  string
+		status int
+		key    string
+	}{
+		{"SET pipe:inline 1\nGET pipe:inline\n", 0, "pipe:inline"},
+		{string(command("SET", "pipe:resp", "2").Marshal()), 0, "pipe:resp"},
+		{"SET pipe:a 1\nLPUSH pipe:a x\n", 1, "pipe:a"},
+
+		// A line that does not parse stops the commands there.
+		{"SET pipe:b 1\nSET \"pipe:c 2\nSET pipe:d 3\n", 1, ""},
+	}
+
+	for _, test := range tests {
+		c := &cli{network: "tcp", addr: l.Addr().String()}
+		status := make(chan int, 1)
+		go func() { status <- c.pipeMode(strings.NewReader(test.input)) }()
+
+		select {
+		case got := <-status:
+			if got != test.status {
+				t.Errorf("Expected status %d for %q, got %d", test.status, test.input, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected --pipe to return for %q", test.input)
+		}
+		c.disconnect()
+
+		if test.key == "" {
+			continue
+		}
+		if _, err := srv.DB().Get(test.key); err != nil {
+			t.Errorf("Expected %s to be set, got %v", test.key, err)
+		}
+	}
+
+	if _, err := srv.DB().Get("pipe:d"); err == nil {
+		t.Errorf("Expected the commands after the malformed line not to run")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// errInterrupted is returned by readLine when Ctrl-C is pressed.
+var errInterrupted = errors.New("interrupted")
+
+// historyMax is the number of lines kept in the history file.
+const historyMax = 1000
+
+// lineReader reads commands from the terminal, with line editing and a
+// history recalled with the arrow keys and kept across sessions. Input
+// that is not a terminal is read line by line.
+type lineReader struct {
+	in          *bufio.Reader
+	history     []string
+	historyFile string
+}
+
+func newLineReader(historyFile string) *lineReader {
+	r := &lineReader{in: bufio.NewReader(os.Stdin), historyFile: historyFile}
+
+	if data, err := os.ReadFile(historyFile); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				r.history = append(r.history, line)
+			}
+		}
+	}
+
+	return r
+}
+
+// addHistory records a line, appending it to the history file.
+func (r *lineReader) addHistory(line string) {
+	if line == "" || (len(r.history) > 0 && r.history[len(r.history)-1] == line) {
+		return
+	}
+	r.history = append(r.history, line)
+
+	if r.historyFile == "" {
+		return
+	}
+	if len(r.history) > historyMax {
+		r.history = r.history[len(r.history)-historyMax:]
+		os.WriteFile(r.historyFile, []byte(strings.Join(r.history, "\n")+"\n"), 0600)
+		return
+	}
+	f, err := os.OpenFile(r.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.WriteString(line + "\n")
+}
+
+// isTerminal reports whether f is a terminal rather than a file or a pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// readLine prints prompt and reads a line.
+func (r *lineReader) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		if isTerminal(os.Stdin) {
+			fmt.Print(prompt)
+		}
+		line, err := r.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	defer restore()
+
+	return r.edit(prompt)
+}
+
+// edit runs the line editor in raw mode.
+func (r *lineReader) edit(prompt string) (string, error) {
+	line := []byte{}
+	pos := 0
+	// index is the position in the history being shown; saved keeps the
+	// line being typed while browsing it.
+	index := len(r.history)
+	saved := ""
+
+	refresh := func() {
+		fmt.Printf("\r%s%s\x1b[K", prompt, line)
+		if back := len(line) - pos; back > 0 {
+			fmt.Printf("\x1b[%dD", back)
+		}
+	}
+	recall := func(i int) {
+		if i < 0 || i > len(r.history) {
+			return
+		}
+		if index == len(r.history) {
+			saved = string(line)
+		}
+		index = i
+		if i == len(r.history) {
+			line = []byte(saved)
+		} else {
+			line = []byte(r.history[i])
+		}
+		pos = len(line)
+		refresh()
+	}
+
+	refresh()
+	for {
+		c, err := r.in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		switch c {
+		case '\r', '\n':
+			fmt.Print("\r\n")
+			return string(line), nil
+		case 3: // Ctrl-C
+			fmt.Print("^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Print("\r\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(line)
+		case 21: // Ctrl-U
+			line = line[:0]
+			pos = 0
+		case 12: // Ctrl-L
+			fmt.Print("\x1b[H\x1b[2J")
+		case 16: // Ctrl-P
+			recall(index - 1)
+			continue
+		case 14: // Ctrl-N
+			recall(index + 1)
+			continue
+		case 27: // Escape sequences of the arrow and editing keys
+			seq := make([]byte, 2)
+			if _, err := io.ReadFull(r.in, seq); err != nil || seq[0] != '[' {
+				continue
+			}
+			switch seq[1] {
+			case 'A':
+				recall(index - 1)
+				continue
+			case 'B':
+				recall(index + 1)
+				continue
+			case 'C':
+				if pos < len(line) {
+					pos++
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+				}
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(line)
+			case '3':
+				if tilde, _ := r.in.ReadByte(); tilde == '~' && pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+				}
+			}
+		default:
+			if c < 32 {
+				continue
+			}
+			line = append(line[:pos], append([]byte{c}, line[pos:]...)...)
+			pos++
+		}
+
+		refresh()
+	}
+}
@@ -0,0 +1,3 @@
+module redisGo
+
+go 1.22.4
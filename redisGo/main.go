@@ -1,86 +1,124 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"net"
-	"strings"
+	"net/http"
+	"os"
+	"strconv"
+
+	"redisGo/server"
 )
 
 func main() {
-	fmt.Println("Listening on port :6379")
+	// Options use the names of the redis.conf directives, so that several
+	// cluster nodes can be started with e.g.
+	// redisGo --port 7000 --cluster-enabled yes --dir node1
+	// and a Sentinel with
+	// redisGo --sentinel --sentinel-monitor "mymaster 127.0.0.1 6379 2"
+	// Local clients can connect on a Unix domain socket as well, with
+	// redisGo --unixsocket /tmp/redisGo.sock --unixsocketperm 700
+	// and Prometheus can scrape http://localhost:9121/metrics with
+	// redisGo --metrics-addr :9121
+	opts := server.Options{AppendFilename: "database.aof"}
+	dir := flag.String("dir", "", "working directory for the AOF and the cluster config file")
+	clusterMode := flag.String("cluster-enabled", "no", "run as a Redis Cluster node (yes or no)")
+	flag.IntVar(&opts.Port, "port", 0, "TCP port to listen on (default 6379, or 26379 for a Sentinel)")
+	flag.StringVar(&opts.ClusterConfigFile, "cluster-config-file", "nodes.conf", "file the cluster node table is saved in")
+	unixSocket := flag.String("unixsocket", "", "path of a Unix domain socket to listen on as well")
+	var unixSocketPerm os.FileMode
+	flag.Func("unixsocketperm", "octal permissions of the Unix domain socket, e.g. 700", func(s string) error {
+		perm, err := strconv.ParseUint(s, 8, 32)
+		if err != nil || perm > 0777 {
+			return fmt.Errorf("invalid permissions %q", s)
+		}
+		unixSocketPerm = os.FileMode(perm)
+		return nil
+	})
+	metricsAddr := flag.String("metrics-addr", "", "address to serve Prometheus metrics on at /metrics, e.g. :9121")
+	flag.BoolVar(&opts.Sentinel, "sentinel", false, "run as a Sentinel monitoring primaries instead of a data server")
+	flag.Func("sentinel-monitor", `primary to monitor as "<name> <ip> <port> <quorum>", may be repeated`, func(s string) error {
+		opts.SentinelMonitors = append(opts.SentinelMonitors, s)
+		return nil
+	})
+	flag.Parse()
 
-	// Create a new server
-	l, err := net.Listen("tcp", ":6379")
-	if err != nil {
-		fmt.Println(err)
-		return
+	if *dir != "" {
+		if err := os.Chdir(*dir); err != nil {
+			fmt.Println(err)
+			return
+		}
 	}
+	opts.ClusterEnabled = *clusterMode == "yes"
+	if opts.Port == 0 && opts.Sentinel {
+		opts.Port = 26379
+	} else if opts.Port == 0 {
+		opts.Port = 6379
+	}
+
+	fmt.Printf("Listening on port :%d\n", opts.Port)
 
-	aof, err := NewAof("database.aof")
+	// Create a new server
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", opts.Port))
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	defer aof.Close()
-
-	aof.Read(func(value Value) {
-		command := strings.ToUpper(value.array[0].bulk)
-		args := value.array[1:]
 
-		handler, ok := Handlers[command]
-		if !ok {
-			fmt.Println("Invalid command: ", command)
+	// The socket is served alongside the TCP port, by the same server.
+	var unixListener net.Listener
+	if *unixSocket != "" {
+		unixListener, err = server.ListenUnix(*unixSocket, unixSocketPerm)
+		if err != nil {
+			fmt.Println(err)
 			return
 		}
+		fmt.Printf("Listening on unix socket %s\n", *unixSocket)
+	}
 
-		handler(args)
-	})
-
-	// Listen for connections
-	conn, err := l.Accept()
+	srv, err := server.New(opts)
 	if err != nil {
 		fmt.Println(err)
+		if unixListener != nil {
+			unixListener.Close()
+		}
 		return
 	}
 
-	defer conn.Close()
-
-	for {
-		resp := NewResp(conn)
-		value, err := resp.Read()
+	if *metricsAddr != "" {
+		ml, err := net.Listen("tcp", *metricsAddr)
 		if err != nil {
 			fmt.Println(err)
+			srv.Close()
+			if unixListener != nil {
+				unixListener.Close()
+			}
 			return
 		}
+		fmt.Printf("Serving metrics on %s/metrics\n", ml.Addr())
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", srv.MetricsHandler())
+		go http.Serve(ml, mux)
+	}
 
-		if value.typ != "array" {
-			fmt.Println("Invalid request, expected array")
-			continue
-		}
-
-		if len(value.array) == 0 {
-			fmt.Println("Invalid request, expected array length > 0")
-			continue
-		}
-
-		command := strings.ToUpper(value.array[0].bulk)
-		args := value.array[1:]
-
-		writer := NewWriter(conn)
-
-		handler, ok := Handlers[command]
-		if !ok {
-			fmt.Println("Invalid command: ", command)
-			writer.Write(Value{typ: "string", str: ""})
-			continue
-		}
+	if unixListener != nil {
+		go func() {
+			if err := srv.Serve(unixListener); err != nil && !errors.Is(err, server.ErrServerClosed) {
+				fmt.Println(err)
+			}
+		}()
+	}
 
-		if command == "SET" || command == "HSET" {
-			aof.Write(value)
-		}
+	// A second signal while shutting down exits at once.
+	go func() {
+		srv.HandleSignals()
+		os.Exit(1)
+	}()
 
-		result := handler(args)
-		writer.Write(result)
+	// Serve returns once SHUTDOWN or a signal closed the server.
+	if err := srv.Serve(l); err != nil && !errors.Is(err, server.ErrServerClosed) {
+		fmt.Println(err)
 	}
 }
-
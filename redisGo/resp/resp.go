@@ -0,0 +1,376 @@
+// Package resp reads and writes values of the Redis serialization
+// protocol, RESP2 and the RESP3 types the server uses.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	STRING  = '+'
+	ERROR   = '-'
+	INTEGER = ':'
+	BULK    = '$'
+	ARRAY   = '*'
+	MAP     = '%'
+	PUSH    = '>'
+)
+
+// Value is a RESP value. Type is one of "string", "error", "integer",
+// "bulk", "null", "array", "map" and "push", and tells which of the other
+// fields holds it: Str for simple strings and errors, Num, Bulk, or Array,
+// whose elements alternate between keys and values for maps.
+type Value struct {
+	Type  string
+	Str   string
+	Num   int
+	Bulk  string
+	Array []Value
+}
+
+type Resp struct {
+	reader *bufio.Reader
+
+	// limit, unless 0, bounds the size of a value, of which n bytes were
+	// read so far.
+	limit int
+	n     int
+}
+
+// ErrTooLarge is returned by Read for a value over the limit set with
+// SetLimit. The stream cannot be read any further.
+var ErrTooLarge = errors.New("resp: value too large")
+
+// ErrProtocol is returned by Read for a value that starts with an unknown
+// type byte. The stream cannot be read any further.
+var ErrProtocol = errors.New("resp: protocol error")
+
+func NewResp(rd io.Reader) *Resp {
+	return &Resp{reader: bufio.NewReader(rd)}
+}
+
+// SetLimit bounds the size in bytes of the values Read accepts, or lifts
+// the bound if limit is 0. Bulk strings are checked before they are
+// allocated.
+func (r *Resp) SetLimit(limit int) {
+	r.limit = limit
+}
+
+// count adds n bytes to the value being read, failing once it is over the
+// limit.
+func (r *Resp) count(n int) error {
+	r.n += n
+	if r.limit > 0 && (r.n > r.limit || n < 0) {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+func (r *Resp) readLine() (line []byte, n int, err error) {
+	for {
+		b, err := r.reader.ReadByte()
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := r.count(1); err != nil {
+			return nil, 0, err
+		}
+		n += 1
+		line = append(line, b)
+		if len(line) >= 2 && line[len(line)-2] == '\r' {
+			break
+		}
+	}
+	return line[:len(line)-2], n, nil
+}
+
+func (r *Resp) readInteger() (x int, n int, err error) {
+	line, n, err := r.readLine()
+	if err != nil {
+		return 0, 0, err
+	}
+	i64, err := strconv.ParseInt(string(line), 10, 64)
+	if err != nil {
+		return 0, n, err
+	}
+	return int(i64), n, nil
+}
+
+// Buffered returns the number of bytes read from the connection but not yet
+// parsed, which is non-zero while there are pipelined commands left.
+func (r *Resp) Buffered() int {
+	return r.reader.Buffered()
+}
+
+func (r *Resp) Read() (Value, error) {
+	r.n = 0
+	return r.read()
+}
+
+func (r *Resp) read() (Value, error) {
+	_type, err := r.reader.ReadByte()
+
+	if err != nil {
+		return Value{}, err
+	}
+	if err := r.count(1); err != nil {
+		return Value{}, err
+	}
+
+	switch _type {
+	case ARRAY:
+		return r.readArray()
+	case PUSH:
+		v, err := r.readArray()
+		v.Type = "push"
+		return v, err
+	case MAP:
+		return r.readMap()
+	case BULK:
+		return r.readBulk()
+	case INTEGER:
+		num, _, err := r.readInteger()
+		return Value{Type: "integer", Num: num}, err
+	case STRING, ERROR:
+		line, _, err := r.readLine()
+		v := Value{Type: "string", Str: string(line)}
+		if _type == ERROR {
+			v.Type = "error"
+		}
+		return v, err
+	default:
+		return Value{}, fmt.Errorf("%w: unknown type %q", ErrProtocol, _type)
+	}
+}
+
+func (r *Resp) readArray() (Value, error) {
+	v := Value{}
+	v.Type = "array"
+
+	// read length of array
+	len, _, err := r.readInteger()
+	if err != nil {
+		return v, err
+	}
+	if len < 0 {
+		return Value{Type: "null"}, nil
+	}
+
+	// foreach line, parse and read the value
+	v.Array = make([]Value, 0)
+	for i := 0; i < len; i++ {
+		val, err := r.read()
+		if err != nil {
+			return v, err
+		}
+
+		// append parsed value to array
+		v.Array = append(v.Array, val)
+	}
+
+	return v, nil
+}
+
+func (r *Resp) readMap() (Value, error) {
+	v := Value{Type: "map"}
+
+	n, _, err := r.readInteger()
+	if err != nil {
+		return v, err
+	}
+
+	for i := 0; i < 2*n; i++ {
+		val, err := r.read()
+		if err != nil {
+			return v, err
+		}
+		v.Array = append(v.Array, val)
+	}
+
+	return v, nil
+}
+
+func (r *Resp) readBulk() (Value, error) {
+	v := Value{}
+
+	v.Type = "bulk"
+
+	len, _, err := r.readInteger()
+	if err != nil {
+		return v, err
+	}
+	if len < 0 {
+		return Value{Type: "null"}, nil
+	}
+	if err := r.count(len); err != nil {
+		return v, err
+	}
+
+	bulk := make([]byte, len)
+
+	if _, err := io.ReadFull(r.reader, bulk); err != nil {
+		return v, err
+	}
+
+	v.Bulk = string(bulk)
+
+	// Read the trailing CRLF
+	r.readLine()
+
+	return v, nil
+}
+
+// Marshal Value to bytes
+func (v Value) Marshal() []byte {
+	switch v.Type {
+	case "array":
+		return v.marshalArray()
+	case "map":
+		return v.marshalMap()
+	case "push":
+		return v.marshalPush()
+	case "bulk":
+		return v.marshalBulk()
+	case "string":
+		return v.marshalString()
+	case "integer":
+		return v.marshalInteger()
+	case "null":
+		return v.marshallNull()
+	case "error":
+		return v.marshallError()
+	default:
+		return []byte{}
+	}
+}
+
+func (v Value) marshalString() []byte {
+	var bytes []byte
+	bytes = append(bytes, STRING)
+	bytes = append(bytes, v.Str...)
+	bytes = append(bytes, '\r', '\n')
+
+	return bytes
+}
+
+func (v Value) marshalInteger() []byte {
+	var bytes []byte
+	bytes = append(bytes, INTEGER)
+	bytes = append(bytes, strconv.Itoa(v.Num)...)
+	bytes = append(bytes, '\r', '\n')
+
+	return bytes
+}
+
+func (v Value) marshalBulk() []byte {
+	var bytes []byte
+	bytes = append(bytes, BULK)
+	bytes = append(bytes, strconv.Itoa(len(v.Bulk))...)
+	bytes = append(bytes, '\r', '\n')
+	bytes = append(bytes, v.Bulk...)
+	bytes = append(bytes, '\r', '\n')
+
+	return bytes
+}
+
+func (v Value) marshalArray() []byte {
+	len := len(v.Array)
+	var bytes []byte
+	bytes = append(bytes, ARRAY)
+	bytes = append(bytes, strconv.Itoa(len)...)
+	bytes = append(bytes, '\r', '\n')
+
+	for i := 0; i < len; i++ {
+		bytes = append(bytes, v.Array[i].Marshal()...)
+	}
+
+	return bytes
+}
+
+// marshalMap writes a RESP3 map; array holds the keys and values in turn.
+func (v Value) marshalMap() []byte {
+	var bytes []byte
+	bytes = append(bytes, MAP)
+	bytes = append(bytes, strconv.Itoa(len(v.Array)/2)...)
+	bytes = append(bytes, '\r', '\n')
+
+	for _, elem := range v.Array {
+		bytes = append(bytes, elem.Marshal()...)
+	}
+
+	return bytes
+}
+
+// marshalPush writes a RESP3 out of band push message.
+func (v Value) marshalPush() []byte {
+	var bytes []byte
+	bytes = append(bytes, PUSH)
+	bytes = append(bytes, strconv.Itoa(len(v.Array))...)
+	bytes = append(bytes, '\r', '\n')
+
+	for _, elem := range v.Array {
+		bytes = append(bytes, elem.Marshal()...)
+	}
+
+	return bytes
+}
+
+// RESP2 returns v with the RESP3 only types downgraded for clients
+// speaking RESP2, including those nested in arrays.
+func (v Value) RESP2() Value {
+	if v.Type == "map" || v.Type == "push" {
+		v.Type = "array"
+	}
+	if v.Type == "array" {
+		array := make([]Value, len(v.Array))
+		for i, elem := range v.Array {
+			array[i] = elem.RESP2()
+		}
+		v.Array = array
+	}
+	return v
+}
+
+func (v Value) marshallError() []byte {
+	var bytes []byte
+	bytes = append(bytes, ERROR)
+	bytes = append(bytes, v.Str...)
+	bytes = append(bytes, '\r', '\n')
+
+	return bytes
+}
+
+func (v Value) marshallNull() []byte {
+	return []byte("$-1\r\n")
+}
+
+// Writer
+
+// Writer buffers replies until Flush is called, so that the replies to a
+// pipeline of commands can go out in a single write.
+type Writer struct {
+	writer *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{writer: bufio.NewWriter(w)}
+}
+
+func (w *Writer) Write(v Value) error {
+	var bytes = v.Marshal()
+
+	_, err := w.writer.Write(bytes)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (w *Writer) Flush() error {
+	return w.writer.Flush()
+}
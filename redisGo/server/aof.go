@@ -0,0 +1,35 @@
+package server
+
+import (
+	"fmt"
+)
+
+// BGREWRITEAOF rewrites the AOF as the commands rebuilding the dataset, in
+// the background. Without a fork to snapshot the dataset, the rewrite
+// holds off every command until the new file is written.
+func (srv *Server) bgrewriteaof(args []Value) Value {
+	if !srv.aofRewriting.CompareAndSwap(false, true) {
+		return Value{Type: "error", Str: "ERR Background append only file rewriting already in progress"}
+	}
+
+	srv.goBackground(func() {
+		defer srv.aofRewriting.Store(false)
+
+		// The commands in flight, including the one that started the
+		// rewrite, complete first.
+		srv.execMu.Lock()
+		defer srv.execMu.Unlock()
+
+		// Closed meanwhile.
+		if srv.aofFile == nil {
+			return
+		}
+		if err := srv.aofFile.Rewrite(srv.writeDataset); err != nil {
+			fmt.Printf("Background AOF rewrite failed: %v\n", err)
+			return
+		}
+		fmt.Println("Background AOF rewrite finished successfully")
+	})
+
+	return Value{Type: "string", Str: "Background append only file rewriting started"}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"redisGo/aof"
+)
+
+func TestAofRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.aof")
+	f, err := aof.New(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	testServer.set(command("rewrite:a", "1").Array)
+	testServer.set(command("rewrite:a", "2").Array)
+	testServer.hset(command("rewrite:h", "f", "v").Array)
+	for _, args := range [][]string{{"SET", "rewrite:a", "1"}, {"SET", "rewrite:a", "2"}, {"HSET", "rewrite:h", "f", "v"}} {
+		f.Write(command(args...))
+	}
+
+	if err := f.Rewrite(testServer.writeDataset); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := map[string]int{}
+	f.Read(func(value Value) error {
+		args := []string{}
+		for _, arg := range value.Array {
+			args = append(args, arg.Bulk)
+		}
+		commands[strings.Join(args, " ")]++
+		return nil
+	})
+
+	if commands["SET rewrite:a 1"] != 0 || commands["SET rewrite:a 2"] != 1 || commands["HSET rewrite:h f v"] != 1 {
+		t.Errorf("Expected the rewritten file to hold the dataset, got %v", commands)
+	}
+
+	// Writes after the rewrite go to the new file.
+	f.Write(command("SET", "rewrite:b", "3"))
+	found := false
+	f.Read(func(value Value) error {
+		found = found || (len(value.Array) == 3 && value.Array[1].Bulk == "rewrite:b")
+		return nil
+	})
+	if !found {
+		t.Errorf("Expected writes to be appended to the rewritten file")
+	}
+
+	testServer.shardOf("rewrite:a").delete("rewrite:a")
+	testServer.shardOf("rewrite:h").delete("rewrite:h")
+}
+
+func TestAofLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.aof")
+	whole := string(command("SET", "load:a", "1").Marshal())
+
+	// A command cut short by a crash is dropped.
+	os.WriteFile(path, []byte(whole+"*3\r\n$3\r\nSET\r\n$6\r\nload:b\r\n$1\r\n2"), 0666)
+	srv, err := New(Options{AppendFilename: path})
+	if err != nil {
+		t.Fatalf("Expected a truncated AOF to load, got %v", err)
+	}
+	if value, err := srv.DB().Get("load:a"); err != nil || value != "1" {
+		t.Errorf("Expected 1, got %q, %v", value, err)
+	}
+	if _, err := srv.DB().Get("load:b"); err == nil {
+		t.Errorf("Expected the partial command to be dropped")
+	}
+	srv.Close()
+	if data, _ := os.ReadFile(path); string(data) != whole {
+		t.Errorf("Expected the AOF to be truncated to the whole commands, got %q", data)
+	}
+
+	// Anything but commands fails, without loading the rest.
+	for _, content := range []string{"+OK\r\n", "*0\r\n", "*1\r\n:1\r\n", "*x\r\n", "?\r\n"} {
+		os.WriteFile(path, []byte(content+whole), 0666)
+		srv, err := New(Options{AppendFilename: path})
+		if !errors.Is(err, aof.ErrCorrupt) {
+			t.Errorf("Expected %q to be refused as corrupt, got %v", content, err)
+		}
+		if err == nil {
+			srv.Close()
+		}
+	}
+}
+
+func TestBgrewriteaof(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.aof")
+	srv, err := New(Options{AppendFilename: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	conn, _ := net.Pipe()
+	client := srv.newClient(conn)
+	srv.call(client, command("SET", "bgrewrite:a", "1"))
+	srv.call(client, command("SET", "bgrewrite:a", "2"))
+
+	result := srv.call(client, command("BGREWRITEAOF"))
+	if result.Str != "Background append only file rewriting started" {
+		t.Fatalf("Expected the rewrite to start, got %v", result)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.aofRewriting.Load() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := srv.aofFile.Stats(); stats.Rewrites != 1 || stats.RewriteError != nil {
+		t.Errorf("Expected 1 successful rewrite, got %+v", stats)
+	}
+
+	// Writes after the rewrite are appended to the new file.
+	srv.call(client, command("SET", "bgrewrite:b", "3"))
+	data, _ := os.ReadFile(path)
+	if strings.Count(string(data), "bgrewrite:a") != 1 || !strings.Contains(string(data), "bgrewrite:b") {
+		t.Errorf("Expected the rewritten file plus the later write, got %q", data)
+	}
+}
@@ -0,0 +1,609 @@
+package server
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Bitmaps are not a type of their own but plain strings, addressed bit by
+// bit: bit 0 is the most significant bit of the first byte. Reading past the
+// end of the string gives zeros, and writing past it grows the string.
+
+// Bit offsets are limited to the 512MB a string can hold.
+const maxBitOffset = 1<<32 - 1
+
+func parseBitOffset(s string, hash bool, width int) (uint64, bool) {
+	multiplier := int64(1)
+	if hash && strings.HasPrefix(s, "#") {
+		s = s[1:]
+		multiplier = int64(width)
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > maxBitOffset/multiplier {
+		return 0, false
+	}
+	n *= multiplier
+	if n+int64(width) > maxBitOffset+1 {
+		return 0, false
+	}
+	return uint64(n), true
+}
+
+func getBit(s string, offset uint64) int {
+	i := offset >> 3
+	if i >= uint64(len(s)) {
+		return 0
+	}
+	return int(s[i]>>(7-offset&7)) & 1
+}
+
+// growBits returns value as a byte slice long enough to hold bits bits.
+func growBits(value string, bits uint64) []byte {
+	buf := []byte(value)
+	if n := int((bits + 7) / 8); n > len(buf) {
+		buf = append(buf, make([]byte, n-len(buf))...)
+	}
+	return buf
+}
+
+// bitRange converts the start and end arguments of BITCOUNT and BITPOS, which
+// may count from the end, into an inclusive range within [0, length). It
+// reports false if the range is empty.
+func bitRange(start, end, length int64) (int64, int64, bool) {
+	if start < 0 {
+		start += length
+	}
+	if end < 0 {
+		end += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= length {
+		end = length - 1
+	}
+	return start, end, start <= end
+}
+
+// parseBitUnit parses the optional BYTE or BIT argument of BITCOUNT and
+// BITPOS.
+func parseBitUnit(args []Value) (bit bool, ok bool) {
+	if len(args) == 0 {
+		return false, true
+	}
+	if len(args) > 1 {
+		return false, false
+	}
+	switch strings.ToUpper(args[0].Bulk) {
+	case "BYTE":
+		return false, true
+	case "BIT":
+		return true, true
+	}
+	return false, false
+}
+
+// lookupBitmap returns the string at key for the read only bit commands.
+func (srv *Server) lookupBitmap(key string) (string, bool, *Value) {
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	if t := sh.typeOf(key); t != "none" && t != "string" {
+		return "", false, &Value{Type: "error", Str: wrongTypeError}
+	}
+	value, ok := sh.getString(key)
+	return value, ok, nil
+}
+
+func (srv *Server) setbit(args []Value) Value {
+	key := args[0].Bulk
+
+	offset, ok := parseBitOffset(args[1].Bulk, false, 1)
+	if !ok {
+		return Value{Type: "error", Str: "ERR bit offset is not an integer or out of range"}
+	}
+	if args[2].Bulk != "0" && args[2].Bulk != "1" {
+		return Value{Type: "error", Str: "ERR bit is not an integer or out of range"}
+	}
+	on := args[2].Bulk == "1"
+
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+	if t := sh.typeOf(key); t != "none" && t != "string" {
+		sh.mu.Unlock()
+		return Value{Type: "error", Str: wrongTypeError}
+	}
+	value, _ := sh.getString(key)
+	buf := growBits(value, offset+1)
+	old := int(buf[offset>>3]>>(7-offset&7)) & 1
+	mask := byte(1) << (7 - offset&7)
+	if on {
+		buf[offset>>3] |= mask
+	} else {
+		buf[offset>>3] &^= mask
+	}
+	sh.setString(key, string(buf))
+	sh.mu.Unlock()
+
+	srv.notifyKeyspaceEvent(notifyString, "setbit", key)
+
+	return Value{Type: "integer", Num: old}
+}
+
+func (srv *Server) getbit(args []Value) Value {
+	offset, ok := parseBitOffset(args[1].Bulk, false, 1)
+	if !ok {
+		return Value{Type: "error", Str: "ERR bit offset is not an integer or out of range"}
+	}
+
+	value, _, errValue := srv.lookupBitmap(args[0].Bulk)
+	if errValue != nil {
+		return *errValue
+	}
+
+	return Value{Type: "integer", Num: getBit(value, offset)}
+}
+
+func (srv *Server) bitcount(args []Value) Value {
+	if len(args) == 2 || len(args) > 4 {
+		return Value{Type: "error", Str: "ERR syntax error"}
+	}
+
+	var start, end int64
+	bit := false
+	if len(args) >= 3 {
+		var err1, err2 error
+		start, err1 = strconv.ParseInt(args[1].Bulk, 10, 64)
+		end, err2 = strconv.ParseInt(args[2].Bulk, 10, 64)
+		if err1 != nil || err2 != nil {
+			return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+		}
+		var ok bool
+		if bit, ok = parseBitUnit(args[3:]); !ok {
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+	}
+
+	value, _, errValue := srv.lookupBitmap(args[0].Bulk)
+	if errValue != nil {
+		return *errValue
+	}
+
+	if len(args) == 1 {
+		start, end = 0, -1
+	}
+	length := int64(len(value))
+	if bit {
+		length *= 8
+	}
+	start, end, ok := bitRange(start, end, length)
+	if !ok {
+		return Value{Type: "integer", Num: 0}
+	}
+
+	count := 0
+	if bit {
+		for i := start; i <= end; i++ {
+			count += getBit(value, uint64(i))
+		}
+	} else {
+		for i := start; i <= end; i++ {
+			count += bits.OnesCount8(value[i])
+		}
+	}
+
+	return Value{Type: "integer", Num: count}
+}
+
+func (srv *Server) bitpos(args []Value) Value {
+	if args[1].Bulk != "0" && args[1].Bulk != "1" {
+		return Value{Type: "error", Str: "ERR The bit argument must be 1 or 0."}
+	}
+	want := 0
+	if args[1].Bulk == "1" {
+		want = 1
+	}
+	if len(args) > 5 {
+		return Value{Type: "error", Str: "ERR syntax error"}
+	}
+
+	start, end := int64(0), int64(-1)
+	endGiven := false
+	bit := false
+	if len(args) >= 3 {
+		var err error
+		if start, err = strconv.ParseInt(args[2].Bulk, 10, 64); err != nil {
+			return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+		}
+	}
+	if len(args) >= 4 {
+		var err error
+		if end, err = strconv.ParseInt(args[3].Bulk, 10, 64); err != nil {
+			return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+		}
+		endGiven = true
+		var ok bool
+		if bit, ok = parseBitUnit(args[4:]); !ok {
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+	}
+
+	value, found, errValue := srv.lookupBitmap(args[0].Bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	// A missing key is an empty string: all of its bits are clear.
+	if !found {
+		if want == 1 {
+			return Value{Type: "integer", Num: -1}
+		}
+		return Value{Type: "integer", Num: 0}
+	}
+
+	length := int64(len(value))
+	if bit {
+		length *= 8
+	}
+	start, end, ok := bitRange(start, end, length)
+	if !ok {
+		return Value{Type: "integer", Num: -1}
+	}
+	if !bit {
+		start, end = start*8, end*8+7
+	}
+
+	for i := start; i <= end; i++ {
+		if getBit(value, uint64(i)) == want {
+			return Value{Type: "integer", Num: int(i)}
+		}
+	}
+
+	// Without an explicit end the string counts as padded with zeros on the
+	// right, so the first clear bit is just past it.
+	if want == 0 && !endGiven {
+		return Value{Type: "integer", Num: int(end + 1)}
+	}
+	return Value{Type: "integer", Num: -1}
+}
+
+func (srv *Server) bitop(args []Value) Value {
+	op := strings.ToUpper(args[0].Bulk)
+	if op != "AND" && op != "OR" && op != "XOR" && op != "NOT" {
+		return Value{Type: "error", Str: "ERR syntax error"}
+	}
+	if op == "NOT" && len(args) != 3 {
+		return Value{Type: "error", Str: "ERR BITOP NOT must be called with a single source key."}
+	}
+
+	dest := args[1].Bulk
+	keys := []string{dest}
+	for _, arg := range args[2:] {
+		keys = append(keys, arg.Bulk)
+	}
+	for _, key := range keys {
+		srv.expireIfNeeded(key)
+	}
+
+	unlock := srv.lockKeys(keys)
+
+	sources := [][]byte{}
+	maxLen := 0
+	for _, key := range keys[1:] {
+		sh := srv.shardOf(key)
+		if t := sh.typeOf(key); t != "none" && t != "string" {
+			unlock()
+			return Value{Type: "error", Str: wrongTypeError}
+		}
+		value, _ := sh.getString(key)
+		source := []byte(value)
+		sources = append(sources, source)
+		if len(source) > maxLen {
+			maxLen = len(source)
+		}
+	}
+
+	// Shorter strings count as padded with zero bytes.
+	result := make([]byte, maxLen)
+	for i := range result {
+		var b byte
+		for j, source := range sources {
+			var s byte
+			if i < len(source) {
+				s = source[i]
+			}
+			switch {
+			case j == 0:
+				b = s
+			case op == "AND":
+				b &= s
+			case op == "OR":
+				b |= s
+			case op == "XOR":
+				b ^= s
+			}
+		}
+		if op == "NOT" {
+			b = ^b
+		}
+		result[i] = b
+	}
+
+	sh := srv.shardOf(dest)
+	existed := sh.delete(dest)
+	if len(result) > 0 {
+		sh.setString(dest, string(result))
+	}
+	unlock()
+
+	if len(result) > 0 {
+		srv.notifyKeyspaceEvent(notifyString, "set", dest)
+	} else if existed {
+		srv.notifyKeyspaceEvent(notifyGeneric, "del", dest)
+	}
+
+	return Value{Type: "integer", Num: len(result)}
+}
+
+// bitfieldType is an integer type of BITFIELD, like i8 or u16.
+type bitfieldType struct {
+	signed bool
+	width  int
+}
+
+func parseBitfieldType(s string) (bitfieldType, bool) {
+	if len(s) < 2 || (s[0] != 'i' && s[0] != 'u') {
+		return bitfieldType{}, false
+	}
+	t := bitfieldType{signed: s[0] == 'i'}
+
+	width, err := strconv.Atoi(s[1:])
+	if err != nil || width < 1 || (t.signed && width > 64) || (!t.signed && width > 63) {
+		return bitfieldType{}, false
+	}
+	t.width = width
+	return t, true
+}
+
+type bitfieldOp struct {
+	op       string
+	typ      bitfieldType
+	offset   uint64
+	value    int64
+	overflow string
+}
+
+// readBits reads width bits from offset; bits past the end of buf are zero.
+func readBits(buf []byte, offset uint64, width int) uint64 {
+	var v uint64
+	for i := 0; i < width; i++ {
+		pos := offset + uint64(i)
+		v <<= 1
+		if pos>>3 < uint64(len(buf)) {
+			v |= uint64(buf[pos>>3]>>(7-pos&7)) & 1
+		}
+	}
+	return v
+}
+
+func writeBits(buf []byte, offset uint64, width int, v uint64) {
+	for i := 0; i < width; i++ {
+		pos := offset + uint64(i)
+		mask := byte(1) << (7 - pos&7)
+		if v>>(width-1-i)&1 == 1 {
+			buf[pos>>3] |= mask
+		} else {
+			buf[pos>>3] &^= mask
+		}
+	}
+}
+
+// get reads the field, sign extending signed ones.
+func (t bitfieldType) get(buf []byte, offset uint64) int64 {
+	v := readBits(buf, offset, t.width)
+	if t.signed && t.width < 64 && v>>(t.width-1)&1 == 1 {
+		v |= math.MaxUint64 << t.width
+	}
+	return int64(v)
+}
+
+// add returns value plus incr within the range of the type. With WRAP the
+// result wraps around, with SAT it saturates at the minimum or maximum, and
+// with FAIL ok is false when the result is out of range. SET checks the new
+// value with an incr of zero.
+func (t bitfieldType) add(value, incr int64, overflow string) (result int64, ok bool) {
+	if !t.signed {
+		max := uint64(1)<<t.width - 1
+		v := uint64(value)
+		wrapped := int64((v + uint64(incr)) & max)
+
+		maxincr := int64(max - v)
+		minincr := -int64(v)
+		if v > max || (incr > 0 && incr > maxincr) {
+			switch overflow {
+			case "WRAP":
+				return wrapped, true
+			case "SAT":
+				return int64(max), true
+			}
+			return 0, false
+		}
+		if incr < 0 && incr < minincr {
+			switch overflow {
+			case "WRAP":
+				return wrapped, true
+			case "SAT":
+				return 0, true
+			}
+			return 0, false
+		}
+		return value + incr, true
+	}
+
+	max := int64(math.MaxInt64)
+	if t.width < 64 {
+		max = 1<<(t.width-1) - 1
+	}
+	min := -max - 1
+
+	wrapped := uint64(value) + uint64(incr)
+	if t.width < 64 {
+		if wrapped>>(t.width-1)&1 == 1 {
+			wrapped |= math.MaxUint64 << t.width
+		} else {
+			wrapped &= ^(math.MaxUint64 << t.width)
+		}
+	}
+
+	// The differences below only overflow int64 for 64 bit fields, which are
+	// checked separately.
+	maxincr := max - value
+	minincr := min - value
+	if value > max || (t.width != 64 && incr > maxincr) || (value >= 0 && incr > 0 && incr > maxincr) {
+		switch overflow {
+		case "WRAP":
+			return int64(wrapped), true
+		case "SAT":
+			return max, true
+		}
+		return 0, false
+	}
+	if value < min || (t.width != 64 && incr < minincr) || (value < 0 && incr < 0 && incr < minincr) {
+		switch overflow {
+		case "WRAP":
+			return int64(wrapped), true
+		case "SAT":
+			return min, true
+		}
+		return 0, false
+	}
+	return value + incr, true
+}
+
+func (srv *Server) bitfield(args []Value) Value {
+	return srv.bitfieldGeneric(args, false)
+}
+
+func (srv *Server) bitfieldRO(args []Value) Value {
+	return srv.bitfieldGeneric(args, true)
+}
+
+func (srv *Server) bitfieldGeneric(args []Value, readonly bool) Value {
+	key := args[0].Bulk
+
+	ops := []bitfieldOp{}
+	overflow := "WRAP"
+	write := false
+	for i := 1; i < len(args); i++ {
+		sub := strings.ToUpper(args[i].Bulk)
+		remaining := len(args) - i - 1
+
+		if sub == "OVERFLOW" && remaining >= 1 {
+			overflow = strings.ToUpper(args[i+1].Bulk)
+			if overflow != "WRAP" && overflow != "SAT" && overflow != "FAIL" {
+				return Value{Type: "error", Str: "ERR Invalid OVERFLOW type specified"}
+			}
+			i++
+			continue
+		}
+
+		if !((sub == "GET" && remaining >= 2) || ((sub == "SET" || sub == "INCRBY") && remaining >= 3)) {
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+
+		typ, ok := parseBitfieldType(args[i+1].Bulk)
+		if !ok {
+			return Value{Type: "error", Str: "ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is."}
+		}
+		offset, ok := parseBitOffset(args[i+2].Bulk, true, typ.width)
+		if !ok {
+			return Value{Type: "error", Str: "ERR bit offset is not an integer or out of range"}
+		}
+
+		op := bitfieldOp{op: sub, typ: typ, offset: offset, overflow: overflow}
+		if sub == "GET" {
+			i += 2
+		} else {
+			if readonly {
+				return Value{Type: "error", Str: "ERR BITFIELD_RO only supports the GET subcommand"}
+			}
+			n, err := strconv.ParseInt(args[i+3].Bulk, 10, 64)
+			if err != nil {
+				return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+			}
+			op.value = n
+			write = true
+			i += 3
+		}
+		ops = append(ops, op)
+	}
+
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+	if t := sh.typeOf(key); t != "none" && t != "string" {
+		sh.mu.Unlock()
+		return Value{Type: "error", Str: wrongTypeError}
+	}
+
+	// The string grows to fit every field written, even those that fail.
+	value, _ := sh.getString(key)
+	buf := []byte(value)
+	if write {
+		var end uint64
+		for _, op := range ops {
+			if op.op != "GET" && op.offset+uint64(op.typ.width) > end {
+				end = op.offset + uint64(op.typ.width)
+			}
+		}
+		buf = growBits(value, end)
+	}
+
+	replies := []Value{}
+	for _, op := range ops {
+		old := op.typ.get(buf, op.offset)
+
+		switch op.op {
+		case "GET":
+			replies = append(replies, Value{Type: "integer", Num: int(old)})
+		case "SET":
+			v, ok := op.typ.add(op.value, 0, op.overflow)
+			if !ok {
+				replies = append(replies, Value{Type: "null"})
+				continue
+			}
+			writeBits(buf, op.offset, op.typ.width, uint64(v))
+			replies = append(replies, Value{Type: "integer", Num: int(old)})
+		case "INCRBY":
+			v, ok := op.typ.add(old, op.value, op.overflow)
+			if !ok {
+				replies = append(replies, Value{Type: "null"})
+				continue
+			}
+			writeBits(buf, op.offset, op.typ.width, uint64(v))
+			replies = append(replies, Value{Type: "integer", Num: int(v)})
+		}
+	}
+
+	if write {
+		sh.setString(key, string(buf))
+	}
+	sh.mu.Unlock()
+
+	if write {
+		srv.notifyKeyspaceEvent(notifyString, "setbit", key)
+	}
+
+	return Value{Type: "array", Array: replies}
+}
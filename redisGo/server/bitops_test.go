@@ -0,0 +1,178 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestSetbitGetbit(t *testing.T) {
+	if result := testServer.setbit(command("bits:a", "7", "1").Array); result.Num != 0 {
+		t.Errorf("Expected old bit 0, got %v", result)
+	}
+	if result := testServer.setbit(command("bits:a", "7", "1").Array); result.Num != 1 {
+		t.Errorf("Expected old bit 1, got %v", result)
+	}
+	if result := testServer.get(command("bits:a").Array); result.Bulk != "\x01" {
+		t.Errorf("Expected \"\\x01\", got %q", result.Bulk)
+	}
+
+	testServer.setbit(command("bits:a", "20", "1").Array)
+	if result := testServer.get(command("bits:a").Array); result.Bulk != "\x01\x00\x08" {
+		t.Errorf("Expected the string to grow to 3 bytes, got %q", result.Bulk)
+	}
+	if result := testServer.getbit(command("bits:a", "20").Array); result.Num != 1 {
+		t.Errorf("Expected bit 20 set, got %v", result)
+	}
+	if result := testServer.getbit(command("bits:a", "1000").Array); result.Num != 0 {
+		t.Errorf("Expected bits past the end to be clear, got %v", result)
+	}
+
+	if result := testServer.setbit(command("bits:a", "-1", "1").Array); result.Type != "error" {
+		t.Errorf("Expected an error for a negative offset, got %v", result)
+	}
+	if result := testServer.setbit(command("bits:a", "0", "2").Array); result.Type != "error" {
+		t.Errorf("Expected an error for a bit of 2, got %v", result)
+	}
+
+	testServer.hset(command("bits:hash", "f", "v").Array)
+	if result := testServer.setbit(command("bits:hash", "0", "1").Array); result.Str != wrongTypeError {
+		t.Errorf("Expected WRONGTYPE, got %v", result)
+	}
+}
+
+func TestBitcountBitpos(t *testing.T) {
+	testServer.set(command("bits:b", "foobar").Array)
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"bits:b"}, 26},
+		{[]string{"bits:b", "0", "0"}, 4},
+		{[]string{"bits:b", "1", "1"}, 6},
+		{[]string{"bits:b", "1", "1", "BYTE"}, 6},
+		{[]string{"bits:b", "5", "30", "BIT"}, 17},
+		{[]string{"bits:b", "-2", "-1"}, 7},
+		{[]string{"bits:missing"}, 0},
+	}
+	for _, test := range tests {
+		if result := testServer.bitcount(command(test.args...).Array); result.Num != test.want {
+			t.Errorf("BITCOUNT %v: expected %d, got %v", test.args, test.want, result)
+		}
+	}
+
+	testServer.set(command("bits:c", "\xff\xf0\x00").Array)
+	positions := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"bits:c", "0"}, 12},
+		{[]string{"bits:c", "1", "2"}, -1},
+		{[]string{"bits:c", "0", "2", "-1", "BIT"}, 12},
+		{[]string{"bits:c", "1", "7", "15", "BIT"}, 7},
+		{[]string{"bits:missing", "0"}, 0},
+		{[]string{"bits:missing", "1"}, -1},
+	}
+	for _, test := range positions {
+		if result := testServer.bitpos(command(test.args...).Array); result.Num != test.want {
+			t.Errorf("BITPOS %v: expected %d, got %v", test.args, test.want, result)
+		}
+	}
+
+	// Without an end, the first clear bit of an all ones string is past it.
+	testServer.set(command("bits:ones", "\xff\xff").Array)
+	if result := testServer.bitpos(command("bits:ones", "0").Array); result.Num != 16 {
+		t.Errorf("Expected 16, got %v", result)
+	}
+	if result := testServer.bitpos(command("bits:ones", "0", "0", "-1").Array); result.Num != -1 {
+		t.Errorf("Expected -1 with an explicit end, got %v", result)
+	}
+}
+
+func TestBitop(t *testing.T) {
+	testServer.set(command("bits:x", "\xf0\x0f").Array)
+	testServer.set(command("bits:y", "\xff").Array)
+
+	tests := []struct {
+		op   string
+		want string
+	}{
+		{"AND", "\xf0\x00"},
+		{"OR", "\xff\x0f"},
+		{"XOR", "\x0f\x0f"},
+	}
+	for _, test := range tests {
+		if result := testServer.bitop(command(test.op, "bits:dest", "bits:x", "bits:y").Array); result.Num != 2 {
+			t.Errorf("BITOP %s: expected a length of 2, got %v", test.op, result)
+		}
+		if result := testServer.get(command("bits:dest").Array); result.Bulk != test.want {
+			t.Errorf("BITOP %s: expected %q, got %q", test.op, test.want, result.Bulk)
+		}
+	}
+
+	testServer.bitop(command("NOT", "bits:dest", "bits:x").Array)
+	if result := testServer.get(command("bits:dest").Array); result.Bulk != "\x0f\xf0" {
+		t.Errorf("BITOP NOT: expected \"\\x0f\\xf0\", got %q", result.Bulk)
+	}
+	if result := testServer.bitop(command("NOT", "bits:dest", "bits:x", "bits:y").Array); result.Type != "error" {
+		t.Errorf("Expected an error for NOT with two keys, got %v", result)
+	}
+
+	// An empty result deletes the destination.
+	testServer.bitop(command("AND", "bits:dest", "bits:missing", "bits:missing2").Array)
+	if testServer.keyExists("bits:dest") {
+		t.Errorf("Expected bits:dest to be deleted")
+	}
+}
+
+func TestBitfield(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []Value
+	}{
+		{[]string{"bits:f", "SET", "i8", "0", "100", "GET", "i8", "0"}, []Value{{Type: "integer", Num: 0}, {Type: "integer", Num: 100}}},
+		{[]string{"bits:f", "INCRBY", "i8", "0", "100"}, []Value{{Type: "integer", Num: -56}}},
+		{[]string{"bits:f", "OVERFLOW", "SAT", "INCRBY", "i8", "0", "-100"}, []Value{{Type: "integer", Num: -128}}},
+		{[]string{"bits:f", "OVERFLOW", "FAIL", "INCRBY", "i8", "0", "-1"}, []Value{{Type: "null"}}},
+		{[]string{"bits:f", "GET", "u4", "0", "GET", "u4", "4"}, []Value{{Type: "integer", Num: 8}, {Type: "integer", Num: 0}}},
+		{[]string{"bits:g", "SET", "u2", "#1", "3", "GET", "u8", "0"}, []Value{{Type: "integer", Num: 0}, {Type: "integer", Num: 48}}},
+		{[]string{"bits:g", "OVERFLOW", "SAT", "SET", "u2", "0", "7", "OVERFLOW", "WRAP", "INCRBY", "u2", "2", "2"}, []Value{{Type: "integer", Num: 0}, {Type: "integer", Num: 1}}},
+		{[]string{"bits:h", "SET", "i64", "0", "-1", "INCRBY", "i64", "0", "1"}, []Value{{Type: "integer", Num: 0}, {Type: "integer", Num: 0}}},
+		{[]string{"bits:h", "SET", "i64", "0", "9223372036854775807", "OVERFLOW", "SAT", "INCRBY", "i64", "0", "1"}, []Value{{Type: "integer", Num: 0}, {Type: "integer", Num: 9223372036854775807}}},
+		{[]string{"bits:h", "OVERFLOW", "WRAP", "INCRBY", "i64", "0", "1"}, []Value{{Type: "integer", Num: -9223372036854775808}}},
+		{[]string{"bits:h", "SET", "u63", "0", "-1"}, []Value{{Type: "integer", Num: 1 << 62}}},
+	}
+	for _, test := range tests {
+		result := testServer.bitfield(command(test.args...).Array)
+		if len(result.Array) != len(test.want) {
+			t.Errorf("BITFIELD %v: expected %v, got %v", test.args, test.want, result)
+			continue
+		}
+		for i, want := range test.want {
+			if result.Array[i].Type != want.Type || result.Array[i].Num != want.Num {
+				t.Errorf("BITFIELD %v: expected %v, got %v", test.args, test.want, result.Array)
+				break
+			}
+		}
+	}
+
+	errors := [][]string{
+		{"bits:f", "GET", "u64", "0"},
+		{"bits:f", "GET", "i65", "0"},
+		{"bits:f", "GET", "x8", "0"},
+		{"bits:f", "SET", "i8", "-1", "0"},
+		{"bits:f", "OVERFLOW", "MAYBE"},
+		{"bits:f", "INCRBY", "i8", "0"},
+	}
+	for _, args := range errors {
+		if result := testServer.bitfield(command(args...).Array); result.Type != "error" {
+			t.Errorf("BITFIELD %v: expected an error, got %v", args, result)
+		}
+	}
+
+	if result := testServer.bitfieldRO(command("bits:f", "SET", "i8", "0", "1").Array); result.Type != "error" {
+		t.Errorf("Expected BITFIELD_RO to refuse SET, got %v", result)
+	}
+	if result := testServer.bitfield(command("bits:missing", "GET", "i8", "0").Array); result.Array[0].Num != 0 || testServer.keyExists("bits:missing") {
+		t.Errorf("Expected GET on a missing key to read 0 without creating it, got %v", result)
+	}
+}
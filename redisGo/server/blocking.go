@@ -0,0 +1,91 @@
+package server
+
+import (
+	"time"
+)
+
+type keyWatch struct {
+	srv  *Server
+	keys []string
+	ch   chan struct{}
+}
+
+// watchKeys registers interest in keys. It must be called before checking
+// for data so that a signal arriving in between is not lost.
+func (srv *Server) watchKeys(keys []string) *keyWatch {
+	w := &keyWatch{srv: srv, keys: keys, ch: make(chan struct{}, 1)}
+
+	srv.keyWaitersMu.Lock()
+	for _, key := range keys {
+		if srv.keyWaiters[key] == nil {
+			srv.keyWaiters[key] = map[chan struct{}]bool{}
+		}
+		srv.keyWaiters[key][w.ch] = true
+	}
+	srv.keyWaitersMu.Unlock()
+
+	return w
+}
+
+func (w *keyWatch) stop() {
+	w.srv.keyWaitersMu.Lock()
+	for _, key := range w.keys {
+		delete(w.srv.keyWaiters[key], w.ch)
+		if len(w.srv.keyWaiters[key]) == 0 {
+			delete(w.srv.keyWaiters, key)
+		}
+	}
+	w.srv.keyWaitersMu.Unlock()
+}
+
+// wait parks the client until one of the keys is signalled. It returns
+// false if the deadline passed or the client was killed first; a zero
+// deadline waits forever.
+//
+// Blocking commands run with execMu held for reading, which would stall
+// any script behind them, so it is released while waiting. Within EXEC,
+// which holds it exclusively, they time out at once.
+func (w *keyWatch) wait(c *Client, deadline time.Time) bool {
+	if c.multi.exec {
+		return false
+	}
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false
+		}
+		timer := time.NewTimer(remaining)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	// Replies to the commands pipelined before this one must not wait for
+	// it to be unblocked.
+	c.Flush()
+
+	w.srv.execMu.RUnlock()
+	defer w.srv.execMu.RLock()
+
+	select {
+	case <-w.ch:
+		return true
+	case <-timeout:
+		return false
+	case <-c.done:
+		return false
+	}
+}
+
+func (srv *Server) signalKeyAsReady(key string) {
+	srv.keyWaitersMu.Lock()
+	defer srv.keyWaitersMu.Unlock()
+
+	for ch := range srv.keyWaiters[key] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,718 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"redisGo/resp"
+)
+
+// Client holds the per-connection state of everyone talking to the server.
+type Client struct {
+	srv       *Server
+	id        int64
+	conn      net.Conn
+	createdAt time.Time
+
+	mu              sync.Mutex
+	name            string
+	lastCmd         string
+	lastInteraction time.Time
+	monitor         bool
+	killed          bool
+	protocol        int
+	tracking        trackingState
+	asking          bool
+	replicaPort     int
+	master          bool
+	multi           multiState
+
+	// Guarded by watchedKeysMu.
+	watched    map[string]bool
+	watchDirty bool
+
+	// Guarded by pubSubMu.
+	channels map[string]bool
+	patterns map[string]bool
+
+	// done is closed when the client is killed, waking it up if it is
+	// blocked on a command.
+	done chan struct{}
+
+	// reader persists across commands so that pipelined requests already
+	// buffered are not lost. writer is shared with the goroutines pushing
+	// messages to the client and is guarded by writeMu.
+	reader  *resp.Resp
+	writeMu sync.Mutex
+	writer  *resp.Writer
+
+	// The writer of a connected client flushes into out, which writeOut
+	// writes to the connection, sending bytes at a time while writing, then
+	// keeps as spare for the next replies; see output.go. outReady wakes
+	// send up. Guarded by writeMu.
+	out            []byte
+	spare          []byte
+	sending        int
+	writing        bool
+	softLimitSince time.Time
+	outReady       chan struct{}
+
+	// These mirror replicas and the subscriptions, for the output buffer
+	// limits, which are checked with writeMu held.
+	replica    atomic.Bool
+	subscribed atomic.Bool
+}
+
+// newClient returns the state of a client talking over conn. Without a
+// connection, the client runs commands for a DB and its replies are
+// discarded once returned.
+func (srv *Server) newClient(conn net.Conn) *Client {
+	now := time.Now()
+
+	c := &Client{
+		srv:             srv,
+		id:              srv.nextClientID.Add(1),
+		conn:            conn,
+		createdAt:       now,
+		lastInteraction: now,
+		done:            make(chan struct{}),
+		protocol:        2,
+		channels:        map[string]bool{},
+		patterns:        map[string]bool{},
+	}
+	if conn == nil {
+		c.writer = resp.NewWriter(io.Discard)
+	} else {
+		c.reader = resp.NewResp(conn)
+		c.writer = resp.NewWriter(outputQueue{c})
+		c.outReady = make(chan struct{}, 1)
+		go c.send()
+	}
+
+	return c
+}
+
+// remoteAddr returns the address of the peer, or "" without a connection.
+func (c *Client) remoteAddr() string {
+	if c.conn == nil {
+		return ""
+	}
+	if c.conn.LocalAddr().Network() == "unix" {
+		return unixAddr(c.conn)
+	}
+	return c.conn.RemoteAddr().String()
+}
+
+// localAddr returns the address the client reached us on, or "" without a
+// connection.
+func (c *Client) localAddr() string {
+	if c.conn == nil {
+		return ""
+	}
+	if c.conn.LocalAddr().Network() == "unix" {
+		return unixAddr(c.conn)
+	}
+	return c.conn.LocalAddr().String()
+}
+
+// unixAddr names both ends of a Unix domain socket connection as Redis
+// does, after the path of the socket with a port of 0: the peer is
+// unnamed.
+func unixAddr(conn net.Conn) string {
+	return conn.LocalAddr().String() + ":0"
+}
+
+// Write sends a reply to the client right away, without waiting for the
+// client to take it. It is safe to call from other goroutines, which is
+// how MONITOR output reaches its subscribers.
+func (c *Client) Write(v Value) error {
+	if err := c.writeBuffered(v); err != nil {
+		return err
+	}
+
+	return c.Flush()
+}
+
+// writeBuffered queues a reply without sending it. The connection loop uses
+// it for replies to pipelined commands and flushes once the input is drained.
+func (c *Client) writeBuffered(v Value) error {
+	if c.resp() < 3 {
+		v = v.RESP2()
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return c.writer.Write(v)
+}
+
+// Flush sends the replies queued so far, leaving the writing to the
+// connection to a goroutine of the client's own.
+func (c *Client) Flush() error {
+	c.writeMu.Lock()
+	err := c.writer.Flush()
+	c.writeMu.Unlock()
+
+	if c.outReady != nil {
+		select {
+		case c.outReady <- struct{}{}:
+		default:
+		}
+	}
+	return err
+}
+
+// resp returns the protocol version negotiated with HELLO.
+func (c *Client) resp() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.protocol
+}
+
+func (c *Client) touch(command string) {
+	c.mu.Lock()
+	c.lastCmd = strings.ToLower(command)
+	c.lastInteraction = time.Now()
+	c.mu.Unlock()
+}
+
+func (c *Client) kill() {
+	c.mu.Lock()
+	if !c.killed {
+		c.killed = true
+		close(c.done)
+	}
+	c.mu.Unlock()
+
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+func (c *Client) isKilled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.killed
+}
+
+func (c *Client) info() string {
+	c.srv.pubSubMu.RLock()
+	sub, psub := len(c.channels), len(c.patterns)
+	c.srv.pubSubMu.RUnlock()
+
+	c.srv.replicasMu.Lock()
+	_, replica := c.srv.replicas[c]
+	c.srv.replicasMu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	flags := ""
+	if c.monitor {
+		flags += "O"
+	}
+	if replica {
+		flags += "S"
+	}
+	if c.master {
+		flags += "M"
+	}
+	if sub+psub > 0 {
+		flags += "P"
+	}
+	if c.tracking.enabled {
+		flags += "t"
+	}
+	if c.tracking.bcast {
+		flags += "B"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=0 sub=%d psub=%d cmd=%s resp=%d",
+		c.id,
+		c.remoteAddr(),
+		c.localAddr(),
+		c.name,
+		int(now.Sub(c.createdAt).Seconds()),
+		int(now.Sub(c.lastInteraction).Seconds()),
+		flags,
+		sub,
+		psub,
+		c.lastCmd,
+		c.protocol,
+	)
+}
+
+// clientType is the type CLIENT LIST TYPE filters on: the connection of
+// a replica to its primary is "master", otherwise it is the class of its
+// output buffer limit.
+func (c *Client) clientType() string {
+	c.mu.Lock()
+	master := c.master
+	c.mu.Unlock()
+
+	if master {
+		return "master"
+	}
+	return c.outputClass()
+}
+
+// Client registry
+
+func (srv *Server) addClient(c *Client) {
+	srv.clientsMu.Lock()
+	srv.clients[c.id] = c
+	srv.clientsMu.Unlock()
+
+	srv.stats.totalConnections.Add(1)
+}
+
+func (srv *Server) removeClient(c *Client) {
+	srv.clientsMu.Lock()
+	delete(srv.clients, c.id)
+	srv.clientsMu.Unlock()
+
+	srv.monitorsMu.Lock()
+	delete(srv.monitors, c.id)
+	srv.monitorsMu.Unlock()
+
+	srv.removeReplica(c)
+	srv.unsubscribeAll(c)
+	srv.disableTracking(c)
+	srv.unwatchAllKeys(c)
+}
+
+// sortedClients returns a snapshot of the registry ordered by client id.
+func (srv *Server) sortedClients() []*Client {
+	srv.clientsMu.RLock()
+	defer srv.clientsMu.RUnlock()
+
+	clients := make([]*Client, 0, len(srv.clients))
+	for _, c := range srv.clients {
+		clients = append(clients, c)
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].id < clients[j].id
+	})
+
+	return clients
+}
+
+var clientHandlers = map[string]func(*Server, *Client, []Value) Value{
+	"CLIENT":       (*Server).client,
+	"HELLO":        (*Server).hello,
+	"MONITOR":      (*Server).monitor,
+	"XREAD":        (*Server).xread,
+	"XREADGROUP":   (*Server).xreadgroup,
+	"SUBSCRIBE":    (*Server).subscribe,
+	"PSUBSCRIBE":   (*Server).psubscribe,
+	"UNSUBSCRIBE":  (*Server).unsubscribe,
+	"PUNSUBSCRIBE": (*Server).punsubscribe,
+	"CLUSTER":      (*Server).cluster,
+	"ASKING":       (*Server).asking,
+	"REPLCONF":     (*Server).replconf,
+	"SYNC":         (*Server).syncCommand,
+	"SHUTDOWN":     (*Server).shutdown,
+	"MULTI":        (*Server).multiCommand,
+	"DISCARD":      (*Server).discardCommand,
+	"WATCH":        (*Server).watchCommand,
+	"UNWATCH":      (*Server).unwatchCommand,
+}
+
+func (srv *Server) client(c *Client, args []Value) Value {
+	name := args[0].Bulk
+	subcommand := strings.ToUpper(name)
+	args = args[1:]
+
+	switch subcommand {
+	case "ID":
+		return Value{Type: "integer", Num: int(c.id)}
+	case "GETNAME":
+		c.mu.Lock()
+		clientName := c.name
+		c.mu.Unlock()
+
+		if clientName == "" {
+			return Value{Type: "null"}
+		}
+		return Value{Type: "bulk", Bulk: clientName}
+	case "SETNAME":
+		return clientSetName(c, args)
+	case "LIST":
+		return srv.clientList(args)
+	case "KILL":
+		return srv.clientKill(c, args)
+	case "PAUSE":
+		return srv.clientPause(args)
+	case "UNPAUSE":
+		srv.unpauseClients()
+		return Value{Type: "string", Str: "OK"}
+	case "TRACKING":
+		return srv.clientTracking(c, args)
+	case "CACHING":
+		return clientCaching(c, args)
+	case "GETREDIR":
+		return clientGetRedir(c)
+	case "TRACKINGINFO":
+		return clientTrackingInfo(c)
+	default:
+		return Value{Type: "error", Str: fmt.Sprintf("ERR unknown subcommand '%s'", name)}
+	}
+}
+
+func clientSetName(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{Type: "error", Str: "ERR wrong number of arguments for 'client|setname' command"}
+	}
+
+	name := args[0].Bulk
+	for _, r := range name {
+		if r <= ' ' || r > '~' {
+			return Value{Type: "error", Str: "ERR Client names cannot contain spaces, newlines or special characters."}
+		}
+	}
+
+	c.mu.Lock()
+	c.name = name
+	c.mu.Unlock()
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+func (srv *Server) clientList(args []Value) Value {
+	var ids map[int64]bool
+	typ := ""
+
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(args[i].Bulk)
+
+		switch {
+		case option == "ID" && i+1 < len(args):
+			ids = map[int64]bool{}
+			for _, arg := range args[i+1:] {
+				id, err := strconv.ParseInt(arg.Bulk, 10, 64)
+				if err != nil || id <= 0 {
+					return Value{Type: "error", Str: "ERR Invalid client ID"}
+				}
+				ids[id] = true
+			}
+			i = len(args)
+		case option == "TYPE" && i+1 < len(args):
+			i++
+			typ = strings.ToLower(args[i].Bulk)
+			if typ == "slave" {
+				typ = "replica"
+			}
+			switch typ {
+			case "normal", "master", "replica", "pubsub":
+			default:
+				return Value{Type: "error", Str: fmt.Sprintf("ERR Unknown client type '%s'", args[i].Bulk)}
+			}
+		default:
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+	}
+
+	var sb strings.Builder
+	for _, c := range srv.sortedClients() {
+		if ids != nil && !ids[c.id] {
+			continue
+		}
+		if typ != "" && c.clientType() != typ {
+			continue
+		}
+
+		sb.WriteString(c.info())
+		sb.WriteString("\n")
+	}
+
+	return Value{Type: "bulk", Bulk: sb.String()}
+}
+
+func (srv *Server) clientKill(self *Client, args []Value) Value {
+	if len(args) == 0 {
+		return Value{Type: "error", Str: "ERR wrong number of arguments for 'client|kill' command"}
+	}
+
+	// Old style: CLIENT KILL addr:port
+	if len(args) == 1 {
+		for _, c := range srv.sortedClients() {
+			if c.remoteAddr() == args[0].Bulk {
+				c.kill()
+				return Value{Type: "string", Str: "OK"}
+			}
+		}
+
+		return Value{Type: "error", Str: "ERR No such client"}
+	}
+
+	if len(args)%2 != 0 {
+		return Value{Type: "error", Str: "ERR syntax error"}
+	}
+
+	var id int64
+	var addr, laddr string
+	skipMe := true
+
+	for i := 0; i < len(args); i += 2 {
+		option := strings.ToUpper(args[i].Bulk)
+		arg := args[i+1].Bulk
+
+		switch option {
+		case "ID":
+			n, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || n <= 0 {
+				return Value{Type: "error", Str: "ERR client-id should be greater than 0"}
+			}
+			id = n
+		case "ADDR":
+			addr = arg
+		case "LADDR":
+			laddr = arg
+		case "SKIPME":
+			switch strings.ToLower(arg) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return Value{Type: "error", Str: "ERR syntax error"}
+			}
+		default:
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+	}
+
+	killed := 0
+	for _, c := range srv.sortedClients() {
+		if id != 0 && c.id != id {
+			continue
+		}
+		if addr != "" && c.remoteAddr() != addr {
+			continue
+		}
+		if laddr != "" && c.localAddr() != laddr {
+			continue
+		}
+		if skipMe && c == self {
+			continue
+		}
+
+		c.kill()
+		killed++
+	}
+
+	return Value{Type: "integer", Num: killed}
+}
+
+// Client pause
+
+func (srv *Server) clientPause(args []Value) Value {
+	if len(args) != 1 && len(args) != 2 {
+		return Value{Type: "error", Str: "ERR wrong number of arguments for 'client|pause' command"}
+	}
+
+	ms, err := strconv.ParseInt(args[0].Bulk, 10, 64)
+	if err != nil || ms < 0 {
+		return Value{Type: "error", Str: "ERR timeout is not an integer or out of range"}
+	}
+
+	all := true
+	if len(args) == 2 {
+		switch strings.ToUpper(args[1].Bulk) {
+		case "ALL":
+			all = true
+		case "WRITE":
+			all = false
+		default:
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+	}
+
+	srv.pauseMu.Lock()
+	now := time.Now()
+	// A pause for all commands is never narrowed down by a later WRITE pause.
+	srv.pauseAll = all || (srv.pauseAll && now.Before(srv.pauseEnd))
+	end := now.Add(time.Duration(ms) * time.Millisecond)
+	if end.After(srv.pauseEnd) {
+		srv.pauseEnd = end
+	}
+	srv.pauseMu.Unlock()
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+func (srv *Server) unpauseClients() {
+	srv.pauseMu.Lock()
+	srv.pauseEnd = time.Time{}
+	close(srv.pauseCh)
+	srv.pauseCh = make(chan struct{})
+	srv.pauseMu.Unlock()
+}
+
+// waitForPause blocks the calling client while a CLIENT PAUSE covering
+// command is in effect. CLIENT itself is never paused so that CLIENT UNPAUSE
+// keeps working.
+func (srv *Server) waitForPause(command string) {
+	if command == "CLIENT" {
+		return
+	}
+
+	for {
+		srv.pauseMu.Lock()
+		remaining := time.Until(srv.pauseEnd)
+		paused := remaining > 0 && (srv.pauseAll || commandHasFlag(command, "write"))
+		ch := srv.pauseCh
+		srv.pauseMu.Unlock()
+
+		if !paused {
+			return
+		}
+
+		select {
+		case <-time.After(remaining):
+		case <-ch:
+		}
+	}
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func (srv *Server) hello(c *Client, args []Value) Value {
+	protocol := c.resp()
+
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0].Bulk)
+		if err != nil {
+			return Value{Type: "error", Str: "ERR Protocol version is not an integer or out of range"}
+		}
+		if n < 2 || n > 3 {
+			return Value{Type: "error", Str: "NOPROTO unsupported protocol version"}
+		}
+		protocol = n
+	}
+
+	name := ""
+	for i := 1; i < len(args); i++ {
+		option := strings.ToUpper(args[i].Bulk)
+
+		switch {
+		case option == "AUTH" && i+2 < len(args):
+			// There are no users besides the passwordless default one.
+			if args[i+1].Bulk != "default" {
+				return Value{Type: "error", Str: "WRONGPASS invalid username-password pair or user is disabled."}
+			}
+			i += 2
+		case option == "SETNAME" && i+1 < len(args):
+			name = args[i+1].Bulk
+			i++
+		default:
+			return Value{Type: "error", Str: fmt.Sprintf("ERR Syntax error in HELLO option '%s'", args[i].Bulk)}
+		}
+	}
+
+	if name != "" {
+		if reply := clientSetName(c, []Value{{Type: "bulk", Bulk: name}}); reply.Type == "error" {
+			return reply
+		}
+	}
+
+	c.mu.Lock()
+	c.protocol = protocol
+	c.mu.Unlock()
+
+	return Value{Type: "map", Array: []Value{
+		{Type: "bulk", Bulk: "server"}, {Type: "bulk", Bulk: "redis"},
+		{Type: "bulk", Bulk: "version"}, {Type: "bulk", Bulk: version},
+		{Type: "bulk", Bulk: "proto"}, {Type: "integer", Num: protocol},
+		{Type: "bulk", Bulk: "id"}, {Type: "integer", Num: int(c.id)},
+		{Type: "bulk", Bulk: "mode"}, {Type: "bulk", Bulk: "standalone"},
+		{Type: "bulk", Bulk: "role"}, {Type: "bulk", Bulk: "master"},
+		{Type: "bulk", Bulk: "modules"}, {Type: "array", Array: []Value{}},
+	}}
+}
+
+// Monitor
+
+func (srv *Server) monitor(c *Client, args []Value) Value {
+	c.mu.Lock()
+	c.monitor = true
+	c.mu.Unlock()
+
+	srv.monitorsMu.Lock()
+	srv.monitors[c.id] = c
+	srv.monitorsMu.Unlock()
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+// feedMonitors streams a command, in the same format as Redis, to every
+// client that issued MONITOR. source is the address of the client that sent
+// the command, or "lua" for commands run by a script.
+func (srv *Server) feedMonitors(source string, value Value) {
+	srv.monitorsMu.RLock()
+	defer srv.monitorsMu.RUnlock()
+
+	if len(srv.monitors) == 0 {
+		return
+	}
+
+	now := time.Now()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d.%06d [0 %s]", now.Unix(), now.Nanosecond()/1000, source)
+	for _, arg := range value.Array {
+		sb.WriteString(" ")
+		sb.WriteString(repr(arg.Bulk))
+	}
+
+	line := Value{Type: "string", Str: sb.String()}
+	for _, m := range srv.monitors {
+		m.Write(line)
+	}
+}
+
+// repr quotes s the way Redis does in MONITOR output.
+func repr(s string) string {
+	var sb strings.Builder
+
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; b {
+		case '\\', '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(b)
+		case '\n':
+			sb.WriteString("\\n")
+		case '\r':
+			sb.WriteString("\\r")
+		case '\t':
+			sb.WriteString("\\t")
+		case '\a':
+			sb.WriteString("\\a")
+		case '\b':
+			sb.WriteString("\\b")
+		default:
+			if b < ' ' || b > '~' {
+				fmt.Fprintf(&sb, "\\x%02x", b)
+			} else {
+				sb.WriteByte(b)
+			}
+		}
+	}
+	sb.WriteByte('"')
+
+	return sb.String()
+}
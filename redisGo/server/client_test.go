@@ -0,0 +1,137 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientSetName(t *testing.T) {
+	client := newTestClient()
+	testServer.addClient(client)
+	defer testServer.removeClient(client)
+
+	result := testServer.call(client, command("CLIENT", "SETNAME", "worker-1"))
+	if result.Str != "OK" {
+		t.Fatalf("Expected OK, got %v", result)
+	}
+
+	result = testServer.call(client, command("CLIENT", "GETNAME"))
+	if result.Bulk != "worker-1" {
+		t.Errorf("Expected name worker-1, got %v", result)
+	}
+
+	result = testServer.call(client, command("CLIENT", "SETNAME", "worker 1"))
+	if result.Type != "error" {
+		t.Errorf("Expected an error for a name with a space, got %v", result)
+	}
+
+	result = testServer.call(client, command("CLIENT", "LIST", "ID", fmt.Sprint(client.id)))
+	lines := strings.Split(strings.TrimSuffix(result.Bulk, "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected 1 client, got %q", result.Bulk)
+	}
+	if !strings.HasPrefix(lines[0], fmt.Sprintf("id=%d ", client.id)) || !strings.Contains(lines[0], " name=worker-1 ") {
+		t.Errorf("Expected the client named worker-1, got %q", lines[0])
+	}
+
+	result = testServer.call(client, command("CLIENT", "LIST", "ID", "abc"))
+	if result.Type != "error" {
+		t.Errorf("Expected an error for an invalid id, got %v", result)
+	}
+}
+
+func TestClientListType(t *testing.T) {
+	normal := newTestClient()
+	testServer.addClient(normal)
+	defer testServer.removeClient(normal)
+
+	subscriber, _ := pipeClient()
+	testServer.addClient(subscriber)
+	defer testServer.removeClient(subscriber)
+	testServer.call(subscriber, command("SUBSCRIBE", "list:type"))
+
+	result := testServer.call(normal, command("CLIENT", "LIST", "TYPE", "pubsub"))
+	if !strings.Contains(result.Bulk, fmt.Sprintf("id=%d ", subscriber.id)) {
+		t.Errorf("Expected the subscriber, got %q", result.Bulk)
+	}
+	if strings.Contains(result.Bulk, fmt.Sprintf("id=%d ", normal.id)) {
+		t.Errorf("Expected no normal client, got %q", result.Bulk)
+	}
+
+	result = testServer.call(normal, command("CLIENT", "LIST", "TYPE", "normal"))
+	if !strings.Contains(result.Bulk, fmt.Sprintf("id=%d ", normal.id)) || strings.Contains(result.Bulk, fmt.Sprintf("id=%d ", subscriber.id)) {
+		t.Errorf("Expected only normal clients, got %q", result.Bulk)
+	}
+
+	result = testServer.call(normal, command("CLIENT", "LIST", "TYPE", "master"))
+	if result.Type != "bulk" || result.Bulk != "" {
+		t.Errorf("Expected no master client, got %v", result)
+	}
+
+	result = testServer.call(normal, command("CLIENT", "LIST", "TYPE", "bogus"))
+	if result.Type != "error" || result.Str != "ERR Unknown client type 'bogus'" {
+		t.Errorf("Expected an unknown client type error, got %v", result)
+	}
+}
+
+func TestClientPause(t *testing.T) {
+	client := newTestClient()
+	defer testServer.unpauseClients()
+
+	result := testServer.call(client, command("CLIENT", "PAUSE", "200", "WRITE"))
+	if result.Str != "OK" {
+		t.Fatalf("Expected OK, got %v", result)
+	}
+
+	start := time.Now()
+	testServer.call(client, command("GET", "pause:key"))
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected GET not to wait for a WRITE pause, took %v", elapsed)
+	}
+
+	testServer.call(client, command("SET", "pause:key", "1"))
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected SET to wait for the pause, took %v", elapsed)
+	}
+
+	testServer.call(client, command("CLIENT", "PAUSE", "10000"))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		testServer.call(newTestClient(), command("CLIENT", "UNPAUSE"))
+	}()
+
+	start = time.Now()
+	testServer.call(client, command("GET", "pause:key"))
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected CLIENT UNPAUSE to release GET, took %v", elapsed)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	monitor, messages := pipeClient()
+	defer testServer.removeClient(monitor)
+
+	result := testServer.call(monitor, command("MONITOR"))
+	if result.Str != "OK" {
+		t.Fatalf("Expected OK, got %v", result)
+	}
+
+	client := newTestClient()
+	testServer.call(client, command("NOSUCHCOMMAND", "monitor:key"))
+	testServer.call(client, command("GET"))
+	testServer.call(client, command("SET", "monitor:key", "a b"))
+
+	select {
+	case message := <-messages:
+		if !strings.HasSuffix(message.Str, `] "SET" "monitor:key" "a b"`) {
+			t.Errorf("Expected the SET command and not the rejected ones, got %q", message.Str)
+		}
+		if !strings.Contains(message.Str, " [0 "+client.remoteAddr()+"] ") {
+			t.Errorf("Expected the address of the client, got %q", message.Str)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the monitor to see the SET command")
+	}
+}
@@ -0,0 +1,1019 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"redisGo/resp"
+)
+
+// Redis Cluster. The keyspace is divided into 16384 hash slots, each served
+// by one node; commands on keys in a slot served elsewhere are answered with
+// a MOVED redirection, or with ASK while the slot is being migrated.
+//
+// Instead of the binary cluster bus, nodes learn about each other by asking
+// every known peer for its CLUSTER NODES view once a second over the client
+// port. A node is trusted for the slots it claims for itself, the higher
+// config epoch winning when two nodes claim the same slot.
+
+const clusterSlots = 16384
+
+type clusterNode struct {
+	name        string
+	ip          string
+	port        int
+	configEpoch uint64
+	myself      bool
+	handshake   bool
+	connected   bool
+	pongRecv    time.Time
+
+	// The link to the node, only used by the cluster cron.
+	conn   net.Conn
+	reader *resp.Resp
+}
+
+var crc16Table = func() [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// crc16 is the CCITT (XMODEM) variant used by Redis Cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+// keyHashSlot returns the slot of key. If the key contains a non-empty hash
+// tag between the first { and the next }, only the tag is hashed, which is
+// how related keys are kept in the same slot.
+func keyHashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) & (clusterSlots - 1))
+}
+
+func randomID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// clusterInit loads the node table from the cluster config file, or creates
+// a new node with a random name, and starts gossiping with the peers.
+func (srv *Server) clusterInit() error {
+	srv.clusterMu.Lock()
+	defer srv.clusterMu.Unlock()
+
+	loaded, err := srv.clusterLoadConfig()
+	if err != nil {
+		return err
+	}
+	if !loaded {
+		srv.myself = &clusterNode{name: randomID(), ip: "127.0.0.1", port: srv.port, myself: true}
+		srv.clusterNodes[srv.myself.name] = srv.myself
+		srv.clusterSaveConfig()
+	}
+
+	srv.goBackground(srv.clusterCron)
+
+	return nil
+}
+
+func (srv *Server) clusterLoadConfig() (bool, error) {
+	data, err := os.ReadFile(srv.clusterConfigFile)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	records := []clusterNodeRecord{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || strings.HasPrefix(line, "vars ") {
+			continue
+		}
+
+		record, err := parseClusterNodeLine(line)
+		if err != nil {
+			return false, fmt.Errorf("invalid cluster config file %s: %v", srv.clusterConfigFile, err)
+		}
+		records = append(records, record)
+
+		n := &clusterNode{name: record.name, ip: record.ip, port: record.port, configEpoch: record.configEpoch}
+		if record.flags["myself"] {
+			n.myself = true
+			n.port = srv.port
+			srv.myself = n
+		}
+		srv.clusterNodes[n.name] = n
+	}
+	if srv.myself == nil {
+		return false, fmt.Errorf("invalid cluster config file %s: no myself node", srv.clusterConfigFile)
+	}
+
+	// Slots can only be resolved once every node is known.
+	for _, record := range records {
+		for _, slot := range record.slots {
+			srv.clusterSlots[slot] = srv.clusterNodes[record.name]
+		}
+		for slot, name := range record.migrating {
+			if n := srv.clusterNodes[name]; n != nil {
+				srv.clusterMigrating[slot] = n
+			}
+		}
+		for slot, name := range record.importing {
+			if n := srv.clusterNodes[name]; n != nil {
+				srv.clusterImporting[slot] = n
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// clusterSaveConfig writes the node table in the CLUSTER NODES format. It
+// must be called with clusterMu held.
+func (srv *Server) clusterSaveConfig() {
+	var sb strings.Builder
+	for _, n := range srv.sortedClusterNodes() {
+		sb.WriteString(srv.describeNode(n))
+		sb.WriteString("\n")
+	}
+	fmt.Fprintf(&sb, "vars currentEpoch %d lastVoteEpoch 0\n", srv.clusterCurrentEpoch())
+
+	tmp := srv.clusterConfigFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(sb.String()), 0644); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := os.Rename(tmp, srv.clusterConfigFile); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (srv *Server) sortedClusterNodes() []*clusterNode {
+	nodes := make([]*clusterNode, 0, len(srv.clusterNodes))
+	for _, n := range srv.clusterNodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].name < nodes[j].name
+	})
+	return nodes
+}
+
+func (srv *Server) clusterCurrentEpoch() uint64 {
+	epoch := uint64(0)
+	for _, n := range srv.clusterNodes {
+		if n.configEpoch > epoch {
+			epoch = n.configEpoch
+		}
+	}
+	return epoch
+}
+
+func (srv *Server) clusterNodeByAddr(ip string, port int) *clusterNode {
+	for _, n := range srv.clusterNodes {
+		if n.ip == ip && n.port == port {
+			return n
+		}
+	}
+	return nil
+}
+
+func (n *clusterNode) addr() string {
+	return net.JoinHostPort(n.ip, strconv.Itoa(n.port))
+}
+
+// slotRanges returns the slots served by n as inclusive ranges.
+func (srv *Server) slotRanges(n *clusterNode) [][2]int {
+	ranges := [][2]int{}
+	for slot := 0; slot < clusterSlots; slot++ {
+		if srv.clusterSlots[slot] != n {
+			continue
+		}
+		if len(ranges) > 0 && ranges[len(ranges)-1][1] == slot-1 {
+			ranges[len(ranges)-1][1] = slot
+		} else {
+			ranges = append(ranges, [2]int{slot, slot})
+		}
+	}
+	return ranges
+}
+
+// describeNode formats n as a CLUSTER NODES line.
+func (srv *Server) describeNode(n *clusterNode) string {
+	flags := []string{}
+	if n.myself {
+		flags = append(flags, "myself")
+	}
+	flags = append(flags, "master")
+	if n.handshake {
+		flags = append(flags, "handshake")
+	} else if !n.myself && !n.connected {
+		flags = append(flags, "fail?")
+	}
+
+	link := "disconnected"
+	if n.myself || n.connected {
+		link = "connected"
+	}
+
+	pong := int64(0)
+	if !n.pongRecv.IsZero() {
+		pong = n.pongRecv.UnixMilli()
+	}
+
+	line := fmt.Sprintf("%s %s:%d@%d %s - 0 %d %d %s",
+		n.name, n.ip, n.port, n.port+10000, strings.Join(flags, ","), pong, n.configEpoch, link)
+
+	for _, r := range srv.slotRanges(n) {
+		if r[0] == r[1] {
+			line += fmt.Sprintf(" %d", r[0])
+		} else {
+			line += fmt.Sprintf(" %d-%d", r[0], r[1])
+		}
+	}
+
+	if n.myself {
+		for _, slot := range sortedSlots(srv.clusterMigrating) {
+			line += fmt.Sprintf(" [%d->-%s]", slot, srv.clusterMigrating[slot].name)
+		}
+		for _, slot := range sortedSlots(srv.clusterImporting) {
+			line += fmt.Sprintf(" [%d-<-%s]", slot, srv.clusterImporting[slot].name)
+		}
+	}
+
+	return line
+}
+
+func sortedSlots(m map[int]*clusterNode) []int {
+	slots := make([]int, 0, len(m))
+	for slot := range m {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	return slots
+}
+
+type clusterNodeRecord struct {
+	name        string
+	ip          string
+	port        int
+	flags       map[string]bool
+	configEpoch uint64
+	slots       []int
+	migrating   map[int]string
+	importing   map[int]string
+}
+
+// parseClusterNodeLine parses a line in the CLUSTER NODES format:
+// id ip:port@cport flags master ping-sent pong-recv config-epoch link slot...
+func parseClusterNodeLine(line string) (clusterNodeRecord, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 8 {
+		return clusterNodeRecord{}, fmt.Errorf("malformed node line %q", line)
+	}
+
+	record := clusterNodeRecord{
+		name:      fields[0],
+		flags:     map[string]bool{},
+		migrating: map[int]string{},
+		importing: map[int]string{},
+	}
+
+	addr, _, _ := strings.Cut(fields[1], "@")
+	ip, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return record, err
+	}
+	record.ip = ip
+	if record.port, err = strconv.Atoi(portString); err != nil {
+		return record, err
+	}
+
+	for _, flag := range strings.Split(fields[2], ",") {
+		record.flags[flag] = true
+	}
+
+	if record.configEpoch, err = strconv.ParseUint(fields[6], 10, 64); err != nil {
+		return record, err
+	}
+
+	for _, field := range fields[8:] {
+		if strings.HasPrefix(field, "[") {
+			field = strings.Trim(field, "[]")
+			if slot, name, ok := strings.Cut(field, "->-"); ok {
+				n, err := strconv.Atoi(slot)
+				if err != nil {
+					return record, err
+				}
+				record.migrating[n] = name
+			} else if slot, name, ok := strings.Cut(field, "-<-"); ok {
+				n, err := strconv.Atoi(slot)
+				if err != nil {
+					return record, err
+				}
+				record.importing[n] = name
+			}
+			continue
+		}
+
+		first, last, found := strings.Cut(field, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return record, err
+		}
+		end := start
+		if found {
+			if end, err = strconv.Atoi(last); err != nil {
+				return record, err
+			}
+		}
+		if start < 0 || end >= clusterSlots || start > end {
+			return record, fmt.Errorf("invalid slot range %q", field)
+		}
+		for slot := start; slot <= end; slot++ {
+			record.slots = append(record.slots, slot)
+		}
+	}
+
+	return record, nil
+}
+
+// clusterCron pings every peer once a second, until the server is closed.
+func (srv *Server) clusterCron() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-srv.done:
+			for _, n := range srv.clusterPeers() {
+				srv.clusterCloseLink(n)
+			}
+			return
+		}
+
+		for _, n := range srv.clusterPeers() {
+			srv.clusterPing(n)
+		}
+	}
+}
+
+// clusterPeers returns the nodes other than myself.
+func (srv *Server) clusterPeers() []*clusterNode {
+	srv.clusterMu.RLock()
+	defer srv.clusterMu.RUnlock()
+
+	peers := []*clusterNode{}
+	for _, n := range srv.clusterNodes {
+		if !n.myself {
+			peers = append(peers, n)
+		}
+	}
+	return peers
+}
+
+// clusterPing fetches the CLUSTER NODES view of n and merges it into ours,
+// connecting to n first if needed.
+func (srv *Server) clusterPing(n *clusterNode) {
+	srv.clusterMu.RLock()
+	addr := n.addr()
+	srv.clusterMu.RUnlock()
+
+	if n.conn == nil {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			srv.clusterSetConnected(n, false)
+			return
+		}
+		n.conn, n.reader = conn, resp.NewResp(conn)
+
+		// Introduce ourselves, so that the peer starts pinging us back, and
+		// learn the address peers know us by.
+		ip, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+		srv.clusterSetMyIP(ip)
+		if _, err := clusterRequest(n, "CLUSTER", "MEET", ip, strconv.Itoa(srv.port)); err != nil {
+			srv.clusterCloseLink(n)
+			return
+		}
+	}
+
+	reply, err := clusterRequest(n, "CLUSTER", "NODES")
+	if err != nil || reply.Type != "bulk" {
+		srv.clusterCloseLink(n)
+		return
+	}
+
+	srv.clusterProcessGossip(n, reply.Bulk)
+}
+
+func clusterRequest(n *clusterNode, args ...string) (Value, error) {
+	request := Value{Type: "array"}
+	for _, arg := range args {
+		request.Array = append(request.Array, Value{Type: "bulk", Bulk: arg})
+	}
+
+	n.conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := n.conn.Write(request.Marshal()); err != nil {
+		return Value{}, err
+	}
+	return n.reader.Read()
+}
+
+func (srv *Server) clusterCloseLink(n *clusterNode) {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn, n.reader = nil, nil
+	}
+	srv.clusterSetConnected(n, false)
+}
+
+func (srv *Server) clusterSetConnected(n *clusterNode, connected bool) {
+	srv.clusterMu.Lock()
+	n.connected = connected
+	srv.clusterMu.Unlock()
+}
+
+func (srv *Server) clusterSetMyIP(ip string) {
+	srv.clusterMu.Lock()
+	defer srv.clusterMu.Unlock()
+
+	if srv.myself.ip != ip {
+		srv.myself.ip = ip
+		srv.clusterSaveConfig()
+	}
+}
+
+// clusterProcessGossip merges the CLUSTER NODES view of sender: the line
+// describing sender itself updates its name, epoch and slots, and nodes we
+// have not heard of are added so that the cron starts pinging them.
+func (srv *Server) clusterProcessGossip(sender *clusterNode, text string) {
+	srv.clusterMu.Lock()
+	defer srv.clusterMu.Unlock()
+
+	changed := false
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		record, err := parseClusterNodeLine(line)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case record.flags["myself"]:
+			if srv.clusterUpdateSender(sender, record) {
+				changed = true
+			}
+		case record.name == srv.myself.name || record.flags["handshake"]:
+		case srv.clusterNodes[record.name] == nil && srv.clusterNodeByAddr(record.ip, record.port) == nil:
+			srv.clusterNodes[record.name] = &clusterNode{
+				name:        record.name,
+				ip:          record.ip,
+				port:        record.port,
+				configEpoch: record.configEpoch,
+			}
+			changed = true
+		}
+	}
+
+	if changed {
+		srv.clusterSaveConfig()
+	}
+}
+
+func (srv *Server) clusterUpdateSender(sender *clusterNode, record clusterNodeRecord) bool {
+	changed := false
+
+	if sender.name != record.name {
+		// The handshake is over and the node is known by its real name,
+		// unless it turns out to be ourselves or a node we already know.
+		delete(srv.clusterNodes, sender.name)
+		if record.name == srv.myself.name || srv.clusterNodes[record.name] != nil {
+			if sender.conn != nil {
+				sender.conn.Close()
+				sender.conn, sender.reader = nil, nil
+			}
+			return true
+		}
+		sender.name = record.name
+		sender.handshake = false
+		srv.clusterNodes[sender.name] = sender
+		changed = true
+	}
+
+	sender.connected = true
+	sender.pongRecv = time.Now()
+	if sender.configEpoch != record.configEpoch {
+		sender.configEpoch = record.configEpoch
+		changed = true
+	}
+
+	claimed := [clusterSlots]bool{}
+	for _, slot := range record.slots {
+		claimed[slot] = true
+	}
+
+	for slot := 0; slot < clusterSlots; slot++ {
+		owner := srv.clusterSlots[slot]
+		switch {
+		case claimed[slot] && owner != sender && (owner == nil || sender.configEpoch > owner.configEpoch):
+			srv.clusterSlots[slot] = sender
+			if owner == srv.myself {
+				delete(srv.clusterMigrating, slot)
+			}
+			changed = true
+		case !claimed[slot] && owner == sender:
+			srv.clusterSlots[slot] = nil
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// clusterRedirect checks that the keys of a command are served by this
+// node, returning the MOVED, ASK or error reply to send instead otherwise.
+func (srv *Server) clusterRedirect(c *Client, command string, args []Value) (Value, bool) {
+	if !srv.clusterEnabled {
+		return Value{}, false
+	}
+
+	// ASKING only applies to the command that follows it.
+	asking := false
+	if command != "ASKING" {
+		c.mu.Lock()
+		asking, c.asking = c.asking, false
+		c.mu.Unlock()
+	}
+
+	keys := commandKeys(command, args)
+	if len(keys) == 0 {
+		return Value{}, false
+	}
+
+	slot := keyHashSlot(keys[0])
+	for _, key := range keys[1:] {
+		if keyHashSlot(key) != slot {
+			return Value{Type: "error", Str: "CROSSSLOT Keys in request don't hash to the same slot"}, true
+		}
+	}
+
+	srv.clusterMu.RLock()
+	defer srv.clusterMu.RUnlock()
+
+	owner := srv.clusterSlots[slot]
+	if owner == nil {
+		return Value{Type: "error", Str: "CLUSTERDOWN Hash slot not served"}, true
+	}
+
+	if owner != srv.myself {
+		if srv.clusterImporting[slot] != nil && asking {
+			return Value{}, false
+		}
+		return Value{Type: "error", Str: fmt.Sprintf("MOVED %d %s", slot, owner.addr())}, true
+	}
+
+	// Keys of a slot being migrated are looked up here first, and the
+	// client is sent to the target node for those already moved.
+	if target := srv.clusterMigrating[slot]; target != nil {
+		missing := 0
+		for _, key := range keys {
+			if !srv.keyExists(key) {
+				missing++
+			}
+		}
+
+		if missing == len(keys) {
+			return Value{Type: "error", Str: fmt.Sprintf("ASK %d %s", slot, target.addr())}, true
+		}
+		if missing > 0 {
+			return Value{Type: "error", Str: "TRYAGAIN Multiple keys request during rehashing of slot"}, true
+		}
+	}
+
+	return Value{}, false
+}
+
+func (srv *Server) asking(c *Client, args []Value) Value {
+	if !srv.clusterEnabled {
+		return Value{Type: "error", Str: "ERR This instance has cluster support disabled"}
+	}
+
+	c.mu.Lock()
+	c.asking = true
+	c.mu.Unlock()
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+// keysInSlot returns up to count keys hashing to slot, or all of them when
+// count is negative.
+func (srv *Server) keysInSlot(slot, count int) []string {
+	keys := []string{}
+	for _, sh := range srv.shards {
+		sh.mu.RLock()
+		for _, key := range sh.keys() {
+			if keyHashSlot(key) == slot {
+				keys = append(keys, key)
+			}
+		}
+		sh.mu.RUnlock()
+	}
+
+	sort.Strings(keys)
+	if count >= 0 && len(keys) > count {
+		keys = keys[:count]
+	}
+	return keys
+}
+
+func parseSlot(arg string) (int, error) {
+	slot, err := strconv.Atoi(arg)
+	if err != nil || slot < 0 || slot >= clusterSlots {
+		return 0, fmt.Errorf("ERR Invalid or out of range slot")
+	}
+	return slot, nil
+}
+
+func (srv *Server) cluster(c *Client, args []Value) Value {
+	if !srv.clusterEnabled {
+		return Value{Type: "error", Str: "ERR This instance has cluster support disabled"}
+	}
+
+	name := args[0].Bulk
+	subcommand := strings.ToUpper(name)
+	args = args[1:]
+
+	switch subcommand {
+	case "KEYSLOT":
+		if len(args) != 1 {
+			return Value{Type: "error", Str: "ERR wrong number of arguments for 'cluster|keyslot' command"}
+		}
+		return Value{Type: "integer", Num: keyHashSlot(args[0].Bulk)}
+	case "MYID":
+		srv.clusterMu.RLock()
+		defer srv.clusterMu.RUnlock()
+		return Value{Type: "bulk", Bulk: srv.myself.name}
+	case "INFO":
+		return srv.clusterInfo()
+	case "NODES":
+		srv.clusterMu.RLock()
+		defer srv.clusterMu.RUnlock()
+
+		var sb strings.Builder
+		for _, n := range srv.sortedClusterNodes() {
+			sb.WriteString(srv.describeNode(n))
+			sb.WriteString("\n")
+		}
+		return Value{Type: "bulk", Bulk: sb.String()}
+	case "SLOTS":
+		return srv.clusterSlotsReply()
+	case "SHARDS":
+		return srv.clusterShards()
+	case "ADDSLOTS", "ADDSLOTSRANGE":
+		return srv.clusterAddSlots(subcommand, args)
+	case "MEET":
+		return srv.clusterMeet(c, args)
+	case "SETSLOT":
+		return srv.clusterSetSlot(args)
+	case "COUNTKEYSINSLOT":
+		if len(args) != 1 {
+			return Value{Type: "error", Str: "ERR wrong number of arguments for 'cluster|countkeysinslot' command"}
+		}
+		slot, err := parseSlot(args[0].Bulk)
+		if err != nil {
+			return Value{Type: "error", Str: err.Error()}
+		}
+		return Value{Type: "integer", Num: len(srv.keysInSlot(slot, -1))}
+	case "GETKEYSINSLOT":
+		if len(args) != 2 {
+			return Value{Type: "error", Str: "ERR wrong number of arguments for 'cluster|getkeysinslot' command"}
+		}
+		slot, err := parseSlot(args[0].Bulk)
+		if err != nil {
+			return Value{Type: "error", Str: err.Error()}
+		}
+		count, err := strconv.Atoi(args[1].Bulk)
+		if err != nil || count < 0 {
+			return Value{Type: "error", Str: "ERR Invalid number of keys"}
+		}
+
+		values := []Value{}
+		for _, key := range srv.keysInSlot(slot, count) {
+			values = append(values, Value{Type: "bulk", Bulk: key})
+		}
+		return Value{Type: "array", Array: values}
+	default:
+		return Value{Type: "error", Str: fmt.Sprintf("ERR unknown subcommand '%s'", name)}
+	}
+}
+
+func (srv *Server) clusterInfo() Value {
+	srv.clusterMu.RLock()
+	defer srv.clusterMu.RUnlock()
+
+	assigned := 0
+	sizes := map[*clusterNode]bool{}
+	for _, owner := range srv.clusterSlots {
+		if owner != nil {
+			assigned++
+			sizes[owner] = true
+		}
+	}
+
+	state := "ok"
+	if assigned < clusterSlots {
+		state = "fail"
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "cluster_state:%s\r\n", state)
+	fmt.Fprintf(&sb, "cluster_slots_assigned:%d\r\n", assigned)
+	fmt.Fprintf(&sb, "cluster_slots_ok:%d\r\n", assigned)
+	fmt.Fprintf(&sb, "cluster_slots_pfail:0\r\n")
+	fmt.Fprintf(&sb, "cluster_slots_fail:0\r\n")
+	fmt.Fprintf(&sb, "cluster_known_nodes:%d\r\n", len(srv.clusterNodes))
+	fmt.Fprintf(&sb, "cluster_size:%d\r\n", len(sizes))
+	fmt.Fprintf(&sb, "cluster_current_epoch:%d\r\n", srv.clusterCurrentEpoch())
+	fmt.Fprintf(&sb, "cluster_my_epoch:%d\r\n", srv.myself.configEpoch)
+
+	return Value{Type: "bulk", Bulk: sb.String()}
+}
+
+func clusterNodeValue(n *clusterNode) Value {
+	return Value{Type: "array", Array: []Value{
+		{Type: "bulk", Bulk: n.ip},
+		{Type: "integer", Num: n.port},
+		{Type: "bulk", Bulk: n.name},
+	}}
+}
+
+func (srv *Server) clusterSlotsReply() Value {
+	srv.clusterMu.RLock()
+	defer srv.clusterMu.RUnlock()
+
+	values := []Value{}
+	for slot := 0; slot < clusterSlots; {
+		owner := srv.clusterSlots[slot]
+		end := slot
+		for end+1 < clusterSlots && srv.clusterSlots[end+1] == owner {
+			end++
+		}
+
+		if owner != nil {
+			values = append(values, Value{Type: "array", Array: []Value{
+				{Type: "integer", Num: slot},
+				{Type: "integer", Num: end},
+				clusterNodeValue(owner),
+			}})
+		}
+		slot = end + 1
+	}
+
+	return Value{Type: "array", Array: values}
+}
+
+func (srv *Server) clusterShards() Value {
+	srv.clusterMu.RLock()
+	defer srv.clusterMu.RUnlock()
+
+	shards := []Value{}
+	for _, n := range srv.sortedClusterNodes() {
+		if n.handshake {
+			continue
+		}
+
+		slots := []Value{}
+		for _, r := range srv.slotRanges(n) {
+			slots = append(slots, Value{Type: "integer", Num: r[0]}, Value{Type: "integer", Num: r[1]})
+		}
+
+		health := "online"
+		if !n.myself && !n.connected {
+			health = "fail"
+		}
+
+		node := Value{Type: "map", Array: []Value{
+			{Type: "bulk", Bulk: "id"}, {Type: "bulk", Bulk: n.name},
+			{Type: "bulk", Bulk: "port"}, {Type: "integer", Num: n.port},
+			{Type: "bulk", Bulk: "ip"}, {Type: "bulk", Bulk: n.ip},
+			{Type: "bulk", Bulk: "endpoint"}, {Type: "bulk", Bulk: n.ip},
+			{Type: "bulk", Bulk: "role"}, {Type: "bulk", Bulk: "master"},
+			{Type: "bulk", Bulk: "replication-offset"}, {Type: "integer", Num: 0},
+			{Type: "bulk", Bulk: "health"}, {Type: "bulk", Bulk: health},
+		}}
+
+		shards = append(shards, Value{Type: "map", Array: []Value{
+			{Type: "bulk", Bulk: "slots"}, {Type: "array", Array: slots},
+			{Type: "bulk", Bulk: "nodes"}, {Type: "array", Array: []Value{node}},
+		}})
+	}
+
+	return Value{Type: "array", Array: shards}
+}
+
+func (srv *Server) clusterAddSlots(subcommand string, args []Value) Value {
+	if len(args) == 0 || (subcommand == "ADDSLOTSRANGE" && len(args)%2 != 0) {
+		return Value{Type: "error", Str: fmt.Sprintf("ERR wrong number of arguments for 'cluster|%s' command", strings.ToLower(subcommand))}
+	}
+
+	slots := []int{}
+	if subcommand == "ADDSLOTS" {
+		for _, arg := range args {
+			slot, err := parseSlot(arg.Bulk)
+			if err != nil {
+				return Value{Type: "error", Str: err.Error()}
+			}
+			slots = append(slots, slot)
+		}
+	} else {
+		for i := 0; i < len(args); i += 2 {
+			start, err := parseSlot(args[i].Bulk)
+			if err != nil {
+				return Value{Type: "error", Str: err.Error()}
+			}
+			end, err := parseSlot(args[i+1].Bulk)
+			if err != nil {
+				return Value{Type: "error", Str: err.Error()}
+			}
+			if start > end {
+				return Value{Type: "error", Str: fmt.Sprintf("ERR start slot number %d is greater than end slot number %d", start, end)}
+			}
+			for slot := start; slot <= end; slot++ {
+				slots = append(slots, slot)
+			}
+		}
+	}
+
+	srv.clusterMu.Lock()
+	defer srv.clusterMu.Unlock()
+
+	seen := map[int]bool{}
+	for _, slot := range slots {
+		if seen[slot] {
+			return Value{Type: "error", Str: fmt.Sprintf("ERR Slot %d specified multiple times", slot)}
+		}
+		seen[slot] = true
+
+		if srv.clusterSlots[slot] != nil {
+			return Value{Type: "error", Str: fmt.Sprintf("ERR Slot %d is already busy", slot)}
+		}
+	}
+
+	for _, slot := range slots {
+		srv.clusterSlots[slot] = srv.myself
+		delete(srv.clusterImporting, slot)
+	}
+	srv.clusterSaveConfig()
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+// clusterMeet adds a node in handshake state. Its real name is learned the
+// first time the cron pings it.
+func (srv *Server) clusterMeet(c *Client, args []Value) Value {
+	if len(args) < 2 || len(args) > 3 {
+		return Value{Type: "error", Str: "ERR wrong number of arguments for 'cluster|meet' command"}
+	}
+
+	ip := args[0].Bulk
+	peerPort, err := strconv.Atoi(args[1].Bulk)
+	if err != nil || peerPort <= 0 || peerPort > 65535 || net.ParseIP(ip) == nil {
+		return Value{Type: "error", Str: fmt.Sprintf("ERR Invalid node address specified: %s:%s", args[0].Bulk, args[1].Bulk)}
+	}
+
+	// The address this connection reached us on is how the peer knows us.
+	if local, _, err := net.SplitHostPort(c.localAddr()); err == nil && net.ParseIP(local) != nil {
+		srv.clusterSetMyIP(local)
+	}
+
+	srv.clusterMu.Lock()
+	defer srv.clusterMu.Unlock()
+
+	if srv.clusterNodeByAddr(ip, peerPort) == nil {
+		n := &clusterNode{name: randomID(), ip: ip, port: peerPort, handshake: true}
+		srv.clusterNodes[n.name] = n
+		srv.clusterSaveConfig()
+	}
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+func (srv *Server) clusterSetSlot(args []Value) Value {
+	if len(args) < 2 {
+		return Value{Type: "error", Str: "ERR wrong number of arguments for 'cluster|setslot' command"}
+	}
+
+	slot, err := parseSlot(args[0].Bulk)
+	if err != nil {
+		return Value{Type: "error", Str: err.Error()}
+	}
+	action := strings.ToUpper(args[1].Bulk)
+
+	if action == "STABLE" {
+		if len(args) != 2 {
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+
+		srv.clusterMu.Lock()
+		defer srv.clusterMu.Unlock()
+
+		delete(srv.clusterMigrating, slot)
+		delete(srv.clusterImporting, slot)
+		srv.clusterSaveConfig()
+		return Value{Type: "string", Str: "OK"}
+	}
+
+	if len(args) != 3 {
+		return Value{Type: "error", Str: "ERR syntax error"}
+	}
+
+	// Counting keys scans the keyspace, so it is done before locking.
+	keys := 0
+	if action == "NODE" {
+		keys = len(srv.keysInSlot(slot, 1))
+	}
+
+	srv.clusterMu.Lock()
+	defer srv.clusterMu.Unlock()
+
+	n := srv.clusterNodes[args[2].Bulk]
+	if n == nil {
+		return Value{Type: "error", Str: fmt.Sprintf("ERR I don't know about node %s", args[2].Bulk)}
+	}
+
+	switch action {
+	case "MIGRATING":
+		if srv.clusterSlots[slot] != srv.myself {
+			return Value{Type: "error", Str: fmt.Sprintf("ERR I'm not the owner of hash slot %d", slot)}
+		}
+		if n == srv.myself {
+			return Value{Type: "error", Str: "ERR I can't migrate to myself"}
+		}
+		srv.clusterMigrating[slot] = n
+	case "IMPORTING":
+		if srv.clusterSlots[slot] == srv.myself {
+			return Value{Type: "error", Str: fmt.Sprintf("ERR I'm already the owner of hash slot %d", slot)}
+		}
+		if n == srv.myself {
+			return Value{Type: "error", Str: "ERR I can't import from myself"}
+		}
+		srv.clusterImporting[slot] = n
+	case "NODE":
+		if srv.clusterSlots[slot] == srv.myself && n != srv.myself && keys > 0 {
+			return Value{Type: "error", Str: fmt.Sprintf("ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot)}
+		}
+
+		// Finishing an import bumps our epoch, so that our claim on the slot
+		// wins over the one of the node it came from.
+		if n == srv.myself && srv.clusterImporting[slot] != nil {
+			srv.myself.configEpoch = srv.clusterCurrentEpoch() + 1
+		}
+
+		srv.clusterSlots[slot] = n
+		delete(srv.clusterMigrating, slot)
+		delete(srv.clusterImporting, slot)
+	default:
+		return Value{Type: "error", Str: "ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP"}
+	}
+
+	srv.clusterSaveConfig()
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+func (srv *Server) infoCluster(sb *strings.Builder) {
+	enabled := 0
+	if srv.clusterEnabled {
+		enabled = 1
+	}
+	fmt.Fprintf(sb, "cluster_enabled:%d\r\n", enabled)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeyHashSlot(t *testing.T) {
+	if got := crc16("123456789"); got != 0x31c3 {
+		t.Errorf("Expected crc16 0x31c3, got %#x", got)
+	}
+
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"foo", 12182},
+		{"123456789", 12739},
+		{"{user1000}.following", keyHashSlot("user1000")},
+		{"{user1000}.followers", keyHashSlot("user1000")},
+		{"foo{}{bar}", int(crc16("foo{}{bar}") & (clusterSlots - 1))},
+		{"foo{{bar}}zap", keyHashSlot("{bar")},
+		{"foo{bar}{zap}", keyHashSlot("bar")},
+	}
+
+	for _, test := range tests {
+		if got := keyHashSlot(test.key); got != test.slot {
+			t.Errorf("Expected slot %d for %q, got %d", test.slot, test.key, got)
+		}
+	}
+}
+
+func TestClusterNodeLine(t *testing.T) {
+	n := &clusterNode{name: "a", ip: "127.0.0.1", port: 7000, configEpoch: 3, myself: true}
+	other := &clusterNode{name: "b", ip: "127.0.0.1", port: 7001}
+
+	testServer.clusterMu.Lock()
+	savedSlots, savedMigrating := testServer.clusterSlots, testServer.clusterMigrating
+	for slot := 0; slot <= 100; slot++ {
+		testServer.clusterSlots[slot] = n
+	}
+	testServer.clusterSlots[200] = n
+	testServer.clusterMigrating = map[int]*clusterNode{200: other}
+	line := testServer.describeNode(n)
+	testServer.clusterSlots, testServer.clusterMigrating = savedSlots, savedMigrating
+	testServer.clusterMu.Unlock()
+
+	if !strings.HasSuffix(line, " 0-100 200 [200->-b]") {
+		t.Errorf("Unexpected node line %q", line)
+	}
+
+	record, err := parseClusterNodeLine(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.name != "a" || record.port != 7000 || record.configEpoch != 3 || !record.flags["myself"] {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if len(record.slots) != 102 || record.migrating[200] != "b" {
+		t.Errorf("Expected 102 slots and a migrating slot, got %d and %v", len(record.slots), record.migrating)
+	}
+}
+
+func TestClusterRedirect(t *testing.T) {
+	testServer.clusterMu.Lock()
+	savedSlots, savedMigrating, savedMyself := testServer.clusterSlots, testServer.clusterMigrating, testServer.myself
+	testServer.myself = &clusterNode{name: "self", ip: "127.0.0.1", port: 7000, myself: true}
+	other := &clusterNode{name: "other", ip: "127.0.0.1", port: 7001}
+	for slot := range testServer.clusterSlots {
+		testServer.clusterSlots[slot] = testServer.myself
+	}
+	testServer.clusterSlots[keyHashSlot("foo")] = other
+	testServer.clusterMigrating = map[int]*clusterNode{keyHashSlot("bar"): other}
+	testServer.clusterMu.Unlock()
+
+	testServer.clusterEnabled = true
+	defer func() {
+		testServer.clusterEnabled = false
+		testServer.clusterMu.Lock()
+		testServer.clusterSlots, testServer.clusterMigrating, testServer.myself = savedSlots, savedMigrating, savedMyself
+		testServer.clusterMu.Unlock()
+	}()
+
+	client := newTestClient()
+	testServer.set(command("{bar}present", "v").Array)
+
+	tests := []struct {
+		command  Value
+		expected string
+	}{
+		{command("GET", "foo"), "MOVED 12182 127.0.0.1:7001"},
+		{command("DEL", "a", "b"), "CROSSSLOT Keys in request don't hash to the same slot"},
+		{command("GET", "bar"), "ASK 5061 127.0.0.1:7001"},
+		{command("EXISTS", "{bar}present", "bar"), "TRYAGAIN Multiple keys request during rehashing of slot"},
+		{command("GET", "{bar}present"), ""},
+		{command("GET", "{a}1"), ""},
+	}
+
+	for _, test := range tests {
+		result := testServer.call(client, test.command)
+		got := ""
+		if result.Type == "error" {
+			got = result.Str
+		}
+		if got != test.expected {
+			t.Errorf("Expected %q for %v, got %q", test.expected, test.command.Array, got)
+		}
+	}
+}
@@ -0,0 +1,469 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// commandSpec describes a command the way the COMMAND command reports it.
+//
+// arity counts the command name: a positive arity is the exact number of
+// arguments, a negative one the minimum. firstKey, lastKey and step give the
+// positions of the keys, counting the command name as 0, with a negative
+// lastKey counting from the end; commands flagged movablekeys find theirs
+// in commandKeys.
+type commandSpec struct {
+	arity    int
+	flags    []string
+	firstKey int
+	lastKey  int
+	step     int
+	group    string
+	since    string
+	summary  string
+
+	// selfPropagating commands write their effects to the AOF themselves,
+	// because replaying them verbatim would not be deterministic, or would
+	// be wasted, as for a SORT without STORE.
+	selfPropagating bool
+}
+
+var commandTable = map[string]*commandSpec{
+	// Connection
+	"PING":         {arity: -1, flags: []string{"fast", "sentinel"}, group: "connection", since: "1.0.0", summary: "Returns the server's liveliness response."},
+	"HELLO":        {arity: -1, flags: []string{"noscript", "loading", "stale", "fast", "sentinel"}, group: "connection", since: "6.0.0", summary: "Handshakes with the Redis server."},
+	"CLIENT":       {arity: -2, flags: []string{"admin", "noscript", "loading", "stale", "sentinel"}, group: "connection", since: "2.4.0", summary: "A container for client connection commands."},
+	"SUBSCRIBE":    {arity: -2, flags: []string{"pubsub", "noscript", "loading", "stale", "sentinel", "no_multi"}, group: "pubsub", since: "2.0.0", summary: "Listens for messages published to channels."},
+	"PSUBSCRIBE":   {arity: -2, flags: []string{"pubsub", "noscript", "loading", "stale", "sentinel", "no_multi"}, group: "pubsub", since: "2.0.0", summary: "Listens for messages published to channels that match one or more patterns."},
+	"UNSUBSCRIBE":  {arity: -1, flags: []string{"pubsub", "noscript", "loading", "stale", "sentinel", "no_multi"}, group: "pubsub", since: "2.0.0", summary: "Stops listening to messages posted to channels."},
+	"PUNSUBSCRIBE": {arity: -1, flags: []string{"pubsub", "noscript", "loading", "stale", "sentinel", "no_multi"}, group: "pubsub", since: "2.0.0", summary: "Stops listening to messages published to channels that match one or more patterns."},
+	"PUBLISH":      {arity: 3, flags: []string{"pubsub", "loading", "stale", "fast", "sentinel"}, group: "pubsub", since: "2.0.0", summary: "Posts a message to a channel."},
+	"PUBSUB":       {arity: -2, flags: []string{"pubsub", "loading", "stale", "sentinel"}, group: "pubsub", since: "2.8.0", summary: "A container for Pub/Sub commands."},
+
+	// Keys and values
+	"SET":         {arity: 3, flags: []string{"write", "denyoom"}, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "1.0.0", summary: "Sets the string value of a key."},
+	"GET":         {arity: 2, flags: []string{"readonly", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "string", since: "1.0.0", summary: "Returns the string value of a key."},
+	"SETBIT":      {arity: 4, flags: []string{"write", "denyoom"}, firstKey: 1, lastKey: 1, step: 1, group: "bitmap", since: "2.2.0", summary: "Sets or clears the bit at offset of the string value. Creates the key if it doesn't exist."},
+	"GETBIT":      {arity: 3, flags: []string{"readonly", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "bitmap", since: "2.2.0", summary: "Returns a bit value by offset."},
+	"BITCOUNT":    {arity: -2, flags: []string{"readonly"}, firstKey: 1, lastKey: 1, step: 1, group: "bitmap", since: "2.6.0", summary: "Counts the number of set bits (population counting) in a string."},
+	"BITPOS":      {arity: -3, flags: []string{"readonly"}, firstKey: 1, lastKey: 1, step: 1, group: "bitmap", since: "2.8.7", summary: "Finds the first set (1) or clear (0) bit in a string."},
+	"BITOP":       {arity: -4, flags: []string{"write", "denyoom"}, firstKey: 2, lastKey: -1, step: 1, group: "bitmap", since: "2.6.0", summary: "Performs bitwise operations on multiple strings, and stores the result."},
+	"BITFIELD":    {arity: -2, flags: []string{"write", "denyoom"}, firstKey: 1, lastKey: 1, step: 1, group: "bitmap", since: "3.2.0", summary: "Performs arbitrary bitfield integer operations on strings."},
+	"BITFIELD_RO": {arity: -2, flags: []string{"readonly", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "bitmap", since: "6.0.0", summary: "Performs arbitrary read-only bitfield integer operations on strings."},
+	"PFADD":       {arity: -2, flags: []string{"write", "denyoom", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "hyperloglog", since: "2.8.9", summary: "Adds elements to a HyperLogLog key. Creates the key if it doesn't exist."},
+	"PFCOUNT":     {arity: -2, flags: []string{"readonly"}, firstKey: 1, lastKey: -1, step: 1, group: "hyperloglog", since: "2.8.9", summary: "Returns the approximated cardinality of the set(s) observed by the HyperLogLog key(s)."},
+	"PFMERGE":     {arity: -2, flags: []string{"write", "denyoom"}, firstKey: 1, lastKey: -1, step: 1, group: "hyperloglog", since: "2.8.9", summary: "Merges one or more HyperLogLog values into a single key."},
+	"HSET":        {arity: 4, flags: []string{"write", "denyoom", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "hash", since: "2.0.0", summary: "Sets the value of a field in a hash."},
+	"HGET":        {arity: 3, flags: []string{"readonly", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "hash", since: "2.0.0", summary: "Returns the value of a field in a hash."},
+	"HGETALL":     {arity: 2, flags: []string{"readonly"}, firstKey: 1, lastKey: 1, step: 1, group: "hash", since: "2.0.0", summary: "Returns all fields and values in a hash."},
+	"DEL":         {arity: -2, flags: []string{"write"}, firstKey: 1, lastKey: -1, step: 1, group: "generic", since: "1.0.0", summary: "Deletes one or more keys."},
+	"EXISTS":      {arity: -2, flags: []string{"readonly", "fast"}, firstKey: 1, lastKey: -1, step: 1, group: "generic", since: "1.0.0", summary: "Determines whether one or more keys exist."},
+	"EXPIRE":      {arity: -3, flags: []string{"write", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "generic", since: "1.0.0", summary: "Sets the expiration time of a key in seconds.", selfPropagating: true},
+	"PEXPIRE":     {arity: -3, flags: []string{"write", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "generic", since: "2.6.0", summary: "Sets the expiration time of a key in milliseconds.", selfPropagating: true},
+	"EXPIREAT":    {arity: -3, flags: []string{"write", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "generic", since: "1.2.0", summary: "Sets the expiration time of a key to a Unix timestamp.", selfPropagating: true},
+	"PEXPIREAT":   {arity: -3, flags: []string{"write", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "generic", since: "2.6.0", summary: "Sets the expiration time of a key to a Unix milliseconds timestamp.", selfPropagating: true},
+	"TTL":         {arity: 2, flags: []string{"readonly", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "generic", since: "1.0.0", summary: "Returns the expiration time in seconds of a key."},
+	"PTTL":        {arity: 2, flags: []string{"readonly", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "generic", since: "2.6.0", summary: "Returns the expiration time in milliseconds of a key."},
+	"PERSIST":     {arity: 2, flags: []string{"write", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "generic", since: "2.2.0", summary: "Removes the expiration time of a key."},
+	"MIGRATE":     {arity: -6, flags: []string{"write", "movablekeys"}, firstKey: 3, lastKey: 3, step: 1, group: "generic", since: "2.6.0", summary: "Atomically transfers a key from one Redis instance to another.", selfPropagating: true},
+	"OBJECT":      {arity: -2, flags: []string{"readonly"}, firstKey: 2, lastKey: 2, step: 1, group: "generic", since: "2.2.3", summary: "A container for object introspection commands."},
+	"DUMP":        {arity: 2, flags: []string{"readonly"}, firstKey: 1, lastKey: 1, step: 1, group: "generic", since: "2.6.0", summary: "Returns a serialized representation of the value stored at a key."},
+	"RESTORE":     {arity: -4, flags: []string{"write", "denyoom"}, firstKey: 1, lastKey: 1, step: 1, group: "generic", since: "2.6.0", summary: "Creates a key from the serialized representation of a value.", selfPropagating: true},
+	"COPY":        {arity: -3, flags: []string{"write", "denyoom"}, firstKey: 1, lastKey: 2, step: 1, group: "generic", since: "6.2.0", summary: "Copies the value of a key to a new key."},
+	"ZADD":        {arity: -4, flags: []string{"write", "denyoom", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "sorted_set", since: "1.2.0", summary: "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist."},
+	"ZREM":        {arity: -3, flags: []string{"write", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "sorted_set", since: "1.2.0", summary: "Removes one or more members from a sorted set. Deletes the sorted set if all members were removed."},
+	"ZCARD":       {arity: 2, flags: []string{"readonly", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "sorted_set", since: "1.2.0", summary: "Returns the number of members in a sorted set."},
+	"ZSCORE":      {arity: 3, flags: []string{"readonly", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "sorted_set", since: "1.2.0", summary: "Returns the score of a member in a sorted set."},
+	"ZRANGE":      {arity: -4, flags: []string{"readonly"}, firstKey: 1, lastKey: 1, step: 1, group: "sorted_set", since: "1.2.0", summary: "Returns members in a sorted set within a range of indexes."},
+	"GEOADD":      {arity: -5, flags: []string{"write", "denyoom"}, firstKey: 1, lastKey: 1, step: 1, group: "geo", since: "3.2.0", summary: "Adds one or more members to a geospatial index. The key is created if it doesn't exist."},
+	"GEODIST":     {arity: -4, flags: []string{"readonly"}, firstKey: 1, lastKey: 1, step: 1, group: "geo", since: "3.2.0", summary: "Returns the distance between two members of a geospatial index."},
+	"GEOPOS":      {arity: -2, flags: []string{"readonly"}, firstKey: 1, lastKey: 1, step: 1, group: "geo", since: "3.2.0", summary: "Returns the longitude and latitude of members from a geospatial index."},
+	"GEOHASH":     {arity: -2, flags: []string{"readonly"}, firstKey: 1, lastKey: 1, step: 1, group: "geo", since: "3.2.0", summary: "Returns members from a geospatial index as geohash strings."},
+	"GEOSEARCH":   {arity: -7, flags: []string{"readonly"}, firstKey: 1, lastKey: 1, step: 1, group: "geo", since: "6.2.0", summary: "Queries a geospatial index for members inside an area of a box or a circle."},
+	"LPUSH":       {arity: -3, flags: []string{"write", "denyoom", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "list", since: "1.0.0", summary: "Prepends one or more elements to a list. Creates the key if it doesn't exist."},
+	"RPUSH":       {arity: -3, flags: []string{"write", "denyoom", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "list", since: "1.0.0", summary: "Appends one or more elements to a list. Creates the key if it doesn't exist."},
+	"LPOP":        {arity: -2, flags: []string{"write", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "list", since: "1.0.0", summary: "Returns the first elements in a list after removing it. Deletes the list if the last element was popped."},
+	"RPOP":        {arity: -2, flags: []string{"write", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "list", since: "1.0.0", summary: "Returns and removes the last elements of a list. Deletes the list if the last element was popped."},
+	"LLEN":        {arity: 2, flags: []string{"readonly", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "list", since: "1.0.0", summary: "Returns the length of a list."},
+	"LRANGE":      {arity: 4, flags: []string{"readonly"}, firstKey: 1, lastKey: 1, step: 1, group: "list", since: "1.0.0", summary: "Returns a range of elements from a list."},
+	"SADD":        {arity: -3, flags: []string{"write", "denyoom", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "set", since: "1.0.0", summary: "Adds one or more members to a set. Creates the key if it doesn't exist."},
+	"SREM":        {arity: -3, flags: []string{"write", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "set", since: "1.0.0", summary: "Removes one or more members from a set. Deletes the set if the last member was removed."},
+	"SMEMBERS":    {arity: 2, flags: []string{"readonly"}, firstKey: 1, lastKey: 1, step: 1, group: "set", since: "1.0.0", summary: "Returns all members of a set."},
+	"SCARD":       {arity: 2, flags: []string{"readonly", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "set", since: "1.0.0", summary: "Returns the number of members in a set."},
+	"SISMEMBER":   {arity: 3, flags: []string{"readonly", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "set", since: "1.0.0", summary: "Determines whether a member belongs to a set."},
+	"SORT":        {arity: -2, flags: []string{"write", "denyoom", "movablekeys"}, firstKey: 1, lastKey: 1, step: 1, group: "generic", since: "1.0.0", summary: "Sorts the elements in a list, a set, or a sorted set, optionally storing the result.", selfPropagating: true},
+	"SORT_RO":     {arity: -2, flags: []string{"readonly", "movablekeys"}, firstKey: 1, lastKey: 1, step: 1, group: "generic", since: "7.0.0", summary: "Returns the sorted elements of a list, a set, or a sorted set."},
+
+	// Streams
+	"XADD":       {arity: -5, flags: []string{"write", "denyoom", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "stream", since: "5.0.0", summary: "Appends a new message to a stream. Creates the key if it doesn't exist.", selfPropagating: true},
+	"XLEN":       {arity: 2, flags: []string{"readonly", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "stream", since: "5.0.0", summary: "Return the number of messages in a stream."},
+	"XRANGE":     {arity: -4, flags: []string{"readonly"}, firstKey: 1, lastKey: 1, step: 1, group: "stream", since: "5.0.0", summary: "Returns the messages from a stream within a range of IDs."},
+	"XREVRANGE":  {arity: -4, flags: []string{"readonly"}, firstKey: 1, lastKey: 1, step: 1, group: "stream", since: "5.0.0", summary: "Returns the messages from a stream within a range of IDs in reverse order."},
+	"XDEL":       {arity: -3, flags: []string{"write", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "stream", since: "5.0.0", summary: "Returns the number of messages after removing them from a stream."},
+	"XTRIM":      {arity: -4, flags: []string{"write"}, firstKey: 1, lastKey: 1, step: 1, group: "stream", since: "5.0.0", summary: "Deletes messages from the beginning of a stream."},
+	"XGROUP":     {arity: -2, flags: []string{"write"}, firstKey: 2, lastKey: 2, step: 1, group: "stream", since: "5.0.0", summary: "A container for consumer groups commands."},
+	"XACK":       {arity: -4, flags: []string{"write", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "stream", since: "5.0.0", summary: "Returns the number of messages that were successfully acknowledged by the consumer group member of a stream."},
+	"XPENDING":   {arity: -3, flags: []string{"readonly"}, firstKey: 1, lastKey: 1, step: 1, group: "stream", since: "5.0.0", summary: "Returns the information and entries from a stream consumer group's pending entries list."},
+	"XCLAIM":     {arity: -6, flags: []string{"write", "fast"}, firstKey: 1, lastKey: 1, step: 1, group: "stream", since: "5.0.0", summary: "Changes, or acquires, ownership of a message in a consumer group, as if the message was delivered a consumer group member.", selfPropagating: true},
+	"XREAD":      {arity: -4, flags: []string{"readonly", "blocking", "movablekeys"}, group: "stream", since: "5.0.0", summary: "Returns messages from multiple streams with IDs greater than the ones requested. Blocks until a message is available otherwise."},
+	"XREADGROUP": {arity: -7, flags: []string{"write", "blocking", "movablekeys"}, group: "stream", since: "5.0.0", summary: "Returns new or historical messages from a stream for a consumer in a group. Blocks until a message is available otherwise.", selfPropagating: true},
+
+	// Scripting
+	"EVAL":       {arity: -3, flags: []string{"noscript", "stale", "movablekeys"}, group: "scripting", since: "2.6.0", summary: "Executes a server-side Lua script."},
+	"EVALSHA":    {arity: -3, flags: []string{"noscript", "stale", "movablekeys"}, group: "scripting", since: "2.6.0", summary: "Executes a server-side Lua script by SHA1 digest."},
+	"EVAL_RO":    {arity: -3, flags: []string{"readonly", "noscript", "stale", "movablekeys"}, group: "scripting", since: "7.0.0", summary: "Executes a read-only server-side Lua script."},
+	"EVALSHA_RO": {arity: -3, flags: []string{"readonly", "noscript", "stale", "movablekeys"}, group: "scripting", since: "7.0.0", summary: "Executes a read-only server-side Lua script by SHA1 digest."},
+	"SCRIPT":     {arity: -2, flags: []string{"noscript"}, group: "scripting", since: "2.6.0", summary: "A container for Lua scripts management commands."},
+
+	// Transactions
+	"MULTI":   {arity: 1, flags: []string{"noscript", "loading", "stale", "fast"}, group: "transactions", since: "1.2.0", summary: "Starts a transaction."},
+	"EXEC":    {arity: 1, flags: []string{"noscript", "loading", "stale", "skip_slowlog"}, group: "transactions", since: "1.2.0", summary: "Executes all commands in a transaction."},
+	"DISCARD": {arity: 1, flags: []string{"noscript", "loading", "stale", "fast"}, group: "transactions", since: "2.0.0", summary: "Discards a transaction."},
+	"WATCH":   {arity: -2, flags: []string{"noscript", "loading", "stale", "fast"}, firstKey: 1, lastKey: -1, step: 1, group: "transactions", since: "2.2.0", summary: "Monitors changes to keys to determine the execution of a transaction."},
+	"UNWATCH": {arity: 1, flags: []string{"noscript", "loading", "stale", "fast"}, group: "transactions", since: "2.2.0", summary: "Forgets about watched keys of a transaction."},
+
+	// Server
+	"COMMAND":  {arity: -1, flags: []string{"loading", "stale", "sentinel"}, group: "server", since: "2.8.13", summary: "Returns detailed information about all commands."},
+	"INFO":     {arity: -1, flags: []string{"loading", "stale", "sentinel"}, group: "server", since: "1.0.0", summary: "Returns information and statistics about the server."},
+	"CONFIG":   {arity: -2, flags: []string{"admin", "noscript", "loading", "stale"}, group: "server", since: "2.0.0", summary: "A container for server configuration commands."},
+	"SLOWLOG":  {arity: -2, flags: []string{"admin", "loading", "stale"}, group: "server", since: "2.2.12", summary: "A container for slow log commands."},
+	"LATENCY":  {arity: -2, flags: []string{"admin", "noscript", "loading", "stale"}, group: "server", since: "2.8.13", summary: "A container for latency diagnostics commands."},
+	"MEMORY":   {arity: -2, flags: []string{"readonly"}, firstKey: 2, lastKey: 2, step: 1, group: "server", since: "4.0.0", summary: "A container for memory diagnostics commands."},
+	"DEBUG":    {arity: -2, flags: []string{"admin", "noscript", "loading", "stale"}, group: "server", since: "1.0.0", summary: "A container for debugging commands."},
+	"MONITOR":  {arity: 1, flags: []string{"admin", "noscript", "loading", "stale", "no_multi"}, group: "server", since: "1.0.0", summary: "Listens for all requests received by the server in real-time."},
+	"SHUTDOWN": {arity: -1, flags: []string{"admin", "noscript", "loading", "stale", "sentinel", "no_multi", "allow_busy"}, group: "server", since: "1.0.0", summary: "Synchronously saves the database(s) to disk and shuts down the Redis server."},
+
+	// Persistence
+	"BGREWRITEAOF": {arity: 1, flags: []string{"admin", "noscript"}, group: "server", since: "1.0.0", summary: "Asynchronously rewrites the append-only file to disk."},
+
+	// Replication
+	"ROLE":      {arity: 1, flags: []string{"noscript", "loading", "stale", "fast", "sentinel"}, group: "server", since: "2.8.12", summary: "Returns the replication role."},
+	"REPLICAOF": {arity: 3, flags: []string{"admin", "noscript", "stale"}, group: "server", since: "5.0.0", summary: "Configures a server as replica of another, or promotes it to a master."},
+	"SLAVEOF":   {arity: 3, flags: []string{"admin", "noscript", "stale"}, group: "server", since: "1.0.0", summary: "Sets a Redis server as a replica of another, or promotes it to being a master."},
+	"REPLCONF":  {arity: -1, flags: []string{"admin", "noscript", "loading", "stale", "no_multi"}, group: "server", since: "3.0.0", summary: "An internal command for configuring the replication stream."},
+	"SYNC":      {arity: 1, flags: []string{"admin", "noscript", "no_multi"}, group: "server", since: "1.0.0", summary: "An internal command used in replication."},
+
+	// Cluster and Sentinel
+	"CLUSTER":  {arity: -2, group: "cluster", since: "3.0.0", summary: "A container for Redis Cluster commands."},
+	"ASKING":   {arity: 1, flags: []string{"fast"}, group: "cluster", since: "3.0.0", summary: "Signals that a cluster client is following an -ASK redirect."},
+	"SENTINEL": {arity: -2, flags: []string{"admin", "only_sentinel", "sentinel"}, group: "sentinel", since: "2.8.4", summary: "A container for Redis Sentinel commands."},
+}
+
+// The ACL category each command group belongs to.
+var groupCategories = map[string]string{
+	"string":       "@string",
+	"bitmap":       "@bitmap",
+	"hash":         "@hash",
+	"list":         "@list",
+	"set":          "@set",
+	"sorted_set":   "@sortedset",
+	"geo":          "@geo",
+	"hyperloglog":  "@hyperloglog",
+	"stream":       "@stream",
+	"generic":      "@keyspace",
+	"pubsub":       "@pubsub",
+	"connection":   "@connection",
+	"scripting":    "@scripting",
+	"transactions": "@transaction",
+}
+
+// unknownCommandError answers a command missing from the table.
+func unknownCommandError(name string, args []Value) Value {
+	quoted := ""
+	for _, arg := range args {
+		quoted += fmt.Sprintf("'%s' ", arg.Bulk)
+	}
+	return Value{Type: "error", Str: fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", name, quoted)}
+}
+
+func (spec *commandSpec) hasFlag(flag string) bool {
+	for _, f := range spec.flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// commandHasFlag reports whether command is known and carries flag.
+func commandHasFlag(command, flag string) bool {
+	spec, ok := commandTable[command]
+	return ok && spec.hasFlag(flag)
+}
+
+// arityError returns the error for a command called with argc arguments,
+// counting its name, if that does not match its arity.
+func (spec *commandSpec) arityError(name string, argc int) (Value, bool) {
+	if (spec.arity > 0 && argc != spec.arity) || argc < -spec.arity {
+		return Value{Type: "error", Str: fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name))}, true
+	}
+	return Value{}, false
+}
+
+// categories derives the ACL categories of a command from its flags and
+// group.
+func (spec *commandSpec) categories() []string {
+	categories := []string{}
+	if spec.hasFlag("write") {
+		categories = append(categories, "@write")
+	}
+	if spec.hasFlag("readonly") && spec.group != "scripting" {
+		categories = append(categories, "@read")
+	}
+	if category, ok := groupCategories[spec.group]; ok {
+		categories = append(categories, category)
+	}
+	if spec.hasFlag("fast") {
+		categories = append(categories, "@fast")
+	} else {
+		categories = append(categories, "@slow")
+	}
+	if spec.hasFlag("blocking") {
+		categories = append(categories, "@blocking")
+	}
+	if spec.hasFlag("admin") {
+		categories = append(categories, "@admin", "@dangerous")
+	}
+	return categories
+}
+
+// commandKeys returns the keys a command operates on.
+func commandKeys(command string, args []Value) []string {
+	keys := []string{}
+
+	switch command {
+	case "XREAD", "XREADGROUP":
+		for i, arg := range args {
+			if strings.ToUpper(arg.Bulk) == "STREAMS" {
+				rest := args[i+1:]
+				for _, key := range rest[:len(rest)/2] {
+					keys = append(keys, key.Bulk)
+				}
+				break
+			}
+		}
+		return keys
+	case "EVAL", "EVALSHA", "EVAL_RO", "EVALSHA_RO":
+		if len(args) < 2 {
+			return keys
+		}
+		numkeys, err := strconv.Atoi(args[1].Bulk)
+		if err != nil || numkeys < 0 || numkeys > len(args)-2 {
+			return keys
+		}
+		for _, key := range args[2 : 2+numkeys] {
+			keys = append(keys, key.Bulk)
+		}
+		return keys
+	case "SORT", "SORT_RO":
+		// The key, and the destination of STORE.
+		if len(args) == 0 {
+			return keys
+		}
+		keys = append(keys, args[0].Bulk)
+		for i := 1; i < len(args); i++ {
+			switch strings.ToUpper(args[i].Bulk) {
+			case "STORE":
+				if i+1 < len(args) {
+					keys = append(keys, args[i+1].Bulk)
+				}
+				i++
+			case "BY", "GET":
+				i++
+			case "LIMIT":
+				i += 2
+			}
+		}
+		return keys
+	case "MIGRATE":
+		// Either the key argument, or the keys following KEYS.
+		if len(args) > 2 && args[2].Bulk != "" {
+			return append(keys, args[2].Bulk)
+		}
+		for i := 5; i < len(args); i++ {
+			if strings.ToUpper(args[i].Bulk) == "KEYS" {
+				for _, key := range args[i+1:] {
+					keys = append(keys, key.Bulk)
+				}
+				break
+			}
+		}
+		return keys
+	}
+
+	spec, ok := commandTable[command]
+	if !ok || spec.firstKey == 0 {
+		return keys
+	}
+
+	last := spec.lastKey
+	if last < 0 {
+		last = len(args) + 1 + last
+	}
+	for i := spec.firstKey; i <= last && i <= len(args); i += spec.step {
+		keys = append(keys, args[i-1].Bulk)
+	}
+
+	return keys
+}
+
+func (srv *Server) commandCommand(args []Value) Value {
+	if len(args) == 0 {
+		infos := []Value{}
+		for _, name := range sortedCommandNames() {
+			infos = append(infos, commandInfo(name))
+		}
+		return Value{Type: "array", Array: infos}
+	}
+
+	subcommand := strings.ToUpper(args[0].Bulk)
+	args = args[1:]
+
+	switch subcommand {
+	case "COUNT":
+		return Value{Type: "integer", Num: len(commandTable)}
+	case "INFO":
+		names := []string{}
+		for _, arg := range args {
+			names = append(names, strings.ToUpper(arg.Bulk))
+		}
+		if len(names) == 0 {
+			names = sortedCommandNames()
+		}
+
+		infos := []Value{}
+		for _, name := range names {
+			if _, ok := commandTable[name]; !ok {
+				infos = append(infos, Value{Type: "null"})
+				continue
+			}
+			infos = append(infos, commandInfo(name))
+		}
+		return Value{Type: "array", Array: infos}
+	case "DOCS":
+		names := []string{}
+		for _, arg := range args {
+			names = append(names, strings.ToUpper(arg.Bulk))
+		}
+		if len(names) == 0 {
+			names = sortedCommandNames()
+		}
+
+		docs := Value{Type: "map"}
+		for _, name := range names {
+			spec, ok := commandTable[name]
+			if !ok {
+				continue
+			}
+			docs.Array = append(docs.Array, Value{Type: "bulk", Bulk: strings.ToLower(name)}, Value{Type: "map", Array: []Value{
+				{Type: "bulk", Bulk: "summary"}, {Type: "bulk", Bulk: spec.summary},
+				{Type: "bulk", Bulk: "since"}, {Type: "bulk", Bulk: spec.since},
+				{Type: "bulk", Bulk: "group"}, {Type: "bulk", Bulk: spec.group},
+			}})
+		}
+		return docs
+	case "GETKEYS":
+		if len(args) == 0 {
+			return Value{Type: "error", Str: "ERR wrong number of arguments for 'command|getkeys' command"}
+		}
+		name := strings.ToUpper(args[0].Bulk)
+		spec, ok := commandTable[name]
+		if !ok {
+			return Value{Type: "error", Str: "ERR Invalid command specified"}
+		}
+		if _, bad := spec.arityError(name, len(args)); bad {
+			return Value{Type: "error", Str: "ERR Invalid number of arguments specified for command"}
+		}
+
+		keys := commandKeys(name, args[1:])
+		if len(keys) == 0 {
+			return Value{Type: "error", Str: "ERR The command has no key arguments"}
+		}
+		result := []Value{}
+		for _, key := range keys {
+			result = append(result, Value{Type: "bulk", Bulk: key})
+		}
+		return Value{Type: "array", Array: result}
+	case "LIST":
+		return commandList(args)
+	}
+
+	return Value{Type: "error", Str: fmt.Sprintf("ERR unknown subcommand '%s'. Try COMMAND HELP.", strings.ToLower(subcommand))}
+}
+
+// commandList answers COMMAND LIST [FILTERBY ACLCAT category|PATTERN pattern].
+func commandList(args []Value) Value {
+	filter := func(name string, spec *commandSpec) bool { return true }
+
+	if len(args) > 0 {
+		if len(args) != 3 || strings.ToUpper(args[0].Bulk) != "FILTERBY" {
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+		value := args[2].Bulk
+		switch strings.ToUpper(args[1].Bulk) {
+		case "ACLCAT":
+			filter = func(name string, spec *commandSpec) bool {
+				for _, category := range spec.categories() {
+					if strings.EqualFold(category, "@"+value) {
+						return true
+					}
+				}
+				return false
+			}
+		case "PATTERN":
+			filter = func(name string, spec *commandSpec) bool {
+				return stringMatch(value, strings.ToLower(name), true)
+			}
+		default:
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+	}
+
+	names := []Value{}
+	for _, name := range sortedCommandNames() {
+		if filter(name, commandTable[name]) {
+			names = append(names, Value{Type: "bulk", Bulk: strings.ToLower(name)})
+		}
+	}
+	return Value{Type: "array", Array: names}
+}
+
+// commandInfo describes a command as COMMAND INFO does: name, arity, flags,
+// first key, last key, step, ACL categories, tips, key specifications and
+// subcommands.
+func commandInfo(name string) Value {
+	spec := commandTable[name]
+
+	flags := []Value{}
+	for _, flag := range spec.flags {
+		flags = append(flags, Value{Type: "string", Str: flag})
+	}
+	categories := []Value{}
+	for _, category := range spec.categories() {
+		categories = append(categories, Value{Type: "string", Str: category})
+	}
+
+	return Value{Type: "array", Array: []Value{
+		{Type: "bulk", Bulk: strings.ToLower(name)},
+		{Type: "integer", Num: spec.arity},
+		{Type: "array", Array: flags},
+		{Type: "integer", Num: spec.firstKey},
+		{Type: "integer", Num: spec.lastKey},
+		{Type: "integer", Num: spec.step},
+		{Type: "array", Array: categories},
+		{Type: "array", Array: []Value{}},
+		{Type: "array", Array: []Value{}},
+		{Type: "array", Array: []Value{}},
+	}}
+}
+
+func sortedCommandNames() []string {
+	names := make([]string, 0, len(commandTable))
+	for name := range commandTable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommandTableCoversHandlers(t *testing.T) {
+	for name := range handlers {
+		if _, ok := commandTable[name]; !ok {
+			t.Errorf("Expected %s to be in the command table", name)
+		}
+	}
+	for name := range clientHandlers {
+		if _, ok := commandTable[name]; !ok {
+			t.Errorf("Expected %s to be in the command table", name)
+		}
+	}
+	for name := range commandTable {
+		_, plain := handlers[name]
+		_, withClient := clientHandlers[name]
+		if !plain && !withClient {
+			t.Errorf("Expected a handler for %s", name)
+		}
+	}
+}
+
+func TestCommandArity(t *testing.T) {
+	client := newTestClient()
+
+	tests := []struct {
+		command  Value
+		expected string
+	}{
+		{command("GET"), "ERR wrong number of arguments for 'get' command"},
+		{command("GET", "a", "b"), "ERR wrong number of arguments for 'get' command"},
+		{command("xadd", "s", "*"), "ERR wrong number of arguments for 'xadd' command"},
+		{command("NOPE", "a"), "ERR unknown command 'NOPE', with args beginning with: 'a' "},
+		{command("GET", "arity:missing"), ""},
+	}
+
+	for _, test := range tests {
+		result := testServer.call(client, test.command)
+		got := ""
+		if result.Type == "error" {
+			got = result.Str
+		}
+		if got != test.expected {
+			t.Errorf("Expected %q for %v, got %q", test.expected, test.command.Array, got)
+		}
+	}
+}
+
+func TestCommandGetKeys(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"SET", "a", "1"}, []string{"a"}},
+		{[]string{"DEL", "a", "b"}, []string{"a", "b"}},
+		{[]string{"XGROUP", "CREATE", "s", "g", "$"}, []string{"s"}},
+		{[]string{"EVAL", "return 1", "2", "k1", "k2", "arg"}, []string{"k1", "k2"}},
+		{[]string{"MIGRATE", "h", "1", "", "0", "10", "KEYS", "a", "b"}, []string{"a", "b"}},
+	}
+
+	for _, test := range tests {
+		result := testServer.commandCommand(command(append([]string{"GETKEYS"}, test.args...)...).Array)
+		got := []string{}
+		for _, v := range result.Array {
+			got = append(got, v.Bulk)
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Expected keys %v for %v, got %v (%v)", test.expected, test.args, got, result)
+		}
+	}
+
+	if result := testServer.commandCommand(command("GETKEYS", "PING").Array); result.Str != "ERR The command has no key arguments" {
+		t.Errorf("Expected no key arguments for PING, got %v", result)
+	}
+	if result := testServer.commandCommand(command("GETKEYS", "GET").Array); result.Str != "ERR Invalid number of arguments specified for command" {
+		t.Errorf("Expected an arity error, got %v", result)
+	}
+}
+
+func TestCommandInfo(t *testing.T) {
+	info := testServer.commandCommand(command("INFO", "get", "nope").Array)
+	if len(info.Array) != 2 || info.Array[1].Type != "null" {
+		t.Fatalf("Expected two entries, the second null, got %v", info)
+	}
+
+	get := info.Array[0].Array
+	if get[0].Bulk != "get" || get[1].Num != 2 || get[3].Num != 1 || get[4].Num != 1 || get[5].Num != 1 {
+		t.Errorf("Unexpected entry for GET: %v", get)
+	}
+	categories := []string{}
+	for _, v := range get[6].Array {
+		categories = append(categories, v.Str)
+	}
+	if !reflect.DeepEqual(categories, []string{"@read", "@string", "@fast"}) {
+		t.Errorf("Unexpected categories for GET: %v", categories)
+	}
+
+	if count := testServer.commandCommand(command("COUNT").Array); count.Num != len(commandTable) {
+		t.Errorf("Expected %d commands, got %d", len(commandTable), count.Num)
+	}
+
+	list := testServer.commandCommand(command("LIST", "FILTERBY", "ACLCAT", "hash").Array)
+	names := []string{}
+	for _, v := range list.Array {
+		names = append(names, v.Bulk)
+	}
+	if !reflect.DeepEqual(names, []string{"hget", "hgetall", "hset"}) {
+		t.Errorf("Unexpected hash commands %v", names)
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configParam describes a runtime tunable exposed through CONFIG GET/SET.
+// Both functions are called with configMu held.
+type configParam struct {
+	get func() string
+	set func(value string) error
+}
+
+// newConfigParams returns the parameters of srv, pointing to its fields.
+func (srv *Server) newConfigParams() map[string]configParam {
+	return map[string]configParam{
+		"slowlog-log-slower-than":   intConfig(&srv.slowlogLogSlowerThan, -1, math.MaxInt64),
+		"slowlog-max-len":           intConfig(&srv.slowlogMaxLen, 0, math.MaxInt64),
+		"latency-monitor-threshold": intConfig(&srv.latencyMonitorThreshold, 0, math.MaxInt64),
+		"notify-keyspace-events":    srv.notifyConfig(),
+		"lfu-log-factor":            intConfig(&srv.lfuLogFactor, 0, math.MaxInt64),
+		"lfu-decay-time":            intConfig(&srv.lfuDecayTime, 0, math.MaxInt64),
+		"hash-max-listpack-entries": intConfig(&srv.hashMaxListpackEntries, 0, math.MaxInt64),
+		"hash-max-listpack-value":   intConfig(&srv.hashMaxListpackValue, 0, math.MaxInt64),
+		"set-max-listpack-entries":  intConfig(&srv.setMaxListpackEntries, 0, math.MaxInt64),
+		"set-max-listpack-value":    intConfig(&srv.setMaxListpackValue, 0, math.MaxInt64),
+		"list-max-listpack-size":    intConfig(&srv.listMaxListpackSize, -5, math.MaxInt64),
+
+		// Keys are never evicted; see object.go for what the policy changes.
+		"maxmemory-policy": enumConfig(&srv.maxmemoryPolicy, maxmemoryPolicies...),
+
+		// How long scripts run before the server replies BUSY, see
+		// scripting.go. Redis 7 renamed lua-time-limit busy-reply-threshold.
+		"lua-time-limit":       intConfig(&srv.luaTimeLimit, 0, math.MaxInt64),
+		"busy-reply-threshold": intConfig(&srv.luaTimeLimit, 0, math.MaxInt64),
+
+		// The client buffer limits, see output.go.
+		"client-output-buffer-limit": srv.outputLimitConfig(),
+		"client-query-buffer-limit":  memoryConfig(&srv.clientQueryBufferLimit, 1<<20, math.MaxInt64),
+	}
+}
+
+func intConfig(p *int64, min, max int64) configParam {
+	return configParam{
+		get: func() string {
+			return strconv.FormatInt(*p, 10)
+		},
+		set: func(value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("argument couldn't be parsed into an integer")
+			}
+			if n < min || n > max {
+				return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+			}
+
+			*p = n
+			return nil
+		},
+	}
+}
+
+// enumConfig is a parameter taking one of values.
+func enumConfig(p *string, values ...string) configParam {
+	return configParam{
+		get: func() string {
+			return *p
+		},
+		set: func(value string) error {
+			for _, v := range values {
+				if strings.EqualFold(value, v) {
+					*p = v
+					return nil
+				}
+			}
+			return fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(values, ", "))
+		},
+	}
+}
+
+// configInt reads an integer parameter without racing with CONFIG SET.
+func (srv *Server) configInt(p *int64) int64 {
+	srv.configMu.RLock()
+	defer srv.configMu.RUnlock()
+
+	return *p
+}
+
+func (srv *Server) config(args []Value) Value {
+	switch strings.ToUpper(args[0].Bulk) {
+	case "GET":
+		return srv.configGet(args[1:])
+	case "SET":
+		return srv.configSet(args[1:])
+	case "RESETSTAT":
+		srv.resetStats()
+		return Value{Type: "string", Str: "OK"}
+	case "REWRITE":
+		return Value{Type: "error", Str: "ERR The server is running without a config file"}
+	default:
+		return Value{Type: "error", Str: fmt.Sprintf("ERR unknown subcommand '%s'", args[0].Bulk)}
+	}
+}
+
+func (srv *Server) configGet(args []Value) Value {
+	if len(args) == 0 {
+		return Value{Type: "error", Str: "ERR wrong number of arguments for 'config|get' command"}
+	}
+
+	srv.configMu.RLock()
+	defer srv.configMu.RUnlock()
+
+	matched := map[string]bool{}
+	for _, arg := range args {
+		for name := range srv.configParams {
+			if stringMatch(strings.ToLower(arg.Bulk), name, true) {
+				matched[name] = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(matched))
+	for name := range matched {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := []Value{}
+	for _, name := range names {
+		values = append(values, Value{Type: "bulk", Bulk: name})
+		values = append(values, Value{Type: "bulk", Bulk: srv.configParams[name].get()})
+	}
+
+	return Value{Type: "array", Array: values}
+}
+
+func (srv *Server) configSet(args []Value) Value {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return Value{Type: "error", Str: "ERR wrong number of arguments for 'config|set' command"}
+	}
+
+	srv.configMu.Lock()
+	defer srv.configMu.Unlock()
+
+	// Validate every parameter before applying any of them, and roll back
+	// if a setter still fails half way through.
+	for i := 0; i < len(args); i += 2 {
+		if _, ok := srv.configParams[strings.ToLower(args[i].Bulk)]; !ok {
+			return Value{Type: "error", Str: fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[i].Bulk)}
+		}
+	}
+
+	previous := map[string]string{}
+	for i := 0; i < len(args); i += 2 {
+		name := strings.ToLower(args[i].Bulk)
+		param := srv.configParams[name]
+
+		if _, ok := previous[name]; !ok {
+			previous[name] = param.get()
+		}
+
+		if err := param.set(args[i+1].Bulk); err != nil {
+			for name, value := range previous {
+				srv.configParams[name].set(value)
+			}
+			return Value{Type: "error", Str: fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %s", args[i].Bulk, err)}
+		}
+	}
+
+	return Value{Type: "string", Str: "OK"}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+)
+
+// DB runs commands against the dataset of the server in-process, as a
+// client would over a connection, without encoding them in RESP. Each call
+// runs as a client of its own, so state such as MULTI or SUBSCRIBE does
+// not carry over from one to the next.
+type DB struct {
+	srv *Server
+}
+
+// DB returns the in-process API of the server.
+func (srv *Server) DB() *DB {
+	return &DB{srv: srv}
+}
+
+// Error is an error reply to a command, such as
+// "WRONGTYPE Operation against a key holding the wrong kind of value".
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// ErrNil is returned by the typed calls when the key or field is missing.
+var ErrNil = errors.New("redisGo: nil")
+
+// Do runs a command and returns its reply in RESP2 form. Error replies are
+// returned as an Error.
+func (db *DB) Do(args ...string) (Value, error) {
+	if len(args) == 0 {
+		return Value{}, Error("ERR empty command")
+	}
+
+	reply := db.srv.call(db.srv.newClient(nil), commandValue(args...)).RESP2()
+	if reply.Type == "error" {
+		return Value{}, Error(reply.Str)
+	}
+
+	return reply, nil
+}
+
+// doInt runs a command replying with an integer.
+func (db *DB) doInt(args ...string) (int, error) {
+	reply, err := db.Do(args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return reply.Num, nil
+}
+
+// doStrings runs a command replying with an array of bulk strings.
+func (db *DB) doStrings(args ...string) ([]string, error) {
+	reply, err := db.Do(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, 0, len(reply.Array))
+	for _, v := range reply.Array {
+		values = append(values, v.Bulk)
+	}
+
+	return values, nil
+}
+
+// doBulk runs a command replying with a bulk string, or ErrNil for a null.
+func (db *DB) doBulk(args ...string) (string, error) {
+	reply, err := db.Do(args...)
+	if err != nil {
+		return "", err
+	}
+	if reply.Type == "null" {
+		return "", ErrNil
+	}
+
+	return reply.Bulk, nil
+}
+
+func (db *DB) Get(key string) (string, error) {
+	return db.doBulk("GET", key)
+}
+
+func (db *DB) Set(key, value string) error {
+	_, err := db.Do("SET", key, value)
+	return err
+}
+
+func (db *DB) Del(keys ...string) (int, error) {
+	return db.doInt(append([]string{"DEL"}, keys...)...)
+}
+
+func (db *DB) Exists(keys ...string) (int, error) {
+	return db.doInt(append([]string{"EXISTS"}, keys...)...)
+}
+
+// Expire sets a timeout of seconds on key, returning whether it exists.
+func (db *DB) Expire(key string, seconds int) (bool, error) {
+	n, err := db.doInt("EXPIRE", key, strconv.Itoa(seconds))
+	return n == 1, err
+}
+
+func (db *DB) HSet(key, field, value string) error {
+	_, err := db.Do("HSET", key, field, value)
+	return err
+}
+
+func (db *DB) HGet(key, field string) (string, error) {
+	return db.doBulk("HGET", key, field)
+}
+
+func (db *DB) HGetAll(key string) (map[string]string, error) {
+	values, err := db.doStrings("HGETALL", key)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		hash[values[i]] = values[i+1]
+	}
+
+	return hash, nil
+}
+
+// LPush and RPush return the length of the list after the push.
+func (db *DB) LPush(key string, values ...string) (int, error) {
+	return db.doInt(append([]string{"LPUSH", key}, values...)...)
+}
+
+func (db *DB) RPush(key string, values ...string) (int, error) {
+	return db.doInt(append([]string{"RPUSH", key}, values...)...)
+}
+
+func (db *DB) LRange(key string, start, stop int) ([]string, error) {
+	return db.doStrings("LRANGE", key, strconv.Itoa(start), strconv.Itoa(stop))
+}
+
+func (db *DB) SAdd(key string, members ...string) (int, error) {
+	return db.doInt(append([]string{"SADD", key}, members...)...)
+}
+
+func (db *DB) SMembers(key string) ([]string, error) {
+	return db.doStrings("SMEMBERS", key)
+}
+
+func (db *DB) ZAdd(key string, score float64, member string) (int, error) {
+	return db.doInt("ZADD", key, strconv.FormatFloat(score, 'g', -1, 64), member)
+}
+
+func (db *DB) ZScore(key, member string) (float64, error) {
+	score, err := db.doBulk("ZSCORE", key, member)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(score, 64)
+}
+
+// Publish sends message to the subscribers of channel, returning how many
+// received it.
+func (db *DB) Publish(channel, message string) (int, error) {
+	return db.doInt("PUBLISH", channel, message)
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"redisGo/resp"
+)
+
+func TestServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.aof")
+	srv, err := New(Options{AppendFilename: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(command("SET", "server:key", "v").Marshal())
+	if reply, err := resp.NewResp(conn).Read(); err != nil || reply.Str != "OK" {
+		t.Fatalf("Expected OK, got %v, %v", reply, err)
+	}
+
+	// Commands over the connection and through the DB share the dataset.
+	if value, err := srv.DB().Get("server:key"); err != nil || value != "v" {
+		t.Errorf("Expected v, got %q, %v", value, err)
+	}
+
+	// A Unix domain socket is served alongside, replacing a stale socket.
+	socket := filepath.Join(t.TempDir(), "redisGo.sock")
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	ul, err := ListenUnix(socket, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("Expected the socket to have permissions 0700, got %v, %v", info.Mode().Perm(), err)
+	}
+	servedUnix := make(chan error, 1)
+	go func() { servedUnix <- srv.Serve(ul) }()
+
+	unixConn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unixConn.Close()
+	reader := resp.NewResp(unixConn)
+	unixConn.Write(command("GET", "server:key").Marshal())
+	if reply, err := reader.Read(); err != nil || reply.Bulk != "v" {
+		t.Errorf("Expected v over the socket, got %v, %v", reply, err)
+	}
+	unixConn.Write(command("CLIENT", "LIST").Marshal())
+	if reply, err := reader.Read(); err != nil || !strings.Contains(reply.Bulk, " addr="+socket+":0 ") {
+		t.Errorf("Expected the client address to be the socket, got %v, %v", reply, err)
+	}
+
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("Expected ErrServerClosed, got %v", err)
+	}
+	if err := <-servedUnix; err != ErrServerClosed {
+		t.Errorf("Expected ErrServerClosed from the socket, got %v", err)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("Expected the socket to be removed, got %v", err)
+	}
+
+	// The next server loads the dataset back from the AOF.
+	srv, err = New(Options{AppendFilename: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	if value, err := srv.DB().Get("server:key"); err != nil || value != "v" {
+		t.Errorf("Expected v after reloading the AOF, got %q, %v", value, err)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	srv, err := New(Options{AppendFilename: filepath.Join(t.TempDir(), "database.aof")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(command("SHUTDOWN", "NOSAVE").Marshal())
+
+	// SHUTDOWN closes the server rather than exiting the process.
+	select {
+	case err := <-served:
+		if err != ErrServerClosed {
+			t.Errorf("Expected ErrServerClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Serve to return after SHUTDOWN")
+	}
+	if err := srv.Close(); err != ErrServerClosed {
+		t.Errorf("Expected the server to be closed already, got %v", err)
+	}
+
+	srv, err = New(Options{})
+	if err != nil {
+		t.Fatalf("Expected a new server after SHUTDOWN, got %v", err)
+	}
+	srv.Close()
+}
+
+func TestServerCloseStopsGoroutines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.aof")
+	before := runtime.NumGoroutine()
+
+	// Cluster nodes and Sentinels run crons of their own, and a Sentinel
+	// keeps a link to each primary it monitors.
+	options := []Options{
+		{AppendFilename: path},
+		{ClusterEnabled: true, ClusterConfigFile: filepath.Join(t.TempDir(), "nodes.conf")},
+		{Sentinel: true, SentinelMonitors: []string{"mymaster 127.0.0.1 1 2"}},
+	}
+	for i := 0; i < 20; i++ {
+		srv, err := New(options[i%len(options)])
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.Close()
+	}
+
+	// The goroutines see they are stopped asynchronously.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected no goroutines left behind, got %d more", after-before)
+	}
+}
+
+func TestServersSideBySide(t *testing.T) {
+	var servers [2]*Server
+	var addrs [2]string
+	for i := range servers {
+		srv, err := New(Options{AppendFilename: filepath.Join(t.TempDir(), "database.aof")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Close()
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go srv.Serve(l)
+		servers[i], addrs[i] = srv, l.Addr().String()
+	}
+
+	// Each server has its own keyspace and configuration.
+	for i, addr := range addrs {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		reader := resp.NewResp(conn)
+		conn.Write(command("SET", "side:key", addr).Marshal())
+		reader.Read()
+		conn.Write(command("CONFIG", "SET", "slowlog-max-len", strconv.Itoa(i+1)).Marshal())
+		if reply, err := reader.Read(); err != nil || reply.Str != "OK" {
+			t.Fatalf("Expected OK, got %v, %v", reply, err)
+		}
+	}
+	for i, srv := range servers {
+		if value, err := srv.DB().Get("side:key"); err != nil || value != addrs[i] {
+			t.Errorf("Expected %s, got %q, %v", addrs[i], value, err)
+		}
+		if srv.slowlogMaxLen != int64(i+1) {
+			t.Errorf("Expected slowlog-max-len %d, got %d", i+1, srv.slowlogMaxLen)
+		}
+	}
+
+	// Closing one server leaves the other serving.
+	servers[0].Close()
+	conn, err := net.Dial("tcp", addrs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(command("GET", "side:key").Marshal())
+	if reply, err := resp.NewResp(conn).Read(); err != nil || reply.Bulk != addrs[1] {
+		t.Errorf("Expected %s, got %v, %v", addrs[1], reply, err)
+	}
+}
+
+func TestDB(t *testing.T) {
+	db := newServer().DB()
+	db.Del("db:string", "db:hash", "db:list", "db:zset")
+
+	if _, err := db.Get("db:string"); err != ErrNil {
+		t.Errorf("Expected ErrNil for a missing key, got %v", err)
+	}
+	db.Set("db:string", "1")
+	if value, _ := db.Get("db:string"); value != "1" {
+		t.Errorf("Expected 1, got %q", value)
+	}
+
+	db.HSet("db:hash", "a", "1")
+	db.HSet("db:hash", "b", "2")
+	if hash, _ := db.HGetAll("db:hash"); !reflect.DeepEqual(hash, map[string]string{"a": "1", "b": "2"}) {
+		t.Errorf("Expected the hash fields, got %v", hash)
+	}
+
+	db.RPush("db:list", "a", "b")
+	db.LPush("db:list", "z")
+	if list, _ := db.LRange("db:list", 0, -1); !reflect.DeepEqual(list, []string{"z", "a", "b"}) {
+		t.Errorf("Expected [z a b], got %v", list)
+	}
+
+	db.ZAdd("db:zset", 1.5, "m")
+	if score, _ := db.ZScore("db:zset", "m"); score != 1.5 {
+		t.Errorf("Expected 1.5, got %v", score)
+	}
+
+	var replyErr Error
+	if _, err := db.LRange("db:string", 0, -1); !errors.As(err, &replyErr) || replyErr[:9] != "WRONGTYPE" {
+		t.Errorf("Expected a WRONGTYPE error, got %v", err)
+	}
+}
@@ -0,0 +1,701 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DUMP payloads follow the layout of Redis: the value in the RDB format,
+// the RDB version and a CRC64 of both, so that RESTORE can refuse payloads
+// that are corrupted or come from a newer version. Strings, lists, sets,
+// hashes and sorted sets use the plain RDB encodings Redis can load; streams
+// use a type of their own, as redisGo does not keep them as listpacks.
+const (
+	rdbVersion = 11
+
+	rdbTypeString = 0
+	rdbTypeList   = 1
+	rdbTypeSet    = 2
+	rdbTypeHash   = 4
+	rdbTypeZset2  = 5
+	rdbTypeStream = 200
+
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+var errBadDump = errors.New("ERR Bad data format")
+
+// The CRC64 of Redis uses the Jones polynomial, reflected, without the
+// initial and final inversions of the Go implementation, which are undone
+// around it.
+var crc64Table = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+func crc64Jones(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crc64Table, p)
+}
+
+type rdbWriter struct {
+	buf []byte
+}
+
+func (w *rdbWriter) writeLen(n uint64) {
+	switch {
+	case n < 1<<6:
+		w.buf = append(w.buf, byte(n))
+	case n < 1<<14:
+		w.buf = append(w.buf, byte(n>>8)|0x40, byte(n))
+	case n <= math.MaxUint32:
+		w.buf = append(w.buf, 0x80)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	default:
+		w.buf = append(w.buf, 0x81)
+		w.buf = binary.BigEndian.AppendUint64(w.buf, n)
+	}
+}
+
+// writeString writes s, as an integer if it is the canonical form of one
+// that fits 32 bits.
+func (w *rdbWriter) writeString(s string) {
+	if n, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(n, 10) == s {
+		switch {
+		case n >= math.MinInt8 && n <= math.MaxInt8:
+			w.buf = append(w.buf, 0xc0|rdbEncInt8, byte(n))
+		case n >= math.MinInt16 && n <= math.MaxInt16:
+			w.buf = append(w.buf, 0xc0|rdbEncInt16)
+			w.buf = binary.LittleEndian.AppendUint16(w.buf, uint16(n))
+		default:
+			w.buf = append(w.buf, 0xc0|rdbEncInt32)
+			w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(n))
+		}
+		return
+	}
+
+	w.writeLen(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *rdbWriter) writeID(id streamID) {
+	w.writeLen(id.ms)
+	w.writeLen(id.seq)
+}
+
+func (w *rdbWriter) writeTime(t time.Time) {
+	w.writeLen(uint64(t.UnixMilli()))
+}
+
+type rdbReader struct {
+	buf []byte
+	err error
+}
+
+func (r *rdbReader) next(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.buf) {
+		r.err = errBadDump
+		return make([]byte, max(n, 0))
+	}
+	p := r.buf[:n]
+	r.buf = r.buf[n:]
+	return p
+}
+
+// readLen reads a length, or the encoding of a string flagged by encoded.
+func (r *rdbReader) readLen() (n uint64, encoded bool) {
+	first := r.next(1)[0]
+	switch first >> 6 {
+	case 0:
+		return uint64(first), false
+	case 1:
+		return uint64(first&0x3f)<<8 | uint64(r.next(1)[0]), false
+	case 3:
+		return uint64(first & 0x3f), true
+	}
+	switch first {
+	case 0x80:
+		return uint64(binary.BigEndian.Uint32(r.next(4))), false
+	case 0x81:
+		return binary.BigEndian.Uint64(r.next(8)), false
+	}
+	r.err = errBadDump
+	return 0, false
+}
+
+// readCount reads the number of elements of an aggregate value, which
+// cannot be empty. Each element takes at least a byte, which bounds the
+// count by what is left of the payload.
+func (r *rdbReader) readCount() int {
+	n, encoded := r.readLen()
+	if encoded || n == 0 || n > uint64(len(r.buf)) {
+		r.err = errBadDump
+		return 0
+	}
+	return int(n)
+}
+
+func (r *rdbReader) readString() string {
+	n, encoded := r.readLen()
+	if !encoded {
+		if n > uint64(len(r.buf)) {
+			r.err = errBadDump
+			return ""
+		}
+		return string(r.next(int(n)))
+	}
+
+	switch n {
+	case rdbEncInt8:
+		return strconv.Itoa(int(int8(r.next(1)[0])))
+	case rdbEncInt16:
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(r.next(2)))))
+	case rdbEncInt32:
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(r.next(4)))))
+	case rdbEncLZF:
+		compressed, _ := r.readLen()
+		length, _ := r.readLen()
+		if compressed > uint64(len(r.buf)) || length > 1<<32 {
+			r.err = errBadDump
+			return ""
+		}
+		s, ok := lzfDecompress(r.next(int(compressed)), int(length))
+		if !ok {
+			r.err = errBadDump
+		}
+		return s
+	}
+	r.err = errBadDump
+	return ""
+}
+
+func (r *rdbReader) readID() streamID {
+	ms, _ := r.readLen()
+	seq, _ := r.readLen()
+	return streamID{ms, seq}
+}
+
+func (r *rdbReader) readTime() time.Time {
+	ms, _ := r.readLen()
+	return time.UnixMilli(int64(ms))
+}
+
+// lzfDecompress expands the LZF compressed strings Redis writes for long
+// values, which therefore show up in its DUMP payloads.
+func lzfDecompress(in []byte, length int) (string, bool) {
+	out := []byte{}
+	for i := 0; i < len(in) && len(out) <= length; {
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 1<<5 {
+			// A literal run of ctrl+1 bytes.
+			if i+ctrl+1 > len(in) {
+				return "", false
+			}
+			out = append(out, in[i:i+ctrl+1]...)
+			i += ctrl + 1
+			continue
+		}
+
+		// A back reference, whose length of 7 is extended by a byte.
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return "", false
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return "", false
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return "", false
+		}
+		// The reference may overlap the bytes it produces.
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	return string(out), len(out) == length
+}
+
+// dumpValue serializes the value at key into a DUMP payload. The caller
+// must hold the shard lock.
+func dumpValue(sh *Shard, key string) ([]byte, bool) {
+	w := &rdbWriter{}
+
+	switch sh.typeOf(key) {
+	case "string":
+		value, _ := sh.getString(key)
+		w.buf = append(w.buf, rdbTypeString)
+		w.writeString(value)
+	case "list":
+		list := sh.lists[key]
+		w.buf = append(w.buf, rdbTypeList)
+		w.writeLen(uint64(list.length()))
+		for _, element := range list.all() {
+			w.writeString(element)
+		}
+	case "set":
+		set := sh.smembers[key]
+		w.buf = append(w.buf, rdbTypeSet)
+		w.writeLen(uint64(set.length()))
+		for _, member := range set.sortedMembers() {
+			w.writeString(member)
+		}
+	case "hash":
+		hash := sh.hsets[key]
+		w.buf = append(w.buf, rdbTypeHash)
+		w.writeLen(uint64(hash.length()))
+		for _, field := range hash.sortedFields() {
+			value, _ := hash.get(field)
+			w.writeString(field)
+			w.writeString(value)
+		}
+	case "zset":
+		z := sh.zsets[key]
+		w.buf = append(w.buf, rdbTypeZset2)
+		w.writeLen(uint64(z.length))
+		for _, entry := range z.rangeByRank(0, z.length-1) {
+			w.writeString(entry.member)
+			w.buf = binary.LittleEndian.AppendUint64(w.buf, math.Float64bits(entry.score))
+		}
+	case "stream":
+		w.buf = append(w.buf, rdbTypeStream)
+		dumpStream(w, sh.streams[key])
+	default:
+		return nil, false
+	}
+
+	w.buf = binary.LittleEndian.AppendUint16(w.buf, rdbVersion)
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, crc64Jones(0, w.buf))
+	return w.buf, true
+}
+
+func dumpStream(w *rdbWriter, s *stream) {
+	entries := s.rangeEntries(streamMinID, streamMaxID, 0, false)
+	w.writeLen(uint64(len(entries)))
+	for _, entry := range entries {
+		w.writeID(entry.id)
+		w.writeLen(uint64(len(entry.fields)))
+		for _, field := range entry.fields {
+			w.writeString(field)
+		}
+	}
+	w.writeID(s.lastID)
+	w.writeID(s.maxDeletedID)
+	w.writeLen(uint64(s.entriesAdded))
+
+	groups := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+
+	w.writeLen(uint64(len(groups)))
+	for _, name := range groups {
+		group := s.groups[name]
+		w.writeString(name)
+		w.writeID(group.lastID)
+		w.writeLen(uint64(group.entriesRead))
+
+		ids := sortedPendingIDs(group.pel)
+		w.writeLen(uint64(len(ids)))
+		for _, id := range ids {
+			nack := group.pel[id]
+			w.writeID(id)
+			w.writeTime(nack.deliveryTime)
+			w.writeLen(uint64(nack.deliveryCount))
+		}
+
+		consumers := make([]string, 0, len(group.consumers))
+		for consumer := range group.consumers {
+			consumers = append(consumers, consumer)
+		}
+		sort.Strings(consumers)
+
+		w.writeLen(uint64(len(consumers)))
+		for _, name := range consumers {
+			consumer := group.consumers[name]
+			w.writeString(name)
+			w.writeTime(consumer.seenTime)
+			w.writeTime(consumer.activeTime)
+
+			ids := sortedPendingIDs(consumer.pel)
+			w.writeLen(uint64(len(ids)))
+			for _, id := range ids {
+				w.writeID(id)
+			}
+		}
+	}
+}
+
+// dumpedValue is a value decoded from a DUMP payload, not yet stored at
+// any key.
+type dumpedValue struct {
+	str    *string
+	list   *listObject
+	set    *setObject
+	hash   *hashObject
+	zset   *zset
+	stream *stream
+}
+
+// store sets key to the value, which must not exist. The caller must hold
+// the shard lock.
+func (v *dumpedValue) store(sh *Shard, key string) {
+	switch {
+	case v.str != nil:
+		sh.setString(key, *v.str)
+	case v.list != nil:
+		sh.lists[key] = v.list
+	case v.set != nil:
+		sh.smembers[key] = v.set
+	case v.hash != nil:
+		sh.hsets[key] = v.hash
+	case v.zset != nil:
+		sh.zsets[key] = v.zset
+	case v.stream != nil:
+		sh.streams[key] = v.stream
+	}
+}
+
+// verifyDump checks the version and checksum of a DUMP payload.
+func verifyDump(payload []byte) bool {
+	if len(payload) < 10 {
+		return false
+	}
+	footer := payload[len(payload)-10:]
+	if binary.LittleEndian.Uint16(footer) > rdbVersion {
+		return false
+	}
+	return binary.LittleEndian.Uint64(footer[2:]) == crc64Jones(0, payload[:len(payload)-8])
+}
+
+// loadDump decodes a DUMP payload, checking its version and checksum first.
+func (srv *Server) loadDump(payload []byte) (*dumpedValue, error) {
+	if !verifyDump(payload) {
+		return nil, errors.New("ERR DUMP payload version or checksum are wrong")
+	}
+
+	r := &rdbReader{buf: payload[:len(payload)-10]}
+	v := &dumpedValue{}
+
+	switch r.next(1)[0] {
+	case rdbTypeString:
+		s := r.readString()
+		v.str = &s
+	case rdbTypeList:
+		n := r.readCount()
+		elements := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			elements = append(elements, r.readString())
+		}
+		v.list = newListObject()
+		v.list.push(srv, elements, false)
+	case rdbTypeSet:
+		n := r.readCount()
+		v.set = newSetObject()
+		for i := 0; i < n && r.err == nil; i++ {
+			if !v.set.add(srv, r.readString()) {
+				return nil, errBadDump
+			}
+		}
+	case rdbTypeHash:
+		n := r.readCount()
+		v.hash = newHashObject()
+		for i := 0; i < n && r.err == nil; i++ {
+			field := r.readString()
+			if !v.hash.set(srv, field, r.readString()) {
+				return nil, errBadDump
+			}
+		}
+	case rdbTypeZset2:
+		n := r.readCount()
+		v.zset = newZset()
+		for i := 0; i < n; i++ {
+			member := r.readString()
+			score := math.Float64frombits(binary.LittleEndian.Uint64(r.next(8)))
+			if math.IsNaN(score) || !v.zset.add(member, score) {
+				return nil, errBadDump
+			}
+		}
+	case rdbTypeStream:
+		v.stream = loadStream(r)
+	default:
+		return nil, errBadDump
+	}
+
+	if r.err != nil || len(r.buf) != 0 {
+		return nil, errBadDump
+	}
+	return v, nil
+}
+
+func loadStream(r *rdbReader) *stream {
+	s := newStream()
+
+	n, _ := r.readLen()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		id := r.readID()
+		if i > 0 && !s.lastID.less(id) {
+			r.err = errBadDump
+		}
+		fields := make([]string, r.readCount())
+		for j := range fields {
+			fields[j] = r.readString()
+		}
+		s.append(id, fields)
+	}
+	s.lastID = r.readID()
+	s.maxDeletedID = r.readID()
+	entriesAdded, _ := r.readLen()
+	s.entriesAdded = int64(entriesAdded)
+
+	groups, _ := r.readLen()
+	for i := uint64(0); i < groups && r.err == nil; i++ {
+		name := r.readString()
+		group := &streamGroup{
+			lastID:    r.readID(),
+			pel:       map[streamID]*streamNACK{},
+			consumers: map[string]*streamConsumer{},
+		}
+		entriesRead, _ := r.readLen()
+		group.entriesRead = int64(entriesRead)
+		s.groups[name] = group
+
+		pending, _ := r.readLen()
+		for j := uint64(0); j < pending && r.err == nil; j++ {
+			id := r.readID()
+			nack := &streamNACK{deliveryTime: r.readTime()}
+			count, _ := r.readLen()
+			nack.deliveryCount = int64(count)
+			group.pel[id] = nack
+		}
+
+		consumers, _ := r.readLen()
+		for j := uint64(0); j < consumers && r.err == nil; j++ {
+			consumer := &streamConsumer{
+				name:       r.readString(),
+				seenTime:   r.readTime(),
+				activeTime: r.readTime(),
+				pel:        map[streamID]*streamNACK{},
+			}
+			group.consumers[consumer.name] = consumer
+
+			// The entries of the consumer share the NACKs of the group.
+			owned, _ := r.readLen()
+			for k := uint64(0); k < owned && r.err == nil; k++ {
+				id := r.readID()
+				nack, ok := group.pel[id]
+				if !ok || nack.consumer != nil {
+					r.err = errBadDump
+					break
+				}
+				nack.consumer = consumer
+				consumer.pel[id] = nack
+			}
+		}
+
+		for _, nack := range group.pel {
+			if nack.consumer == nil {
+				r.err = errBadDump
+			}
+		}
+	}
+
+	return s
+}
+
+func (srv *Server) dump(args []Value) Value {
+	key := args[0].Bulk
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	payload, ok := dumpValue(sh, key)
+	if !ok {
+		return Value{Type: "null"}
+	}
+	return Value{Type: "bulk", Bulk: string(payload)}
+}
+
+// RESTORE propagates itself with an absolute time to live, so that the AOF
+// does not extend it on every load.
+func (srv *Server) restore(args []Value) Value {
+	key := args[0].Bulk
+
+	ttl, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+	}
+	if ttl < 0 {
+		return Value{Type: "error", Str: "ERR Invalid TTL value, must be >= 0"}
+	}
+
+	replace, absttl := false, false
+	idletime, freq := int64(-1), int64(-1)
+	for i := 3; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i].Bulk); {
+		case option == "REPLACE":
+			replace = true
+		case option == "ABSTTL":
+			absttl = true
+		case option == "IDLETIME" && i+1 < len(args) && freq < 0:
+			idletime, err = strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+			}
+			if idletime < 0 {
+				return Value{Type: "error", Str: "ERR Invalid IDLETIME value, must be >= 0"}
+			}
+			i++
+		case option == "FREQ" && i+1 < len(args) && idletime < 0:
+			freq, err = strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+			}
+			if freq < 0 || freq > 255 {
+				return Value{Type: "error", Str: "ERR Invalid FREQ value, must be >= 0 and <= 255"}
+			}
+			i++
+		default:
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+	}
+
+	value, err := srv.loadDump([]byte(args[2].Bulk))
+	if err != nil {
+		return Value{Type: "error", Str: err.Error()}
+	}
+
+	now := time.Now()
+	var when time.Time
+	if ttl > 0 && absttl {
+		when = time.UnixMilli(ttl)
+	} else if ttl > 0 {
+		when = now.Add(time.Duration(ttl) * time.Millisecond)
+	}
+
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+	if sh.exists(key) && !replace {
+		sh.mu.Unlock()
+		return Value{Type: "error", Str: "BUSYKEY Target key name already exists."}
+	}
+
+	// A key restored already expired is deleted rather than stored, except
+	// by replicas and the AOF load, which wait for the DEL that follows.
+	if !when.IsZero() && !now.Before(when) && !srv.loading && !srv.isReplica() {
+		deleted := sh.delete(key)
+		sh.mu.Unlock()
+
+		if deleted {
+			srv.propagate(Value{Type: "bulk", Bulk: "DEL"}, Value{Type: "bulk", Bulk: key})
+			srv.notifyKeyspaceEvent(notifyGeneric, "del", key)
+		}
+		return Value{Type: "string", Str: "OK"}
+	}
+
+	sh.delete(key)
+	value.store(sh, key)
+	if !when.IsZero() {
+		sh.expires[key] = when
+	}
+
+	lastAccess, counter := now, uint8(lfuInitVal)
+	if idletime >= 0 {
+		lastAccess = now.Add(-time.Duration(idletime) * time.Second)
+	}
+	if freq >= 0 {
+		counter = uint8(freq)
+	}
+	sh.access[key] = newKeyAccess(lastAccess, counter)
+	sh.mu.Unlock()
+
+	expireAt := "0"
+	if !when.IsZero() {
+		expireAt = strconv.FormatInt(when.UnixMilli(), 10)
+	}
+	srv.propagate(
+		Value{Type: "bulk", Bulk: "RESTORE"},
+		Value{Type: "bulk", Bulk: key},
+		Value{Type: "bulk", Bulk: expireAt},
+		args[2],
+		Value{Type: "bulk", Bulk: "REPLACE"},
+		Value{Type: "bulk", Bulk: "ABSTTL"},
+	)
+	srv.notifyKeyspaceEvent(notifyGeneric, "restore", key)
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+// COPY duplicates the value through its DUMP payload, which makes a deep
+// copy of every type. Only database 0 exists.
+func (srv *Server) copyCommand(args []Value) Value {
+	source, destination := args[0].Bulk, args[1].Bulk
+
+	replace := false
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i].Bulk); {
+		case option == "REPLACE":
+			replace = true
+		case option == "DB" && i+1 < len(args):
+			db, err := strconv.Atoi(args[i+1].Bulk)
+			if err != nil {
+				return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+			}
+			if db != 0 {
+				return Value{Type: "error", Str: "ERR DB index is out of range"}
+			}
+			i++
+		default:
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+	}
+
+	if source == destination {
+		return Value{Type: "error", Str: "ERR source and destination objects are the same"}
+	}
+
+	srv.expireIfNeeded(source)
+	srv.expireIfNeeded(destination)
+
+	unlock := srv.lockKeys([]string{source, destination})
+	src, dst := srv.shardOf(source), srv.shardOf(destination)
+
+	payload, ok := dumpValue(src, source)
+	if !ok || (dst.exists(destination) && !replace) {
+		unlock()
+		return Value{Type: "integer", Num: 0}
+	}
+	value, err := srv.loadDump(payload)
+	if err != nil {
+		unlock()
+		return Value{Type: "error", Str: err.Error()}
+	}
+
+	dst.delete(destination)
+	value.store(dst, destination)
+	if when, ok := src.expires[source]; ok {
+		dst.expires[destination] = when
+	}
+	unlock()
+
+	srv.notifyKeyspaceEvent(notifyGeneric, "copy_to", destination)
+
+	return Value{Type: "integer", Num: 1}
+}
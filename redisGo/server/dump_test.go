@@ -0,0 +1,163 @@
+package server
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestCRC64(t *testing.T) {
+	if crc := crc64Jones(0, []byte("123456789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Errorf("Expected 0xe9c6d914c4b8d9ca, got %#x", crc)
+	}
+}
+
+func TestDumpRestore(t *testing.T) {
+	testServer.set(command("dump:string", "hello").Array)
+	testServer.set(command("dump:int", "-4000").Array)
+	testServer.rpush(command("dump:list", "a", "12", "c").Array)
+	testServer.sadd(command("dump:set", "x", "y").Array)
+	testServer.hset(command("dump:hash", "f", "v").Array)
+	testServer.zadd(command("dump:zset", "1.5", "a", "-2", "b").Array)
+	testServer.xadd(command("dump:stream", "1-1", "f", "v").Array)
+	testServer.xadd(command("dump:stream", "2-1", "g", "w").Array)
+	testServer.xgroup(command("CREATE", "dump:stream", "group", "0").Array)
+	testServer.xgroup(command("CREATECONSUMER", "dump:stream", "group", "alice").Array)
+	testServer.xclaim(command("dump:stream", "group", "alice", "0", "1-1", "RETRYCOUNT", "3", "FORCE", "JUSTID").Array)
+
+	for _, key := range []string{"dump:string", "dump:int", "dump:list", "dump:set", "dump:hash", "dump:zset", "dump:stream"} {
+		payload := testServer.dump(command(key).Array)
+		if payload.Type != "bulk" {
+			t.Errorf("%s: expected a payload, got %v", key, payload)
+			continue
+		}
+
+		restored := key + ":restored"
+		if result := testServer.restore(command(restored, "0", payload.Bulk).Array); result.Str != "OK" {
+			t.Errorf("%s: expected OK, got %v", key, result)
+			continue
+		}
+		if again := testServer.dump(command(restored).Array); again.Bulk != payload.Bulk {
+			t.Errorf("%s: expected the restored key to dump the same payload", key)
+		}
+	}
+
+	expectBulks(t, "restored list", testServer.lrange(command("dump:list:restored", "0", "-1").Array), "a", "12", "c")
+	sh := testServer.shardOf("dump:stream:restored")
+	if nack := sh.streams["dump:stream:restored"].groups["group"].pel[streamID{1, 1}]; nack == nil || nack.consumer.name != "alice" || nack.deliveryCount != 3 {
+		t.Errorf("Expected the pending entry of alice to be restored, got %v", nack)
+	}
+
+	if result := testServer.dump(command("dump:missing").Array); result.Type != "null" {
+		t.Errorf("Expected null for a missing key, got %v", result)
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	testServer.set(command("restore:a", "value").Array)
+	payload := testServer.dump(command("restore:a").Array).Bulk
+
+	if result := testServer.restore(command("restore:a", "0", payload).Array); result.Str != "BUSYKEY Target key name already exists." {
+		t.Errorf("Expected BUSYKEY, got %v", result)
+	}
+	if result := testServer.restore(command("restore:a", "0", payload, "REPLACE").Array); result.Str != "OK" {
+		t.Errorf("Expected REPLACE to succeed, got %v", result)
+	}
+
+	corrupted := []byte(payload)
+	corrupted[2] ^= 1
+	if result := testServer.restore(command("restore:b", "0", string(corrupted)).Array); result.Str != "ERR DUMP payload version or checksum are wrong" {
+		t.Errorf("Expected a checksum error, got %v", result)
+	}
+	if result := testServer.restore(command("restore:b", "-1", payload).Array); result.Str != "ERR Invalid TTL value, must be >= 0" {
+		t.Errorf("Expected a TTL error, got %v", result)
+	}
+
+	if result := testServer.restore(command("restore:b", "100000", payload).Array); result.Str != "OK" {
+		t.Errorf("Expected OK, got %v", result)
+	}
+	if result := testServer.pttl(command("restore:b").Array); result.Num <= 0 || result.Num > 100000 {
+		t.Errorf("Expected a TTL of up to 100000 ms, got %v", result)
+	}
+
+	// A TTL in the past does not create the key.
+	if result := testServer.restore(command("restore:c", "1", payload, "ABSTTL").Array); result.Str != "OK" || testServer.keyExists("restore:c") {
+		t.Errorf("Expected an already expired key to be skipped, got %v", result)
+	}
+
+	if result := testServer.restore(command("restore:d", "0", payload, "IDLETIME", "1000").Array); result.Str != "OK" {
+		t.Errorf("Expected OK, got %v", result)
+	}
+	if result := testServer.object(command("IDLETIME", "restore:d").Array); result.Num < 1000 {
+		t.Errorf("Expected an idle time of at least 1000, got %v", result)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	testServer.rpush(command("copy:src", "a", "b").Array)
+	testServer.pexpire(command("copy:src", "100000").Array)
+
+	if result := testServer.copyCommand(command("copy:src", "copy:dst").Array); result.Num != 1 {
+		t.Errorf("Expected 1, got %v", result)
+	}
+	testServer.rpush(command("copy:src", "c").Array)
+	expectBulks(t, "copy", testServer.lrange(command("copy:dst", "0", "-1").Array), "a", "b")
+	if result := testServer.pttl(command("copy:dst").Array); result.Num <= 0 {
+		t.Errorf("Expected the TTL to be copied, got %v", result)
+	}
+
+	if result := testServer.copyCommand(command("copy:src", "copy:dst").Array); result.Num != 0 {
+		t.Errorf("Expected 0 without REPLACE, got %v", result)
+	}
+	if result := testServer.copyCommand(command("copy:src", "copy:dst", "REPLACE").Array); result.Num != 1 {
+		t.Errorf("Expected 1 with REPLACE, got %v", result)
+	}
+	if result := testServer.copyCommand(command("copy:missing", "copy:dst", "REPLACE").Array); result.Num != 0 {
+		t.Errorf("Expected 0 for a missing source, got %v", result)
+	}
+	if result := testServer.copyCommand(command("copy:src", "copy:other", "DB", "1").Array); result.Str != "ERR DB index is out of range" {
+		t.Errorf("Expected a DB error, got %v", result)
+	}
+}
+
+func TestObject(t *testing.T) {
+	testServer.set(command("object:short", "x").Array)
+	testServer.rpush(command("object:list", "x").Array)
+
+	if result := testServer.object(command("ENCODING", "object:short").Array); result.Bulk != "embstr" {
+		t.Errorf("Expected embstr, got %v", result)
+	}
+	if result := testServer.object(command("ENCODING", "object:list").Array); result.Bulk != "listpack" {
+		t.Errorf("Expected listpack, got %v", result)
+	}
+	if result := testServer.object(command("FREQ", "object:missing").Array); result.Type != "null" {
+		t.Errorf("Expected null for a missing key, got %v", result)
+	}
+
+	if result := testServer.object(command("FREQ", "object:short").Array); result.Type != "error" {
+		t.Errorf("Expected an error without an LFU policy, got %v", result)
+	}
+	testServer.config(command("SET", "maxmemory-policy", "allkeys-lfu").Array)
+	defer testServer.config(command("SET", "maxmemory-policy", "noeviction").Array)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				testServer.touchKeys("GET", command("object:short").Array)
+			}
+		}()
+	}
+	wg.Wait()
+	if result := testServer.object(command("FREQ", "object:short").Array); result.Num <= lfuInitVal {
+		t.Errorf("Expected the frequency to grow with the accesses, got %v", result)
+	}
+	if result := testServer.object(command("IDLETIME", "object:short").Array); result.Type != "error" {
+		t.Errorf("Expected an error for the idle time with an LFU policy, got %v", result)
+	}
+
+	if result := testServer.memory(command("USAGE", "object:list").Array); result.Type != "integer" || result.Num <= 0 {
+		t.Errorf("Expected a positive memory usage, got %v", result)
+	}
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keyExists reports whether key holds a value of any type.
+func (srv *Server) keyExists(key string) bool {
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	return sh.exists(key)
+}
+
+// deleteKey removes key, whatever its type, along with its expiry.
+func (srv *Server) deleteKey(key string) bool {
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	return sh.delete(key)
+}
+
+func (srv *Server) removeExpire(key string) bool {
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	_, ok := sh.expires[key]
+	delete(sh.expires, key)
+	return ok
+}
+
+// expireIfNeeded lazily deletes key if its time to live has passed. It must
+// be called before the handler takes the lock of the key's shard.
+func (srv *Server) expireIfNeeded(key string) bool {
+	// Replicas wait for the DEL of their primary.
+	if srv.loading || srv.isReplica() {
+		return false
+	}
+
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+	when, ok := sh.expires[key]
+	expired := ok && !time.Now().Before(when)
+	if expired {
+		sh.delete(key)
+	}
+	sh.mu.Unlock()
+
+	if !expired {
+		return false
+	}
+
+	srv.stats.expiredKeys.Add(1)
+	srv.propagate(Value{Type: "bulk", Bulk: "DEL"}, Value{Type: "bulk", Bulk: key})
+	srv.notifyKeyspaceEvent(notifyExpired, "expired", key)
+	srv.signalModifiedKeys(nil, []string{key})
+
+	return true
+}
+
+// Active expiry: keys nobody reads would otherwise never be deleted, so a
+// sample of volatile keys is checked ten times a second, repeating while a
+// large share of the sample turns out to be expired.
+const (
+	activeExpireInterval    = 100 * time.Millisecond
+	activeExpireSampleSize  = 20
+	activeExpireRepeatRatio = 4
+)
+
+// activeExpireCycle runs the active expiry until done is closed.
+func (srv *Server) activeExpireCycle() {
+	ticker := time.NewTicker(activeExpireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-srv.done:
+			return
+		}
+		if srv.isReplica() {
+			continue
+		}
+
+		srv.execMu.RLock()
+		for {
+			if srv.activeExpireSample() <= activeExpireSampleSize/activeExpireRepeatRatio {
+				break
+			}
+		}
+		srv.execMu.RUnlock()
+	}
+}
+
+// activeExpireSample checks a sample of volatile keys, starting from a
+// random shard and relying on the random map iteration order within each,
+// and returns how many of them expired.
+func (srv *Server) activeExpireSample() int {
+	keys := []string{}
+
+	first := rand.Intn(len(srv.shards))
+	for i := 0; i < len(srv.shards) && len(keys) < activeExpireSampleSize; i++ {
+		sh := srv.shards[(first+i)%len(srv.shards)]
+		sh.mu.RLock()
+		for key := range sh.expires {
+			keys = append(keys, key)
+			if len(keys) == activeExpireSampleSize {
+				break
+			}
+		}
+		sh.mu.RUnlock()
+	}
+
+	expired := 0
+	for _, key := range keys {
+		if srv.expireIfNeeded(key) {
+			expired++
+		}
+	}
+	return expired
+}
+
+func (srv *Server) del(args []Value) Value {
+	keys := []string{}
+	for _, arg := range args {
+		srv.expireIfNeeded(arg.Bulk)
+		keys = append(keys, arg.Bulk)
+	}
+
+	unlock := srv.lockKeys(keys)
+	deleted := []string{}
+	for _, key := range keys {
+		if srv.shardOf(key).delete(key) {
+			deleted = append(deleted, key)
+		}
+	}
+	unlock()
+
+	for _, key := range deleted {
+		srv.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	}
+
+	return Value{Type: "integer", Num: len(deleted)}
+}
+
+func (srv *Server) exists(args []Value) Value {
+	keys := []string{}
+	for _, arg := range args {
+		srv.expireIfNeeded(arg.Bulk)
+		keys = append(keys, arg.Bulk)
+	}
+
+	unlock := srv.rlockKeys(keys)
+	defer unlock()
+
+	count := 0
+	for _, key := range keys {
+		if srv.shardOf(key).exists(key) {
+			count++
+		}
+	}
+
+	return Value{Type: "integer", Num: count}
+}
+
+func (srv *Server) expire(args []Value) Value {
+	return srv.expireGeneric("expire", args, time.Now(), time.Second)
+}
+
+func (srv *Server) pexpire(args []Value) Value {
+	return srv.expireGeneric("pexpire", args, time.Now(), time.Millisecond)
+}
+
+func (srv *Server) expireat(args []Value) Value {
+	return srv.expireGeneric("expireat", args, time.Unix(0, 0), time.Second)
+}
+
+func (srv *Server) pexpireat(args []Value) Value {
+	return srv.expireGeneric("pexpireat", args, time.Unix(0, 0), time.Millisecond)
+}
+
+// expireGeneric implements the EXPIRE family: the deadline is basetime plus
+// the argument in units. The command is logged as an absolute PEXPIREAT so
+// that replaying the AOF later does not extend the key's life.
+func (srv *Server) expireGeneric(name string, args []Value, basetime time.Time, unit time.Duration) Value {
+	if len(args) < 2 || len(args) > 3 {
+		return Value{Type: "error", Str: fmt.Sprintf("ERR wrong number of arguments for '%s' command", name)}
+	}
+
+	key := args[0].Bulk
+
+	n, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+	}
+
+	// Like Redis, the deadline is computed in milliseconds, refusing any
+	// that overflows.
+	perMilli := int64(unit / time.Millisecond)
+	base := basetime.UnixMilli()
+	if n > math.MaxInt64/perMilli || n < math.MinInt64/perMilli ||
+		(n > 0 && n*perMilli > math.MaxInt64-base) || (n < 0 && n*perMilli < math.MinInt64-base) {
+		return Value{Type: "error", Str: fmt.Sprintf("ERR invalid expire time in '%s' command", name)}
+	}
+	when := time.UnixMilli(base + n*perMilli)
+
+	option := ""
+	if len(args) == 3 {
+		option = strings.ToUpper(args[2].Bulk)
+		if option != "NX" && option != "XX" && option != "GT" && option != "LT" {
+			return Value{Type: "error", Str: fmt.Sprintf("ERR Unsupported option %s", args[2].Bulk)}
+		}
+	}
+
+	srv.expireIfNeeded(key)
+
+	// The check and the update happen under one lock, so that the key
+	// cannot be deleted in between, which would leave its TTL to the next
+	// key of that name.
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+
+	if !sh.exists(key) {
+		sh.mu.Unlock()
+		return Value{Type: "integer", Num: 0}
+	}
+	current, volatile := sh.expires[key]
+
+	// A key without a TTL counts as having an infinite one for GT and LT.
+	skip := false
+	switch option {
+	case "NX":
+		skip = volatile
+	case "XX":
+		skip = !volatile
+	case "GT":
+		skip = !volatile || !when.After(current)
+	case "LT":
+		skip = volatile && !when.Before(current)
+	}
+	if skip {
+		sh.mu.Unlock()
+		return Value{Type: "integer", Num: 0}
+	}
+
+	if !when.After(time.Now()) && !srv.loading {
+		sh.delete(key)
+		sh.mu.Unlock()
+
+		srv.propagate(Value{Type: "bulk", Bulk: "DEL"}, Value{Type: "bulk", Bulk: key})
+		srv.notifyKeyspaceEvent(notifyGeneric, "del", key)
+		return Value{Type: "integer", Num: 1}
+	}
+
+	sh.expires[key] = when
+	sh.mu.Unlock()
+
+	srv.propagate(
+		Value{Type: "bulk", Bulk: "PEXPIREAT"},
+		Value{Type: "bulk", Bulk: key},
+		Value{Type: "bulk", Bulk: strconv.FormatInt(when.UnixMilli(), 10)},
+	)
+	srv.notifyKeyspaceEvent(notifyGeneric, "expire", key)
+
+	return Value{Type: "integer", Num: 1}
+}
+
+func (srv *Server) ttl(args []Value) Value {
+	return srv.ttlGeneric(args, time.Second)
+}
+
+func (srv *Server) pttl(args []Value) Value {
+	return srv.ttlGeneric(args, time.Millisecond)
+}
+
+func (srv *Server) ttlGeneric(args []Value, unit time.Duration) Value {
+	key := args[0].Bulk
+
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+	found := sh.exists(key)
+	when, ok := sh.expires[key]
+	sh.mu.RUnlock()
+
+	if !found {
+		return Value{Type: "integer", Num: -2}
+	}
+	if !ok {
+		return Value{Type: "integer", Num: -1}
+	}
+
+	// Round to the nearest unit, as Redis does. Milliseconds hold TTLs far
+	// longer than a time.Duration.
+	remaining := when.UnixMilli() - time.Now().UnixMilli()
+	perMilli := int64(unit / time.Millisecond)
+	return Value{Type: "integer", Num: int((remaining + perMilli/2) / perMilli)}
+}
+
+func (srv *Server) persist(args []Value) Value {
+	key := args[0].Bulk
+
+	srv.expireIfNeeded(key)
+	if !srv.removeExpire(key) {
+		return Value{Type: "integer", Num: 0}
+	}
+
+	srv.notifyKeyspaceEvent(notifyGeneric, "persist", key)
+
+	return Value{Type: "integer", Num: 1}
+}
@@ -0,0 +1,604 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Geospatial indexes are sorted sets whose scores are 52 bit geohashes: the
+// bits of the longitude and latitude, each scaled to 26 bits, interleaved.
+// Points close to each other mostly share a prefix and hence sort close
+// together, so an area can be searched with a few ranges of scores. As in
+// Redis, latitudes are limited to the range of the Web Mercator projection.
+const (
+	geoLongMin = -180.0
+	geoLongMax = 180.0
+	geoLatMin  = -85.05112878
+	geoLatMax  = 85.05112878
+
+	geoStepMax = 26
+
+	earthRadiusInMeters = 6372797.560856
+	mercatorMax         = 20037726.37
+)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+func interleave64(x, y uint32) uint64 {
+	var bits uint64
+	for i := 0; i < 32; i++ {
+		bits |= uint64(x>>i&1)<<(2*i) | uint64(y>>i&1)<<(2*i+1)
+	}
+	return bits
+}
+
+func deinterleave64(bits uint64) (x, y uint32) {
+	for i := 0; i < 32; i++ {
+		x |= uint32(bits>>(2*i)&1) << i
+		y |= uint32(bits>>(2*i+1)&1) << i
+	}
+	return x, y
+}
+
+// geohashEncode returns the geohash of the point with step bits per
+// coordinate, within the given latitude range.
+func geohashEncode(long, lat float64, step uint, latMin, latMax float64) uint64 {
+	latOffset := (lat - latMin) / (latMax - latMin) * float64(uint64(1)<<step)
+	longOffset := (long - geoLongMin) / (geoLongMax - geoLongMin) * float64(uint64(1)<<step)
+	return interleave64(uint32(latOffset), uint32(longOffset))
+}
+
+// geohashArea is the box a geohash of step bits per coordinate stands for.
+type geohashArea struct {
+	longMin, longMax float64
+	latMin, latMax   float64
+}
+
+func geohashDecode(bits uint64, step uint) geohashArea {
+	latIndex, longIndex := deinterleave64(bits)
+	cells := float64(uint64(1) << step)
+	latScale := geoLatMax - geoLatMin
+	longScale := geoLongMax - geoLongMin
+
+	return geohashArea{
+		longMin: geoLongMin + float64(longIndex)/cells*longScale,
+		longMax: geoLongMin + float64(longIndex+1)/cells*longScale,
+		latMin:  geoLatMin + float64(latIndex)/cells*latScale,
+		latMax:  geoLatMin + float64(latIndex+1)/cells*latScale,
+	}
+}
+
+// geoDecodeScore returns the center of the box a score stands for.
+func geoDecodeScore(score float64) (long, lat float64) {
+	area := geohashDecode(uint64(score), geoStepMax)
+	long = math.Max(geoLongMin, math.Min(geoLongMax, (area.longMin+area.longMax)/2))
+	lat = math.Max(geoLatMin, math.Min(geoLatMax, (area.latMin+area.latMax)/2))
+	return long, lat
+}
+
+// geohashString returns the standard 11 character geohash of a score, which
+// unlike the score uses the full -90 to 90 range of latitudes.
+func geohashString(score float64) string {
+	long, lat := geoDecodeScore(score)
+	bits := geohashEncode(long, lat, geoStepMax, -90, 90)
+
+	buf := make([]byte, 11)
+	for i := range buf {
+		// The 52 bits only fill 10 characters and a half.
+		idx := 0
+		if i < 10 {
+			idx = int(bits >> (52 - (i+1)*5) & 0x1f)
+		}
+		buf[i] = geohashAlphabet[idx]
+	}
+	return string(buf)
+}
+
+func degToRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func radToDeg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// geoDistance returns the distance in meters between two points along the
+// surface of the earth, with the haversine formula.
+func geoDistance(long1, lat1, long2, lat2 float64) float64 {
+	lat1r, lat2r := degToRad(lat1), degToRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin(degToRad(long2-long1) / 2)
+	return 2 * earthRadiusInMeters * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// geoUnits maps each unit to its length in meters.
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"ft": 0.3048,
+	"mi": 1609.34,
+}
+
+func parseGeoUnit(s string) (float64, bool) {
+	unit, ok := geoUnits[strings.ToLower(s)]
+	return unit, ok
+}
+
+func parseLongLat(longArg, latArg string) (float64, float64, *Value) {
+	long, err1 := strconv.ParseFloat(longArg, 64)
+	lat, err2 := strconv.ParseFloat(latArg, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, &Value{Type: "error", Str: "ERR value is not a valid float"}
+	}
+	if long < geoLongMin || long > geoLongMax || lat < geoLatMin || lat > geoLatMax {
+		return 0, 0, &Value{Type: "error", Str: fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", long, lat)}
+	}
+	return long, lat, nil
+}
+
+const geoUnitError = "ERR unsupported unit provided. please use M, KM, FT, MI"
+
+func (srv *Server) geoadd(args []Value) Value {
+	key := args[0].Bulk
+
+	nx, xx, ch := false, false, false
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "NX":
+			nx = true
+			continue
+		case "XX":
+			xx = true
+			continue
+		case "CH":
+			ch = true
+			continue
+		}
+		break
+	}
+	if nx && xx {
+		return Value{Type: "error", Str: "ERR XX and NX options at the same time are not compatible"}
+	}
+
+	triples := args[i:]
+	if len(triples) == 0 || len(triples)%3 != 0 {
+		return Value{Type: "error", Str: "ERR syntax error. Try GEOADD key [x1] [y1] [name1] [x2] [y2] [name2] ... "}
+	}
+
+	entries := []zsetEntry{}
+	for j := 0; j < len(triples); j += 3 {
+		long, lat, errValue := parseLongLat(triples[j].Bulk, triples[j+1].Bulk)
+		if errValue != nil {
+			return *errValue
+		}
+		bits := geohashEncode(long, lat, geoStepMax, geoLatMin, geoLatMax)
+		entries = append(entries, zsetEntry{member: triples[j+2].Bulk, score: float64(bits)})
+	}
+
+	return srv.zaddEntries(key, entries, nx, xx, ch)
+}
+
+// lookupGeo returns the sorted set at key, or nil, for the read only geo
+// commands. The caller must release the shard lock.
+func (srv *Server) lookupGeo(key string) (*Shard, *zset, *Value) {
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+
+	z, errValue := lookupZset(sh, key, false)
+	if errValue != nil {
+		sh.mu.RUnlock()
+		return nil, nil, errValue
+	}
+	return sh, z, nil
+}
+
+func (srv *Server) geodist(args []Value) Value {
+	unit := 1.0
+	if len(args) == 4 {
+		var ok bool
+		if unit, ok = parseGeoUnit(args[3].Bulk); !ok {
+			return Value{Type: "error", Str: geoUnitError}
+		}
+	} else if len(args) > 4 {
+		return Value{Type: "error", Str: "ERR syntax error"}
+	}
+
+	sh, z, errValue := srv.lookupGeo(args[0].Bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	defer sh.mu.RUnlock()
+
+	if z == nil {
+		return Value{Type: "null"}
+	}
+	score1, ok1 := z.dict[args[1].Bulk]
+	score2, ok2 := z.dict[args[2].Bulk]
+	if !ok1 || !ok2 {
+		return Value{Type: "null"}
+	}
+
+	long1, lat1 := geoDecodeScore(score1)
+	long2, lat2 := geoDecodeScore(score2)
+	distance := geoDistance(long1, lat1, long2, lat2) / unit
+
+	return Value{Type: "bulk", Bulk: strconv.FormatFloat(distance, 'f', 4, 64)}
+}
+
+func (srv *Server) geopos(args []Value) Value {
+	sh, z, errValue := srv.lookupGeo(args[0].Bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	defer sh.mu.RUnlock()
+
+	values := []Value{}
+	for _, arg := range args[1:] {
+		var score float64
+		ok := false
+		if z != nil {
+			score, ok = z.dict[arg.Bulk]
+		}
+		if !ok {
+			values = append(values, Value{Type: "null"})
+			continue
+		}
+
+		long, lat := geoDecodeScore(score)
+		values = append(values, Value{Type: "array", Array: []Value{
+			{Type: "bulk", Bulk: strconv.FormatFloat(long, 'f', 17, 64)},
+			{Type: "bulk", Bulk: strconv.FormatFloat(lat, 'f', 17, 64)},
+		}})
+	}
+
+	return Value{Type: "array", Array: values}
+}
+
+func (srv *Server) geohash(args []Value) Value {
+	sh, z, errValue := srv.lookupGeo(args[0].Bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	defer sh.mu.RUnlock()
+
+	values := []Value{}
+	for _, arg := range args[1:] {
+		var score float64
+		ok := false
+		if z != nil {
+			score, ok = z.dict[arg.Bulk]
+		}
+		if !ok {
+			values = append(values, Value{Type: "null"})
+			continue
+		}
+		values = append(values, Value{Type: "bulk", Bulk: geohashString(score)})
+	}
+
+	return Value{Type: "array", Array: values}
+}
+
+// geoShape is the area of a GEOSEARCH: a circle of the given radius or a box
+// of the given width and height, in meters, around a center.
+type geoShape struct {
+	long, lat     float64
+	radius        float64
+	width, height float64
+	byBox         bool
+	unit          float64
+}
+
+// contains reports whether the point is within the shape, and how far it
+// is from the center.
+func (shape *geoShape) contains(long, lat float64) (float64, bool) {
+	if !shape.byBox {
+		distance := geoDistance(shape.long, shape.lat, long, lat)
+		return distance, distance <= shape.radius
+	}
+
+	// The box is bounded by the distances along the meridian and the
+	// parallel of the center.
+	if geoDistance(shape.long, shape.lat, shape.long, lat) > shape.height/2 {
+		return 0, false
+	}
+	if geoDistance(shape.long, shape.lat, long, shape.lat) > shape.width/2 {
+		return 0, false
+	}
+	return geoDistance(shape.long, shape.lat, long, lat), true
+}
+
+// boundingBox returns the longitudes and latitudes bounding the shape.
+func (shape *geoShape) boundingBox() geohashArea {
+	halfWidth, halfHeight := shape.radius, shape.radius
+	if shape.byBox {
+		halfWidth, halfHeight = shape.width/2, shape.height/2
+	}
+
+	latr := degToRad(shape.lat)
+	latDelta := radToDeg(halfHeight / earthRadiusInMeters)
+	// The box is wider on the side closer to the equator.
+	longDelta := math.Max(
+		radToDeg(halfWidth/earthRadiusInMeters/math.Cos(latr+halfHeight/earthRadiusInMeters)),
+		radToDeg(halfWidth/earthRadiusInMeters/math.Cos(latr-halfHeight/earthRadiusInMeters)),
+	)
+	if math.IsNaN(longDelta) || longDelta < 0 || longDelta > 180 {
+		longDelta = 180
+	}
+
+	return geohashArea{
+		longMin: shape.long - longDelta,
+		longMax: shape.long + longDelta,
+		latMin:  math.Max(geoLatMin, shape.lat-latDelta),
+		latMax:  math.Min(geoLatMax, shape.lat+latDelta),
+	}
+}
+
+// geohashEstimateSteps returns the precision at which a geohash box is about
+// as large as the range, so that the box of the center and its eight
+// neighbours cover it.
+func geohashEstimateSteps(rangeMeters, lat float64) uint {
+	if rangeMeters == 0 {
+		return geoStepMax
+	}
+
+	step := 1
+	for rangeMeters < mercatorMax && step < 64 {
+		rangeMeters *= 2
+		step++
+	}
+	step -= 2
+
+	// Boxes get narrower towards the poles.
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+
+	return uint(max(1, min(step, geoStepMax)))
+}
+
+// scoreRanges returns the ranges of scores of the points that may be in the
+// shape: those of the geohash box of the center and its neighbours, at a
+// precision where they cover the bounding box of the shape.
+func (shape *geoShape) scoreRanges() [][2]float64 {
+	radius := shape.radius
+	if shape.byBox {
+		radius = math.Hypot(shape.width/2, shape.height/2)
+	}
+	bounds := shape.boundingBox()
+
+	step := geohashEstimateSteps(radius, shape.lat)
+	for ; step > 1; step-- {
+		center := geohashDecode(geohashEncode(shape.long, shape.lat, step, geoLatMin, geoLatMax), step)
+		width, height := center.longMax-center.longMin, center.latMax-center.latMin
+		if bounds.longMin >= center.longMin-width && bounds.longMax <= center.longMax+width &&
+			bounds.latMin >= center.latMin-height && bounds.latMax <= center.latMax+height {
+			break
+		}
+	}
+
+	// At the lowest precision the neighbours are the whole world.
+	if step <= 1 {
+		return [][2]float64{{0, float64(uint64(1) << (2 * geoStepMax))}}
+	}
+
+	latIndex, longIndex := deinterleave64(geohashEncode(shape.long, shape.lat, step, geoLatMin, geoLatMax))
+	cells := int64(1) << step
+	shift := 2 * (geoStepMax - step)
+
+	seen := map[uint64]bool{}
+	ranges := [][2]float64{}
+	for dlat := int64(-1); dlat <= 1; dlat++ {
+		for dlong := int64(-1); dlong <= 1; dlong++ {
+			lat := int64(latIndex) + dlat
+			if lat < 0 || lat >= cells {
+				continue
+			}
+			// Longitudes wrap around the antimeridian.
+			long := (int64(longIndex) + dlong + cells) % cells
+
+			bits := interleave64(uint32(lat), uint32(long))
+			if seen[bits] {
+				continue
+			}
+			seen[bits] = true
+			ranges = append(ranges, [2]float64{float64(bits << shift), float64((bits + 1) << shift)})
+		}
+	}
+	return ranges
+}
+
+type geoPoint struct {
+	member   string
+	score    float64
+	long     float64
+	lat      float64
+	distance float64
+}
+
+// search returns the members of z within the shape. With countAny set it stops
+// after count of them.
+func (shape *geoShape) search(z *zset, count int, countAny bool) []geoPoint {
+	points := []geoPoint{}
+	for _, r := range shape.scoreRanges() {
+		z.each(r[0], func(member string, score float64) bool {
+			if score >= r[1] {
+				return false
+			}
+			long, lat := geoDecodeScore(score)
+			if distance, ok := shape.contains(long, lat); ok {
+				points = append(points, geoPoint{member: member, score: score, long: long, lat: lat, distance: distance})
+			}
+			return !countAny || count == 0 || len(points) < count
+		})
+		if countAny && count > 0 && len(points) >= count {
+			break
+		}
+	}
+	return points
+}
+
+func (srv *Server) geosearch(args []Value) Value {
+	key := args[0].Bulk
+
+	shape := geoShape{}
+	var fromMember string
+	fromMemberSet, fromLonLatSet, bySet := false, false, false
+	sortOrder := ""
+	count, countAny := 0, false
+	withCoord, withDist, withHash := false, false, false
+
+	for i := 1; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch option := strings.ToUpper(args[i].Bulk); {
+		case option == "FROMMEMBER" && remaining >= 1:
+			if fromLonLatSet {
+				return Value{Type: "error", Str: "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH"}
+			}
+			fromMember = args[i+1].Bulk
+			fromMemberSet = true
+			i++
+		case option == "FROMLONLAT" && remaining >= 2:
+			if fromMemberSet {
+				return Value{Type: "error", Str: "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH"}
+			}
+			long, lat, errValue := parseLongLat(args[i+1].Bulk, args[i+2].Bulk)
+			if errValue != nil {
+				return *errValue
+			}
+			shape.long, shape.lat = long, lat
+			fromLonLatSet = true
+			i += 2
+		case option == "BYRADIUS" && remaining >= 2:
+			if bySet {
+				return Value{Type: "error", Str: "ERR exactly one of BYRADIUS and BYBOX arguments must be provided for GEOSEARCH"}
+			}
+			radius, err := strconv.ParseFloat(args[i+1].Bulk, 64)
+			if err != nil || radius < 0 {
+				return Value{Type: "error", Str: "ERR radius cannot be negative"}
+			}
+			unit, ok := parseGeoUnit(args[i+2].Bulk)
+			if !ok {
+				return Value{Type: "error", Str: geoUnitError}
+			}
+			shape.radius, shape.unit = radius*unit, unit
+			bySet = true
+			i += 2
+		case option == "BYBOX" && remaining >= 3:
+			if bySet {
+				return Value{Type: "error", Str: "ERR exactly one of BYRADIUS and BYBOX arguments must be provided for GEOSEARCH"}
+			}
+			width, err1 := strconv.ParseFloat(args[i+1].Bulk, 64)
+			height, err2 := strconv.ParseFloat(args[i+2].Bulk, 64)
+			if err1 != nil || err2 != nil || width < 0 || height < 0 {
+				return Value{Type: "error", Str: "ERR height or width cannot be negative"}
+			}
+			unit, ok := parseGeoUnit(args[i+3].Bulk)
+			if !ok {
+				return Value{Type: "error", Str: geoUnitError}
+			}
+			shape.width, shape.height, shape.unit = width*unit, height*unit, unit
+			shape.byBox = true
+			bySet = true
+			i += 3
+		case option == "ASC" || option == "DESC":
+			sortOrder = option
+		case option == "COUNT" && remaining >= 1:
+			n, err := strconv.Atoi(args[i+1].Bulk)
+			if err != nil || n <= 0 {
+				return Value{Type: "error", Str: "ERR COUNT must be > 0"}
+			}
+			count = n
+			i++
+			if i+1 < len(args) && strings.ToUpper(args[i+1].Bulk) == "ANY" {
+				countAny = true
+				i++
+			}
+		case option == "WITHCOORD":
+			withCoord = true
+		case option == "WITHDIST":
+			withDist = true
+		case option == "WITHHASH":
+			withHash = true
+		case option == "ANY":
+			return Value{Type: "error", Str: "ERR the ANY argument requires COUNT argument"}
+		default:
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+	}
+
+	if !fromMemberSet && !fromLonLatSet {
+		return Value{Type: "error", Str: "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH"}
+	}
+	if !bySet {
+		return Value{Type: "error", Str: "ERR exactly one of BYRADIUS and BYBOX arguments must be provided for GEOSEARCH"}
+	}
+
+	// COUNT without ANY returns the closest points.
+	if count > 0 && !countAny && sortOrder == "" {
+		sortOrder = "ASC"
+	}
+
+	sh, z, errValue := srv.lookupGeo(key)
+	if errValue != nil {
+		return *errValue
+	}
+	defer sh.mu.RUnlock()
+
+	if z == nil {
+		return Value{Type: "array", Array: []Value{}}
+	}
+	if fromMemberSet {
+		score, ok := z.dict[fromMember]
+		if !ok {
+			return Value{Type: "error", Str: "ERR could not decode requested zset member"}
+		}
+		shape.long, shape.lat = geoDecodeScore(score)
+	}
+
+	points := shape.search(z, count, countAny)
+
+	switch sortOrder {
+	case "ASC":
+		sort.SliceStable(points, func(i, j int) bool { return points[i].distance < points[j].distance })
+	case "DESC":
+		sort.SliceStable(points, func(i, j int) bool { return points[i].distance > points[j].distance })
+	}
+	if count > 0 && len(points) > count {
+		points = points[:count]
+	}
+
+	values := []Value{}
+	for _, point := range points {
+		name := Value{Type: "bulk", Bulk: point.member}
+		if !withCoord && !withDist && !withHash {
+			values = append(values, name)
+			continue
+		}
+
+		item := []Value{name}
+		if withDist {
+			item = append(item, Value{Type: "bulk", Bulk: strconv.FormatFloat(point.distance/shape.unit, 'f', 4, 64)})
+		}
+		if withHash {
+			item = append(item, Value{Type: "integer", Num: int(point.score)})
+		}
+		if withCoord {
+			item = append(item, Value{Type: "array", Array: []Value{
+				{Type: "bulk", Bulk: strconv.FormatFloat(point.long, 'f', 17, 64)},
+				{Type: "bulk", Bulk: strconv.FormatFloat(point.lat, 'f', 17, 64)},
+			}})
+		}
+		values = append(values, Value{Type: "array", Array: item})
+	}
+
+	return Value{Type: "array", Array: values}
+}
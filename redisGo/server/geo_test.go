@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestGeoSicily(t *testing.T) {
+	// The examples of the Redis documentation.
+	testServer.geoadd(command("geo:sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania").Array)
+
+	if result := testServer.zscore(command("geo:sicily", "Palermo").Array); result.Bulk != "3479099956230698" {
+		t.Errorf("Expected the score 3479099956230698, got %v", result)
+	}
+	if result := testServer.geodist(command("geo:sicily", "Palermo", "Catania").Array); result.Bulk != "166274.1516" {
+		t.Errorf("Expected 166274.1516, got %v", result)
+	}
+	if result := testServer.geodist(command("geo:sicily", "Palermo", "Catania", "km").Array); result.Bulk != "166.2742" {
+		t.Errorf("Expected 166.2742, got %v", result)
+	}
+	if result := testServer.geohash(command("geo:sicily", "Palermo", "Catania").Array); result.Array[0].Bulk != "sqc8b49rny0" || result.Array[1].Bulk != "sqdtr74hyu0" {
+		t.Errorf("Expected sqc8b49rny0 and sqdtr74hyu0, got %v", result)
+	}
+	if result := testServer.geopos(command("geo:sicily", "Palermo", "Nowhere").Array); result.Array[0].Array[0].Bulk != "13.36138933897018433" || result.Array[1].Type != "null" {
+		t.Errorf("Expected the position of Palermo and a null, got %v", result)
+	}
+
+	result := testServer.geosearch(command("geo:sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC", "WITHDIST").Array)
+	if len(result.Array) != 2 || result.Array[0].Array[0].Bulk != "Catania" || result.Array[0].Array[1].Bulk != "56.4413" ||
+		result.Array[1].Array[1].Bulk != "190.4424" {
+		t.Errorf("Expected Catania at 56.4413 and Palermo at 190.4424, got %v", result)
+	}
+
+	result = testServer.geosearch(command("geo:sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "100", "km").Array)
+	if len(result.Array) != 1 || result.Array[0].Bulk != "Catania" {
+		t.Errorf("Expected Catania only, got %v", result)
+	}
+}
+
+func TestGeoSearchMatchesFullScan(t *testing.T) {
+	args := []string{"geo:random"}
+	for i := 0; i < 2000; i++ {
+		args = append(args, fmt.Sprintf("%f", rand.Float64()*40-20), fmt.Sprintf("%f", rand.Float64()*40+20), fmt.Sprintf("p%d", i))
+	}
+	testServer.geoadd(command(args...).Array)
+
+	z := testServer.shardOf("geo:random").zsets["geo:random"]
+	for i := 0; i < 50; i++ {
+		shape := geoShape{long: rand.Float64()*40 - 20, lat: rand.Float64()*40 + 20, unit: 1}
+		if i%2 == 0 {
+			shape.radius = rand.Float64() * 1000000
+		} else {
+			shape.byBox = true
+			shape.width, shape.height = rand.Float64()*2000000, rand.Float64()*2000000
+		}
+
+		want := 0
+		z.each(0, func(member string, score float64) bool {
+			if _, ok := shape.contains(geoDecodeScore(score)); ok {
+				want++
+			}
+			return true
+		})
+
+		if got := len(shape.search(z, 0, false)); got != want {
+			t.Errorf("Expected %d points in %+v, got %d", want, shape, got)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+// stringMatch reports whether s matches the glob-style pattern, using the
+// same rules as Redis: '*', '?', '[...]' classes with ranges and '^'
+// negation, and '\' to escape the next character.
+func stringMatch(pattern, s string, nocase bool) bool {
+	lower := func(b byte) byte {
+		if nocase && b >= 'A' && b <= 'Z' {
+			return b + ('a' - 'A')
+		}
+		return b
+	}
+
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if stringMatch(pattern[1:], s[i:], nocase) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) >= 2:
+					pattern = pattern[1:]
+					if lower(pattern[0]) == lower(s[0]) {
+						match = true
+					}
+				case len(pattern) >= 3 && pattern[1] == '-':
+					start, end := lower(pattern[0]), lower(pattern[2])
+					if start > end {
+						start, end = end, start
+					}
+					pattern = pattern[2:]
+					if c := lower(s[0]); c >= start && c <= end {
+						match = true
+					}
+				default:
+					if lower(pattern[0]) == lower(s[0]) {
+						match = true
+					}
+				}
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				// Unterminated class: treat the end of the pattern as ']'.
+				pattern = "]"
+			}
+
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || lower(pattern[0]) != lower(s[0]) {
+				return false
+			}
+			s = s[1:]
+		}
+
+		pattern = pattern[1:]
+	}
+
+	return len(s) == 0
+}
@@ -0,0 +1,207 @@
+package server
+
+var handlers = map[string]func(*Server, []Value) Value{
+	"PING":        (*Server).ping,
+	"SET":         (*Server).set,
+	"GET":         (*Server).get,
+	"SETBIT":      (*Server).setbit,
+	"GETBIT":      (*Server).getbit,
+	"BITCOUNT":    (*Server).bitcount,
+	"BITPOS":      (*Server).bitpos,
+	"BITOP":       (*Server).bitop,
+	"BITFIELD":    (*Server).bitfield,
+	"BITFIELD_RO": (*Server).bitfieldRO,
+	"PFADD":       (*Server).pfadd,
+	"PFCOUNT":     (*Server).pfcount,
+	"PFMERGE":     (*Server).pfmerge,
+	"ZADD":        (*Server).zadd,
+	"ZREM":        (*Server).zrem,
+	"ZCARD":       (*Server).zcard,
+	"ZSCORE":      (*Server).zscore,
+	"ZRANGE":      (*Server).zrange,
+	"GEOADD":      (*Server).geoadd,
+	"GEODIST":     (*Server).geodist,
+	"GEOPOS":      (*Server).geopos,
+	"GEOHASH":     (*Server).geohash,
+	"GEOSEARCH":   (*Server).geosearch,
+	"LPUSH":       (*Server).lpush,
+	"RPUSH":       (*Server).rpush,
+	"LPOP":        (*Server).lpop,
+	"RPOP":        (*Server).rpop,
+	"LLEN":        (*Server).llen,
+	"LRANGE":      (*Server).lrange,
+	"SADD":        (*Server).sadd,
+	"SREM":        (*Server).srem,
+	"SMEMBERS":    (*Server).smembers,
+	"SCARD":       (*Server).scard,
+	"SISMEMBER":   (*Server).sismember,
+	"SORT":        (*Server).sortCommand,
+	"SORT_RO":     (*Server).sortRO,
+	"HSET":        (*Server).hset,
+	"HGET":        (*Server).hget,
+	"HGETALL":     (*Server).hgetall,
+	"INFO":        (*Server).info,
+	"CONFIG":      (*Server).config,
+	"SLOWLOG":     (*Server).slowlog,
+	"LATENCY":     (*Server).latency,
+	"MEMORY":      (*Server).memory,
+	"DEBUG":       (*Server).debug,
+	"DEL":         (*Server).del,
+	"EXISTS":      (*Server).exists,
+	"EXPIRE":      (*Server).expire,
+	"PEXPIRE":     (*Server).pexpire,
+	"EXPIREAT":    (*Server).expireat,
+	"PEXPIREAT":   (*Server).pexpireat,
+	"TTL":         (*Server).ttl,
+	"PTTL":        (*Server).pttl,
+	"PERSIST":     (*Server).persist,
+	"OBJECT":      (*Server).object,
+	"DUMP":        (*Server).dump,
+	"RESTORE":     (*Server).restore,
+	"COPY":        (*Server).copyCommand,
+	"PUBLISH":     (*Server).publish,
+	"PUBSUB":      (*Server).pubsub,
+	"XADD":        (*Server).xadd,
+	"XLEN":        (*Server).xlen,
+	"XRANGE":      (*Server).xrange,
+	"XREVRANGE":   (*Server).xrevrange,
+	"XDEL":        (*Server).xdel,
+	"XTRIM":       (*Server).xtrim,
+	"XGROUP":      (*Server).xgroup,
+	"XACK":        (*Server).xack,
+	"XPENDING":    (*Server).xpending,
+	"XCLAIM":      (*Server).xclaim,
+	"MIGRATE":     (*Server).migrate,
+	"ROLE":        (*Server).role,
+	"SENTINEL":    (*Server).sentinel,
+	"COMMAND":     (*Server).commandCommand,
+
+	// Persistence
+	"BGREWRITEAOF": (*Server).bgrewriteaof,
+}
+
+func (srv *Server) ping(args []Value) Value {
+	if len(args) == 0 {
+		return Value{Type: "string", Str: "PONG"}
+	}
+
+	return Value{Type: "string", Str: args[0].Bulk}
+}
+
+func (srv *Server) set(args []Value) Value {
+	key := args[0].Bulk
+	value := args[1].Bulk
+
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+	// SET overwrites a value of any type and discards its time to live.
+	sh.delete(key)
+	sh.setString(key, value)
+	sh.mu.Unlock()
+
+	srv.notifyKeyspaceEvent(notifyString, "set", key)
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+func (srv *Server) get(args []Value) Value {
+	key := args[0].Bulk
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+	if t := sh.typeOf(key); t != "none" && t != "string" {
+		sh.mu.RUnlock()
+		return Value{Type: "error", Str: wrongTypeError}
+	}
+	value, ok := sh.getString(key)
+	sh.mu.RUnlock()
+
+	if !ok {
+		return Value{Type: "null"}
+	}
+
+	return Value{Type: "bulk", Bulk: value}
+}
+
+// lookupHash returns the hash at key. The caller must hold the shard lock.
+func lookupHash(sh *Shard, key string) (*hashObject, *Value) {
+	if t := sh.typeOf(key); t != "none" && t != "hash" {
+		return nil, &Value{Type: "error", Str: wrongTypeError}
+	}
+	return sh.hsets[key], nil
+}
+
+func (srv *Server) hset(args []Value) Value {
+	hash := args[0].Bulk
+	key := args[1].Bulk
+	value := args[2].Bulk
+
+	sh := srv.shardOf(hash)
+	sh.mu.Lock()
+	h, errValue := lookupHash(sh, hash)
+	if errValue != nil {
+		sh.mu.Unlock()
+		return *errValue
+	}
+	if h == nil {
+		h = newHashObject()
+		sh.hsets[hash] = h
+	}
+	h.set(srv, key, value)
+	sh.mu.Unlock()
+
+	srv.notifyKeyspaceEvent(notifyHash, "hset", hash)
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+func (srv *Server) hget(args []Value) Value {
+	hash := args[0].Bulk
+	key := args[1].Bulk
+	srv.expireIfNeeded(hash)
+
+	sh := srv.shardOf(hash)
+	sh.mu.RLock()
+	h, errValue := lookupHash(sh, hash)
+	if errValue != nil {
+		sh.mu.RUnlock()
+		return *errValue
+	}
+	value, ok := "", false
+	if h != nil {
+		value, ok = h.get(key)
+	}
+	sh.mu.RUnlock()
+
+	if !ok {
+		return Value{Type: "null"}
+	}
+
+	return Value{Type: "bulk", Bulk: value}
+}
+
+func (srv *Server) hgetall(args []Value) Value {
+	hash := args[0].Bulk
+	srv.expireIfNeeded(hash)
+
+	sh := srv.shardOf(hash)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	value, errValue := lookupHash(sh, hash)
+	if errValue != nil {
+		return *errValue
+	}
+	if value == nil {
+		return Value{Type: "null"}
+	}
+
+	values := []Value{}
+	value.each(func(k, v string) {
+		values = append(values, Value{Type: "bulk", Bulk: k})
+		values = append(values, Value{Type: "bulk", Bulk: v})
+	})
+
+	return Value{Type: "array", Array: values}
+}
@@ -0,0 +1,448 @@
+package server
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+)
+
+// HyperLogLogs are stored as strings in the format Redis uses, so that they
+// can be moved between the two with DUMP or GET and SET. A 16 byte header
+//
+//	"HYLL" | encoding | 3 unused bytes | cached cardinality, little endian
+//
+// is followed by 16384 registers of 6 bits. The dense encoding packs them,
+// least significant bit first, in 12288 bytes. The sparse encoding, used
+// while most registers are zero, run length encodes them with three opcodes:
+//
+//	00xxxxxx           ZERO: 1 to 64 zero registers
+//	01xxxxxx yyyyyyyy  XZERO: 1 to 16384 zero registers
+//	1vvvvvxx           VAL: 1 to 4 registers of value 1 to 32
+//
+// The most significant bit of the cached cardinality marks it as stale.
+const (
+	hllP              = 14
+	hllQ              = 64 - hllP
+	hllRegisters      = 1 << hllP
+	hllBits           = 6
+	hllRegisterMax    = 1<<hllBits - 1
+	hllHeaderSize     = 16
+	hllDenseSize      = hllHeaderSize + (hllRegisters*hllBits+7)/8
+	hllDense          = 0
+	hllSparse         = 1
+	hllSparseValMax   = 32
+	hllSparseMaxBytes = 3000
+	hllAlphaInf       = 0.721347520444481703680
+)
+
+const (
+	hllWrongTypeError = "WRONGTYPE Key is not a valid HyperLogLog string value."
+	hllCorruptedError = "INVALIDOBJ Corrupted HLL object detected"
+)
+
+type hllRegisterSet [hllRegisters]uint8
+
+// murmurHash64A is the hash Redis uses for HyperLogLog elements.
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ uint64(len(key))*m
+
+	for len(key) >= 8 {
+		k := binary.LittleEndian.Uint64(key)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		key = key[8:]
+	}
+
+	if len(key) > 0 {
+		for i := len(key) - 1; i >= 0; i-- {
+			h ^= uint64(key[i]) << (8 * i)
+		}
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen returns the register an element maps to and the length of the
+// run of zeros, plus one, in the rest of its hash.
+func hllPatLen(element string) (int, uint8) {
+	hash := murmurHash64A([]byte(element), 0xadc83b19)
+	index := int(hash & (hllRegisters - 1))
+
+	// The bit at position Q makes sure the loop ends.
+	hash >>= hllP
+	hash |= 1 << hllQ
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+// decodeHLL checks that value is a HyperLogLog and unpacks its registers.
+func decodeHLL(value string) (*hllRegisterSet, int, *Value) {
+	if len(value) < hllHeaderSize || value[:4] != "HYLL" || value[4] > hllSparse ||
+		(value[4] == hllDense && len(value) != hllDenseSize) {
+		return nil, 0, &Value{Type: "error", Str: hllWrongTypeError}
+	}
+
+	regs := &hllRegisterSet{}
+	data := value[hllHeaderSize:]
+
+	if value[4] == hllDense {
+		for i := range regs {
+			regs[i] = hllDenseGet(data, i)
+		}
+		return regs, hllDense, nil
+	}
+
+	index := 0
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		run, val := 0, uint8(0)
+		switch {
+		case b&0xc0 == 0x00:
+			run = int(b&0x3f) + 1
+		case b&0xc0 == 0x40:
+			if i+1 == len(data) {
+				return nil, 0, &Value{Type: "error", Str: hllCorruptedError}
+			}
+			i++
+			run = (int(b&0x3f)<<8 | int(data[i])) + 1
+		default:
+			val = (b>>2)&0x1f + 1
+			run = int(b&0x03) + 1
+		}
+		if index+run > hllRegisters {
+			return nil, 0, &Value{Type: "error", Str: hllCorruptedError}
+		}
+		for ; run > 0; run-- {
+			regs[index] = val
+			index++
+		}
+	}
+	if index != hllRegisters {
+		return nil, 0, &Value{Type: "error", Str: hllCorruptedError}
+	}
+	return regs, hllSparse, nil
+}
+
+func hllDenseGet(data string, i int) uint8 {
+	byteIndex := i * hllBits / 8
+	fb := uint(i * hllBits & 7)
+	v := uint(data[byteIndex]) >> fb
+	if byteIndex+1 < len(data) {
+		v |= uint(data[byteIndex+1]) << (8 - fb)
+	}
+	return uint8(v & hllRegisterMax)
+}
+
+func hllDenseSet(data []byte, i int, val uint8) {
+	byteIndex := i * hllBits / 8
+	fb := uint(i * hllBits & 7)
+	data[byteIndex] &^= hllRegisterMax << fb
+	data[byteIndex] |= val << fb
+	if byteIndex+1 < len(data) {
+		data[byteIndex+1] &^= hllRegisterMax >> (8 - fb)
+		data[byteIndex+1] |= val >> (8 - fb)
+	}
+}
+
+// encodeHLL packs regs with the given encoding, keeping the cached
+// cardinality of the header. The sparse encoding falls back to dense once it
+// grows past hllSparseMaxBytes or a register exceeds what VAL can hold.
+func encodeHLL(regs *hllRegisterSet, encoding int, header string) string {
+	if encoding == hllSparse {
+		if sparse, ok := encodeSparseHLL(regs); ok {
+			return hllHeader(hllSparse, header) + sparse
+		}
+	}
+
+	data := make([]byte, hllDenseSize-hllHeaderSize)
+	for i, val := range regs {
+		hllDenseSet(data, i, val)
+	}
+	return hllHeader(hllDense, header) + string(data)
+}
+
+func encodeSparseHLL(regs *hllRegisterSet) (string, bool) {
+	var b strings.Builder
+
+	for i := 0; i < hllRegisters; {
+		val := regs[i]
+		run := 1
+		for i+run < hllRegisters && regs[i+run] == val {
+			run++
+		}
+		i += run
+
+		if val > hllSparseValMax {
+			return "", false
+		}
+		for run > 0 {
+			n := run
+			switch {
+			case val != 0:
+				n = min(n, 4)
+				b.WriteByte(0x80 | (val-1)<<2 | byte(n-1))
+			case n > 64:
+				b.WriteByte(0x40 | byte((n-1)>>8))
+				b.WriteByte(byte(n - 1))
+			default:
+				b.WriteByte(byte(n - 1))
+			}
+			run -= n
+		}
+
+		if hllHeaderSize+b.Len() > hllSparseMaxBytes {
+			return "", false
+		}
+	}
+	return b.String(), true
+}
+
+// hllHeader builds a header with encoding, copying the cached cardinality
+// from header, if any.
+func hllHeader(encoding byte, header string) string {
+	h := []byte{'H', 'Y', 'L', 'L', encoding, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	if len(header) >= hllHeaderSize {
+		copy(h[8:], header[8:hllHeaderSize])
+	}
+	return string(h)
+}
+
+// hllInvalidateCache marks the cached cardinality of value as stale.
+func hllInvalidateCache(value string) string {
+	b := []byte(value)
+	b[15] |= 0x80
+	return string(b)
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+// count estimates the cardinality with the improved estimator of Otmar
+// Ertl, which Redis uses as well.
+func (regs *hllRegisterSet) count() uint64 {
+	histogram := [64]int{}
+	for _, val := range regs {
+		histogram[val]++
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func (regs *hllRegisterSet) merge(other *hllRegisterSet) {
+	for i, val := range other {
+		if val > regs[i] {
+			regs[i] = val
+		}
+	}
+}
+
+// lookupHLL returns the HyperLogLog at key, if any. The caller must hold the
+// shard lock.
+func lookupHLL(sh *Shard, key string) (string, bool, *Value) {
+	if t := sh.typeOf(key); t != "none" && t != "string" {
+		return "", false, &Value{Type: "error", Str: wrongTypeError}
+	}
+	value, ok := sh.getString(key)
+	return value, ok, nil
+}
+
+func (srv *Server) pfadd(args []Value) Value {
+	key := args[0].Bulk
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+
+	value, ok, errValue := lookupHLL(sh, key)
+	if errValue != nil {
+		sh.mu.Unlock()
+		return *errValue
+	}
+	created := !ok
+	if created {
+		regs := &hllRegisterSet{}
+		value = encodeHLL(regs, hllSparse, "")
+	}
+
+	regs, encoding, errValue := decodeHLL(value)
+	if errValue != nil {
+		sh.mu.Unlock()
+		return *errValue
+	}
+
+	updated := false
+	for _, arg := range args[1:] {
+		index, count := hllPatLen(arg.Bulk)
+		if count > regs[index] {
+			regs[index] = count
+			updated = true
+		}
+	}
+
+	if updated {
+		value = hllInvalidateCache(encodeHLL(regs, encoding, value))
+	}
+	if updated || created {
+		sh.setString(key, value)
+	}
+	sh.mu.Unlock()
+
+	if !updated && !created {
+		return Value{Type: "integer", Num: 0}
+	}
+
+	srv.notifyKeyspaceEvent(notifyString, "pfadd", key)
+
+	return Value{Type: "integer", Num: 1}
+}
+
+func (srv *Server) pfcount(args []Value) Value {
+	keys := []string{}
+	for _, arg := range args {
+		srv.expireIfNeeded(arg.Bulk)
+		keys = append(keys, arg.Bulk)
+	}
+
+	// The cardinality of a single key is cached in its header.
+	if len(keys) == 1 {
+		key := keys[0]
+		sh := srv.shardOf(key)
+		sh.mu.Lock()
+		defer sh.mu.Unlock()
+
+		value, ok, errValue := lookupHLL(sh, key)
+		if errValue != nil {
+			return *errValue
+		}
+		if !ok {
+			return Value{Type: "integer", Num: 0}
+		}
+		regs, _, errValue := decodeHLL(value)
+		if errValue != nil {
+			return *errValue
+		}
+
+		if value[15]&0x80 == 0 {
+			return Value{Type: "integer", Num: int(binary.LittleEndian.Uint64([]byte(value[8:16])))}
+		}
+		card := regs.count()
+		b := []byte(value)
+		binary.LittleEndian.PutUint64(b[8:16], card)
+		sh.setString(key, string(b))
+
+		return Value{Type: "integer", Num: int(card)}
+	}
+
+	unlock := srv.rlockKeys(keys)
+	defer unlock()
+
+	union := &hllRegisterSet{}
+	for _, key := range keys {
+		value, ok, errValue := lookupHLL(srv.shardOf(key), key)
+		if errValue != nil {
+			return *errValue
+		}
+		if !ok {
+			continue
+		}
+		regs, _, errValue := decodeHLL(value)
+		if errValue != nil {
+			return *errValue
+		}
+		union.merge(regs)
+	}
+
+	return Value{Type: "integer", Num: int(union.count())}
+}
+
+// pfmerge merges the sources into dest, which is part of the union if it
+// exists. The result stays sparse while dest and every source are.
+func (srv *Server) pfmerge(args []Value) Value {
+	keys := []string{}
+	for _, arg := range args {
+		srv.expireIfNeeded(arg.Bulk)
+		keys = append(keys, arg.Bulk)
+	}
+	dest := keys[0]
+
+	unlock := srv.lockKeys(keys)
+
+	union := &hllRegisterSet{}
+	encoding := hllSparse
+	for _, key := range keys {
+		value, ok, errValue := lookupHLL(srv.shardOf(key), key)
+		if errValue != nil {
+			unlock()
+			return *errValue
+		}
+		if !ok {
+			continue
+		}
+		regs, enc, errValue := decodeHLL(value)
+		if errValue != nil {
+			unlock()
+			return *errValue
+		}
+		if enc == hllDense {
+			encoding = hllDense
+		}
+		union.merge(regs)
+	}
+
+	sh := srv.shardOf(dest)
+	sh.setString(dest, hllInvalidateCache(encodeHLL(union, encoding, "")))
+	unlock()
+
+	srv.notifyKeyspaceEvent(notifyString, "pfadd", dest)
+
+	return Value{Type: "string", Str: "OK"}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestMurmurHash64A(t *testing.T) {
+	// Computed with the C implementation in Redis.
+	tests := map[string]uint64{
+		"":                 0xd8dfea6585bc9732,
+		"hello":            0x0f656f01eecfe400,
+		"abcdefghijklmnop": 0xd006e2f88c34e470,
+	}
+	for s, want := range tests {
+		if got := murmurHash64A([]byte(s), 0xadc83b19); got != want {
+			t.Errorf("Expected the hash of %q to be %x, got %x", s, want, got)
+		}
+	}
+}
+
+func TestHLLSparseEncoding(t *testing.T) {
+	if result := testServer.pfadd(command("hll:empty").Array); result.Num != 1 {
+		t.Errorf("Expected PFADD to create the key, got %v", result)
+	}
+
+	// A single XZERO opcode covering all the registers.
+	want := "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff"
+	if result := testServer.get(command("hll:empty").Array); result.Bulk != want {
+		t.Errorf("Expected %q, got %q", want, result.Bulk)
+	}
+
+	if result := testServer.pfadd(command("hll:small", "a", "b", "c").Array); result.Num != 1 {
+		t.Errorf("Expected PFADD to report an update, got %v", result)
+	}
+	if result := testServer.pfadd(command("hll:small", "a", "b").Array); result.Num != 0 {
+		t.Errorf("Expected no update for elements already added, got %v", result)
+	}
+	if result := testServer.pfcount(command("hll:small").Array); result.Num != 3 {
+		t.Errorf("Expected a cardinality of 3, got %v", result)
+	}
+
+	// PFCOUNT caches the cardinality in the header.
+	value := testServer.get(command("hll:small").Array).Bulk
+	if value[4] != hllSparse || value[15]&0x80 != 0 || value[8] != 3 {
+		t.Errorf("Expected a sparse HLL caching a cardinality of 3, got %q", value[:16])
+	}
+}
+
+func TestHLLEncodingsRoundTrip(t *testing.T) {
+	regs := &hllRegisterSet{}
+	for i := 0; i < 200; i++ {
+		regs[rand.Intn(hllRegisters)] = uint8(rand.Intn(hllSparseValMax) + 1)
+	}
+
+	for _, encoding := range []int{hllSparse, hllDense} {
+		decoded, got, errValue := decodeHLL(encodeHLL(regs, encoding, ""))
+		if errValue != nil {
+			t.Fatalf("Expected encoding %d to decode, got %v", encoding, errValue)
+		}
+		if got != encoding {
+			t.Errorf("Expected encoding %d, got %d", encoding, got)
+		}
+		if *decoded != *regs {
+			t.Errorf("Expected encoding %d to round trip the registers", encoding)
+		}
+	}
+
+	// A register too large for a VAL opcode forces the dense encoding.
+	regs[0] = hllSparseValMax + 1
+	if value := encodeHLL(regs, hllSparse, ""); value[4] != hllDense {
+		t.Errorf("Expected a dense encoding, got %d", value[4])
+	}
+}
+
+func TestHLLErrorBound(t *testing.T) {
+	// The standard error is 1.04/sqrt(16384), about 0.81%.
+	bound := 3 * 1.04 / math.Sqrt(hllRegisters)
+
+	added := 0
+	for _, n := range []int{10, 100, 1000, 10000, 100000, 500000} {
+		args := []string{"hll:bound"}
+		for ; added < n; added++ {
+			args = append(args, fmt.Sprintf("element:%d", added))
+		}
+		testServer.pfadd(command(args...).Array)
+
+		card := testServer.pfcount(command("hll:bound").Array).Num
+		if err := math.Abs(float64(card-n)) / float64(n); err > bound {
+			t.Errorf("Expected a cardinality of %d within %.2f%%, got %d", n, bound*100, card)
+		}
+	}
+
+	if value := testServer.get(command("hll:bound").Array); value.Bulk[4] != hllDense {
+		t.Errorf("Expected the HLL to be promoted to the dense encoding")
+	}
+}
+
+func TestPfmerge(t *testing.T) {
+	for i := 0; i < 3000; i++ {
+		testServer.pfadd(command("hll:m1", fmt.Sprintf("m:%d", i)).Array)
+		testServer.pfadd(command("hll:m2", fmt.Sprintf("m:%d", i+2000)).Array)
+	}
+
+	union := testServer.pfcount(command("hll:m1", "hll:m2").Array).Num
+	if math.Abs(float64(union-5000)) > 5000*0.025 {
+		t.Errorf("Expected a union of about 5000, got %d", union)
+	}
+
+	if result := testServer.pfmerge(command("hll:merged", "hll:m1", "hll:m2").Array); result.Str != "OK" {
+		t.Errorf("Expected OK, got %v", result)
+	}
+	if result := testServer.pfcount(command("hll:merged").Array); result.Num != union {
+		t.Errorf("Expected the merged key to count %d, got %v", union, result)
+	}
+
+	testServer.set(command("hll:string", "not a hyperloglog").Array)
+	if result := testServer.pfcount(command("hll:string").Array); result.Str != hllWrongTypeError {
+		t.Errorf("Expected %q, got %v", hllWrongTypeError, result)
+	}
+	if result := testServer.pfmerge(command("hll:merged", "hll:string").Array); result.Type != "error" {
+		t.Errorf("Expected an error merging a plain string, got %v", result)
+	}
+}
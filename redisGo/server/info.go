@@ -0,0 +1,242 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+const version = "7.0.0"
+
+type commandStat struct {
+	calls  int64
+	failed int64
+
+	// duration is the time spent in all the calls, kept to the nanosecond
+	// as most take less than a microsecond.
+	duration time.Duration
+
+	// buckets counts the calls by duration, the ith those within
+	// durationBuckets[i] and above the previous bound, the last the
+	// slower ones.
+	buckets [len(durationBuckets) + 1]int64
+}
+
+func (srv *Server) recordCommand(command string, duration time.Duration, result Value) {
+	srv.stats.totalCommands.Add(1)
+
+	srv.commandStatsMu.Lock()
+	defer srv.commandStatsMu.Unlock()
+
+	stat, ok := srv.commandStats[command]
+	if !ok {
+		stat = &commandStat{}
+		srv.commandStats[command] = stat
+	}
+
+	stat.calls++
+	stat.duration += duration
+	stat.buckets[sort.Search(len(durationBuckets), func(i int) bool { return duration <= durationBuckets[i] })]++
+	if result.Type == "error" {
+		stat.failed++
+	}
+}
+
+func (srv *Server) resetStats() {
+	srv.stats.totalConnections.Store(0)
+	srv.stats.totalCommands.Store(0)
+	srv.stats.expiredKeys.Store(0)
+	srv.stats.queryBufferLimitDisconnections.Store(0)
+	srv.stats.outputBufferLimitDisconnections.Store(0)
+
+	srv.commandStatsMu.Lock()
+	srv.commandStats = map[string]*commandStat{}
+	srv.commandStatsMu.Unlock()
+}
+
+var infoSections = []struct {
+	name string
+	fn   func(srv *Server, sb *strings.Builder)
+}{
+	{"server", (*Server).infoServer},
+	{"clients", (*Server).infoClients},
+	{"memory", (*Server).infoMemory},
+	{"persistence", (*Server).infoPersistence},
+	{"stats", (*Server).infoStats},
+	{"replication", (*Server).infoReplication},
+	{"commandstats", (*Server).infoCommandStats},
+	{"cluster", (*Server).infoCluster},
+	{"sentinel", (*Server).infoSentinel},
+	{"keyspace", (*Server).infoKeyspace},
+}
+
+func (srv *Server) info(args []Value) Value {
+	requested := map[string]bool{}
+	for _, arg := range args {
+		requested[strings.ToLower(arg.Bulk)] = true
+	}
+
+	all := requested["all"] || requested["everything"]
+	def := len(requested) == 0 || requested["default"]
+
+	var sb strings.Builder
+	for _, section := range infoSections {
+		include := all || requested[section.name]
+		if def && section.name != "commandstats" {
+			include = true
+		}
+		if section.name == "sentinel" && !srv.sentinelMode {
+			include = false
+		}
+		if !include {
+			continue
+		}
+
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		section.fn(srv, &sb)
+	}
+
+	return Value{Type: "bulk", Bulk: sb.String()}
+}
+
+func (srv *Server) infoServer(sb *strings.Builder) {
+	uptime := time.Since(srv.startTime)
+	executable, _ := os.Executable()
+
+	fmt.Fprintf(sb, "redis_version:%s\r\n", version)
+	mode := "standalone"
+	if srv.clusterEnabled {
+		mode = "cluster"
+	}
+	if srv.sentinelMode {
+		mode = "sentinel"
+	}
+	fmt.Fprintf(sb, "redis_mode:%s\r\n", mode)
+	fmt.Fprintf(sb, "os:%s\r\n", runtime.GOOS)
+	fmt.Fprintf(sb, "arch_bits:%d\r\n", 32<<(^uint(0)>>63))
+	fmt.Fprintf(sb, "go_version:%s\r\n", runtime.Version())
+	fmt.Fprintf(sb, "process_id:%d\r\n", os.Getpid())
+	fmt.Fprintf(sb, "run_id:%s\r\n", srv.runID)
+	fmt.Fprintf(sb, "tcp_port:%d\r\n", srv.port)
+	fmt.Fprintf(sb, "uptime_in_seconds:%d\r\n", int64(uptime.Seconds()))
+	fmt.Fprintf(sb, "uptime_in_days:%d\r\n", int64(uptime.Hours()/24))
+	fmt.Fprintf(sb, "executable:%s\r\n", executable)
+}
+
+func (srv *Server) infoClients(sb *strings.Builder) {
+	srv.clientsMu.RLock()
+	connected := len(srv.clients)
+	srv.clientsMu.RUnlock()
+
+	srv.monitorsMu.RLock()
+	monitors := len(srv.monitors)
+	srv.monitorsMu.RUnlock()
+
+	fmt.Fprintf(sb, "connected_clients:%d\r\n", connected)
+	fmt.Fprintf(sb, "monitor_clients:%d\r\n", monitors)
+}
+
+func (srv *Server) infoMemory(sb *strings.Builder) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	fmt.Fprintf(sb, "used_memory:%d\r\n", m.HeapAlloc)
+	fmt.Fprintf(sb, "used_memory_human:%s\r\n", humanBytes(m.HeapAlloc))
+	fmt.Fprintf(sb, "used_memory_sys:%d\r\n", m.Sys)
+	fmt.Fprintf(sb, "used_memory_sys_human:%s\r\n", humanBytes(m.Sys))
+	fmt.Fprintf(sb, "gc_cycles:%d\r\n", m.NumGC)
+	fmt.Fprintf(sb, "mem_allocator:go\r\n")
+}
+
+func (srv *Server) infoPersistence(sb *strings.Builder) {
+	fmt.Fprintf(sb, "loading:0\r\n")
+	if srv.aofFile == nil {
+		fmt.Fprintf(sb, "aof_enabled:0\r\n")
+		return
+	}
+	fmt.Fprintf(sb, "aof_enabled:1\r\n")
+	fmt.Fprintf(sb, "aof_last_write_status:%s\r\n", srv.aofFile.WriteStatus())
+	fmt.Fprintf(sb, "aof_current_size:%d\r\n", srv.aofFile.Size())
+
+	aofStats := srv.aofFile.Stats()
+	rewriteStatus := "ok"
+	if aofStats.RewriteError != nil {
+		rewriteStatus = "err"
+	}
+	lastRewrite := int64(-1)
+	if aofStats.Rewrites > 0 {
+		lastRewrite = int64(aofStats.LastRewrite.Seconds())
+	}
+	rewriting := 0
+	if srv.aofRewriting.Load() {
+		rewriting = 1
+	}
+	fmt.Fprintf(sb, "aof_rewrite_in_progress:%d\r\n", rewriting)
+	fmt.Fprintf(sb, "aof_rewrites:%d\r\n", aofStats.Rewrites)
+	fmt.Fprintf(sb, "aof_last_rewrite_time_sec:%d\r\n", lastRewrite)
+	fmt.Fprintf(sb, "aof_last_bgrewrite_status:%s\r\n", rewriteStatus)
+}
+
+func (srv *Server) infoStats(sb *strings.Builder) {
+	fmt.Fprintf(sb, "total_connections_received:%d\r\n", srv.stats.totalConnections.Load())
+	fmt.Fprintf(sb, "total_commands_processed:%d\r\n", srv.stats.totalCommands.Load())
+	fmt.Fprintf(sb, "expired_keys:%d\r\n", srv.stats.expiredKeys.Load())
+	// Keys are never evicted: there is no maxmemory.
+	fmt.Fprintf(sb, "evicted_keys:0\r\n")
+	fmt.Fprintf(sb, "client_query_buffer_limit_disconnections:%d\r\n", srv.stats.queryBufferLimitDisconnections.Load())
+	fmt.Fprintf(sb, "client_output_buffer_limit_disconnections:%d\r\n", srv.stats.outputBufferLimitDisconnections.Load())
+}
+
+func (srv *Server) infoCommandStats(sb *strings.Builder) {
+	srv.commandStatsMu.Lock()
+	defer srv.commandStatsMu.Unlock()
+
+	commands := make([]string, 0, len(srv.commandStats))
+	for command := range srv.commandStats {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	for _, command := range commands {
+		stat := srv.commandStats[command]
+		usec := float64(stat.duration) / float64(time.Microsecond)
+		fmt.Fprintf(sb, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,failed_calls=%d\r\n",
+			strings.ToLower(command), stat.calls, stat.duration.Microseconds(), usec/float64(stat.calls), stat.failed)
+	}
+}
+
+func (srv *Server) infoKeyspace(sb *strings.Builder) {
+	keys, expires := 0, 0
+	for _, sh := range srv.shards {
+		sh.mu.RLock()
+		keys += len(sh.sets) + len(sh.ints) + len(sh.hsets) + len(sh.streams) + len(sh.zsets) + len(sh.lists) + len(sh.smembers)
+		expires += len(sh.expires)
+		sh.mu.RUnlock()
+	}
+
+	if keys > 0 {
+		fmt.Fprintf(sb, "db0:keys=%d,expires=%d,avg_ttl=0\r\n", keys, expires)
+	}
+}
+
+func humanBytes(n uint64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+
+	if i == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.2f%s", f, units[i])
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfoSections(t *testing.T) {
+	client := newTestClient()
+	testServer.call(client, command("SET", "info:key", "1"))
+
+	result := testServer.call(client, command("INFO"))
+	for _, header := range []string{"# Server\r\n", "# Clients\r\n", "# Memory\r\n", "# Persistence\r\n", "# Stats\r\n", "# Replication\r\n", "# Keyspace\r\n"} {
+		if !strings.Contains(result.Bulk, header) {
+			t.Errorf("Expected INFO to include %q", header)
+		}
+	}
+	if strings.Contains(result.Bulk, "# Commandstats") {
+		t.Errorf("Expected INFO not to include the command statistics")
+	}
+
+	result = testServer.call(client, command("INFO", "memory"))
+	if !strings.HasPrefix(result.Bulk, "# Memory\r\n") || strings.Count(result.Bulk, "# ") != 1 {
+		t.Errorf("Expected only the memory section, got %q", result.Bulk)
+	}
+
+	result = testServer.call(client, command("INFO", "commandstats"))
+	if !strings.Contains(result.Bulk, "cmdstat_set:calls=") {
+		t.Errorf("Expected statistics for SET, got %q", result.Bulk)
+	}
+
+	result = testServer.call(client, command("INFO", "all"))
+	if !strings.Contains(result.Bulk, "# Commandstats\r\n") || !strings.Contains(result.Bulk, "# Server\r\n") {
+		t.Errorf("Expected every section, got %q", result.Bulk)
+	}
+	if strings.Contains(result.Bulk, "# Sentinel") {
+		t.Errorf("Expected no sentinel section outside of sentinel mode")
+	}
+}
+
+func TestCommandStatsDuration(t *testing.T) {
+	testServer.resetStats()
+	defer testServer.resetStats()
+
+	// Calls shorter than a microsecond still add up.
+	for i := 0; i < 1000; i++ {
+		testServer.recordCommand("GET", 500*time.Nanosecond, Value{Type: "null"})
+	}
+
+	var sb strings.Builder
+	testServer.infoCommandStats(&sb)
+	if expected := "cmdstat_get:calls=1000,usec=500,usec_per_call=0.50,failed_calls=0\r\n"; sb.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, sb.String())
+	}
+
+	sb.Reset()
+	testServer.metricsCommands(&sb)
+	if expected := "redisgo_command_duration_seconds_sum{cmd=\"get\"} 0.0005\n"; !strings.Contains(sb.String(), expected) {
+		t.Errorf("Expected %q in the metrics, got %q", expected, sb.String())
+	}
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The keyspace is split into shards, each holding the keys that hash to it
+// along with their expiry times, so that commands on unrelated keys do not
+// contend for a single lock.
+const keyspaceShards = 64
+
+type Shard struct {
+	mu       sync.RWMutex
+	sets     map[string]string
+	ints     map[string]int64 // strings holding integers, see setString
+	hsets    map[string]*hashObject
+	streams  map[string]*stream
+	zsets    map[string]*zset
+	lists    map[string]*listObject
+	smembers map[string]*setObject // the set type; sets holds strings
+	expires  map[string]time.Time
+	access   map[string]*keyAccess
+}
+
+func newShards(n int) []*Shard {
+	shards := make([]*Shard, n)
+	for i := range shards {
+		shards[i] = &Shard{
+			sets:     map[string]string{},
+			ints:     map[string]int64{},
+			hsets:    map[string]*hashObject{},
+			streams:  map[string]*stream{},
+			zsets:    map[string]*zset{},
+			lists:    map[string]*listObject{},
+			smembers: map[string]*setObject{},
+			expires:  map[string]time.Time{},
+			access:   map[string]*keyAccess{},
+		}
+	}
+	return shards
+}
+
+func (srv *Server) shardIndex(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(srv.shards)))
+}
+
+func (srv *Server) shardOf(key string) *Shard {
+	return srv.shards[srv.shardIndex(key)]
+}
+
+// flushKeyspace deletes every key.
+func (srv *Server) flushKeyspace() {
+	for _, sh := range srv.shards {
+		sh.mu.Lock()
+		sh.sets = map[string]string{}
+		sh.ints = map[string]int64{}
+		sh.hsets = map[string]*hashObject{}
+		sh.streams = map[string]*stream{}
+		sh.zsets = map[string]*zset{}
+		sh.lists = map[string]*listObject{}
+		sh.smembers = map[string]*setObject{}
+		sh.expires = map[string]time.Time{}
+		sh.access = map[string]*keyAccess{}
+		sh.mu.Unlock()
+	}
+}
+
+// exists reports whether key holds a value of any type. The caller must
+// hold the shard lock.
+func (sh *Shard) exists(key string) bool {
+	if _, ok := sh.sets[key]; ok {
+		return true
+	}
+	if _, ok := sh.ints[key]; ok {
+		return true
+	}
+	if _, ok := sh.hsets[key]; ok {
+		return true
+	}
+	if _, ok := sh.streams[key]; ok {
+		return true
+	}
+	if _, ok := sh.zsets[key]; ok {
+		return true
+	}
+	if _, ok := sh.lists[key]; ok {
+		return true
+	}
+	_, ok := sh.smembers[key]
+	return ok
+}
+
+// typeOf returns the type of the value at key, or "none". The caller must
+// hold the shard lock.
+func (sh *Shard) typeOf(key string) string {
+	if _, ok := sh.sets[key]; ok {
+		return "string"
+	}
+	if _, ok := sh.ints[key]; ok {
+		return "string"
+	}
+	if _, ok := sh.hsets[key]; ok {
+		return "hash"
+	}
+	if _, ok := sh.streams[key]; ok {
+		return "stream"
+	}
+	if _, ok := sh.zsets[key]; ok {
+		return "zset"
+	}
+	if _, ok := sh.lists[key]; ok {
+		return "list"
+	}
+	if _, ok := sh.smembers[key]; ok {
+		return "set"
+	}
+	return "none"
+}
+
+// getString returns the string at key. The caller must hold the shard lock.
+func (sh *Shard) getString(key string) (string, bool) {
+	if n, ok := sh.ints[key]; ok {
+		return strconv.FormatInt(n, 10), true
+	}
+	value, ok := sh.sets[key]
+	return value, ok
+}
+
+// setString sets key to the string value, keeping it as an int64 if it is
+// the canonical form of one, which is what Redis calls the int encoding.
+// The caller must hold the shard lock.
+func (sh *Shard) setString(key, value string) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && strconv.FormatInt(n, 10) == value {
+		delete(sh.sets, key)
+		sh.ints[key] = n
+		return
+	}
+	delete(sh.ints, key)
+	sh.sets[key] = value
+}
+
+const wrongTypeError = "WRONGTYPE Operation against a key holding the wrong kind of value"
+
+// delete removes key, whatever its type, along with its expiry and access
+// record. The caller must hold the shard lock.
+func (sh *Shard) delete(key string) bool {
+	deleted := sh.exists(key)
+
+	delete(sh.sets, key)
+	delete(sh.ints, key)
+	delete(sh.hsets, key)
+	delete(sh.streams, key)
+	delete(sh.zsets, key)
+	delete(sh.lists, key)
+	delete(sh.smembers, key)
+	delete(sh.expires, key)
+	delete(sh.access, key)
+
+	return deleted
+}
+
+// keys returns every key in the shard, whatever its type. The caller must
+// hold the shard lock.
+func (sh *Shard) keys() []string {
+	seen := map[string]bool{}
+	for key := range sh.sets {
+		seen[key] = true
+	}
+	for key := range sh.ints {
+		seen[key] = true
+	}
+	for key := range sh.hsets {
+		seen[key] = true
+	}
+	for key := range sh.streams {
+		seen[key] = true
+	}
+	for key := range sh.zsets {
+		seen[key] = true
+	}
+	for key := range sh.lists {
+		seen[key] = true
+	}
+	for key := range sh.smembers {
+		seen[key] = true
+	}
+
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// keyShards returns the distinct shards holding keys, in index order.
+// Commands touching several keys lock their shards in this order, so two
+// of them can never wait on each other.
+func (srv *Server) keyShards(keys []string) []*Shard {
+	indexes := []int{}
+	seen := map[int]bool{}
+	for _, key := range keys {
+		i := srv.shardIndex(key)
+		if !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+
+	shards := make([]*Shard, len(indexes))
+	for i, index := range indexes {
+		shards[i] = srv.shards[index]
+	}
+	return shards
+}
+
+// lockKeys write-locks the shards holding keys and returns a function
+// releasing them.
+func (srv *Server) lockKeys(keys []string) func() {
+	shards := srv.keyShards(keys)
+	for _, sh := range shards {
+		sh.mu.Lock()
+	}
+
+	return func() {
+		for i := len(shards) - 1; i >= 0; i-- {
+			shards[i].mu.Unlock()
+		}
+	}
+}
+
+// rlockKeys read-locks the shards holding keys and returns a function
+// releasing them.
+func (srv *Server) rlockKeys(keys []string) func() {
+	shards := srv.keyShards(keys)
+	for _, sh := range shards {
+		sh.mu.RLock()
+	}
+
+	return func() {
+		for i := len(shards) - 1; i >= 0; i-- {
+			shards[i].mu.RUnlock()
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func TestKeyShards(t *testing.T) {
+	keys := []string{}
+	for i := 0; i < 200; i++ {
+		keys = append(keys, fmt.Sprintf("shard:%d", i))
+	}
+
+	shards := testServer.keyShards(append(keys, keys...))
+	if len(shards) != len(testServer.shards) {
+		t.Errorf("Expected 200 keys to cover all %d shards once, got %d", len(testServer.shards), len(shards))
+	}
+
+	index := map[*Shard]int{}
+	for i, sh := range testServer.shards {
+		index[sh] = i
+	}
+	for i := 1; i < len(shards); i++ {
+		if index[shards[i-1]] >= index[shards[i]] {
+			t.Fatalf("Expected shards in index order, got %d before %d", index[shards[i-1]], index[shards[i]])
+		}
+	}
+}
+
+func TestMultiKeyCommands(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		testServer.set(command(fmt.Sprintf("multi:%d", i), "v").Array)
+	}
+
+	if result := testServer.exists(command("multi:0", "multi:9", "multi:missing").Array); result.Num != 2 {
+		t.Errorf("Expected 2 existing keys, got %v", result)
+	}
+
+	args := []string{}
+	for i := 0; i < 10; i++ {
+		args = append(args, fmt.Sprintf("multi:%d", i))
+	}
+	if result := testServer.del(command(args...).Array); result.Num != 10 {
+		t.Errorf("Expected 10 deleted keys, got %v", result)
+	}
+	if result := testServer.exists(command(args...).Array); result.Num != 0 {
+		t.Errorf("Expected no keys left, got %v", result)
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	key := "wrongtype:key"
+	creates := map[string][]string{
+		"string": {"SET", key, "v"},
+		"hash":   {"HSET", key, "f", "v"},
+		"list":   {"RPUSH", key, "a"},
+		"set":    {"SADD", key, "a"},
+		"zset":   {"ZADD", key, "1", "a"},
+		"stream": {"XADD", key, "*", "f", "v"},
+	}
+	commands := map[string][][]string{
+		"string": {{"GET", key}, {"SETBIT", key, "0", "1"}, {"PFADD", key, "a"}},
+		"hash":   {{"HSET", key, "f", "v"}, {"HGET", key, "f"}, {"HGETALL", key}},
+		"list":   {{"RPUSH", key, "a"}, {"LPUSH", key, "a"}, {"LRANGE", key, "0", "-1"}},
+		"set":    {{"SADD", key, "a"}, {"SMEMBERS", key}},
+		"zset":   {{"ZADD", key, "1", "a"}, {"ZSCORE", key, "a"}},
+		"stream": {{"XADD", key, "*", "f", "v"}, {"XLEN", key}, {"XRANGE", key, "-", "+"}, {"XGROUP", "CREATE", key, "g", "$", "MKSTREAM"}},
+	}
+
+	client := newTestClient()
+	sh := testServer.shardOf(key)
+	typeOf := func() string {
+		sh.mu.RLock()
+		defer sh.mu.RUnlock()
+		return sh.typeOf(key)
+	}
+
+	for typ, create := range creates {
+		testServer.call(client, command("DEL", key))
+		if result := testServer.call(client, command(create...)); result.Type == "error" {
+			t.Fatalf("Expected %v to create a %s, got %v", create, typ, result)
+		}
+
+		for other, args := range commands {
+			if other == typ {
+				continue
+			}
+			for _, arg := range args {
+				result := testServer.call(client, command(arg...))
+				if result.Type != "error" || result.Str != wrongTypeError {
+					t.Errorf("Expected WRONGTYPE for %v on a %s, got %v", arg, typ, result)
+				}
+			}
+		}
+		if got := typeOf(); got != typ {
+			t.Errorf("Expected the key to still hold a %s, got %s", typ, got)
+		}
+
+		testServer.call(client, command("SET", key, "v"))
+		sh.mu.RLock()
+		held := 0
+		for _, ok := range []bool{sh.hsets[key] != nil, sh.lists[key] != nil, sh.smembers[key] != nil, sh.zsets[key] != nil, sh.streams[key] != nil} {
+			if ok {
+				held++
+			}
+		}
+		sh.mu.RUnlock()
+		if got := typeOf(); got != "string" || held != 0 {
+			t.Errorf("Expected SET to replace the %s with a string, got %s and %d other values", typ, got, held)
+		}
+	}
+	testServer.call(client, command("DEL", key))
+}
+
+// benchmarkKeyspace runs SET and GET on distinct keys from every CPU with
+// the keyspace split into n shards. A single shard behaves like the former
+// global SETsMu lock.
+func benchmarkKeyspace(b *testing.B, n int) {
+	saved := testServer.shards
+	testServer.shards = newShards(n)
+	defer func() { testServer.shards = saved }()
+
+	var worker atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		id := worker.Add(1)
+		i := 0
+		for pb.Next() {
+			key := fmt.Sprintf("bench:%d:%d", id, i%1000)
+			testServer.set(command(key, "v").Array)
+			testServer.get(command(key).Array)
+			i++
+		}
+	})
+}
+
+func BenchmarkKeyspaceGlobalLock(b *testing.B) { benchmarkKeyspace(b, 1) }
+func BenchmarkKeyspaceSharded(b *testing.B)    { benchmarkKeyspace(b, keyspaceShards) }
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const latencyHistoryLen = 160
+
+type latencySample struct {
+	time    int64 // unix seconds
+	latency int64 // milliseconds
+}
+
+type latencyEvent struct {
+	samples []latencySample
+	max     int64
+}
+
+// latencyAddSampleIfNeeded records a sample for event when duration is at
+// least latency-monitor-threshold.
+func (srv *Server) latencyAddSampleIfNeeded(event string, duration time.Duration) {
+	threshold := srv.configInt(&srv.latencyMonitorThreshold)
+	ms := duration.Milliseconds()
+
+	if threshold == 0 || ms < threshold {
+		return
+	}
+
+	srv.latencyAddSample(event, ms)
+}
+
+func (srv *Server) latencyAddSample(event string, ms int64) {
+	srv.latencyEventsMu.Lock()
+	defer srv.latencyEventsMu.Unlock()
+
+	e, ok := srv.latencyEvents[event]
+	if !ok {
+		e = &latencyEvent{}
+		srv.latencyEvents[event] = e
+	}
+
+	if ms > e.max {
+		e.max = ms
+	}
+
+	now := time.Now().Unix()
+
+	// Samples within the same second are merged, keeping the worst one.
+	if n := len(e.samples); n > 0 && e.samples[n-1].time == now {
+		if ms > e.samples[n-1].latency {
+			e.samples[n-1].latency = ms
+		}
+		return
+	}
+
+	e.samples = append(e.samples, latencySample{time: now, latency: ms})
+	if len(e.samples) > latencyHistoryLen {
+		e.samples = e.samples[1:]
+	}
+}
+
+func (srv *Server) latency(args []Value) Value {
+	srv.latencyEventsMu.Lock()
+	defer srv.latencyEventsMu.Unlock()
+
+	switch strings.ToUpper(args[0].Bulk) {
+	case "LATEST":
+		names := make([]string, 0, len(srv.latencyEvents))
+		for name := range srv.latencyEvents {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		events := []Value{}
+		for _, name := range names {
+			e := srv.latencyEvents[name]
+			last := e.samples[len(e.samples)-1]
+
+			events = append(events, Value{Type: "array", Array: []Value{
+				{Type: "bulk", Bulk: name},
+				{Type: "integer", Num: int(last.time)},
+				{Type: "integer", Num: int(last.latency)},
+				{Type: "integer", Num: int(e.max)},
+			}})
+		}
+
+		return Value{Type: "array", Array: events}
+	case "HISTORY":
+		if len(args) != 2 {
+			return Value{Type: "error", Str: "ERR wrong number of arguments for 'latency|history' command"}
+		}
+
+		samples := []Value{}
+		if e, ok := srv.latencyEvents[args[1].Bulk]; ok {
+			for _, s := range e.samples {
+				samples = append(samples, Value{Type: "array", Array: []Value{
+					{Type: "integer", Num: int(s.time)},
+					{Type: "integer", Num: int(s.latency)},
+				}})
+			}
+		}
+
+		return Value{Type: "array", Array: samples}
+	case "RESET":
+		reset := 0
+		if len(args) == 1 {
+			reset = len(srv.latencyEvents)
+			srv.latencyEvents = map[string]*latencyEvent{}
+		}
+		for _, arg := range args[1:] {
+			if _, ok := srv.latencyEvents[arg.Bulk]; ok {
+				delete(srv.latencyEvents, arg.Bulk)
+				reset++
+			}
+		}
+
+		return Value{Type: "integer", Num: reset}
+	default:
+		return Value{Type: "error", Str: fmt.Sprintf("ERR unknown subcommand '%s'", args[0].Bulk)}
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"strconv"
+)
+
+// lookupList returns the list at key. The caller must hold the shard lock.
+func lookupList(sh *Shard, key string) (*listObject, *Value) {
+	if t := sh.typeOf(key); t != "none" && t != "list" {
+		return nil, &Value{Type: "error", Str: wrongTypeError}
+	}
+	return sh.lists[key], nil
+}
+
+func (srv *Server) lpush(args []Value) Value {
+	return srv.pushGeneric(args, true)
+}
+
+func (srv *Server) rpush(args []Value) Value {
+	return srv.pushGeneric(args, false)
+}
+
+// pushGeneric adds the elements to the head of the list, one after the
+// other so that the last one ends up first, or to its tail.
+func (srv *Server) pushGeneric(args []Value, head bool) Value {
+	key := args[0].Bulk
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+
+	list, errValue := lookupList(sh, key)
+	if errValue != nil {
+		sh.mu.Unlock()
+		return *errValue
+	}
+	if list == nil {
+		list = newListObject()
+		sh.lists[key] = list
+	}
+
+	elements := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		elements[i] = arg.Bulk
+	}
+	list.push(srv, elements, head)
+	length := list.length()
+	sh.mu.Unlock()
+
+	if head {
+		srv.notifyKeyspaceEvent(notifyList, "lpush", key)
+	} else {
+		srv.notifyKeyspaceEvent(notifyList, "rpush", key)
+	}
+
+	return Value{Type: "integer", Num: length}
+}
+
+func (srv *Server) lpop(args []Value) Value {
+	return srv.popGeneric(args, true)
+}
+
+func (srv *Server) rpop(args []Value) Value {
+	return srv.popGeneric(args, false)
+}
+
+// popGeneric removes elements from the head or the tail of the list. Without
+// a count it replies with a single element, with one with an array.
+func (srv *Server) popGeneric(args []Value, head bool) Value {
+	key := args[0].Bulk
+
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1].Bulk)
+		if err != nil || n < 0 {
+			return Value{Type: "error", Str: "ERR value is out of range, must be positive"}
+		}
+		count = n
+	} else if len(args) > 2 {
+		return Value{Type: "error", Str: "ERR syntax error"}
+	}
+
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+
+	list, errValue := lookupList(sh, key)
+	if errValue != nil {
+		sh.mu.Unlock()
+		return *errValue
+	}
+	if list == nil {
+		sh.mu.Unlock()
+		return Value{Type: "null"}
+	}
+
+	count = min(count, list.length())
+	popped := []Value{}
+	for i := 0; i < count; i++ {
+		popped = append(popped, Value{Type: "bulk", Bulk: list.pop(head)})
+	}
+	emptied := list.length() == 0
+	if emptied {
+		delete(sh.lists, key)
+	}
+	sh.mu.Unlock()
+
+	if count > 0 {
+		if head {
+			srv.notifyKeyspaceEvent(notifyList, "lpop", key)
+		} else {
+			srv.notifyKeyspaceEvent(notifyList, "rpop", key)
+		}
+	}
+	if emptied {
+		srv.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	}
+
+	if len(args) == 1 {
+		return popped[0]
+	}
+	return Value{Type: "array", Array: popped}
+}
+
+func (srv *Server) llen(args []Value) Value {
+	key := args[0].Bulk
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	list, errValue := lookupList(sh, key)
+	if errValue != nil {
+		return *errValue
+	}
+	if list == nil {
+		return Value{Type: "integer", Num: 0}
+	}
+
+	return Value{Type: "integer", Num: list.length()}
+}
+
+func (srv *Server) lrange(args []Value) Value {
+	key := args[0].Bulk
+
+	start, err1 := strconv.Atoi(args[1].Bulk)
+	stop, err2 := strconv.Atoi(args[2].Bulk)
+	if err1 != nil || err2 != nil {
+		return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+	}
+
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	list, errValue := lookupList(sh, key)
+	if errValue != nil {
+		return *errValue
+	}
+
+	values := []Value{}
+	if list == nil {
+		return Value{Type: "array", Array: values}
+	}
+
+	length := list.length()
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+
+	for _, element := range list.rangeElements(start, stop) {
+		values = append(values, Value{Type: "bulk", Bulk: element})
+	}
+
+	return Value{Type: "array", Array: values}
+}
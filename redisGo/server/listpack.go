@@ -0,0 +1,365 @@
+package server
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// listpack packs a sequence of strings into a single byte slice, each one
+// prefixed with its length as a uvarint. Like the listpacks of Redis, it
+// spares small hashes, sets and lists the allocations of a map entry or a
+// string per element, at the cost of linear scans, which is why those types
+// convert to a map or a quicklist once they outgrow the thresholds the
+// Server is configured with, hash-max-listpack-entries and the like.
+type listpack struct {
+	buf   []byte
+	count int
+}
+
+// entry returns the entry starting at offset and the offset of the next.
+func (lp *listpack) entry(offset int) ([]byte, int) {
+	n, size := binary.Uvarint(lp.buf[offset:])
+	start := offset + size
+	return lp.buf[start : start+int(n)], start + int(n)
+}
+
+// offset returns where entry i starts, or the end of the buffer for the
+// entry after the last.
+func (lp *listpack) offset(i int) int {
+	offset := 0
+	for ; i > 0; i-- {
+		_, offset = lp.entry(offset)
+	}
+	return offset
+}
+
+func (lp *listpack) get(i int) string {
+	entry, _ := lp.entry(lp.offset(i))
+	return string(entry)
+}
+
+func (lp *listpack) entries() []string {
+	entries := make([]string, 0, lp.count)
+	for offset := 0; offset < len(lp.buf); {
+		var entry []byte
+		entry, offset = lp.entry(offset)
+		entries = append(entries, string(entry))
+	}
+	return entries
+}
+
+// index returns the position of the first entry equal to s among every
+// step-th entry, or -1.
+func (lp *listpack) index(s string, step int) int {
+	offset := 0
+	for i := 0; i < lp.count; i++ {
+		var entry []byte
+		entry, offset = lp.entry(offset)
+		if i%step == 0 && string(entry) == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// insert adds values before entry i.
+func (lp *listpack) insert(i int, values ...string) {
+	packed := []byte{}
+	for _, value := range values {
+		packed = binary.AppendUvarint(packed, uint64(len(value)))
+		packed = append(packed, value...)
+	}
+
+	offset := lp.offset(i)
+	buf := make([]byte, 0, len(lp.buf)+len(packed))
+	buf = append(buf, lp.buf[:offset]...)
+	buf = append(buf, packed...)
+	lp.buf = append(buf, lp.buf[offset:]...)
+	lp.count += len(values)
+}
+
+func (lp *listpack) append(values ...string) {
+	lp.insert(lp.count, values...)
+}
+
+// remove deletes n entries from entry i on.
+func (lp *listpack) remove(i, n int) {
+	start := lp.offset(i)
+	end := start
+	for j := 0; j < n; j++ {
+		_, end = lp.entry(end)
+	}
+	lp.buf = append(lp.buf[:start:start], lp.buf[end:]...)
+	lp.count -= n
+}
+
+func (lp *listpack) replace(i int, value string) {
+	lp.remove(i, 1)
+	lp.insert(i, value)
+}
+
+// hashObject is the hash type: a listpack of fields and values while it is
+// small, a map once it is not.
+type hashObject struct {
+	lp   *listpack
+	dict map[string]string
+}
+
+func newHashObject() *hashObject {
+	return &hashObject{lp: &listpack{}}
+}
+
+func (h *hashObject) length() int {
+	if h.lp != nil {
+		return h.lp.count / 2
+	}
+	return len(h.dict)
+}
+
+func (h *hashObject) get(field string) (string, bool) {
+	if h.lp != nil {
+		if i := h.lp.index(field, 2); i >= 0 {
+			return h.lp.get(i + 1), true
+		}
+		return "", false
+	}
+	value, ok := h.dict[field]
+	return value, ok
+}
+
+// set sets field to value and reports whether the field is new.
+func (h *hashObject) set(srv *Server, field, value string) bool {
+	if h.lp != nil && (int64(len(field)) > srv.configInt(&srv.hashMaxListpackValue) || int64(len(value)) > srv.configInt(&srv.hashMaxListpackValue)) {
+		h.convert()
+	}
+
+	if h.lp != nil {
+		if i := h.lp.index(field, 2); i >= 0 {
+			h.lp.replace(i+1, value)
+			return false
+		}
+		if int64(h.length()) < srv.configInt(&srv.hashMaxListpackEntries) {
+			h.lp.append(field, value)
+			return true
+		}
+		h.convert()
+	}
+
+	_, ok := h.dict[field]
+	h.dict[field] = value
+	return !ok
+}
+
+func (h *hashObject) convert() {
+	h.dict = make(map[string]string, h.length())
+	h.each(func(field, value string) {
+		h.dict[field] = value
+	})
+	h.lp = nil
+}
+
+// each calls fn for every field, in insertion order while the hash is a
+// listpack.
+func (h *hashObject) each(fn func(field, value string)) {
+	if h.lp != nil {
+		entries := h.lp.entries()
+		for i := 0; i < len(entries); i += 2 {
+			fn(entries[i], entries[i+1])
+		}
+		return
+	}
+	for field, value := range h.dict {
+		fn(field, value)
+	}
+}
+
+// sortedFields returns the fields in lexicographic order.
+func (h *hashObject) sortedFields() []string {
+	fields := []string{}
+	h.each(func(field, _ string) {
+		fields = append(fields, field)
+	})
+	sort.Strings(fields)
+	return fields
+}
+
+func (h *hashObject) encoding() string {
+	if h.lp != nil {
+		return "listpack"
+	}
+	return "hashtable"
+}
+
+// setObject is the set type: a listpack of members while it is small, a
+// map once it is not.
+type setObject struct {
+	lp   *listpack
+	dict map[string]bool
+}
+
+func newSetObject() *setObject {
+	return &setObject{lp: &listpack{}}
+}
+
+func (s *setObject) length() int {
+	if s.lp != nil {
+		return s.lp.count
+	}
+	return len(s.dict)
+}
+
+func (s *setObject) has(member string) bool {
+	if s.lp != nil {
+		return s.lp.index(member, 1) >= 0
+	}
+	return s.dict[member]
+}
+
+// add adds member and reports whether it was not there already.
+func (s *setObject) add(srv *Server, member string) bool {
+	if s.has(member) {
+		return false
+	}
+
+	if s.lp != nil && (int64(len(member)) > srv.configInt(&srv.setMaxListpackValue) || int64(s.lp.count) >= srv.configInt(&srv.setMaxListpackEntries)) {
+		s.convert()
+	}
+	if s.lp != nil {
+		s.lp.append(member)
+	} else {
+		s.dict[member] = true
+	}
+	return true
+}
+
+// remove removes member and reports whether it was there.
+func (s *setObject) remove(member string) bool {
+	if s.lp != nil {
+		i := s.lp.index(member, 1)
+		if i < 0 {
+			return false
+		}
+		s.lp.remove(i, 1)
+		return true
+	}
+
+	if !s.dict[member] {
+		return false
+	}
+	delete(s.dict, member)
+	return true
+}
+
+func (s *setObject) convert() {
+	s.dict = make(map[string]bool, s.lp.count)
+	for _, member := range s.lp.entries() {
+		s.dict[member] = true
+	}
+	s.lp = nil
+}
+
+// members returns the members in no particular order.
+func (s *setObject) members() []string {
+	if s.lp != nil {
+		return s.lp.entries()
+	}
+	members := make([]string, 0, len(s.dict))
+	for member := range s.dict {
+		members = append(members, member)
+	}
+	return members
+}
+
+func (s *setObject) encoding() string {
+	if s.lp != nil {
+		return "listpack"
+	}
+	return "hashtable"
+}
+
+// listObject is the list type: a listpack while it is small, and a
+// quicklist of listpacks once it is not.
+type listObject struct {
+	lp *listpack
+	ql *quicklist
+}
+
+func newListObject() *listObject {
+	return &listObject{lp: &listpack{}}
+}
+
+func (l *listObject) length() int {
+	if l.lp != nil {
+		return l.lp.count
+	}
+	return l.ql.count
+}
+
+// push adds the values to the head of the list, one after the other so
+// that the last one ends up first, or to its tail.
+func (l *listObject) push(srv *Server, values []string, head bool) {
+	if l.lp != nil {
+		if head {
+			reversed := make([]string, len(values))
+			for i, value := range values {
+				reversed[len(values)-1-i] = value
+			}
+			l.lp.insert(0, reversed...)
+		} else {
+			l.lp.append(values...)
+		}
+		if srv.listpackFits(l.lp.count, len(l.lp.buf)) {
+			return
+		}
+
+		l.ql = &quicklist{}
+		for _, element := range l.lp.entries() {
+			l.ql.push(srv, element, false)
+		}
+		l.lp = nil
+		return
+	}
+
+	for _, value := range values {
+		l.ql.push(srv, value, head)
+	}
+}
+
+// pop removes and returns the element at the head or the tail of the
+// list, which must not be empty.
+func (l *listObject) pop(head bool) string {
+	if l.lp != nil {
+		i := 0
+		if !head {
+			i = l.lp.count - 1
+		}
+		value := l.lp.get(i)
+		l.lp.remove(i, 1)
+		return value
+	}
+
+	return l.ql.pop(head)
+}
+
+// rangeElements returns the elements from start to stop, which must be
+// valid indexes.
+func (l *listObject) rangeElements(start, stop int) []string {
+	if start > stop {
+		return []string{}
+	}
+	if l.lp != nil {
+		return l.lp.entries()[start : stop+1]
+	}
+	return l.ql.rangeElements(start, stop)
+}
+
+func (l *listObject) all() []string {
+	return l.rangeElements(0, l.length()-1)
+}
+
+func (l *listObject) encoding() string {
+	if l.lp != nil {
+		return "listpack"
+	}
+	return "quicklist"
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestListpack(t *testing.T) {
+	lp := &listpack{}
+	lp.append("b", "", strings.Repeat("x", 200))
+	lp.insert(0, "a")
+	lp.replace(2, "c")
+	lp.remove(3, 1)
+
+	if entries := strings.Join(lp.entries(), ","); entries != "a,b,c" || lp.count != 3 {
+		t.Errorf("Expected a,b,c, got %q with %d entries", entries, lp.count)
+	}
+	if i := lp.index("c", 1); i != 2 {
+		t.Errorf("Expected c at 2, got %d", i)
+	}
+	if i := lp.index("b", 2); i != -1 {
+		t.Errorf("Expected b to be skipped at an odd position, got %d", i)
+	}
+}
+
+func TestQuicklist(t *testing.T) {
+	testServer.configMu.Lock()
+	testServer.listMaxListpackSize = 3
+	testServer.configMu.Unlock()
+	defer func() {
+		testServer.configMu.Lock()
+		testServer.listMaxListpackSize = -2
+		testServer.configMu.Unlock()
+	}()
+
+	list := newListObject()
+	list.push(testServer, []string{"4", "5"}, false)
+	list.push(testServer, []string{"3", "2", "1", "0"}, true)
+	list.push(testServer, []string{"6", "7", "8", "9"}, false)
+	if list.encoding() != "quicklist" || list.ql.nodes != 4 {
+		t.Errorf("Expected a quicklist of 4 nodes, got %s with %d", list.encoding(), list.ql.nodes)
+	}
+	if elements := strings.Join(list.all(), ","); elements != "0,1,2,3,4,5,6,7,8,9" {
+		t.Errorf("Expected 0 to 9 in order, got %s", elements)
+	}
+	if elements := strings.Join(list.rangeElements(2, 7), ","); elements != "2,3,4,5,6,7" {
+		t.Errorf("Expected 2 to 7, got %s", elements)
+	}
+
+	for i := 0; i < 4; i++ {
+		list.pop(true)
+	}
+	if value := list.pop(false); value != "9" {
+		t.Errorf("Expected 9 from the tail, got %s", value)
+	}
+	if list.length() != 5 || list.ql.nodes != 2 {
+		t.Errorf("Expected 5 elements in 2 nodes after popping, got %d in %d", list.length(), list.ql.nodes)
+	}
+	if elements := strings.Join(list.all(), ","); elements != "4,5,6,7,8" {
+		t.Errorf("Expected 4 to 8, got %s", elements)
+	}
+}
+
+func TestCompactEncodings(t *testing.T) {
+	encoding := func(key string) string {
+		return testServer.object(command("ENCODING", key).Array).Bulk
+	}
+
+	testServer.set(command("enc:int", "12345").Array)
+	testServer.set(command("enc:padded", "012345").Array)
+	if encoding("enc:int") != "int" || encoding("enc:padded") != "embstr" {
+		t.Errorf("Expected int and embstr, got %s and %s", encoding("enc:int"), encoding("enc:padded"))
+	}
+	if result := testServer.get(command("enc:int").Array); result.Bulk != "12345" {
+		t.Errorf("Expected 12345, got %v", result)
+	}
+	testServer.set(command("enc:int", "text").Array)
+	if result := testServer.get(command("enc:int").Array); result.Bulk != "text" || encoding("enc:int") != "embstr" {
+		t.Errorf("Expected the int to be replaced by a string, got %v", result)
+	}
+
+	for i := 0; i < 128; i++ {
+		testServer.hset(command("enc:hash", "f"+strconv.Itoa(i), "v").Array)
+	}
+	if encoding("enc:hash") != "listpack" {
+		t.Errorf("Expected a listpack at 128 fields, got %s", encoding("enc:hash"))
+	}
+	testServer.hset(command("enc:hash", "one-more", "v").Array)
+	if encoding("enc:hash") != "hashtable" {
+		t.Errorf("Expected a hashtable past 128 fields, got %s", encoding("enc:hash"))
+	}
+	if result := testServer.hget(command("enc:hash", "f99").Array); result.Bulk != "v" {
+		t.Errorf("Expected the fields to survive the conversion, got %v", result)
+	}
+
+	testServer.hset(command("enc:bigvalue", "f", strings.Repeat("v", 65)).Array)
+	if encoding("enc:bigvalue") != "hashtable" {
+		t.Errorf("Expected a hashtable for a long value, got %s", encoding("enc:bigvalue"))
+	}
+
+	testServer.sadd(command("enc:set", "a", "b").Array)
+	if encoding("enc:set") != "listpack" {
+		t.Errorf("Expected a listpack, got %s", encoding("enc:set"))
+	}
+	testServer.sadd(command("enc:set", strings.Repeat("m", 65)).Array)
+	if encoding("enc:set") != "hashtable" {
+		t.Errorf("Expected a hashtable for a long member, got %s", encoding("enc:set"))
+	}
+	expectBulks(t, "converted set", testServer.smembers(command("enc:set").Array), "a", "b", strings.Repeat("m", 65))
+
+	testServer.configMu.Lock()
+	testServer.listMaxListpackSize = 4
+	testServer.configMu.Unlock()
+	defer func() {
+		testServer.configMu.Lock()
+		testServer.listMaxListpackSize = -2
+		testServer.configMu.Unlock()
+	}()
+
+	testServer.lpush(command("enc:list", "c", "b", "a").Array)
+	if encoding("enc:list") != "listpack" {
+		t.Errorf("Expected a listpack, got %s", encoding("enc:list"))
+	}
+	testServer.rpush(command("enc:list", "d", "e").Array)
+	if encoding("enc:list") != "quicklist" {
+		t.Errorf("Expected a quicklist past 4 elements, got %s", encoding("enc:list"))
+	}
+	expectBulks(t, "converted list", testServer.lrange(command("enc:list", "0", "-1").Array), "a", "b", "c", "d", "e")
+}
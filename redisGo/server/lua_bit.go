@@ -0,0 +1,89 @@
+package server
+
+import (
+	"math"
+	"math/bits"
+	"strings"
+)
+
+// The bit library, as LuaBitOp defines it: operations on numbers taken as
+// 32-bit integers, returning signed 32-bit results.
+
+func openLuaBit(L *luaState) {
+	bit := newLuaTable()
+	luaRegister(bit, "tobit", luaBitUnary("tobit", func(x uint32) uint32 { return x }))
+	luaRegister(bit, "bnot", luaBitUnary("bnot", func(x uint32) uint32 { return ^x }))
+	luaRegister(bit, "bswap", luaBitUnary("bswap", bits.ReverseBytes32))
+	luaRegister(bit, "band", luaBitFold("band", func(x, y uint32) uint32 { return x & y }))
+	luaRegister(bit, "bor", luaBitFold("bor", func(x, y uint32) uint32 { return x | y }))
+	luaRegister(bit, "bxor", luaBitFold("bxor", func(x, y uint32) uint32 { return x ^ y }))
+	luaRegister(bit, "lshift", luaBitShift("lshift", func(x uint32, n uint) uint32 { return x << n }))
+	luaRegister(bit, "rshift", luaBitShift("rshift", func(x uint32, n uint) uint32 { return x >> n }))
+	luaRegister(bit, "arshift", luaBitShift("arshift", func(x uint32, n uint) uint32 { return uint32(int32(x) >> n) }))
+	luaRegister(bit, "rol", luaBitShift("rol", func(x uint32, n uint) uint32 { return bits.RotateLeft32(x, int(n)) }))
+	luaRegister(bit, "ror", luaBitShift("ror", func(x uint32, n uint) uint32 { return bits.RotateLeft32(x, -int(n)) }))
+	luaRegister(bit, "tohex", luaBitTohex)
+	bit.readonly = true
+	L.globals.set("bit", bit)
+}
+
+// checkBit converts an argument to a 32-bit integer the way LuaBitOp does,
+// rounding to the nearest integer and wrapping modulo 2^32.
+func (L *luaState) checkBit(args []luaValue, i int, fname string) uint32 {
+	n := math.RoundToEven(L.checkNumber(args, i, fname))
+	return uint32(int64(math.Mod(n, 1<<32)))
+}
+
+func luaBitResult(x uint32) []luaValue {
+	return []luaValue{float64(int32(x))}
+}
+
+func luaBitUnary(name string, fn func(uint32) uint32) func(*luaState, []luaValue) []luaValue {
+	return func(L *luaState, args []luaValue) []luaValue {
+		return luaBitResult(fn(L.checkBit(args, 0, name)))
+	}
+}
+
+func luaBitFold(name string, fn func(x, y uint32) uint32) func(*luaState, []luaValue) []luaValue {
+	return func(L *luaState, args []luaValue) []luaValue {
+		x := L.checkBit(args, 0, name)
+		for i := 1; i < len(args); i++ {
+			x = fn(x, L.checkBit(args, i, name))
+		}
+		return luaBitResult(x)
+	}
+}
+
+func luaBitShift(name string, fn func(x uint32, n uint) uint32) func(*luaState, []luaValue) []luaValue {
+	return func(L *luaState, args []luaValue) []luaValue {
+		x := L.checkBit(args, 0, name)
+		n := uint(L.checkBit(args, 1, name) & 31)
+		return luaBitResult(fn(x, n))
+	}
+}
+
+// tohex formats the n low hex digits of x, 8 by default, in upper case if
+// n is negative.
+func luaBitTohex(L *luaState, args []luaValue) []luaValue {
+	x := L.checkBit(args, 0, "tohex")
+	n := 8
+	if luaArg(args, 1) != nil {
+		n = int(int32(L.checkBit(args, 1, "tohex")))
+	}
+
+	digits := "0123456789abcdef"
+	if n < 0 {
+		n = -n
+		digits = strings.ToUpper(digits)
+	}
+	if n > 8 {
+		n = 8
+	}
+
+	buf := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		buf[i] = digits[x&15]
+		x >>= 4
+	}
+	return []luaValue{string(buf)}
+}
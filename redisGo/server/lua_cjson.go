@@ -0,0 +1,194 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+)
+
+// The cjson library, as Lua CJSON 2.1 behaves with the defaults Redis
+// keeps.
+
+const luaCjsonMaxDepth = 1000
+
+// luaCjsonNull is cjson.null, which stands for JSON null inside tables.
+var luaCjsonNull = &luaUserdata{name: "null"}
+
+func openLuaCjson(L *luaState) {
+	cjson := newLuaTable()
+	luaRegister(cjson, "encode", luaCjsonEncode)
+	luaRegister(cjson, "decode", luaCjsonDecode)
+	cjson.set("null", luaCjsonNull)
+	cjson.readonly = true
+	L.globals.set("cjson", cjson)
+}
+
+func luaCjsonEncode(L *luaState, args []luaValue) []luaValue {
+	if len(args) != 1 {
+		L.errorf("bad argument #1 to 'encode' (expected 1 argument)")
+	}
+
+	var sb strings.Builder
+	luaCjsonAppend(L, &sb, args[0], 0)
+	return []luaValue{sb.String()}
+}
+
+func luaCjsonAppend(L *luaState, sb *strings.Builder, v luaValue, depth int) {
+	switch x := v.(type) {
+	case nil:
+		sb.WriteString("null")
+	case bool:
+		if x {
+			sb.WriteString("true")
+		} else {
+			sb.WriteString("false")
+		}
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			L.errorf("Cannot serialise number: must not be NaN or Inf")
+		}
+		sb.WriteString(luaFormatNumber(x))
+	case string:
+		luaCjsonAppendString(sb, x)
+	case *luaTable:
+		depth++
+		if depth > luaCjsonMaxDepth {
+			L.errorf("Cannot serialise, excessive nesting (%d)", depth)
+		}
+
+		if n := luaCjsonArrayLength(L, x); n > 0 {
+			sb.WriteByte('[')
+			for i := 1; i <= n; i++ {
+				if i > 1 {
+					sb.WriteByte(',')
+				}
+				luaCjsonAppend(L, sb, x.get(float64(i)), depth)
+			}
+			sb.WriteByte(']')
+			return
+		}
+
+		sb.WriteByte('{')
+		first := true
+		for k, value, _ := x.next(nil); k != nil; k, value, _ = x.next(k) {
+			if !first {
+				sb.WriteByte(',')
+			}
+			first = false
+
+			switch key := k.(type) {
+			case string:
+				luaCjsonAppendString(sb, key)
+			case float64:
+				luaCjsonAppendString(sb, luaFormatNumber(key))
+			default:
+				L.errorf("Cannot serialise table: table key must be a number or string")
+			}
+			sb.WriteByte(':')
+			luaCjsonAppend(L, sb, value, depth)
+		}
+		sb.WriteByte('}')
+	default:
+		if v == luaCjsonNull {
+			sb.WriteString("null")
+			return
+		}
+		L.errorf("Cannot serialise %s: type not supported", luaTypeName(v))
+	}
+}
+
+// luaCjsonArrayLength returns the length of t if its keys are all positive
+// integers, for it to be encoded as an array, or 0. Arrays where more than
+// half of the elements would be null, beyond the first ten, are refused.
+func luaCjsonArrayLength(L *luaState, t *luaTable) int {
+	max, items := 0, 0
+	for k, _, _ := t.next(nil); k != nil; k, _, _ = t.next(k) {
+		n, ok := k.(float64)
+		if !ok || n < 1 || n != math.Floor(n) {
+			return 0
+		}
+		if int(n) > max {
+			max = int(n)
+		}
+		items++
+	}
+
+	if max > 10 && max > 2*items {
+		L.errorf("Cannot serialise table: excessively sparse array")
+	}
+	return max
+}
+
+func luaCjsonAppendString(sb *strings.Builder, s string) {
+	const hex = "0123456789abcdef"
+
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '/':
+			sb.WriteString(`\/`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				sb.WriteString(`\u00`)
+				sb.WriteByte(hex[c>>4])
+				sb.WriteByte(hex[c&0xf])
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	sb.WriteByte('"')
+}
+
+func luaCjsonDecode(L *luaState, args []luaValue) []luaValue {
+	if len(args) != 1 {
+		L.errorf("bad argument #1 to 'decode' (expected 1 argument)")
+	}
+	s := L.checkString(args, 0, "decode")
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			L.errorf("Expected value but found invalid token at character %d", syntax.Offset)
+		}
+		L.errorf("Expected value but found invalid token at character 1")
+	}
+
+	return []luaValue{luaFromJSON(v)}
+}
+
+func luaFromJSON(v interface{}) luaValue {
+	switch x := v.(type) {
+	case nil:
+		return luaCjsonNull
+	case []interface{}:
+		t := newLuaTable()
+		for i, elem := range x {
+			t.set(float64(i+1), luaFromJSON(elem))
+		}
+		return t
+	case map[string]interface{}:
+		t := newLuaTable()
+		for k, elem := range x {
+			t.set(k, luaFromJSON(elem))
+		}
+		return t
+	}
+	return v
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// The cmsgpack library, as lua_cmsgpack behaves in Redis: pack encodes its
+// arguments as consecutive MessagePack objects and unpack decodes all the
+// objects of a string.
+
+// Tables nested deeper than this are packed as nil.
+const luaCmsgpackMaxNesting = 16
+
+func openLuaCmsgpack(L *luaState) {
+	cmsgpack := newLuaTable()
+	luaRegister(cmsgpack, "pack", luaCmsgpackPack)
+	luaRegister(cmsgpack, "unpack", luaCmsgpackUnpack)
+	cmsgpack.readonly = true
+	L.globals.set("cmsgpack", cmsgpack)
+}
+
+func luaCmsgpackPack(L *luaState, args []luaValue) []luaValue {
+	if len(args) == 0 {
+		L.errorf("MessagePack pack needs input.")
+	}
+
+	var buf []byte
+	for _, arg := range args {
+		buf = luaCmsgpackEncode(buf, arg, 0)
+	}
+	return []luaValue{string(buf)}
+}
+
+func luaCmsgpackEncode(buf []byte, v luaValue, depth int) []byte {
+	switch x := v.(type) {
+	case bool:
+		if x {
+			return append(buf, 0xc3)
+		}
+		return append(buf, 0xc2)
+	case float64:
+		if !math.IsInf(x, 0) && float64(int64(x)) == x {
+			return luaCmsgpackEncodeInt(buf, int64(x))
+		}
+		if float64(float32(x)) == x || math.IsNaN(x) {
+			buf = append(buf, 0xca)
+			return binary.BigEndian.AppendUint32(buf, math.Float32bits(float32(x)))
+		}
+		buf = append(buf, 0xcb)
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(x))
+	case string:
+		n := len(x)
+		switch {
+		case n < 32:
+			buf = append(buf, 0xa0|byte(n))
+		case n <= math.MaxUint8:
+			buf = append(buf, 0xd9, byte(n))
+		case n <= math.MaxUint16:
+			buf = append(buf, 0xda)
+			buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+		default:
+			buf = append(buf, 0xdb)
+			buf = binary.BigEndian.AppendUint32(buf, uint32(n))
+		}
+		return append(buf, x...)
+	case *luaTable:
+		if depth >= luaCmsgpackMaxNesting {
+			return append(buf, 0xc0)
+		}
+		if n, ok := luaCmsgpackArrayLength(x); ok {
+			buf = luaCmsgpackHeader(buf, n, 0x90, 0xdc, 0xdd)
+			for i := 1; i <= n; i++ {
+				buf = luaCmsgpackEncode(buf, x.get(float64(i)), depth+1)
+			}
+			return buf
+		}
+
+		n := 0
+		for k, _, _ := x.next(nil); k != nil; k, _, _ = x.next(k) {
+			n++
+		}
+		buf = luaCmsgpackHeader(buf, n, 0x80, 0xde, 0xdf)
+		for k, value, _ := x.next(nil); k != nil; k, value, _ = x.next(k) {
+			buf = luaCmsgpackEncode(buf, k, depth+1)
+			buf = luaCmsgpackEncode(buf, value, depth+1)
+		}
+		return buf
+	}
+
+	// nil, and the types MessagePack cannot represent.
+	return append(buf, 0xc0)
+}
+
+func luaCmsgpackEncodeInt(buf []byte, n int64) []byte {
+	switch {
+	case n >= 0 && n <= math.MaxInt8:
+		return append(buf, byte(n))
+	case n >= 0 && n <= math.MaxUint8:
+		return append(buf, 0xcc, byte(n))
+	case n >= 0 && n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xcd), uint16(n))
+	case n >= 0 && n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xce), uint32(n))
+	case n >= 0:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcf), uint64(n))
+	case n >= -32:
+		return append(buf, byte(int8(n)))
+	case n >= math.MinInt8:
+		return append(buf, 0xd0, byte(int8(n)))
+	case n >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(int16(n)))
+	case n >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(int32(n)))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(n))
+}
+
+// luaCmsgpackHeader appends the header of an array or a map of n elements,
+// in its fixed, 16-bit or 32-bit form.
+func luaCmsgpackHeader(buf []byte, n int, fix, b16, b32 byte) []byte {
+	switch {
+	case n < 16:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, b16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buf, b32), uint32(n))
+}
+
+// luaCmsgpackArrayLength returns the length of t if its keys are exactly
+// 1 to n, for it to be packed as an array. An empty table is an array.
+func luaCmsgpackArrayLength(t *luaTable) (int, bool) {
+	max, count := 0, 0
+	for k, _, _ := t.next(nil); k != nil; k, _, _ = t.next(k) {
+		n, ok := k.(float64)
+		if !ok || n < 1 || n != math.Floor(n) {
+			return 0, false
+		}
+		if int(n) > max {
+			max = int(n)
+		}
+		count++
+	}
+	return max, max == count
+}
+
+func luaCmsgpackUnpack(L *luaState, args []luaValue) []luaValue {
+	data := L.checkString(args, 0, "unpack")
+
+	d := &luaCmsgpackDecoder{L: L, data: data}
+	values := []luaValue{}
+	for d.pos < len(d.data) {
+		values = append(values, d.decode())
+	}
+	return values
+}
+
+type luaCmsgpackDecoder struct {
+	L    *luaState
+	data string
+	pos  int
+}
+
+func (d *luaCmsgpackDecoder) take(n int) string {
+	if n < 0 || n > len(d.data)-d.pos {
+		d.L.errorf("Missing bytes in input.")
+	}
+	s := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return s
+}
+
+func (d *luaCmsgpackDecoder) uint(n int) uint64 {
+	var x uint64
+	for _, b := range []byte(d.take(n)) {
+		x = x<<8 | uint64(b)
+	}
+	return x
+}
+
+func (d *luaCmsgpackDecoder) decode() luaValue {
+	c := d.take(1)[0]
+
+	switch {
+	case c <= 0x7f:
+		return float64(c)
+	case c >= 0xe0:
+		return float64(int8(c))
+	case c&0xe0 == 0xa0:
+		return d.take(int(c & 0x1f))
+	case c&0xf0 == 0x90:
+		return d.array(int(c & 0x0f))
+	case c&0xf0 == 0x80:
+		return d.table(int(c & 0x0f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil
+	case 0xc2:
+		return false
+	case 0xc3:
+		return true
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return float64(d.uint(1 << (c - 0xcc)))
+	case 0xd0:
+		return float64(int8(d.uint(1)))
+	case 0xd1:
+		return float64(int16(d.uint(2)))
+	case 0xd2:
+		return float64(int32(d.uint(4)))
+	case 0xd3:
+		return float64(int64(d.uint(8)))
+	case 0xca:
+		return float64(math.Float32frombits(uint32(d.uint(4))))
+	case 0xcb:
+		return math.Float64frombits(d.uint(8))
+	case 0xd9, 0xc4:
+		return d.take(int(d.uint(1)))
+	case 0xda, 0xc5:
+		return d.take(int(d.uint(2)))
+	case 0xdb, 0xc6:
+		return d.take(int(d.uint(4)))
+	case 0xdc:
+		return d.array(int(d.uint(2)))
+	case 0xdd:
+		return d.array(int(d.uint(4)))
+	case 0xde:
+		return d.table(int(d.uint(2)))
+	case 0xdf:
+		return d.table(int(d.uint(4)))
+	}
+
+	d.L.errorf("Bad data format in input.")
+	return nil
+}
+
+func (d *luaCmsgpackDecoder) array(n int) luaValue {
+	t := newLuaTable()
+	for i := 1; i <= n; i++ {
+		t.set(float64(i), d.decode())
+	}
+	return t
+}
+
+func (d *luaCmsgpackDecoder) table(n int) luaValue {
+	t := newLuaTable()
+	for i := 0; i < n; i++ {
+		k := d.decode()
+		v := d.decode()
+		if f, ok := k.(float64); k == nil || ok && math.IsNaN(f) {
+			continue
+		}
+		t.set(k, v)
+	}
+	return t
+}
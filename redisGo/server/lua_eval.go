@@ -0,0 +1,996 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+)
+
+// Lua values are represented with plain Go types: nil, bool, float64,
+// string, *luaTable, *luaFunction, *luaGoFunction and *luaUserdata.
+type luaValue = interface{}
+
+const luaMaxCallDepth = 200
+
+type luaFunction struct {
+	proto *luaProto
+	scope *luaScope
+}
+
+// luaUserdata is an opaque value, such as cjson.null.
+type luaUserdata struct {
+	name string
+}
+
+type luaGoFunction struct {
+	name string
+	fn   func(L *luaState, args []luaValue) []luaValue
+}
+
+// luaError carries a Lua error value through Go panics until it reaches a
+// pcall or the script boundary.
+type luaError struct {
+	value luaValue
+}
+
+func (e *luaError) Error() string {
+	if s, ok := e.value.(string); ok {
+		return s
+	}
+	if t, ok := e.value.(*luaTable); ok {
+		if msg, ok := t.get("err").(string); ok {
+			return msg
+		}
+	}
+	return luaToString(e.value)
+}
+
+// Tables
+
+type luaTableEntry struct {
+	key   luaValue
+	value luaValue
+}
+
+type luaTable struct {
+	seq      []luaValue // seq[i] holds the value for key i+1
+	index    map[luaValue]int
+	entries  []luaTableEntry
+	deleted  int
+	readonly bool
+	meta     *luaTable
+}
+
+func newLuaTable() *luaTable {
+	return &luaTable{index: map[luaValue]int{}}
+}
+
+// seqIndex maps integral number keys to their position in seq, allowing
+// one past the end so that appends stay in the sequence part.
+func (t *luaTable) seqIndex(key luaValue) (int, bool) {
+	n, ok := key.(float64)
+	if !ok || n != math.Trunc(n) || n < 1 || n > float64(len(t.seq)+1) {
+		return 0, false
+	}
+	return int(n) - 1, true
+}
+
+func (t *luaTable) get(key luaValue) luaValue {
+	if i, ok := t.seqIndex(key); ok && i < len(t.seq) {
+		return t.seq[i]
+	}
+
+	if i, ok := t.index[key]; ok {
+		return t.entries[i].value
+	}
+	return nil
+}
+
+func (t *luaTable) set(key, value luaValue) {
+	if i, ok := t.seqIndex(key); ok {
+		switch {
+		case i < len(t.seq):
+			t.seq[i] = value
+			for len(t.seq) > 0 && t.seq[len(t.seq)-1] == nil {
+				t.seq = t.seq[:len(t.seq)-1]
+			}
+			return
+		case value != nil:
+			t.hashSet(key, nil)
+			t.seq = append(t.seq, value)
+
+			// Pull following integer keys over from the hash part.
+			for {
+				next := float64(len(t.seq) + 1)
+				v := t.hashGet(next)
+				if v == nil {
+					break
+				}
+				t.hashSet(next, nil)
+				t.seq = append(t.seq, v)
+			}
+			return
+		}
+	}
+
+	t.hashSet(key, value)
+}
+
+func (t *luaTable) hashGet(key luaValue) luaValue {
+	if i, ok := t.index[key]; ok {
+		return t.entries[i].value
+	}
+	return nil
+}
+
+func (t *luaTable) hashSet(key, value luaValue) {
+	if i, ok := t.index[key]; ok {
+		if t.entries[i].value != nil && value == nil {
+			t.deleted++
+		} else if t.entries[i].value == nil && value != nil {
+			t.deleted--
+		}
+		t.entries[i].value = value
+		return
+	}
+
+	if value == nil {
+		return
+	}
+
+	// Deleted entries are kept as tombstones so that next() keeps working
+	// while fields are cleared during a traversal; they are only dropped
+	// when new keys are added, which Lua forbids during traversal anyway.
+	if t.deleted > len(t.entries)/2 {
+		entries := make([]luaTableEntry, 0, len(t.entries)-t.deleted+1)
+		for _, e := range t.entries {
+			if e.value != nil {
+				entries = append(entries, e)
+			}
+		}
+
+		t.entries = entries
+		t.index = make(map[luaValue]int, len(entries))
+		for i, e := range entries {
+			t.index[e.key] = i
+		}
+		t.deleted = 0
+	}
+
+	t.index[key] = len(t.entries)
+	t.entries = append(t.entries, luaTableEntry{key: key, value: value})
+}
+
+func (t *luaTable) length() int {
+	return len(t.seq)
+}
+
+// next returns the key/value pair following key in traversal order.
+func (t *luaTable) next(key luaValue) (luaValue, luaValue, bool) {
+	start := 0
+
+	if key != nil {
+		if i, ok := t.seqIndex(key); ok && i < len(t.seq) {
+			start = i + 1
+		} else if i, ok := t.index[key]; ok {
+			start = len(t.seq) + i + 1
+		} else {
+			return nil, nil, false
+		}
+	}
+
+	for i := start; i < len(t.seq); i++ {
+		if t.seq[i] != nil {
+			return float64(i + 1), t.seq[i], true
+		}
+	}
+
+	if start < len(t.seq) {
+		start = len(t.seq)
+	}
+	for i := start - len(t.seq); i < len(t.entries); i++ {
+		if t.entries[i].value != nil {
+			return t.entries[i].key, t.entries[i].value, true
+		}
+	}
+
+	return nil, nil, true
+}
+
+// Scopes
+
+type luaScope struct {
+	names   []string
+	values  []*luaValue
+	parent  *luaScope
+	varargs []luaValue
+	isFunc  bool
+}
+
+func newLuaScope(parent *luaScope) *luaScope {
+	return &luaScope{parent: parent}
+}
+
+func (s *luaScope) declare(name string, value luaValue) {
+	v := value
+	s.names = append(s.names, name)
+	s.values = append(s.values, &v)
+}
+
+func (s *luaScope) lookup(name string) *luaValue {
+	for scope := s; scope != nil; scope = scope.parent {
+		for i := len(scope.names) - 1; i >= 0; i-- {
+			if scope.names[i] == name {
+				return scope.values[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (s *luaScope) vararg() []luaValue {
+	for scope := s; scope != nil; scope = scope.parent {
+		if scope.isFunc {
+			return scope.varargs
+		}
+	}
+	return nil
+}
+
+// Interpreter state
+
+type luaState struct {
+	chunk   string
+	globals *luaTable
+	depth   int
+	line    int
+
+	// stringMeta is the metatable shared by all strings, which makes the
+	// string library available as methods.
+	stringMeta *luaTable
+
+	// hook, if set, is called every luaHookInterval blocks run, which is
+	// where a script that runs for too long is stopped. Once killed is
+	// set, pcall no longer catches errors, so the script cannot go on.
+	hook   func(L *luaState)
+	steps  int
+	killed bool
+
+	// rand backs math.random.
+	rand *rand.Rand
+}
+
+const luaHookInterval = 1000
+
+func newLuaState(chunk string) *luaState {
+	return &luaState{chunk: chunk, globals: newLuaTable()}
+}
+
+func (L *luaState) errorf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if L.line > 0 {
+		msg = fmt.Sprintf("%s:%d: %s", L.chunk, L.line, msg)
+	}
+	panic(&luaError{value: msg})
+}
+
+// run executes a compiled chunk, converting any Lua error into a Go error.
+func (L *luaState) run(proto *luaProto, args ...luaValue) (results []luaValue, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			le, ok := r.(*luaError)
+			if !ok {
+				panic(r)
+			}
+			err = le
+		}
+	}()
+
+	fn := &luaFunction{proto: proto}
+	return L.call(fn, args), nil
+}
+
+// pcall runs fn, returning the Lua error value instead of raising it.
+func (L *luaState) pcall(fn luaValue, args []luaValue) (results []luaValue, errValue luaValue, ok bool) {
+	depth := L.depth
+	line := L.line
+
+	defer func() {
+		if r := recover(); r != nil {
+			le, isLuaErr := r.(*luaError)
+			if !isLuaErr || L.killed {
+				panic(r)
+			}
+			L.depth = depth
+			L.line = line
+			results, errValue, ok = nil, le.value, false
+		}
+	}()
+
+	return L.call(fn, args), nil, true
+}
+
+func (L *luaState) call(fn luaValue, args []luaValue) []luaValue {
+	switch f := fn.(type) {
+	case *luaGoFunction:
+		return f.fn(L, args)
+	case *luaFunction:
+		if L.depth >= luaMaxCallDepth {
+			L.errorf("stack overflow")
+		}
+		L.depth++
+		defer func() { L.depth-- }()
+
+		scope := newLuaScope(f.scope)
+		scope.isFunc = true
+		for i, name := range f.proto.params {
+			var v luaValue
+			if i < len(args) {
+				v = args[i]
+			}
+			scope.declare(name, v)
+		}
+		if f.proto.isVararg && len(args) > len(f.proto.params) {
+			scope.varargs = args[len(f.proto.params):]
+		}
+
+		line := L.line
+		_, results := L.execBlock(f.proto.body, scope)
+		L.line = line
+
+		return results
+	default:
+		if handler := L.metaField(fn, "__call"); handler != nil {
+			return L.call(handler, append([]luaValue{fn}, args...))
+		}
+		L.errorf("attempt to call a %s value", luaTypeName(fn))
+		return nil
+	}
+}
+
+// Statements
+
+const (
+	luaFlowNormal = iota
+	luaFlowBreak
+	luaFlowReturn
+)
+
+func (L *luaState) execBlock(block luaBlock, scope *luaScope) (int, []luaValue) {
+	if L.hook != nil {
+		L.steps++
+		if L.steps%luaHookInterval == 0 {
+			L.hook(L)
+		}
+	}
+
+	for _, stmt := range block {
+		if flow, results := L.exec(stmt, scope); flow != luaFlowNormal {
+			return flow, results
+		}
+	}
+	return luaFlowNormal, nil
+}
+
+func (L *luaState) exec(stmt luaStmt, scope *luaScope) (int, []luaValue) {
+	switch s := stmt.(type) {
+	case *stmtLocal:
+		values := L.evalList(s.exprs, scope, len(s.names))
+		for i, name := range s.names {
+			scope.declare(name, values[i])
+		}
+	case *stmtAssign:
+		L.line = s.line
+
+		// Evaluate table and key operands before any assignment happens.
+		type target struct {
+			ref   *luaValue
+			table luaValue
+			key   luaValue
+			name  string
+			line  int
+		}
+		targets := make([]target, len(s.targets))
+		for i, t := range s.targets {
+			switch e := t.(type) {
+			case *exprName:
+				targets[i] = target{ref: scope.lookup(e.name), name: e.name, line: e.line}
+			case *exprIndex:
+				targets[i] = target{table: L.eval(e.obj, scope), key: L.eval(e.key, scope), line: e.line}
+			}
+		}
+
+		values := L.evalList(s.exprs, scope, len(s.targets))
+		for i, t := range targets {
+			L.line = t.line
+			switch {
+			case t.ref != nil:
+				*t.ref = values[i]
+			case t.name != "":
+				L.setIndex(L.globals, t.name, values[i])
+			default:
+				L.setIndex(t.table, t.key, values[i])
+			}
+		}
+	case *stmtCall:
+		L.evalMulti(s.call, scope)
+	case *stmtDo:
+		return L.execBlock(s.body, newLuaScope(scope))
+	case *stmtWhile:
+		for luaTruthy(L.eval(s.cond, scope)) {
+			flow, results := L.execBlock(s.body, newLuaScope(scope))
+			if flow == luaFlowBreak {
+				break
+			}
+			if flow == luaFlowReturn {
+				return flow, results
+			}
+		}
+	case *stmtRepeat:
+		for {
+			inner := newLuaScope(scope)
+			flow, results := L.execBlock(s.body, inner)
+			if flow == luaFlowBreak {
+				break
+			}
+			if flow == luaFlowReturn {
+				return flow, results
+			}
+			// The condition can see the body's locals.
+			if luaTruthy(L.eval(s.cond, inner)) {
+				break
+			}
+		}
+	case *stmtIf:
+		for i, cond := range s.conds {
+			if luaTruthy(L.eval(cond, scope)) {
+				return L.execBlock(s.blocks[i], newLuaScope(scope))
+			}
+		}
+		if s.elseBlock != nil {
+			return L.execBlock(s.elseBlock, newLuaScope(scope))
+		}
+	case *stmtNumericFor:
+		L.line = s.line
+		start := L.forNumber(L.eval(s.start, scope), "initial")
+		limit := L.forNumber(L.eval(s.limit, scope), "limit")
+		step := 1.0
+		if s.step != nil {
+			step = L.forNumber(L.eval(s.step, scope), "step")
+		}
+		if step == 0 {
+			L.errorf("'for' step is zero")
+		}
+
+		for i := start; (step > 0 && i <= limit) || (step <= 0 && i >= limit); i += step {
+			inner := newLuaScope(scope)
+			inner.declare(s.name, i)
+
+			flow, results := L.execBlock(s.body, inner)
+			if flow == luaFlowBreak {
+				break
+			}
+			if flow == luaFlowReturn {
+				return flow, results
+			}
+		}
+	case *stmtGenericFor:
+		init := L.evalList(s.exprs, scope, 3)
+		fn, state, control := init[0], init[1], init[2]
+
+		for {
+			L.line = s.line
+			values := L.call(fn, []luaValue{state, control})
+			if len(values) == 0 || values[0] == nil {
+				break
+			}
+			control = values[0]
+
+			inner := newLuaScope(scope)
+			for i, name := range s.names {
+				var v luaValue
+				if i < len(values) {
+					v = values[i]
+				}
+				inner.declare(name, v)
+			}
+
+			flow, results := L.execBlock(s.body, inner)
+			if flow == luaFlowBreak {
+				break
+			}
+			if flow == luaFlowReturn {
+				return flow, results
+			}
+		}
+	case *stmtFunction:
+		L.line = s.line
+		fn := &luaFunction{proto: s.proto, scope: scope}
+
+		switch target := s.target.(type) {
+		case *exprName:
+			if ref := scope.lookup(target.name); ref != nil {
+				*ref = fn
+			} else {
+				L.setIndex(L.globals, target.name, fn)
+			}
+		case *exprIndex:
+			L.setIndex(L.eval(target.obj, scope), L.eval(target.key, scope), fn)
+		}
+	case *stmtLocalFunction:
+		// Declared first so the function can call itself recursively.
+		scope.declare(s.name, nil)
+		*scope.lookup(s.name) = &luaFunction{proto: s.proto, scope: scope}
+	case *stmtReturn:
+		if len(s.exprs) == 1 {
+			// Tail calls and varargs keep all their values.
+			return luaFlowReturn, L.evalMulti(s.exprs[0], scope)
+		}
+		return luaFlowReturn, L.evalList(s.exprs, scope, -1)
+	case *stmtBreak:
+		return luaFlowBreak, nil
+	}
+
+	return luaFlowNormal, nil
+}
+
+func (L *luaState) forNumber(v luaValue, what string) float64 {
+	n, ok := luaToNumber(v)
+	if !ok {
+		L.errorf("'for' %s value must be a number", what)
+	}
+	return n
+}
+
+// Expressions
+
+// evalList evaluates exprs, expanding the last one if it yields multiple
+// values. want pads or truncates the result; -1 keeps everything.
+func (L *luaState) evalList(exprs []luaExpr, scope *luaScope, want int) []luaValue {
+	values := []luaValue{}
+	for i, e := range exprs {
+		if i == len(exprs)-1 {
+			values = append(values, L.evalMulti(e, scope)...)
+		} else {
+			values = append(values, L.eval(e, scope))
+		}
+	}
+
+	if want < 0 {
+		return values
+	}
+	for len(values) < want {
+		values = append(values, nil)
+	}
+	return values[:want]
+}
+
+// evalMulti evaluates an expression that may produce several values.
+func (L *luaState) evalMulti(expr luaExpr, scope *luaScope) []luaValue {
+	switch e := expr.(type) {
+	case *exprCall:
+		fn := L.eval(e.fn, scope)
+		args := L.evalList(e.args, scope, -1)
+		L.line = e.line
+		if fn == nil {
+			L.errorf("attempt to call %s (a nil value)", luaDescribe(e.fn))
+		}
+		return L.call(fn, args)
+	case *exprMethodCall:
+		obj := L.eval(e.obj, scope)
+		L.line = e.line
+		fn := L.index(obj, e.name)
+		args := append([]luaValue{obj}, L.evalList(e.args, scope, -1)...)
+		L.line = e.line
+		if fn == nil {
+			L.errorf("attempt to call method '%s' (a nil value)", e.name)
+		}
+		return L.call(fn, args)
+	case *exprVararg:
+		return append([]luaValue{}, scope.vararg()...)
+	}
+
+	return []luaValue{L.eval(expr, scope)}
+}
+
+func (L *luaState) eval(expr luaExpr, scope *luaScope) luaValue {
+	switch e := expr.(type) {
+	case *exprNil:
+		return nil
+	case *exprTrue:
+		return true
+	case *exprFalse:
+		return false
+	case *exprNumber:
+		return e.value
+	case *exprString:
+		return e.value
+	case *exprVararg:
+		if args := scope.vararg(); len(args) > 0 {
+			return args[0]
+		}
+		return nil
+	case *exprFunction:
+		return &luaFunction{proto: e.proto, scope: scope}
+	case *exprParen:
+		return L.eval(e.expr, scope)
+	case *exprName:
+		if ref := scope.lookup(e.name); ref != nil {
+			return *ref
+		}
+		L.line = e.line
+		return L.getGlobal(e.name)
+	case *exprIndex:
+		obj := L.eval(e.obj, scope)
+		key := L.eval(e.key, scope)
+		L.line = e.line
+		if _, ok := obj.(*luaTable); !ok && L.metatable(obj) == nil {
+			L.errorf("attempt to index %s (a %s value)", luaDescribe(e.obj), luaTypeName(obj))
+		}
+		return L.index(obj, key)
+	case *exprCall, *exprMethodCall:
+		if values := L.evalMulti(e, scope); len(values) > 0 {
+			return values[0]
+		}
+		return nil
+	case *exprTable:
+		t := newLuaTable()
+		pos := 1.0
+		for i, field := range e.fields {
+			if field.key != nil {
+				key := L.eval(field.key, scope)
+				L.line = e.line
+				if key == nil {
+					L.errorf("table index is nil")
+				}
+				t.set(key, L.eval(field.value, scope))
+				continue
+			}
+
+			if i == len(e.fields)-1 {
+				for _, v := range L.evalMulti(field.value, scope) {
+					t.set(pos, v)
+					pos++
+				}
+				continue
+			}
+
+			t.set(pos, L.eval(field.value, scope))
+			pos++
+		}
+		return t
+	case *exprUnary:
+		v := L.eval(e.expr, scope)
+		L.line = e.line
+
+		switch e.op {
+		case "not":
+			return !luaTruthy(v)
+		case "-":
+			n, ok := luaToNumber(v)
+			if !ok {
+				L.errorf("attempt to perform arithmetic on %s (a %s value)", luaDescribe(e.expr), luaTypeName(v))
+			}
+			return -n
+		case "#":
+			switch x := v.(type) {
+			case string:
+				return float64(len(x))
+			case *luaTable:
+				return float64(x.length())
+			}
+			L.errorf("attempt to get length of %s (a %s value)", luaDescribe(e.expr), luaTypeName(v))
+		}
+	case *exprBinary:
+		switch e.op {
+		case "and":
+			left := L.eval(e.left, scope)
+			if !luaTruthy(left) {
+				return left
+			}
+			return L.eval(e.right, scope)
+		case "or":
+			left := L.eval(e.left, scope)
+			if luaTruthy(left) {
+				return left
+			}
+			return L.eval(e.right, scope)
+		}
+
+		left := L.eval(e.left, scope)
+		right := L.eval(e.right, scope)
+		L.line = e.line
+		return L.binary(e, left, right)
+	}
+
+	return nil
+}
+
+func (L *luaState) binary(e *exprBinary, left, right luaValue) luaValue {
+	switch e.op {
+	case "==":
+		return luaRawEqual(left, right)
+	case "~=":
+		return !luaRawEqual(left, right)
+	case "<":
+		return L.less(left, right)
+	case ">":
+		return L.less(right, left)
+	case "<=":
+		return !L.less(right, left)
+	case ">=":
+		return !L.less(left, right)
+	case "..":
+		ls, lok := luaToStringCoerce(left)
+		rs, rok := luaToStringCoerce(right)
+		if !lok {
+			L.errorf("attempt to concatenate %s (a %s value)", luaDescribe(e.left), luaTypeName(left))
+		}
+		if !rok {
+			L.errorf("attempt to concatenate %s (a %s value)", luaDescribe(e.right), luaTypeName(right))
+		}
+		return ls + rs
+	}
+
+	a, ok := luaToNumber(left)
+	if !ok {
+		L.errorf("attempt to perform arithmetic on %s (a %s value)", luaDescribe(e.left), luaTypeName(left))
+	}
+	b, ok := luaToNumber(right)
+	if !ok {
+		L.errorf("attempt to perform arithmetic on %s (a %s value)", luaDescribe(e.right), luaTypeName(right))
+	}
+
+	switch e.op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		return a / b
+	case "%":
+		return a - math.Floor(a/b)*b
+	case "^":
+		return math.Pow(a, b)
+	}
+
+	return nil
+}
+
+func (L *luaState) less(left, right luaValue) bool {
+	switch a := left.(type) {
+	case float64:
+		if b, ok := right.(float64); ok {
+			return a < b
+		}
+	case string:
+		if b, ok := right.(string); ok {
+			return a < b
+		}
+	}
+
+	lt, rt := luaTypeName(left), luaTypeName(right)
+	if lt == rt {
+		L.errorf("attempt to compare two %s values", lt)
+	}
+	L.errorf("attempt to compare %s with %s", lt, rt)
+	return false
+}
+
+// metatable returns the metatable of v: tables have their own, strings
+// share one and other values have none.
+func (L *luaState) metatable(v luaValue) *luaTable {
+	switch x := v.(type) {
+	case *luaTable:
+		return x.meta
+	case string:
+		return L.stringMeta
+	}
+	return nil
+}
+
+// metaField returns the metamethod name of v, or nil.
+func (L *luaState) metaField(v luaValue, name string) luaValue {
+	if meta := L.metatable(v); meta != nil {
+		return meta.get(name)
+	}
+	return nil
+}
+
+// index returns obj[key], falling back to the __index metamethod when the
+// key is absent.
+func (L *luaState) index(obj, key luaValue) luaValue {
+	for loop := 0; loop < luaMaxCallDepth; loop++ {
+		t, ok := obj.(*luaTable)
+		if ok {
+			if v := t.get(key); v != nil {
+				return v
+			}
+		}
+
+		handler := L.metaField(obj, "__index")
+		if handler == nil {
+			if !ok {
+				L.errorf("attempt to index a %s value", luaTypeName(obj))
+			}
+			return nil
+		}
+		if _, isTable := handler.(*luaTable); !isTable {
+			if results := L.call(handler, []luaValue{obj, key}); len(results) > 0 {
+				return results[0]
+			}
+			return nil
+		}
+		obj = handler
+	}
+
+	L.errorf("loop in gettable")
+	return nil
+}
+
+// setIndex assigns obj[key], deferring to the __newindex metamethod when
+// the key is absent.
+func (L *luaState) setIndex(obj, key, value luaValue) {
+	for loop := 0; loop < luaMaxCallDepth; loop++ {
+		var handler luaValue
+		if t, ok := obj.(*luaTable); !ok || t.get(key) == nil {
+			handler = L.metaField(obj, "__newindex")
+		}
+		if handler == nil {
+			L.rawSetIndex(obj, key, value)
+			return
+		}
+		if _, isTable := handler.(*luaTable); !isTable {
+			L.call(handler, []luaValue{obj, key, value})
+			return
+		}
+		obj = handler
+	}
+
+	L.errorf("loop in settable")
+}
+
+// rawSetIndex assigns obj[key] without invoking any metamethod.
+func (L *luaState) rawSetIndex(obj, key, value luaValue) {
+	t, ok := obj.(*luaTable)
+	if !ok {
+		L.errorf("attempt to index a %s value", luaTypeName(obj))
+	}
+	if key == nil {
+		L.errorf("table index is nil")
+	}
+	if n, ok := key.(float64); ok && math.IsNaN(n) {
+		L.errorf("table index is NaN")
+	}
+
+	if t.readonly {
+		if t == L.globals {
+			L.errorf("Attempt to modify a readonly table: Script attempted to create global variable '%s'", luaToString(key))
+		}
+		L.errorf("Attempt to modify a readonly table")
+	}
+
+	t.set(key, value)
+}
+
+func (L *luaState) getGlobal(name string) luaValue {
+	v := L.globals.get(name)
+	if v == nil && L.globals.readonly {
+		L.errorf("Script attempted to access nonexistent global variable '%s'", name)
+	}
+	return v
+}
+
+// Helpers
+
+func luaDescribe(expr luaExpr) string {
+	switch e := expr.(type) {
+	case *exprName:
+		return fmt.Sprintf("'%s'", e.name)
+	case *exprIndex:
+		if key, ok := e.key.(*exprString); ok {
+			return fmt.Sprintf("field '%s'", key.value)
+		}
+	case *exprString:
+		return "a string value"
+	}
+	return "a value"
+}
+
+func luaTruthy(v luaValue) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	}
+	return true
+}
+
+func luaTypeName(v luaValue) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *luaTable:
+		return "table"
+	case *luaFunction, *luaGoFunction:
+		return "function"
+	}
+	return "userdata"
+}
+
+func luaRawEqual(a, b luaValue) bool {
+	return a == b
+}
+
+func luaToNumber(v luaValue) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case string:
+		return luaParseNumber(x)
+	}
+	return 0, false
+}
+
+func luaFormatNumber(n float64) string {
+	if n == math.Trunc(n) && math.Abs(n) < 1e15 {
+		return strconv.FormatInt(int64(n), 10)
+	}
+
+	switch {
+	case math.IsInf(n, 1):
+		return "inf"
+	case math.IsInf(n, -1):
+		return "-inf"
+	case math.IsNaN(n):
+		return "nan"
+	}
+
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+// luaToStringCoerce converts strings and numbers the way concatenation does.
+func luaToStringCoerce(v luaValue) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case float64:
+		return luaFormatNumber(x), true
+	}
+	return "", false
+}
+
+func luaToString(v luaValue) string {
+	switch x := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		if x {
+			return "true"
+		}
+		return "false"
+	case float64:
+		return luaFormatNumber(x)
+	case string:
+		return x
+	case *luaTable:
+		return fmt.Sprintf("table: %p", x)
+	case *luaFunction:
+		return fmt.Sprintf("function: %p", x)
+	case *luaGoFunction:
+		return fmt.Sprintf("function: builtin: %p", x)
+	case *luaUserdata:
+		return fmt.Sprintf("userdata: %p", x)
+	}
+	return fmt.Sprintf("userdata: %v", v)
+}
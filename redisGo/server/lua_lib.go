@@ -0,0 +1,1237 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The subset of the Lua standard library that is available to scripts.
+// Like Redis, nothing that touches the file system or the clock is exposed.
+
+func luaRegister(t *luaTable, name string, fn func(L *luaState, args []luaValue) []luaValue) {
+	t.set(name, &luaGoFunction{name: name, fn: fn})
+}
+
+func luaArg(args []luaValue, i int) luaValue {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+func (L *luaState) argError(i int, fname, msg string) {
+	L.errorf("bad argument #%d to '%s' (%s)", i+1, fname, msg)
+}
+
+func (L *luaState) checkTable(args []luaValue, i int, fname string) *luaTable {
+	t, ok := luaArg(args, i).(*luaTable)
+	if !ok {
+		L.argError(i, fname, fmt.Sprintf("table expected, got %s", luaTypeNameArg(args, i)))
+	}
+	return t
+}
+
+func (L *luaState) checkNumber(args []luaValue, i int, fname string) float64 {
+	n, ok := luaToNumber(luaArg(args, i))
+	if !ok {
+		L.argError(i, fname, fmt.Sprintf("number expected, got %s", luaTypeNameArg(args, i)))
+	}
+	return n
+}
+
+func (L *luaState) checkInt(args []luaValue, i int, fname string) int {
+	return int(L.checkNumber(args, i, fname))
+}
+
+func (L *luaState) optInt(args []luaValue, i int, fname string, def int) int {
+	if luaArg(args, i) == nil {
+		return def
+	}
+	return L.checkInt(args, i, fname)
+}
+
+func (L *luaState) checkString(args []luaValue, i int, fname string) string {
+	s, ok := luaToStringCoerce(luaArg(args, i))
+	if !ok {
+		L.argError(i, fname, fmt.Sprintf("string expected, got %s", luaTypeNameArg(args, i)))
+	}
+	return s
+}
+
+func luaTypeNameArg(args []luaValue, i int) string {
+	if i >= len(args) {
+		return "no value"
+	}
+	return luaTypeName(args[i])
+}
+
+// openLuaLibs installs the base, string, table and math libraries, then
+// the cjson, bit, struct and cmsgpack libraries Redis adds.
+func openLuaLibs(L *luaState) {
+	globals := L.globals
+	luaRegister(globals, "assert", luaAssert)
+	luaRegister(globals, "error", luaErrorFn)
+	luaRegister(globals, "getmetatable", luaGetmetatable)
+	luaRegister(globals, "ipairs", luaIpairs)
+	luaRegister(globals, "next", luaNext)
+	luaRegister(globals, "pairs", luaPairs)
+	luaRegister(globals, "pcall", luaPcall)
+	luaRegister(globals, "rawequal", luaRawequal)
+	luaRegister(globals, "rawget", luaRawget)
+	luaRegister(globals, "rawset", luaRawset)
+	luaRegister(globals, "select", luaSelect)
+	luaRegister(globals, "setmetatable", luaSetmetatable)
+	luaRegister(globals, "tonumber", luaTonumber)
+	luaRegister(globals, "tostring", luaTostring)
+	luaRegister(globals, "type", luaType)
+	luaRegister(globals, "unpack", luaUnpack)
+	luaRegister(globals, "xpcall", luaXpcall)
+	globals.set("_VERSION", "Lua 5.1")
+
+	str := newLuaTable()
+	luaRegister(str, "byte", luaStringByte)
+	luaRegister(str, "char", luaStringChar)
+	luaRegister(str, "find", luaStringFind)
+	luaRegister(str, "format", luaStringFormat)
+	luaRegister(str, "gmatch", luaStringGmatch)
+	luaRegister(str, "gsub", luaStringGsub)
+	luaRegister(str, "len", luaStringLen)
+	luaRegister(str, "lower", luaStringLower)
+	luaRegister(str, "match", luaStringMatch)
+	luaRegister(str, "rep", luaStringRep)
+	luaRegister(str, "reverse", luaStringReverse)
+	luaRegister(str, "sub", luaStringSub)
+	luaRegister(str, "upper", luaStringUpper)
+	str.readonly = true
+	globals.set("string", str)
+
+	L.stringMeta = newLuaTable()
+	L.stringMeta.set("__index", str)
+	L.stringMeta.readonly = true
+
+	tbl := newLuaTable()
+	luaRegister(tbl, "concat", luaTableConcat)
+	luaRegister(tbl, "getn", luaTableGetn)
+	luaRegister(tbl, "insert", luaTableInsert)
+	luaRegister(tbl, "remove", luaTableRemove)
+	luaRegister(tbl, "sort", luaTableSort)
+	tbl.readonly = true
+	globals.set("table", tbl)
+
+	m := newLuaTable()
+	luaRegister(m, "abs", luaMath1("abs", math.Abs))
+	luaRegister(m, "ceil", luaMath1("ceil", math.Ceil))
+	luaRegister(m, "exp", luaMath1("exp", math.Exp))
+	luaRegister(m, "floor", luaMath1("floor", math.Floor))
+	luaRegister(m, "log", luaMath1("log", math.Log))
+	luaRegister(m, "log10", luaMath1("log10", math.Log10))
+	luaRegister(m, "sqrt", luaMath1("sqrt", math.Sqrt))
+	luaRegister(m, "fmod", luaMath2("fmod", math.Mod))
+	luaRegister(m, "pow", luaMath2("pow", math.Pow))
+	luaRegister(m, "max", luaMathMax)
+	luaRegister(m, "min", luaMathMin)
+	luaRegister(m, "modf", luaMathModf)
+	luaRegister(m, "random", luaMathRandom)
+	luaRegister(m, "randomseed", luaMathRandomseed)
+	m.set("huge", math.Inf(1))
+	m.set("pi", math.Pi)
+	m.readonly = true
+	globals.set("math", m)
+
+	// Like Redis, every script starts from the same seed, so that it draws
+	// the same numbers each time it runs.
+	L.rand = rand.New(rand.NewSource(0))
+
+	openLuaCjson(L)
+	openLuaBit(L)
+	openLuaStruct(L)
+	openLuaCmsgpack(L)
+}
+
+// Base library
+
+func luaAssert(L *luaState, args []luaValue) []luaValue {
+	if !luaTruthy(luaArg(args, 0)) {
+		if msg := luaArg(args, 1); msg != nil {
+			panic(&luaError{value: msg})
+		}
+		L.errorf("assertion failed!")
+	}
+	return args
+}
+
+func luaErrorFn(L *luaState, args []luaValue) []luaValue {
+	value := luaArg(args, 0)
+
+	// Like Lua, string messages get the position of the caller prepended
+	// unless level 0 is requested.
+	if s, ok := value.(string); ok && L.optInt(args, 1, "error", 1) > 0 && L.line > 0 {
+		value = fmt.Sprintf("%s:%d: %s", L.chunk, L.line, s)
+	}
+
+	panic(&luaError{value: value})
+}
+
+func luaGetmetatable(L *luaState, args []luaValue) []luaValue {
+	if len(args) == 0 {
+		L.argError(0, "getmetatable", "value expected")
+	}
+
+	meta := L.metatable(args[0])
+	if meta == nil {
+		return []luaValue{nil}
+	}
+	if protected := meta.get("__metatable"); protected != nil {
+		return []luaValue{protected}
+	}
+	return []luaValue{meta}
+}
+
+func luaIpairs(L *luaState, args []luaValue) []luaValue {
+	t := L.checkTable(args, 0, "ipairs")
+
+	iter := &luaGoFunction{name: "ipairs_iterator", fn: func(L *luaState, args []luaValue) []luaValue {
+		i := L.checkNumber(args, 1, "ipairs") + 1
+		v := t.get(i)
+		if v == nil {
+			return []luaValue{nil}
+		}
+		return []luaValue{i, v}
+	}}
+
+	return []luaValue{iter, t, 0.0}
+}
+
+func luaNext(L *luaState, args []luaValue) []luaValue {
+	t := L.checkTable(args, 0, "next")
+
+	k, v, ok := t.next(luaArg(args, 1))
+	if !ok {
+		L.errorf("invalid key to 'next'")
+	}
+	if k == nil {
+		return []luaValue{nil}
+	}
+	return []luaValue{k, v}
+}
+
+func luaPairs(L *luaState, args []luaValue) []luaValue {
+	t := L.checkTable(args, 0, "pairs")
+	return []luaValue{&luaGoFunction{name: "next", fn: luaNext}, t, nil}
+}
+
+func luaPcall(L *luaState, args []luaValue) []luaValue {
+	if len(args) == 0 {
+		L.argError(0, "pcall", "value expected")
+	}
+
+	results, errValue, ok := L.pcall(args[0], args[1:])
+	if !ok {
+		return []luaValue{false, errValue}
+	}
+	return append([]luaValue{true}, results...)
+}
+
+// xpcall calls the message handler with the error value, to return what
+// it returns instead.
+func luaXpcall(L *luaState, args []luaValue) []luaValue {
+	if len(args) < 2 {
+		L.argError(1, "xpcall", "value expected")
+	}
+
+	results, errValue, ok := L.pcall(args[0], nil)
+	if ok {
+		return append([]luaValue{true}, results...)
+	}
+
+	handled, errValue, ok := L.pcall(args[1], []luaValue{errValue})
+	if !ok {
+		return []luaValue{false, errValue}
+	}
+	return []luaValue{false, luaArg(handled, 0)}
+}
+
+func luaRawequal(L *luaState, args []luaValue) []luaValue {
+	return []luaValue{luaRawEqual(luaArg(args, 0), luaArg(args, 1))}
+}
+
+func luaRawget(L *luaState, args []luaValue) []luaValue {
+	t := L.checkTable(args, 0, "rawget")
+	return []luaValue{t.get(luaArg(args, 1))}
+}
+
+func luaRawset(L *luaState, args []luaValue) []luaValue {
+	t := L.checkTable(args, 0, "rawset")
+	L.rawSetIndex(t, luaArg(args, 1), luaArg(args, 2))
+	return []luaValue{t}
+}
+
+func luaSelect(L *luaState, args []luaValue) []luaValue {
+	if s, ok := luaArg(args, 0).(string); ok && s == "#" {
+		return []luaValue{float64(len(args) - 1)}
+	}
+
+	n := L.checkInt(args, 0, "select")
+	if n < 0 {
+		n = len(args) + n
+	} else if n > len(args) {
+		n = len(args)
+	}
+	if n < 1 {
+		L.argError(0, "select", "index out of range")
+	}
+	return args[n:]
+}
+
+func luaSetmetatable(L *luaState, args []luaValue) []luaValue {
+	t := L.checkTable(args, 0, "setmetatable")
+
+	var meta *luaTable
+	switch m := luaArg(args, 1).(type) {
+	case nil:
+	case *luaTable:
+		meta = m
+	default:
+		L.argError(1, "setmetatable", "nil or table expected")
+	}
+
+	if t.meta != nil && t.meta.get("__metatable") != nil {
+		L.errorf("cannot change a protected metatable")
+	}
+	if t.readonly {
+		L.errorf("Attempt to modify a readonly table")
+	}
+
+	t.meta = meta
+	return []luaValue{t}
+}
+
+func luaTonumber(L *luaState, args []luaValue) []luaValue {
+	base := L.optInt(args, 1, "tonumber", 10)
+	v := luaArg(args, 0)
+
+	if base == 10 {
+		if n, ok := luaToNumber(v); ok {
+			return []luaValue{n}
+		}
+		return []luaValue{nil}
+	}
+
+	if base < 2 || base > 36 {
+		L.argError(1, "tonumber", "base out of range")
+	}
+	s := strings.ToLower(strings.TrimSpace(L.checkString(args, 0, "tonumber")))
+	n, err := strconv.ParseInt(s, base, 64)
+	if err != nil {
+		return []luaValue{nil}
+	}
+	return []luaValue{float64(n)}
+}
+
+func luaTostring(L *luaState, args []luaValue) []luaValue {
+	if len(args) == 0 {
+		L.argError(0, "tostring", "value expected")
+	}
+	if handler := L.metaField(args[0], "__tostring"); handler != nil {
+		if results := L.call(handler, args[:1]); len(results) > 0 {
+			return results[:1]
+		}
+		return []luaValue{nil}
+	}
+	return []luaValue{luaToString(args[0])}
+}
+
+func luaType(L *luaState, args []luaValue) []luaValue {
+	if len(args) == 0 {
+		L.argError(0, "type", "value expected")
+	}
+	return []luaValue{luaTypeName(args[0])}
+}
+
+func luaUnpack(L *luaState, args []luaValue) []luaValue {
+	t := L.checkTable(args, 0, "unpack")
+	i := L.optInt(args, 1, "unpack", 1)
+	j := L.optInt(args, 2, "unpack", t.length())
+
+	if j-i >= 8000 {
+		L.errorf("too many results to unpack")
+	}
+
+	values := []luaValue{}
+	for k := i; k <= j; k++ {
+		values = append(values, t.get(float64(k)))
+	}
+	return values
+}
+
+// String library
+
+// luaStringRange converts Lua's 1-based, possibly negative, inclusive
+// indexes into a Go slice range of s.
+func luaStringRange(length, i, j int) (int, int) {
+	if i < 0 {
+		i = length + i + 1
+	}
+	if j < 0 {
+		j = length + j + 1
+	}
+	if i < 1 {
+		i = 1
+	}
+	if j > length {
+		j = length
+	}
+	if i > j {
+		return 0, 0
+	}
+	return i - 1, j
+}
+
+func luaStringByte(L *luaState, args []luaValue) []luaValue {
+	s := L.checkString(args, 0, "byte")
+	i := L.optInt(args, 1, "byte", 1)
+	j := L.optInt(args, 2, "byte", i)
+
+	start, end := luaStringRange(len(s), i, j)
+	values := []luaValue{}
+	for k := start; k < end; k++ {
+		values = append(values, float64(s[k]))
+	}
+	return values
+}
+
+func luaStringChar(L *luaState, args []luaValue) []luaValue {
+	b := make([]byte, len(args))
+	for i := range args {
+		c := L.checkInt(args, i, "char")
+		if c < 0 || c > 255 {
+			L.argError(i, "char", "invalid value")
+		}
+		b[i] = byte(c)
+	}
+	return []luaValue{string(b)}
+}
+
+func luaStringLen(L *luaState, args []luaValue) []luaValue {
+	return []luaValue{float64(len(L.checkString(args, 0, "len")))}
+}
+
+func luaStringLower(L *luaState, args []luaValue) []luaValue {
+	return []luaValue{strings.ToLower(L.checkString(args, 0, "lower"))}
+}
+
+func luaStringUpper(L *luaState, args []luaValue) []luaValue {
+	return []luaValue{strings.ToUpper(L.checkString(args, 0, "upper"))}
+}
+
+func luaStringRep(L *luaState, args []luaValue) []luaValue {
+	s := L.checkString(args, 0, "rep")
+	n := L.checkInt(args, 1, "rep")
+	if n <= 0 {
+		return []luaValue{""}
+	}
+	if len(s)*n > 512*1024*1024 {
+		L.errorf("resulting string too large")
+	}
+	return []luaValue{strings.Repeat(s, n)}
+}
+
+func luaStringReverse(L *luaState, args []luaValue) []luaValue {
+	s := []byte(L.checkString(args, 0, "reverse"))
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+	return []luaValue{string(s)}
+}
+
+func luaStringSub(L *luaState, args []luaValue) []luaValue {
+	s := L.checkString(args, 0, "sub")
+	start, end := luaStringRange(len(s), L.optInt(args, 1, "sub", 1), L.optInt(args, 2, "sub", -1))
+	return []luaValue{s[start:end]}
+}
+
+func luaStringFind(L *luaState, args []luaValue) []luaValue {
+	return luaStrFind(L, args, "find", true)
+}
+
+func luaStringMatch(L *luaState, args []luaValue) []luaValue {
+	return luaStrFind(L, args, "match", false)
+}
+
+func luaStrFind(L *luaState, args []luaValue, fname string, find bool) []luaValue {
+	s := L.checkString(args, 0, fname)
+	pattern := L.checkString(args, 1, fname)
+	init := L.optInt(args, 2, fname, 1)
+
+	if init < 0 {
+		init = len(s) + init + 1
+		if init < 1 {
+			init = 1
+		}
+	} else if init == 0 {
+		init = 1
+	}
+	if init > len(s)+1 {
+		return []luaValue{nil}
+	}
+
+	plain := luaTruthy(luaArg(args, 3))
+	if find && (plain || !strings.ContainsAny(pattern, "^$*+?.([%-")) {
+		i := strings.Index(s[init-1:], pattern)
+		if i < 0 {
+			return []luaValue{nil}
+		}
+		return []luaValue{float64(init + i), float64(init + i + len(pattern) - 1)}
+	}
+
+	anchor := strings.HasPrefix(pattern, "^")
+	if anchor {
+		pattern = pattern[1:]
+	}
+
+	for pos := init - 1; pos <= len(s); pos++ {
+		ms := &luaMatchState{L: L, src: s, pattern: pattern}
+		if end := ms.match(pos, 0); end >= 0 {
+			if find {
+				return append([]luaValue{float64(pos + 1), float64(end)}, ms.captureValues(pos, end, false)...)
+			}
+			return ms.captureValues(pos, end, true)
+		}
+		if anchor {
+			break
+		}
+	}
+
+	return []luaValue{nil}
+}
+
+func luaStringGmatch(L *luaState, args []luaValue) []luaValue {
+	s := L.checkString(args, 0, "gmatch")
+	pattern := L.checkString(args, 1, "gmatch")
+	pos := 0
+
+	iter := &luaGoFunction{name: "gmatch_iterator", fn: func(L *luaState, _ []luaValue) []luaValue {
+		for ; pos <= len(s); pos++ {
+			ms := &luaMatchState{L: L, src: s, pattern: pattern}
+			if end := ms.match(pos, 0); end >= 0 {
+				start := pos
+				if end == pos {
+					pos++
+				} else {
+					pos = end
+				}
+				return ms.captureValues(start, end, true)
+			}
+		}
+		return []luaValue{nil}
+	}}
+
+	return []luaValue{iter}
+}
+
+func luaStringGsub(L *luaState, args []luaValue) []luaValue {
+	s := L.checkString(args, 0, "gsub")
+	pattern := L.checkString(args, 1, "gsub")
+	repl := luaArg(args, 2)
+	maxN := L.optInt(args, 3, "gsub", len(s)+1)
+
+	switch repl.(type) {
+	case string, float64, *luaTable, *luaFunction, *luaGoFunction:
+	default:
+		L.argError(2, "gsub", "string/function/table expected")
+	}
+
+	anchor := strings.HasPrefix(pattern, "^")
+	if anchor {
+		pattern = pattern[1:]
+	}
+
+	var sb strings.Builder
+	pos, n := 0, 0
+	for n < maxN {
+		ms := &luaMatchState{L: L, src: s, pattern: pattern}
+		end := ms.match(pos, 0)
+		if end >= 0 {
+			n++
+			sb.WriteString(luaGsubValue(L, ms, repl, pos, end))
+		}
+
+		switch {
+		case end >= 0 && end > pos:
+			pos = end
+		case pos < len(s):
+			sb.WriteByte(s[pos])
+			pos++
+		default:
+			pos = len(s) + 1
+		}
+
+		if pos > len(s) || anchor {
+			break
+		}
+	}
+	if pos < len(s) {
+		sb.WriteString(s[pos:])
+	}
+
+	return []luaValue{sb.String(), float64(n)}
+}
+
+func luaGsubValue(L *luaState, ms *luaMatchState, repl luaValue, start, end int) string {
+	whole := ms.src[start:end]
+
+	var value luaValue
+	switch r := repl.(type) {
+	case string, float64:
+		rs, _ := luaToStringCoerce(r)
+
+		var sb strings.Builder
+		for i := 0; i < len(rs); i++ {
+			if rs[i] != '%' || i+1 >= len(rs) {
+				sb.WriteByte(rs[i])
+				continue
+			}
+
+			i++
+			switch c := rs[i]; {
+			case c == '0':
+				sb.WriteString(whole)
+			case c >= '1' && c <= '9':
+				v := ms.captureValue(int(c-'1'), start, end)
+				sv, _ := luaToStringCoerce(v)
+				sb.WriteString(sv)
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		return sb.String()
+	case *luaTable:
+		value = r.get(ms.captureValues(start, end, true)[0])
+	default:
+		results := L.call(r, ms.captureValues(start, end, true))
+		if len(results) > 0 {
+			value = results[0]
+		}
+	}
+
+	if !luaTruthy(value) {
+		return whole
+	}
+	s, ok := luaToStringCoerce(value)
+	if !ok {
+		L.errorf("invalid replacement value (a %s)", luaTypeName(value))
+	}
+	return s
+}
+
+func luaStringFormat(L *luaState, args []luaValue) []luaValue {
+	format := L.checkString(args, 0, "format")
+	arg := 1
+
+	var sb strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			sb.WriteByte(format[i])
+			continue
+		}
+
+		i++
+		if i >= len(format) {
+			L.errorf("invalid option '%%' to 'format'")
+		}
+		if format[i] == '%' {
+			sb.WriteByte('%')
+			continue
+		}
+
+		// Flags, width and precision are passed through to fmt unchanged.
+		start := i
+		for i < len(format) && strings.IndexByte("-+ #0", format[i]) >= 0 {
+			i++
+		}
+		for i < len(format) && (isLuaDigit(format[i]) || format[i] == '.') {
+			i++
+		}
+		if i >= len(format) {
+			L.errorf("invalid option to 'format'")
+		}
+		spec := "%" + format[start:i]
+
+		if arg >= len(args) {
+			L.argError(arg, "format", "no value")
+		}
+
+		switch verb := format[i]; verb {
+		case 'd', 'i':
+			fmt.Fprintf(&sb, spec+"d", int64(L.checkNumber(args, arg, "format")))
+		case 'c':
+			sb.WriteByte(byte(L.checkInt(args, arg, "format")))
+		case 'o', 'x', 'X':
+			fmt.Fprintf(&sb, spec+string(verb), int64(L.checkNumber(args, arg, "format")))
+		case 'e', 'E', 'f', 'g', 'G':
+			fmt.Fprintf(&sb, spec+string(verb), L.checkNumber(args, arg, "format"))
+		case 's':
+			fmt.Fprintf(&sb, spec+"s", luaToString(args[arg]))
+		case 'q':
+			sb.WriteString(luaQuote(L.checkString(args, arg, "format")))
+		default:
+			L.errorf("invalid option '%%%c' to 'format'", verb)
+		}
+		arg++
+	}
+
+	return []luaValue{sb.String()}
+}
+
+func luaQuote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\', '\n':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\r':
+			sb.WriteString("\\r")
+		case 0:
+			sb.WriteString("\\000")
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// Table library
+
+func luaTableConcat(L *luaState, args []luaValue) []luaValue {
+	t := L.checkTable(args, 0, "concat")
+	sep := ""
+	if luaArg(args, 1) != nil {
+		sep = L.checkString(args, 1, "concat")
+	}
+	i := L.optInt(args, 2, "concat", 1)
+	j := L.optInt(args, 3, "concat", t.length())
+
+	parts := []string{}
+	for k := i; k <= j; k++ {
+		s, ok := luaToStringCoerce(t.get(float64(k)))
+		if !ok {
+			L.errorf("invalid value (at index %d) in table for 'concat'", k)
+		}
+		parts = append(parts, s)
+	}
+	return []luaValue{strings.Join(parts, sep)}
+}
+
+func luaTableGetn(L *luaState, args []luaValue) []luaValue {
+	return []luaValue{float64(L.checkTable(args, 0, "getn").length())}
+}
+
+func luaTableInsert(L *luaState, args []luaValue) []luaValue {
+	t := L.checkTable(args, 0, "insert")
+	n := t.length()
+
+	switch len(args) {
+	case 2:
+		L.rawSetIndex(t, float64(n+1), args[1])
+	case 3:
+		pos := L.checkInt(args, 1, "insert")
+		for k := n; k >= pos; k-- {
+			L.rawSetIndex(t, float64(k+1), t.get(float64(k)))
+		}
+		L.rawSetIndex(t, float64(pos), args[2])
+	default:
+		L.errorf("wrong number of arguments to 'insert'")
+	}
+	return nil
+}
+
+func luaTableRemove(L *luaState, args []luaValue) []luaValue {
+	t := L.checkTable(args, 0, "remove")
+	n := t.length()
+	if n == 0 {
+		return nil
+	}
+
+	pos := L.optInt(args, 1, "remove", n)
+	value := t.get(float64(pos))
+	for k := pos; k < n; k++ {
+		L.rawSetIndex(t, float64(k), t.get(float64(k+1)))
+	}
+	L.rawSetIndex(t, float64(n), nil)
+
+	return []luaValue{value}
+}
+
+func luaTableSort(L *luaState, args []luaValue) []luaValue {
+	t := L.checkTable(args, 0, "sort")
+	cmp := luaArg(args, 1)
+	if t.readonly {
+		L.errorf("Attempt to modify a readonly table")
+	}
+
+	values := make([]luaValue, t.length())
+	for i := range values {
+		values[i] = t.get(float64(i + 1))
+	}
+
+	sort.SliceStable(values, func(i, j int) bool {
+		if cmp != nil {
+			results := L.call(cmp, []luaValue{values[i], values[j]})
+			return len(results) > 0 && luaTruthy(results[0])
+		}
+		return L.less(values[i], values[j])
+	})
+
+	for i, v := range values {
+		t.set(float64(i+1), v)
+	}
+	return nil
+}
+
+// Math library
+
+func luaMath1(name string, fn func(float64) float64) func(*luaState, []luaValue) []luaValue {
+	return func(L *luaState, args []luaValue) []luaValue {
+		return []luaValue{fn(L.checkNumber(args, 0, name))}
+	}
+}
+
+func luaMath2(name string, fn func(float64, float64) float64) func(*luaState, []luaValue) []luaValue {
+	return func(L *luaState, args []luaValue) []luaValue {
+		return []luaValue{fn(L.checkNumber(args, 0, name), L.checkNumber(args, 1, name))}
+	}
+}
+
+func luaMathMax(L *luaState, args []luaValue) []luaValue {
+	best := L.checkNumber(args, 0, "max")
+	for i := 1; i < len(args); i++ {
+		best = math.Max(best, L.checkNumber(args, i, "max"))
+	}
+	return []luaValue{best}
+}
+
+func luaMathMin(L *luaState, args []luaValue) []luaValue {
+	best := L.checkNumber(args, 0, "min")
+	for i := 1; i < len(args); i++ {
+		best = math.Min(best, L.checkNumber(args, i, "min"))
+	}
+	return []luaValue{best}
+}
+
+func luaMathModf(L *luaState, args []luaValue) []luaValue {
+	integer, frac := math.Modf(L.checkNumber(args, 0, "modf"))
+	return []luaValue{integer, frac}
+}
+
+// random draws as Lua 5.1 does: a number in [0, 1) without arguments,
+// and an integer in [1, m] or [m, n] otherwise.
+func luaMathRandom(L *luaState, args []luaValue) []luaValue {
+	r := L.rand.Float64()
+
+	switch len(args) {
+	case 0:
+		return []luaValue{r}
+	case 1:
+		m := L.checkNumber(args, 0, "random")
+		if m < 1 {
+			L.argError(0, "random", "interval is empty")
+		}
+		return []luaValue{math.Floor(r*m) + 1}
+	case 2:
+		m := L.checkNumber(args, 0, "random")
+		n := L.checkNumber(args, 1, "random")
+		if m > n {
+			L.argError(1, "random", "interval is empty")
+		}
+		return []luaValue{math.Floor(r*(n-m+1)) + m}
+	}
+
+	L.errorf("wrong number of arguments")
+	return nil
+}
+
+func luaMathRandomseed(L *luaState, args []luaValue) []luaValue {
+	L.rand.Seed(int64(L.checkNumber(args, 0, "randomseed")))
+	return nil
+}
+
+// Patterns, ported from the matcher in Lua's lstrlib.c.
+
+const luaMaxCaptures = 32
+
+const (
+	luaCapUnfinished = -1
+	luaCapPosition   = -2
+)
+
+type luaMatchState struct {
+	L        *luaState
+	src      string
+	pattern  string
+	level    int
+	captures [luaMaxCaptures]struct{ start, length int }
+	depth    int
+}
+
+func (ms *luaMatchState) captureValue(i, start, end int) luaValue {
+	if i >= ms.level {
+		if i == 0 {
+			return ms.src[start:end]
+		}
+		ms.L.errorf("invalid capture index")
+	}
+
+	c := ms.captures[i]
+	if c.length == luaCapUnfinished {
+		ms.L.errorf("unfinished capture")
+	}
+	if c.length == luaCapPosition {
+		return float64(c.start + 1)
+	}
+	return ms.src[c.start : c.start+c.length]
+}
+
+// captureValues returns the captures of a match, or the whole match when
+// the pattern has none and wholeIfNone is set.
+func (ms *luaMatchState) captureValues(start, end int, wholeIfNone bool) []luaValue {
+	if ms.level == 0 {
+		if wholeIfNone {
+			return []luaValue{ms.src[start:end]}
+		}
+		return nil
+	}
+
+	values := make([]luaValue, ms.level)
+	for i := range values {
+		values[i] = ms.captureValue(i, start, end)
+	}
+	return values
+}
+
+func (ms *luaMatchState) classEnd(p int) int {
+	if p >= len(ms.pattern) {
+		ms.L.errorf("malformed pattern (ends with '%%')")
+	}
+
+	c := ms.pattern[p]
+	p++
+
+	if c == '%' {
+		if p >= len(ms.pattern) {
+			ms.L.errorf("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	}
+
+	if c == '[' {
+		if p < len(ms.pattern) && ms.pattern[p] == '^' {
+			p++
+		}
+		for {
+			if p >= len(ms.pattern) {
+				ms.L.errorf("malformed pattern (missing ']')")
+			}
+			c := ms.pattern[p]
+			p++
+			if c == '%' {
+				p++
+			}
+			if p < len(ms.pattern) && ms.pattern[p] == ']' {
+				return p + 1
+			}
+			if p >= len(ms.pattern) {
+				ms.L.errorf("malformed pattern (missing ']')")
+			}
+		}
+	}
+
+	return p
+}
+
+func luaMatchClass(c byte, class byte) bool {
+	var res bool
+
+	lower := class | 0x20
+	switch lower {
+	case 'a':
+		res = (c|0x20) >= 'a' && (c|0x20) <= 'z'
+	case 'c':
+		res = c < 32 || c == 127
+	case 'd':
+		res = c >= '0' && c <= '9'
+	case 'l':
+		res = c >= 'a' && c <= 'z'
+	case 'p':
+		res = (c >= 33 && c <= 47) || (c >= 58 && c <= 64) || (c >= 91 && c <= 96) || (c >= 123 && c <= 126)
+	case 's':
+		res = c == ' ' || (c >= '\t' && c <= '\r')
+	case 'u':
+		res = c >= 'A' && c <= 'Z'
+	case 'w':
+		res = (c >= '0' && c <= '9') || ((c|0x20) >= 'a' && (c|0x20) <= 'z')
+	case 'x':
+		res = (c >= '0' && c <= '9') || ((c|0x20) >= 'a' && (c|0x20) <= 'f')
+	default:
+		return class == c
+	}
+
+	if class >= 'A' && class <= 'Z' {
+		return !res
+	}
+	return res
+}
+
+// matchBracketClass matches c against the set [..] between p and ec-1.
+func (ms *luaMatchState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	p++
+	if ms.pattern[p] == '^' {
+		sig = false
+		p++
+	}
+
+	for ; p < ec; p++ {
+		switch {
+		case ms.pattern[p] == '%' && p+1 < ec:
+			p++
+			if luaMatchClass(c, ms.pattern[p]) {
+				return sig
+			}
+		case p+2 < ec && ms.pattern[p+1] == '-':
+			if ms.pattern[p] <= c && c <= ms.pattern[p+2] {
+				return sig
+			}
+			p += 2
+		case ms.pattern[p] == c:
+			return sig
+		}
+	}
+
+	return !sig
+}
+
+func (ms *luaMatchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+
+	c := ms.src[s]
+	switch ms.pattern[p] {
+	case '.':
+		return true
+	case '%':
+		return luaMatchClass(c, ms.pattern[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	}
+	return ms.pattern[p] == c
+}
+
+// match returns the end of the match of the pattern from p against the
+// source from s, or -1.
+func (ms *luaMatchState) match(s, p int) int {
+	ms.depth++
+	defer func() { ms.depth-- }()
+	if ms.depth > 200 {
+		ms.L.errorf("pattern too complex")
+	}
+
+	for {
+		if p >= len(ms.pattern) {
+			return s
+		}
+
+		switch ms.pattern[p] {
+		case '(':
+			if p+1 < len(ms.pattern) && ms.pattern[p+1] == ')' {
+				return ms.startCapture(s, p+2, luaCapPosition)
+			}
+			return ms.startCapture(s, p+1, luaCapUnfinished)
+		case ')':
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pattern) {
+				if s == len(ms.src) {
+					return s
+				}
+				return -1
+			}
+		case '%':
+			if p+1 < len(ms.pattern) {
+				switch next := ms.pattern[p+1]; {
+				case next == 'b':
+					s = ms.matchBalance(s, p+2)
+					if s < 0 {
+						return -1
+					}
+					p += 4
+					continue
+				case next == 'f':
+					p += 2
+					if p >= len(ms.pattern) || ms.pattern[p] != '[' {
+						ms.L.errorf("missing '[' after '%%f' in pattern")
+					}
+					ep := ms.classEnd(p)
+					var prev, cur byte
+					if s > 0 {
+						prev = ms.src[s-1]
+					}
+					if s < len(ms.src) {
+						cur = ms.src[s]
+					}
+					if !ms.matchBracketClass(prev, p, ep-1) && ms.matchBracketClass(cur, p, ep-1) {
+						p = ep
+						continue
+					}
+					return -1
+				case next >= '0' && next <= '9':
+					s = ms.matchCapture(s, next)
+					if s < 0 {
+						return -1
+					}
+					p += 2
+					continue
+				}
+			}
+		}
+
+		ep := ms.classEnd(p)
+		matched := ms.singleMatch(s, p, ep)
+
+		var quantifier byte
+		if ep < len(ms.pattern) {
+			quantifier = ms.pattern[ep]
+		}
+
+		switch quantifier {
+		case '?':
+			if matched {
+				if res := ms.match(s+1, ep+1); res >= 0 {
+					return res
+				}
+			}
+			p = ep + 1
+			continue
+		case '*':
+			return ms.maxExpand(s, p, ep)
+		case '+':
+			if !matched {
+				return -1
+			}
+			return ms.maxExpand(s+1, p, ep)
+		case '-':
+			return ms.minExpand(s, p, ep)
+		}
+
+		if !matched {
+			return -1
+		}
+		s++
+		p = ep
+	}
+}
+
+func (ms *luaMatchState) maxExpand(s, p, ep int) int {
+	i := 0
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- {
+		if res := ms.match(s+i, ep+1); res >= 0 {
+			return res
+		}
+	}
+	return -1
+}
+
+func (ms *luaMatchState) minExpand(s, p, ep int) int {
+	for {
+		if res := ms.match(s, ep+1); res >= 0 {
+			return res
+		}
+		if !ms.singleMatch(s, p, ep) {
+			return -1
+		}
+		s++
+	}
+}
+
+func (ms *luaMatchState) startCapture(s, p, what int) int {
+	if ms.level >= luaMaxCaptures {
+		ms.L.errorf("too many captures")
+	}
+
+	ms.captures[ms.level].start = s
+	ms.captures[ms.level].length = what
+	ms.level++
+
+	res := ms.match(s, p)
+	if res < 0 {
+		ms.level--
+	}
+	return res
+}
+
+func (ms *luaMatchState) endCapture(s, p int) int {
+	l := -1
+	for i := ms.level - 1; i >= 0; i-- {
+		if ms.captures[i].length == luaCapUnfinished {
+			l = i
+			break
+		}
+	}
+	if l < 0 {
+		ms.L.errorf("invalid pattern capture")
+	}
+
+	ms.captures[l].length = s - ms.captures[l].start
+	res := ms.match(s, p)
+	if res < 0 {
+		ms.captures[l].length = luaCapUnfinished
+	}
+	return res
+}
+
+func (ms *luaMatchState) matchBalance(s, p int) int {
+	if p+1 >= len(ms.pattern) {
+		ms.L.errorf("unbalanced pattern")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pattern[p] {
+		return -1
+	}
+
+	open, close := ms.pattern[p], ms.pattern[p+1]
+	count := 1
+	for i := s + 1; i < len(ms.src); i++ {
+		switch ms.src[i] {
+		case close:
+			count--
+			if count == 0 {
+				return i + 1
+			}
+		case open:
+			count++
+		}
+	}
+	return -1
+}
+
+func (ms *luaMatchState) matchCapture(s int, c byte) int {
+	l := int(c - '1')
+	if l < 0 || l >= ms.level || ms.captures[l].length == luaCapUnfinished {
+		ms.L.errorf("invalid capture index")
+	}
+
+	capture := ms.src[ms.captures[l].start : ms.captures[l].start+ms.captures[l].length]
+	if strings.HasPrefix(ms.src[s:], capture) {
+		return s + len(capture)
+	}
+	return -1
+}
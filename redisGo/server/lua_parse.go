@@ -0,0 +1,980 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// This file holds the lexer and parser for the Lua 5.1 dialect understood by
+// the scripting engine. Source is turned into the small AST below, which
+// lua_eval.go walks directly.
+
+type luaExpr interface{}
+type luaStmt interface{}
+
+type luaBlock []luaStmt
+
+type luaProto struct {
+	name     string
+	params   []string
+	isVararg bool
+	body     luaBlock
+	line     int
+}
+
+type (
+	exprNil    struct{}
+	exprTrue   struct{}
+	exprFalse  struct{}
+	exprVararg struct{ line int }
+	exprNumber struct{ value float64 }
+	exprString struct{ value string }
+
+	exprFunction struct{ proto *luaProto }
+
+	exprTable struct {
+		fields []luaTableField
+		line   int
+	}
+
+	exprBinary struct {
+		op          string
+		left, right luaExpr
+		line        int
+	}
+
+	exprUnary struct {
+		op   string
+		expr luaExpr
+		line int
+	}
+
+	exprName struct {
+		name string
+		line int
+	}
+
+	exprIndex struct {
+		obj, key luaExpr
+		line     int
+	}
+
+	exprCall struct {
+		fn   luaExpr
+		args []luaExpr
+		line int
+	}
+
+	exprMethodCall struct {
+		obj  luaExpr
+		name string
+		args []luaExpr
+		line int
+	}
+
+	exprParen struct{ expr luaExpr }
+)
+
+type luaTableField struct {
+	key   luaExpr // nil for positional fields
+	value luaExpr
+}
+
+type (
+	stmtLocal struct {
+		names []string
+		exprs []luaExpr
+	}
+
+	stmtAssign struct {
+		targets []luaExpr
+		exprs   []luaExpr
+		line    int
+	}
+
+	stmtCall struct{ call luaExpr }
+
+	stmtDo struct{ body luaBlock }
+
+	stmtWhile struct {
+		cond luaExpr
+		body luaBlock
+	}
+
+	stmtRepeat struct {
+		body luaBlock
+		cond luaExpr
+	}
+
+	stmtIf struct {
+		conds     []luaExpr
+		blocks    []luaBlock
+		elseBlock luaBlock
+	}
+
+	stmtNumericFor struct {
+		name               string
+		start, limit, step luaExpr
+		body               luaBlock
+		line               int
+	}
+
+	stmtGenericFor struct {
+		names []string
+		exprs []luaExpr
+		body  luaBlock
+		line  int
+	}
+
+	stmtFunction struct {
+		target luaExpr
+		proto  *luaProto
+		line   int
+	}
+
+	stmtLocalFunction struct {
+		name  string
+		proto *luaProto
+	}
+
+	stmtReturn struct{ exprs []luaExpr }
+
+	stmtBreak struct{}
+)
+
+// Lexer
+
+const (
+	tokEOF = iota
+	tokName
+	tokNumber
+	tokString
+	tokKeyword
+	tokOp
+)
+
+type luaToken struct {
+	kind   int
+	text   string
+	number float64
+	line   int
+}
+
+var luaKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "function": true, "if": true,
+	"in": true, "local": true, "nil": true, "not": true, "or": true,
+	"repeat": true, "return": true, "then": true, "true": true, "until": true,
+	"while": true,
+}
+
+type luaSyntaxError struct {
+	chunk string
+	line  int
+	msg   string
+}
+
+func (e *luaSyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.chunk, e.line, e.msg)
+}
+
+type luaLexer struct {
+	src   string
+	pos   int
+	line  int
+	chunk string
+}
+
+func (lx *luaLexer) errorf(format string, args ...interface{}) {
+	panic(&luaSyntaxError{chunk: lx.chunk, line: lx.line, msg: fmt.Sprintf(format, args...)})
+}
+
+func (lx *luaLexer) peekByte(offset int) byte {
+	if lx.pos+offset < len(lx.src) {
+		return lx.src[lx.pos+offset]
+	}
+	return 0
+}
+
+func isLuaNameStart(b byte) bool {
+	return b == '_' || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func isLuaDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// longBracketLevel returns the level of a long bracket starting at the
+// current position ("[[" is 0, "[==[" is 2) or -1 if there is none.
+func (lx *luaLexer) longBracketLevel() int {
+	if lx.peekByte(0) != '[' {
+		return -1
+	}
+
+	level := 0
+	for lx.peekByte(1+level) == '=' {
+		level++
+	}
+	if lx.peekByte(1+level) != '[' {
+		return -1
+	}
+
+	return level
+}
+
+func (lx *luaLexer) readLongString(level int) string {
+	lx.pos += level + 2
+
+	// A newline directly after the opening bracket is skipped.
+	if lx.peekByte(0) == '\r' {
+		lx.pos++
+	}
+	if lx.peekByte(0) == '\n' {
+		lx.pos++
+		lx.line++
+	}
+
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(lx.src[lx.pos:], closing)
+	if end < 0 {
+		lx.errorf("unfinished long string")
+	}
+
+	s := lx.src[lx.pos : lx.pos+end]
+	lx.line += strings.Count(s, "\n")
+	lx.pos += end + len(closing)
+
+	return s
+}
+
+func (lx *luaLexer) skipSpaceAndComments() {
+	for lx.pos < len(lx.src) {
+		b := lx.src[lx.pos]
+
+		switch {
+		case b == '\n':
+			lx.line++
+			lx.pos++
+		case b == ' ' || b == '\t' || b == '\r' || b == '\f' || b == '\v':
+			lx.pos++
+		case b == '-' && lx.peekByte(1) == '-':
+			lx.pos += 2
+			if level := lx.longBracketLevel(); level >= 0 {
+				lx.readLongString(level)
+				continue
+			}
+			for lx.pos < len(lx.src) && lx.src[lx.pos] != '\n' {
+				lx.pos++
+			}
+		case b == '#' && lx.pos == 0 && lx.peekByte(1) == '!':
+			for lx.pos < len(lx.src) && lx.src[lx.pos] != '\n' {
+				lx.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (lx *luaLexer) next() luaToken {
+	lx.skipSpaceAndComments()
+
+	if lx.pos >= len(lx.src) {
+		return luaToken{kind: tokEOF, text: "<eof>", line: lx.line}
+	}
+
+	line := lx.line
+	b := lx.src[lx.pos]
+
+	switch {
+	case isLuaNameStart(b):
+		start := lx.pos
+		for lx.pos < len(lx.src) && (isLuaNameStart(lx.src[lx.pos]) || isLuaDigit(lx.src[lx.pos])) {
+			lx.pos++
+		}
+
+		name := lx.src[start:lx.pos]
+		if luaKeywords[name] {
+			return luaToken{kind: tokKeyword, text: name, line: line}
+		}
+		return luaToken{kind: tokName, text: name, line: line}
+	case isLuaDigit(b) || (b == '.' && isLuaDigit(lx.peekByte(1))):
+		return lx.readNumber()
+	case b == '"' || b == '\'':
+		return luaToken{kind: tokString, text: lx.readString(b), line: line}
+	case b == '[':
+		if level := lx.longBracketLevel(); level >= 0 {
+			return luaToken{kind: tokString, text: lx.readLongString(level), line: line}
+		}
+	}
+
+	for _, op := range []string{"...", "..", "==", "~=", "<=", ">="} {
+		if strings.HasPrefix(lx.src[lx.pos:], op) {
+			lx.pos += len(op)
+			return luaToken{kind: tokOp, text: op, line: line}
+		}
+	}
+
+	if strings.IndexByte("+-*/%^#<>=(){}[];:,.", b) >= 0 {
+		lx.pos++
+		return luaToken{kind: tokOp, text: string(b), line: line}
+	}
+
+	lx.errorf("unexpected symbol near '%c'", b)
+	return luaToken{}
+}
+
+func (lx *luaLexer) readNumber() luaToken {
+	start := lx.pos
+
+	if lx.src[lx.pos] == '0' && (lx.peekByte(1) == 'x' || lx.peekByte(1) == 'X') {
+		lx.pos += 2
+		for lx.pos < len(lx.src) && strings.IndexByte("0123456789abcdefABCDEF", lx.src[lx.pos]) >= 0 {
+			lx.pos++
+		}
+	} else {
+		for lx.pos < len(lx.src) {
+			b := lx.src[lx.pos]
+			if isLuaDigit(b) || b == '.' {
+				lx.pos++
+			} else if (b == 'e' || b == 'E') && lx.pos+1 < len(lx.src) {
+				lx.pos++
+				if lx.src[lx.pos] == '+' || lx.src[lx.pos] == '-' {
+					lx.pos++
+				}
+			} else {
+				break
+			}
+		}
+	}
+
+	// Numbers may not run straight into names, as in "3x".
+	for lx.pos < len(lx.src) && (isLuaNameStart(lx.src[lx.pos]) || isLuaDigit(lx.src[lx.pos])) {
+		lx.pos++
+	}
+
+	text := lx.src[start:lx.pos]
+	n, ok := luaParseNumber(text)
+	if !ok {
+		lx.errorf("malformed number near '%s'", text)
+	}
+
+	return luaToken{kind: tokNumber, text: text, number: n, line: lx.line}
+}
+
+func (lx *luaLexer) readString(quote byte) string {
+	lx.pos++
+
+	var sb strings.Builder
+	for {
+		if lx.pos >= len(lx.src) {
+			lx.errorf("unfinished string")
+		}
+
+		b := lx.src[lx.pos]
+		switch b {
+		case quote:
+			lx.pos++
+			return sb.String()
+		case '\n':
+			lx.errorf("unfinished string")
+		case '\\':
+			lx.pos++
+			if lx.pos >= len(lx.src) {
+				lx.errorf("unfinished string")
+			}
+
+			e := lx.src[lx.pos]
+			switch e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case 'a':
+				sb.WriteByte('\a')
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'v':
+				sb.WriteByte('\v')
+			case '\n':
+				lx.line++
+				sb.WriteByte('\n')
+			case 'x':
+				if lx.pos+2 >= len(lx.src) {
+					lx.errorf("hexadecimal digit expected")
+				}
+				n, err := strconv.ParseUint(lx.src[lx.pos+1:lx.pos+3], 16, 8)
+				if err != nil {
+					lx.errorf("hexadecimal digit expected")
+				}
+				sb.WriteByte(byte(n))
+				lx.pos += 2
+			default:
+				if isLuaDigit(e) {
+					n := 0
+					for i := 0; i < 3 && lx.pos < len(lx.src) && isLuaDigit(lx.src[lx.pos]); i++ {
+						n = n*10 + int(lx.src[lx.pos]-'0')
+						lx.pos++
+					}
+					if n > 255 {
+						lx.errorf("escape sequence too large")
+					}
+					sb.WriteByte(byte(n))
+					continue
+				}
+				sb.WriteByte(e)
+			}
+			lx.pos++
+		default:
+			sb.WriteByte(b)
+			lx.pos++
+		}
+	}
+}
+
+// luaParseNumber converts a Lua numeric literal or string into a number.
+func luaParseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+
+	neg := false
+	body := s
+	if body[0] == '-' || body[0] == '+' {
+		neg = body[0] == '-'
+		body = body[1:]
+	}
+
+	if strings.HasPrefix(body, "0x") || strings.HasPrefix(body, "0X") {
+		n, err := strconv.ParseUint(body[2:], 16, 64)
+		if err != nil {
+			return 0, false
+		}
+		if neg {
+			return -float64(n), true
+		}
+		return float64(n), true
+	}
+
+	// Go accepts forms Lua does not, such as "Inf", "NaN" and underscores.
+	for i := 0; i < len(body); i++ {
+		if strings.IndexByte("0123456789.eE+-", body[i]) < 0 {
+			return 0, false
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); !ok || ne.Err != strconv.ErrRange {
+			return 0, false
+		}
+	}
+
+	return n, true
+}
+
+// Parser
+
+type luaParser struct {
+	lx   *luaLexer
+	tok  luaToken
+	peek *luaToken
+}
+
+// luaCompile parses source into the prototype of its main chunk.
+func luaCompile(chunk, source string) (proto *luaProto, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(*luaSyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = se
+		}
+	}()
+
+	p := &luaParser{lx: &luaLexer{src: source, line: 1, chunk: chunk}}
+	p.advance()
+
+	body := p.block()
+	if p.tok.kind != tokEOF {
+		p.errorf("'<eof>' expected near '%s'", p.tok.text)
+	}
+
+	return &luaProto{name: "main chunk", isVararg: true, body: body, line: 0}, nil
+}
+
+func (p *luaParser) errorf(format string, args ...interface{}) {
+	panic(&luaSyntaxError{chunk: p.lx.chunk, line: p.tok.line, msg: fmt.Sprintf(format, args...)})
+}
+
+func (p *luaParser) advance() {
+	if p.peek != nil {
+		p.tok = *p.peek
+		p.peek = nil
+		return
+	}
+	p.tok = p.lx.next()
+}
+
+func (p *luaParser) lookahead() luaToken {
+	if p.peek == nil {
+		t := p.lx.next()
+		p.peek = &t
+	}
+	return *p.peek
+}
+
+func (p *luaParser) is(text string) bool {
+	return (p.tok.kind == tokOp || p.tok.kind == tokKeyword) && p.tok.text == text
+}
+
+func (p *luaParser) accept(text string) bool {
+	if p.is(text) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *luaParser) expect(text string) {
+	if !p.accept(text) {
+		p.errorf("'%s' expected near '%s'", text, p.tok.text)
+	}
+}
+
+func (p *luaParser) expectMatch(text, opener string, line int) {
+	if p.accept(text) {
+		return
+	}
+	if line == p.tok.line {
+		p.errorf("'%s' expected near '%s'", text, p.tok.text)
+	}
+	p.errorf("'%s' expected (to close '%s' at line %d) near '%s'", text, opener, line, p.tok.text)
+}
+
+func (p *luaParser) name() string {
+	if p.tok.kind != tokName {
+		p.errorf("<name> expected near '%s'", p.tok.text)
+	}
+	name := p.tok.text
+	p.advance()
+	return name
+}
+
+func (p *luaParser) blockEnds() bool {
+	if p.tok.kind == tokEOF {
+		return true
+	}
+	if p.tok.kind != tokKeyword {
+		return false
+	}
+
+	switch p.tok.text {
+	case "end", "else", "elseif", "until":
+		return true
+	}
+	return false
+}
+
+func (p *luaParser) block() luaBlock {
+	block := luaBlock{}
+
+	for !p.blockEnds() {
+		if p.is("return") {
+			p.advance()
+
+			var exprs []luaExpr
+			if !p.blockEnds() && !p.is(";") {
+				exprs = p.exprList()
+			}
+			p.accept(";")
+
+			block = append(block, &stmtReturn{exprs: exprs})
+			if !p.blockEnds() {
+				p.errorf("'end' expected near '%s'", p.tok.text)
+			}
+			break
+		}
+
+		if p.is("break") {
+			p.advance()
+			p.accept(";")
+
+			block = append(block, &stmtBreak{})
+			if !p.blockEnds() {
+				p.errorf("'end' expected near '%s'", p.tok.text)
+			}
+			break
+		}
+
+		block = append(block, p.statement())
+		p.accept(";")
+	}
+
+	return block
+}
+
+func (p *luaParser) statement() luaStmt {
+	line := p.tok.line
+
+	switch {
+	case p.is("if"):
+		p.advance()
+		s := &stmtIf{}
+
+		s.conds = append(s.conds, p.expr())
+		p.expect("then")
+		s.blocks = append(s.blocks, p.block())
+
+		for p.is("elseif") {
+			p.advance()
+			s.conds = append(s.conds, p.expr())
+			p.expect("then")
+			s.blocks = append(s.blocks, p.block())
+		}
+
+		if p.accept("else") {
+			s.elseBlock = p.block()
+		}
+
+		p.expectMatch("end", "if", line)
+		return s
+	case p.is("while"):
+		p.advance()
+		cond := p.expr()
+		p.expect("do")
+		body := p.block()
+		p.expectMatch("end", "while", line)
+
+		return &stmtWhile{cond: cond, body: body}
+	case p.is("do"):
+		p.advance()
+		body := p.block()
+		p.expectMatch("end", "do", line)
+
+		return &stmtDo{body: body}
+	case p.is("repeat"):
+		p.advance()
+		body := p.block()
+		p.expectMatch("until", "repeat", line)
+
+		return &stmtRepeat{body: body, cond: p.expr()}
+	case p.is("for"):
+		p.advance()
+		first := p.name()
+
+		if p.accept("=") {
+			s := &stmtNumericFor{name: first, line: line}
+			s.start = p.expr()
+			p.expect(",")
+			s.limit = p.expr()
+			if p.accept(",") {
+				s.step = p.expr()
+			}
+			p.expect("do")
+			s.body = p.block()
+			p.expectMatch("end", "for", line)
+
+			return s
+		}
+
+		s := &stmtGenericFor{names: []string{first}, line: line}
+		for p.accept(",") {
+			s.names = append(s.names, p.name())
+		}
+		p.expect("in")
+		s.exprs = p.exprList()
+		p.expect("do")
+		s.body = p.block()
+		p.expectMatch("end", "for", line)
+
+		return s
+	case p.is("function"):
+		p.advance()
+
+		name := p.name()
+		var target luaExpr = &exprName{name: name, line: line}
+		fullName := name
+
+		for p.is(".") {
+			p.advance()
+			key := p.name()
+			target = &exprIndex{obj: target, key: &exprString{value: key}, line: line}
+			fullName += "." + key
+		}
+
+		isMethod := false
+		if p.accept(":") {
+			key := p.name()
+			target = &exprIndex{obj: target, key: &exprString{value: key}, line: line}
+			fullName += ":" + key
+			isMethod = true
+		}
+
+		proto := p.funcBody(fullName, isMethod, line)
+		return &stmtFunction{target: target, proto: proto, line: line}
+	case p.is("local"):
+		p.advance()
+
+		if p.accept("function") {
+			name := p.name()
+			return &stmtLocalFunction{name: name, proto: p.funcBody(name, false, line)}
+		}
+
+		s := &stmtLocal{names: []string{p.name()}}
+		for p.accept(",") {
+			s.names = append(s.names, p.name())
+		}
+		if p.accept("=") {
+			s.exprs = p.exprList()
+		}
+
+		return s
+	}
+
+	expr := p.suffixedExpr()
+
+	if p.is("=") || p.is(",") {
+		targets := []luaExpr{expr}
+		for p.accept(",") {
+			targets = append(targets, p.suffixedExpr())
+		}
+
+		for _, target := range targets {
+			switch target.(type) {
+			case *exprName, *exprIndex:
+			default:
+				p.errorf("syntax error near '%s'", p.tok.text)
+			}
+		}
+
+		p.expect("=")
+		return &stmtAssign{targets: targets, exprs: p.exprList(), line: line}
+	}
+
+	switch expr.(type) {
+	case *exprCall, *exprMethodCall:
+		return &stmtCall{call: expr}
+	}
+
+	p.errorf("syntax error near '%s'", p.tok.text)
+	return nil
+}
+
+func (p *luaParser) funcBody(name string, isMethod bool, line int) *luaProto {
+	proto := &luaProto{name: name, line: line}
+	if isMethod {
+		proto.params = append(proto.params, "self")
+	}
+
+	p.expect("(")
+	if !p.is(")") {
+		for {
+			if p.accept("...") {
+				proto.isVararg = true
+				break
+			}
+
+			proto.params = append(proto.params, p.name())
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	p.expect(")")
+
+	proto.body = p.block()
+	p.expectMatch("end", "function", line)
+
+	return proto
+}
+
+func (p *luaParser) exprList() []luaExpr {
+	exprs := []luaExpr{p.expr()}
+	for p.accept(",") {
+		exprs = append(exprs, p.expr())
+	}
+	return exprs
+}
+
+func (p *luaParser) primaryExpr() luaExpr {
+	line := p.tok.line
+
+	if p.tok.kind == tokName {
+		return &exprName{name: p.name(), line: line}
+	}
+
+	if p.accept("(") {
+		expr := p.expr()
+		p.expectMatch(")", "(", line)
+		return &exprParen{expr: expr}
+	}
+
+	p.errorf("unexpected symbol near '%s'", p.tok.text)
+	return nil
+}
+
+func (p *luaParser) suffixedExpr() luaExpr {
+	expr := p.primaryExpr()
+
+	for {
+		line := p.tok.line
+
+		switch {
+		case p.is("."):
+			p.advance()
+			expr = &exprIndex{obj: expr, key: &exprString{value: p.name()}, line: line}
+		case p.is("["):
+			p.advance()
+			key := p.expr()
+			p.expect("]")
+			expr = &exprIndex{obj: expr, key: key, line: line}
+		case p.is(":"):
+			p.advance()
+			name := p.name()
+			expr = &exprMethodCall{obj: expr, name: name, args: p.callArgs(), line: line}
+		case p.is("(") || p.is("{") || p.tok.kind == tokString:
+			expr = &exprCall{fn: expr, args: p.callArgs(), line: line}
+		default:
+			return expr
+		}
+	}
+}
+
+func (p *luaParser) callArgs() []luaExpr {
+	switch {
+	case p.tok.kind == tokString:
+		s := p.tok.text
+		p.advance()
+		return []luaExpr{&exprString{value: s}}
+	case p.is("{"):
+		return []luaExpr{p.tableConstructor()}
+	case p.is("("):
+		line := p.tok.line
+		p.advance()
+		if p.accept(")") {
+			return nil
+		}
+		args := p.exprList()
+		p.expectMatch(")", "(", line)
+		return args
+	}
+
+	p.errorf("function arguments expected near '%s'", p.tok.text)
+	return nil
+}
+
+func (p *luaParser) tableConstructor() luaExpr {
+	line := p.tok.line
+	p.expect("{")
+
+	t := &exprTable{line: line}
+	for !p.is("}") {
+		switch {
+		case p.is("["):
+			p.advance()
+			key := p.expr()
+			p.expect("]")
+			p.expect("=")
+			t.fields = append(t.fields, luaTableField{key: key, value: p.expr()})
+		case p.tok.kind == tokName && p.lookahead().kind == tokOp && p.lookahead().text == "=":
+			key := p.name()
+			p.advance()
+			t.fields = append(t.fields, luaTableField{key: &exprString{value: key}, value: p.expr()})
+		default:
+			t.fields = append(t.fields, luaTableField{value: p.expr()})
+		}
+
+		if !p.accept(",") && !p.accept(";") {
+			break
+		}
+	}
+	p.expectMatch("}", "{", line)
+
+	return t
+}
+
+func (p *luaParser) simpleExpr() luaExpr {
+	line := p.tok.line
+
+	switch {
+	case p.tok.kind == tokNumber:
+		n := p.tok.number
+		p.advance()
+		return &exprNumber{value: n}
+	case p.tok.kind == tokString:
+		s := p.tok.text
+		p.advance()
+		return &exprString{value: s}
+	case p.accept("nil"):
+		return &exprNil{}
+	case p.accept("true"):
+		return &exprTrue{}
+	case p.accept("false"):
+		return &exprFalse{}
+	case p.accept("..."):
+		return &exprVararg{line: line}
+	case p.is("{"):
+		return p.tableConstructor()
+	case p.accept("function"):
+		return &exprFunction{proto: p.funcBody("anonymous", false, line)}
+	}
+
+	return p.suffixedExpr()
+}
+
+// Binary operator priorities as {left, right}, following lparser.c.
+var luaBinaryPriority = map[string][2]int{
+	"+": {6, 6}, "-": {6, 6},
+	"*": {7, 7}, "/": {7, 7}, "%": {7, 7},
+	"^":  {10, 9},
+	"..": {5, 4},
+	"==": {3, 3}, "~=": {3, 3},
+	"<": {3, 3}, "<=": {3, 3}, ">": {3, 3}, ">=": {3, 3},
+	"and": {2, 2},
+	"or":  {1, 1},
+}
+
+const luaUnaryPriority = 8
+
+func (p *luaParser) expr() luaExpr {
+	return p.subExpr(0)
+}
+
+func (p *luaParser) subExpr(limit int) luaExpr {
+	var left luaExpr
+
+	line := p.tok.line
+	if p.is("not") || p.is("-") || p.is("#") {
+		op := p.tok.text
+		p.advance()
+		left = &exprUnary{op: op, expr: p.subExpr(luaUnaryPriority), line: line}
+	} else {
+		left = p.simpleExpr()
+	}
+
+	for {
+		if p.tok.kind != tokOp && p.tok.kind != tokKeyword {
+			return left
+		}
+
+		priority, ok := luaBinaryPriority[p.tok.text]
+		if !ok || priority[0] <= limit {
+			return left
+		}
+
+		op := p.tok.text
+		line := p.tok.line
+		p.advance()
+
+		right := p.subExpr(priority[1])
+		left = &exprBinary{op: op, left: left, right: right, line: line}
+	}
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+)
+
+// The struct library, ported from the lua_struct.c Redis ships: pack,
+// unpack and size convert between numbers and strings and binary records
+// described by a format.
+
+const luaStructMaxIntSize = 32
+
+// luaStructFormat walks a format, keeping the byte order and the maximum
+// alignment its control options set. Little endian is the native order.
+type luaStructFormat struct {
+	L      *luaState
+	fmt    string
+	pos    int
+	big    bool
+	align  int
+	fname  string
+	option byte
+}
+
+func newLuaStructFormat(L *luaState, fmt, fname string) *luaStructFormat {
+	return &luaStructFormat{L: L, fmt: fmt, align: 1, fname: fname}
+}
+
+// next moves to the next option, returning its size, false at the end.
+func (f *luaStructFormat) next() (int, bool) {
+	if f.pos >= len(f.fmt) {
+		return 0, false
+	}
+	f.option = f.fmt[f.pos]
+	f.pos++
+
+	switch f.option {
+	case 'b', 'B', 'x':
+		return 1, true
+	case 'h', 'H':
+		return 2, true
+	case 'f':
+		return 4, true
+	case 'l', 'L', 'T', 'd':
+		return 8, true
+	case 'c':
+		return f.number(1), true
+	case 'i', 'I':
+		size := f.number(4)
+		if size > luaStructMaxIntSize {
+			f.L.errorf("integral size %d is larger than limit of %d", size, luaStructMaxIntSize)
+		}
+		return size, true
+	}
+	return 0, true
+}
+
+func (f *luaStructFormat) number(def int) int {
+	if f.pos >= len(f.fmt) || !isDigit(f.fmt[f.pos]) {
+		return def
+	}
+
+	n := 0
+	for f.pos < len(f.fmt) && isDigit(f.fmt[f.pos]) {
+		if n > (math.MaxInt32-int(f.fmt[f.pos]-'0'))/10 {
+			f.L.errorf("integral size overflow")
+		}
+		n = n*10 + int(f.fmt[f.pos]-'0')
+		f.pos++
+	}
+	return n
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// padding returns how many bytes align an option of the given size at
+// offset.
+func (f *luaStructFormat) padding(offset, size int) int {
+	if size == 0 || f.option == 'c' {
+		return 0
+	}
+	if size > f.align {
+		size = f.align
+	}
+	return (size - (offset & (size - 1))) & (size - 1)
+}
+
+// control applies an option that is not a value.
+func (f *luaStructFormat) control() {
+	switch f.option {
+	case ' ':
+	case '>':
+		f.big = true
+	case '<':
+		f.big = false
+	case '!':
+		a := f.number(8)
+		if a == 0 || a&(a-1) != 0 {
+			f.L.errorf("alignment %d is not a power of 2", a)
+		}
+		f.align = a
+	default:
+		f.L.argError(0, f.fname, "invalid format option '"+string(f.option)+"'")
+	}
+}
+
+func (f *luaStructFormat) order() binary.ByteOrder {
+	if f.big {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+func openLuaStruct(L *luaState) {
+	st := newLuaTable()
+	luaRegister(st, "pack", luaStructPack)
+	luaRegister(st, "unpack", luaStructUnpack)
+	luaRegister(st, "size", luaStructSize)
+	st.readonly = true
+	L.globals.set("struct", st)
+}
+
+func luaStructPack(L *luaState, args []luaValue) []luaValue {
+	f := newLuaStructFormat(L, L.checkString(args, 0, "pack"), "pack")
+	arg := 1
+
+	var buf bytes.Buffer
+	for {
+		size, ok := f.next()
+		if !ok {
+			break
+		}
+		buf.WriteString(strings.Repeat("\x00", f.padding(buf.Len(), size)))
+
+		switch f.option {
+		case 'b', 'B', 'h', 'H', 'l', 'L', 'T', 'i', 'I':
+			n := L.checkNumber(args, arg, "pack")
+			arg++
+
+			var x uint64
+			if n < 0 {
+				x = uint64(int64(n))
+			} else {
+				x = uint64(n)
+			}
+			b := make([]byte, size)
+			for i := 0; i < size && i < 8; i++ {
+				if f.big {
+					b[size-1-i] = byte(x >> (8 * i))
+				} else {
+					b[i] = byte(x >> (8 * i))
+				}
+			}
+			buf.Write(b)
+		case 'x':
+			buf.WriteByte(0)
+		case 'f':
+			b := make([]byte, 4)
+			f.order().PutUint32(b, math.Float32bits(float32(L.checkNumber(args, arg, "pack"))))
+			arg++
+			buf.Write(b)
+		case 'd':
+			b := make([]byte, 8)
+			f.order().PutUint64(b, math.Float64bits(L.checkNumber(args, arg, "pack")))
+			arg++
+			buf.Write(b)
+		case 'c', 's':
+			s := L.checkString(args, arg, "pack")
+			arg++
+			if size == 0 {
+				size = len(s)
+			}
+			if len(s) < size {
+				L.argError(arg-1, "pack", "string too short")
+			}
+			buf.WriteString(s[:size])
+			if f.option == 's' {
+				buf.WriteByte(0)
+			}
+		default:
+			f.control()
+		}
+	}
+
+	return []luaValue{buf.String()}
+}
+
+func luaStructUnpack(L *luaState, args []luaValue) []luaValue {
+	f := newLuaStructFormat(L, L.checkString(args, 0, "unpack"), "unpack")
+	data := L.checkString(args, 1, "unpack")
+	pos := L.optInt(args, 2, "unpack", 1)
+	if pos < 1 {
+		L.argError(2, "unpack", "offset must be 1 or greater")
+	}
+	pos--
+
+	results := []luaValue{}
+	for {
+		size, ok := f.next()
+		if !ok {
+			break
+		}
+		pos += f.padding(pos, size)
+		if size > len(data) || pos > len(data)-size {
+			L.argError(1, "unpack", "data string too short")
+		}
+
+		switch f.option {
+		case 'b', 'B', 'h', 'H', 'l', 'L', 'T', 'i', 'I':
+			var x uint64
+			for i := 0; i < size; i++ {
+				b := data[pos+size-1-i]
+				if f.big {
+					b = data[pos+i]
+				}
+				x = x<<8 | uint64(b)
+			}
+			signed := f.option >= 'a'
+			if signed && size < 8 && x&(1<<(8*size-1)) != 0 {
+				x |= math.MaxUint64 << (8 * size)
+			}
+			if signed {
+				results = append(results, float64(int64(x)))
+			} else {
+				results = append(results, float64(x))
+			}
+		case 'x':
+		case 'f':
+			results = append(results, float64(math.Float32frombits(f.order().Uint32([]byte(data[pos:pos+4])))))
+		case 'd':
+			results = append(results, math.Float64frombits(f.order().Uint64([]byte(data[pos:pos+8]))))
+		case 'c':
+			if size == 0 {
+				var n float64
+				if len(results) > 0 {
+					n, ok = results[len(results)-1].(float64)
+				}
+				if !ok {
+					L.errorf("format 'c0' needs a previous size")
+				}
+				results = results[:len(results)-1]
+				size = int(n)
+				if size > len(data) || pos > len(data)-size {
+					L.argError(1, "unpack", "data string too short")
+				}
+			}
+			results = append(results, data[pos:pos+size])
+		case 's':
+			end := strings.IndexByte(data[pos:], 0)
+			if end < 0 {
+				L.errorf("unfinished string in data")
+			}
+			results = append(results, data[pos:pos+end])
+			size = end + 1
+		default:
+			f.control()
+		}
+		pos += size
+	}
+
+	return append(results, float64(pos+1))
+}
+
+func luaStructSize(L *luaState, args []luaValue) []luaValue {
+	f := newLuaStructFormat(L, L.checkString(args, 0, "size"), "size")
+
+	pos := 0
+	for {
+		size, ok := f.next()
+		if !ok {
+			break
+		}
+		pos += f.padding(pos, size)
+
+		switch {
+		case f.option == 's':
+			L.argError(0, "size", "options 's' has no fixed size")
+		case f.option == 'c' && size == 0:
+			L.argError(0, "size", "options 'c0' has no fixed size")
+		case size == 0:
+			f.control()
+		}
+		pos += size
+	}
+
+	return []luaValue{float64(pos)}
+}
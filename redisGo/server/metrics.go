@@ -0,0 +1,185 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// durationBuckets are the upper bounds of the histogram of command
+// durations.
+var durationBuckets = [...]time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// MetricsHandler serves the statistics INFO reports in the Prometheus text
+// format, for a /metrics endpoint: the commands with their durations, the
+// clients, the keyspace by type, the memory, the AOF and the expired keys.
+func (srv *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var sb strings.Builder
+		srv.writeMetrics(&sb)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		io.WriteString(w, sb.String())
+	})
+}
+
+func (srv *Server) writeMetrics(sb *strings.Builder) {
+	srv.metricsServer(sb)
+	srv.metricsCommands(sb)
+	srv.metricsKeyspace(sb)
+	metricsMemory(sb)
+	srv.metricsPersistence(sb)
+}
+
+// metric writes the HELP and TYPE lines of a metric, which its samples
+// follow.
+func metric(sb *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(sb, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(sb, "# TYPE %s %s\n", name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func label(name, value string) string {
+	return fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(value))
+}
+
+func (srv *Server) metricsServer(sb *strings.Builder) {
+	srv.clientsMu.RLock()
+	connected := len(srv.clients)
+	srv.clientsMu.RUnlock()
+
+	metric(sb, "redisgo_uptime_seconds", "gauge", "Time since the server started.")
+	fmt.Fprintf(sb, "redisgo_uptime_seconds %d\n", int64(time.Since(srv.startTime).Seconds()))
+	metric(sb, "redisgo_connected_clients", "gauge", "Clients connected.")
+	fmt.Fprintf(sb, "redisgo_connected_clients %d\n", connected)
+	metric(sb, "redisgo_connections_received_total", "counter", "Connections accepted.")
+	fmt.Fprintf(sb, "redisgo_connections_received_total %d\n", srv.stats.totalConnections.Load())
+	metric(sb, "redisgo_commands_processed_total", "counter", "Commands processed.")
+	fmt.Fprintf(sb, "redisgo_commands_processed_total %d\n", srv.stats.totalCommands.Load())
+	metric(sb, "redisgo_expired_keys_total", "counter", "Keys deleted once their time to live passed.")
+	fmt.Fprintf(sb, "redisgo_expired_keys_total %d\n", srv.stats.expiredKeys.Load())
+	metric(sb, "redisgo_client_query_buffer_limit_disconnections_total", "counter", "Clients disconnected for a request over client-query-buffer-limit.")
+	fmt.Fprintf(sb, "redisgo_client_query_buffer_limit_disconnections_total %d\n", srv.stats.queryBufferLimitDisconnections.Load())
+	metric(sb, "redisgo_client_output_buffer_limit_disconnections_total", "counter", "Clients disconnected for replies over client-output-buffer-limit.")
+	fmt.Fprintf(sb, "redisgo_client_output_buffer_limit_disconnections_total %d\n", srv.stats.outputBufferLimitDisconnections.Load())
+}
+
+func (srv *Server) metricsCommands(sb *strings.Builder) {
+	srv.commandStatsMu.Lock()
+	defer srv.commandStatsMu.Unlock()
+
+	commands := make([]string, 0, len(srv.commandStats))
+	for command := range srv.commandStats {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	metric(sb, "redisgo_commands_total", "counter", "Calls by command.")
+	for _, command := range commands {
+		fmt.Fprintf(sb, "redisgo_commands_total{%s} %d\n", label("cmd", strings.ToLower(command)), srv.commandStats[command].calls)
+	}
+	metric(sb, "redisgo_commands_failed_total", "counter", "Calls by command that replied with an error.")
+	for _, command := range commands {
+		fmt.Fprintf(sb, "redisgo_commands_failed_total{%s} %d\n", label("cmd", strings.ToLower(command)), srv.commandStats[command].failed)
+	}
+
+	metric(sb, "redisgo_command_duration_seconds", "histogram", "Time spent running each command.")
+	for _, command := range commands {
+		stat := srv.commandStats[command]
+		cmd := label("cmd", strings.ToLower(command))
+
+		var count int64
+		for i, bound := range durationBuckets {
+			count += stat.buckets[i]
+			fmt.Fprintf(sb, "redisgo_command_duration_seconds_bucket{%s,le=\"%g\"} %d\n", cmd, bound.Seconds(), count)
+		}
+		fmt.Fprintf(sb, "redisgo_command_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", cmd, stat.calls)
+		fmt.Fprintf(sb, "redisgo_command_duration_seconds_sum{%s} %g\n", cmd, stat.duration.Seconds())
+		fmt.Fprintf(sb, "redisgo_command_duration_seconds_count{%s} %d\n", cmd, stat.calls)
+	}
+}
+
+func (srv *Server) metricsKeyspace(sb *strings.Builder) {
+	keys := map[string]int{}
+	expires := 0
+	for _, sh := range srv.shards {
+		sh.mu.RLock()
+		keys["string"] += len(sh.sets) + len(sh.ints)
+		keys["hash"] += len(sh.hsets)
+		keys["list"] += len(sh.lists)
+		keys["set"] += len(sh.smembers)
+		keys["zset"] += len(sh.zsets)
+		keys["stream"] += len(sh.streams)
+		expires += len(sh.expires)
+		sh.mu.RUnlock()
+	}
+
+	metric(sb, "redisgo_keys", "gauge", "Keys by type.")
+	for _, kind := range []string{"string", "hash", "list", "set", "zset", "stream"} {
+		fmt.Fprintf(sb, "redisgo_keys{%s} %d\n", label("type", kind), keys[kind])
+	}
+	metric(sb, "redisgo_keys_expiring", "gauge", "Keys with a time to live.")
+	fmt.Fprintf(sb, "redisgo_keys_expiring %d\n", expires)
+}
+
+func metricsMemory(sb *strings.Builder) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	metric(sb, "redisgo_memory_used_bytes", "gauge", "Memory allocated on the heap, used_memory in INFO.")
+	fmt.Fprintf(sb, "redisgo_memory_used_bytes %d\n", m.HeapAlloc)
+	metric(sb, "redisgo_memory_sys_bytes", "gauge", "Memory obtained from the operating system.")
+	fmt.Fprintf(sb, "redisgo_memory_sys_bytes %d\n", m.Sys)
+}
+
+func (srv *Server) metricsPersistence(sb *strings.Builder) {
+	metric(sb, "redisgo_aof_enabled", "gauge", "Whether the dataset is persisted in an AOF.")
+	if srv.aofFile == nil {
+		fmt.Fprintf(sb, "redisgo_aof_enabled 0\n")
+		return
+	}
+	fmt.Fprintf(sb, "redisgo_aof_enabled 1\n")
+
+	writeOK, rewriteOK := 1, 1
+	if srv.aofFile.WriteStatus() != "ok" {
+		writeOK = 0
+	}
+	aofStats := srv.aofFile.Stats()
+	if aofStats.RewriteError != nil {
+		rewriteOK = 0
+	}
+
+	metric(sb, "redisgo_aof_size_bytes", "gauge", "Size of the AOF.")
+	fmt.Fprintf(sb, "redisgo_aof_size_bytes %d\n", srv.aofFile.Size())
+	metric(sb, "redisgo_aof_last_write_ok", "gauge", "Whether the last write to the AOF succeeded.")
+	fmt.Fprintf(sb, "redisgo_aof_last_write_ok %d\n", writeOK)
+	metric(sb, "redisgo_aof_fsyncs_total", "counter", "Fsyncs of the AOF.")
+	fmt.Fprintf(sb, "redisgo_aof_fsyncs_total %d\n", aofStats.Fsyncs)
+	metric(sb, "redisgo_aof_fsync_seconds_total", "counter", "Time spent in fsyncs of the AOF.")
+	fmt.Fprintf(sb, "redisgo_aof_fsync_seconds_total %g\n", aofStats.FsyncTime.Seconds())
+	metric(sb, "redisgo_aof_rewrites_total", "counter", "Rewrites of the AOF.")
+	fmt.Fprintf(sb, "redisgo_aof_rewrites_total %d\n", aofStats.Rewrites)
+	metric(sb, "redisgo_aof_rewrite_seconds_total", "counter", "Time spent rewriting the AOF.")
+	fmt.Fprintf(sb, "redisgo_aof_rewrite_seconds_total %g\n", aofStats.RewriteTime.Seconds())
+	metric(sb, "redisgo_aof_last_rewrite_seconds", "gauge", "Duration of the last rewrite of the AOF.")
+	fmt.Fprintf(sb, "redisgo_aof_last_rewrite_seconds %g\n", aofStats.LastRewrite.Seconds())
+	metric(sb, "redisgo_aof_last_rewrite_ok", "gauge", "Whether the last rewrite of the AOF succeeded.")
+	fmt.Fprintf(sb, "redisgo_aof_last_rewrite_ok %d\n", rewriteOK)
+}
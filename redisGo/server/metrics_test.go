@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	testServer.resetStats()
+	client := testServer.newClient(nil)
+	testServer.call(client, command("SET", "metrics:string", "v"))
+	testServer.call(client, command("GET", "metrics:string"))
+	testServer.call(client, command("GET", "metrics:string"))
+	testServer.call(client, command("LRANGE", "metrics:string", "0", "-1"))
+
+	testServer.call(client, command("SET", "metrics:volatile", "v"))
+	testServer.call(client, command("PEXPIRE", "metrics:volatile", "1"))
+	time.Sleep(2 * time.Millisecond)
+	testServer.call(client, command("GET", "metrics:volatile"))
+
+	recorder := httptest.NewRecorder()
+	testServer.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+
+	for _, line := range []string{
+		"# TYPE redisgo_commands_total counter",
+		`redisgo_commands_total{cmd="get"} 3`,
+		`redisgo_commands_failed_total{cmd="lrange"} 1`,
+		"# TYPE redisgo_command_duration_seconds histogram",
+		`redisgo_command_duration_seconds_bucket{cmd="get",le="+Inf"} 3`,
+		`redisgo_command_duration_seconds_count{cmd="set"} 2`,
+		"redisgo_commands_processed_total 7",
+		"redisgo_expired_keys_total 1",
+		`redisgo_keys{type="string"} `,
+		"redisgo_connected_clients ",
+		"redisgo_memory_used_bytes ",
+		"redisgo_aof_enabled ",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("Expected the metrics to contain %q, got\n%s", line, body)
+		}
+	}
+
+	// The buckets of the histogram are cumulative.
+	if !strings.Contains(body, `redisgo_command_duration_seconds_bucket{cmd="get",le="1"} 3`) {
+		t.Errorf("Expected every GET within the last bucket, got\n%s", body)
+	}
+}
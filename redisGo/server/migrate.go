@@ -0,0 +1,270 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"redisGo/resp"
+)
+
+// MIGRATE moves keys to another instance by sending it the commands that
+// rebuild each key, and deletes the local copies once the target accepted
+// all of them. The shards holding the keys stay locked meanwhile, so that
+// the keys cannot change between being copied and being deleted.
+func (srv *Server) migrate(args []Value) Value {
+	host, targetPort, key, db := args[0].Bulk, args[1].Bulk, args[2].Bulk, args[3].Bulk
+
+	ms, err := strconv.ParseInt(args[4].Bulk, 10, 64)
+	if err != nil || ms < 0 {
+		return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+	}
+	timeout := time.Duration(ms) * time.Millisecond
+	if timeout == 0 {
+		timeout = time.Second
+	}
+
+	if db != "0" {
+		return Value{Type: "error", Str: "ERR DB index is out of range"}
+	}
+
+	copyKeys, replace := false, false
+	keys := []string{}
+	if key != "" {
+		keys = append(keys, key)
+	}
+
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "COPY":
+			copyKeys = true
+		case "REPLACE":
+			replace = true
+		case "AUTH":
+			// The server has no authentication, so the password is ignored.
+			if i+1 >= len(args) {
+				return Value{Type: "error", Str: "ERR syntax error"}
+			}
+			i++
+		case "AUTH2":
+			if i+2 >= len(args) {
+				return Value{Type: "error", Str: "ERR syntax error"}
+			}
+			i += 2
+		case "KEYS":
+			if key != "" {
+				return Value{Type: "error", Str: "ERR When using MIGRATE KEYS option, the key argument must be set to the empty string"}
+			}
+			for _, arg := range args[i+1:] {
+				keys = append(keys, arg.Bulk)
+			}
+			i = len(args)
+		default:
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+	}
+
+	for _, key := range keys {
+		srv.expireIfNeeded(key)
+	}
+
+	unlock := srv.lockKeys(keys)
+	defer unlock()
+
+	present := []string{}
+	restore := [][][]string{}
+	for _, key := range keys {
+		sh := srv.shardOf(key)
+		if sh.exists(key) {
+			present = append(present, key)
+			restore = append(restore, keyRestoreCommands(sh, key))
+		}
+	}
+	if len(present) == 0 {
+		return Value{Type: "string", Str: "NOKEY"}
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, targetPort), timeout)
+	if err != nil {
+		return Value{Type: "error", Str: "IOERR error or timeout connecting to the client"}
+	}
+	defer conn.Close()
+
+	target := &migrateTarget{conn: conn, reader: resp.NewResp(conn), timeout: timeout, asking: srv.clusterEnabled}
+
+	for i, key := range present {
+		if replace {
+			if reply := target.request("DEL", key); reply.Type == "error" {
+				return reply
+			}
+		} else {
+			reply := target.request("EXISTS", key)
+			if reply.Type == "error" {
+				return reply
+			}
+			if reply.Num > 0 {
+				return Value{Type: "error", Str: "BUSYKEY Target key name already exists."}
+			}
+		}
+
+		for _, command := range restore[i] {
+			if reply := target.request(command...); reply.Type == "error" {
+				return reply
+			}
+		}
+	}
+
+	if copyKeys {
+		return Value{Type: "string", Str: "OK"}
+	}
+
+	deleted := []Value{{Type: "bulk", Bulk: "DEL"}}
+	for _, key := range present {
+		srv.shardOf(key).delete(key)
+		deleted = append(deleted, Value{Type: "bulk", Bulk: key})
+	}
+	srv.propagate(deleted...)
+	srv.signalModifiedKeys(nil, present)
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+type migrateTarget struct {
+	conn    net.Conn
+	reader  *resp.Resp
+	timeout time.Duration
+	asking  bool
+}
+
+// request sends a command to the target and returns its reply, turning I/O
+// failures and error replies into the errors MIGRATE answers with. With
+// asking, in cluster mode, every command is preceded by ASKING, as the
+// target is usually importing the slot.
+func (t *migrateTarget) request(args ...string) Value {
+	commands := [][]string{args}
+	if t.asking {
+		commands = [][]string{{"ASKING"}, args}
+	}
+
+	request := []byte{}
+	for _, command := range commands {
+		value := Value{Type: "array"}
+		for _, arg := range command {
+			value.Array = append(value.Array, Value{Type: "bulk", Bulk: arg})
+		}
+		request = append(request, value.Marshal()...)
+	}
+
+	t.conn.SetDeadline(time.Now().Add(t.timeout))
+	if _, err := t.conn.Write(request); err != nil {
+		return Value{Type: "error", Str: "IOERR error or timeout writing to target instance"}
+	}
+
+	var reply Value
+	for range commands {
+		value, err := t.reader.Read()
+		if err != nil {
+			return Value{Type: "error", Str: "IOERR error or timeout reading to target instance"}
+		}
+		if value.Type == "error" {
+			return Value{Type: "error", Str: fmt.Sprintf("ERR Target instance replied with error: %s", value.Str)}
+		}
+		reply = value
+	}
+
+	return reply
+}
+
+// keyRestoreCommands returns the commands that rebuild key, whatever its
+// type, along with its time to live. The caller must hold the shard lock.
+func keyRestoreCommands(sh *Shard, key string) [][]string {
+	commands := [][]string{}
+
+	if value, ok := sh.getString(key); ok {
+		commands = append(commands, []string{"SET", key, value})
+	}
+
+	if hash, ok := sh.hsets[key]; ok {
+		for _, field := range hash.sortedFields() {
+			value, _ := hash.get(field)
+			commands = append(commands, []string{"HSET", key, field, value})
+		}
+	}
+
+	if s, ok := sh.streams[key]; ok {
+		commands = append(commands, streamRestoreCommands(key, s)...)
+	}
+
+	if z, ok := sh.zsets[key]; ok {
+		command := []string{"ZADD", key}
+		for _, entry := range z.rangeByRank(0, z.length-1) {
+			command = append(command, formatScore(entry.score), entry.member)
+		}
+		commands = append(commands, command)
+	}
+
+	if list, ok := sh.lists[key]; ok {
+		commands = append(commands, append([]string{"RPUSH", key}, list.all()...))
+	}
+
+	if set, ok := sh.smembers[key]; ok {
+		commands = append(commands, append([]string{"SADD", key}, set.sortedMembers()...))
+	}
+
+	if when, ok := sh.expires[key]; ok {
+		commands = append(commands, []string{"PEXPIREAT", key, strconv.FormatInt(when.UnixMilli(), 10)})
+	}
+
+	return commands
+}
+
+func streamRestoreCommands(key string, s *stream) [][]string {
+	commands := [][]string{}
+
+	entries := s.rangeEntries(streamMinID, streamMaxID, 0, false)
+	for _, entry := range entries {
+		commands = append(commands, append([]string{"XADD", key, entry.id.String()}, entry.fields...))
+	}
+
+	// An empty stream still remembers its last ID: add an entry with it and
+	// trim it away straight after.
+	if len(entries) == 0 && s.lastID != streamMinID {
+		commands = append(commands, []string{"XADD", key, "MAXLEN", "0", s.lastID.String(), "", ""})
+	}
+
+	groups := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+
+	for _, name := range groups {
+		group := s.groups[name]
+		commands = append(commands, []string{"XGROUP", "CREATE", key, name, group.lastID.String(),
+			"MKSTREAM", "ENTRIESREAD", strconv.FormatInt(group.entriesRead, 10)})
+
+		consumers := make([]string, 0, len(group.consumers))
+		for consumer := range group.consumers {
+			consumers = append(consumers, consumer)
+		}
+		sort.Strings(consumers)
+
+		for _, consumer := range consumers {
+			commands = append(commands, []string{"XGROUP", "CREATECONSUMER", key, name, consumer})
+
+			pel := group.consumers[consumer].pel
+			for _, id := range sortedPendingIDs(pel) {
+				nack := pel[id]
+				commands = append(commands, []string{"XCLAIM", key, name, consumer, "0", id.String(),
+					"TIME", strconv.FormatInt(nack.deliveryTime.UnixMilli(), 10),
+					"RETRYCOUNT", strconv.FormatInt(nack.deliveryCount, 10),
+					"FORCE", "JUSTID"})
+			}
+		}
+	}
+
+	return commands
+}
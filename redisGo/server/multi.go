@@ -0,0 +1,171 @@
+package server
+
+// multiState holds the commands a client queues between MULTI and EXEC.
+// Only the connection of the client touches it.
+type multiState struct {
+	active   bool
+	commands []Value
+
+	// aborted is set when a command could not be queued, as EXEC then
+	// discards the whole transaction.
+	aborted bool
+
+	// exec is set while EXEC runs the queue, during which commands cannot
+	// block.
+	exec bool
+}
+
+// Commands that a client in MULTI runs right away rather than queueing.
+var transactionCommands = map[string]bool{
+	"MULTI":   true,
+	"EXEC":    true,
+	"DISCARD": true,
+	"WATCH":   true,
+}
+
+// EXEC calls back into ClientHandlers, so it is registered at init time to
+// avoid an initialization cycle.
+func init() {
+	clientHandlers["EXEC"] = (*Server).execCommand
+}
+
+// queueMultiCommand queues a command for EXEC.
+func queueMultiCommand(c *Client, spec *commandSpec, value Value) Value {
+	if spec.hasFlag("no_multi") {
+		c.flagTransaction()
+		return Value{Type: "error", Str: "ERR Command not allowed inside a transaction"}
+	}
+
+	c.multi.commands = append(c.multi.commands, value)
+	return Value{Type: "string", Str: "QUEUED"}
+}
+
+// flagTransaction marks the transaction of c, if any, for EXECABORT after
+// a command was rejected.
+func (c *Client) flagTransaction() {
+	if c.multi.active {
+		c.multi.aborted = true
+	}
+}
+
+// discardTransaction leaves MULTI and forgets the watched keys.
+func (srv *Server) discardTransaction(c *Client) {
+	c.multi = multiState{}
+	srv.unwatchAllKeys(c)
+}
+
+func (srv *Server) multiCommand(c *Client, args []Value) Value {
+	if c.multi.active {
+		return Value{Type: "error", Str: "ERR MULTI calls can not be nested"}
+	}
+
+	c.multi.active = true
+	return Value{Type: "string", Str: "OK"}
+}
+
+func (srv *Server) discardCommand(c *Client, args []Value) Value {
+	if !c.multi.active {
+		return Value{Type: "error", Str: "ERR DISCARD without MULTI"}
+	}
+
+	srv.discardTransaction(c)
+	return Value{Type: "string", Str: "OK"}
+}
+
+// execCommand runs the queued commands. It holds execMu exclusively, so no
+// other command runs in between, and each command is propagated on its own.
+func (srv *Server) execCommand(c *Client, args []Value) Value {
+	if !c.multi.active {
+		return Value{Type: "error", Str: "ERR EXEC without MULTI"}
+	}
+	if c.multi.aborted {
+		srv.discardTransaction(c)
+		return Value{Type: "error", Str: "EXECABORT Transaction discarded because of previous errors."}
+	}
+
+	srv.watchedKeysMu.Lock()
+	dirty := c.watchDirty
+	srv.watchedKeysMu.Unlock()
+
+	commands := c.multi.commands
+	srv.discardTransaction(c)
+	if dirty {
+		return Value{Type: "null"}
+	}
+
+	c.multi.exec = true
+	defer func() { c.multi.exec = false }()
+
+	replies := make([]Value, 0, len(commands))
+	for _, value := range commands {
+		replies = append(replies, srv.runCommand(c, value))
+	}
+
+	return Value{Type: "array", Array: replies}
+}
+
+func (srv *Server) watchCommand(c *Client, args []Value) Value {
+	if c.multi.active {
+		return Value{Type: "error", Str: "ERR WATCH inside MULTI is not allowed"}
+	}
+
+	srv.watchedKeysMu.Lock()
+	defer srv.watchedKeysMu.Unlock()
+
+	if c.watched == nil {
+		c.watched = map[string]bool{}
+	}
+	for _, arg := range args {
+		key := arg.Bulk
+		if srv.watchedKeys[key] == nil {
+			srv.watchedKeys[key] = map[*Client]bool{}
+		}
+		srv.watchedKeys[key][c] = true
+		c.watched[key] = true
+	}
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+func (srv *Server) unwatchCommand(c *Client, args []Value) Value {
+	srv.unwatchAllKeys(c)
+	return Value{Type: "string", Str: "OK"}
+}
+
+// unwatchAllKeys forgets the keys c watches.
+func (srv *Server) unwatchAllKeys(c *Client) {
+	srv.watchedKeysMu.Lock()
+	defer srv.watchedKeysMu.Unlock()
+
+	for key := range c.watched {
+		delete(srv.watchedKeys[key], c)
+		if len(srv.watchedKeys[key]) == 0 {
+			delete(srv.watchedKeys, key)
+		}
+	}
+	c.watched = nil
+	c.watchDirty = false
+}
+
+// touchWatchedKeys fails the transactions of the clients watching keys.
+func (srv *Server) touchWatchedKeys(keys []string) {
+	srv.watchedKeysMu.Lock()
+	defer srv.watchedKeysMu.Unlock()
+
+	if len(srv.watchedKeys) == 0 {
+		return
+	}
+	for _, key := range keys {
+		for c := range srv.watchedKeys[key] {
+			c.watchDirty = true
+		}
+	}
+}
+
+// signalModifiedKeys is called whenever keys change: it fails the
+// transactions watching them and invalidates them in client side caches.
+// modifier is the client that changed them, or nil.
+func (srv *Server) signalModifiedKeys(modifier *Client, keys []string) {
+	srv.touchWatchedKeys(keys)
+	srv.trackingInvalidateKeys(modifier, keys)
+}
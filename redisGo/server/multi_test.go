@@ -0,0 +1,56 @@
+package server
+
+import "testing"
+
+func TestMultiExec(t *testing.T) {
+	client := newTestClient()
+	testServer.call(client, command("DEL", "multi:a"))
+
+	testServer.call(client, command("MULTI"))
+	if result := testServer.call(client, command("SET", "multi:a", "1")); result.Str != "QUEUED" {
+		t.Fatalf("Expected QUEUED, got %v", result)
+	}
+	testServer.call(client, command("GET", "multi:a"))
+	if result := testServer.call(newTestClient(), command("GET", "multi:a")); result.Type != "null" {
+		t.Errorf("Expected queued commands not to run before EXEC, got %v", result)
+	}
+
+	result := testServer.call(client, command("EXEC"))
+	if len(result.Array) != 2 || result.Array[0].Str != "OK" || result.Array[1].Bulk != "1" {
+		t.Errorf("Expected [OK 1], got %v", result)
+	}
+
+	// A command rejected while queueing discards the transaction.
+	testServer.call(client, command("MULTI"))
+	testServer.call(client, command("SET", "multi:a"))
+	testServer.call(client, command("SET", "multi:a", "2"))
+	if result := testServer.call(client, command("EXEC")); result.Type != "error" || result.Str[:9] != "EXECABORT" {
+		t.Errorf("Expected EXECABORT, got %v", result)
+	}
+	if result := testServer.call(client, command("GET", "multi:a")); result.Bulk != "1" {
+		t.Errorf("Expected the aborted transaction not to run, got %v", result)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	client := newTestClient()
+	defer testServer.unwatchAllKeys(client)
+
+	testServer.call(client, command("WATCH", "watch:a"))
+	testServer.call(newTestClient(), command("SET", "watch:a", "other"))
+	testServer.call(client, command("MULTI"))
+	testServer.call(client, command("SET", "watch:a", "mine"))
+	if result := testServer.call(client, command("EXEC")); result.Type != "null" {
+		t.Errorf("Expected EXEC to fail after a watched key changed, got %v", result)
+	}
+	if result := testServer.call(client, command("GET", "watch:a")); result.Bulk != "other" {
+		t.Errorf("Expected other, got %v", result)
+	}
+
+	// EXEC unwatches the keys, so the next transaction goes through.
+	testServer.call(client, command("MULTI"))
+	testServer.call(client, command("SET", "watch:a", "mine"))
+	if result := testServer.call(client, command("EXEC")); len(result.Array) != 1 {
+		t.Errorf("Expected the transaction to run, got %v", result)
+	}
+}
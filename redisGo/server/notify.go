@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Keyspace event classes, as configured through notify-keyspace-events.
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZset                 // z
+	notifyExpired              // x
+	notifyEvicted              // e
+	notifyStream               // t
+	notifyKeyMiss              // m
+	notifyNew                  // n
+
+	// A is an alias for every class but m and n.
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash |
+		notifyZset | notifyExpired | notifyEvicted | notifyStream
+)
+
+var notifyFlags = []struct {
+	flag  byte
+	class int
+}{
+	{'g', notifyGeneric},
+	{'$', notifyString},
+	{'l', notifyList},
+	{'s', notifySet},
+	{'h', notifyHash},
+	{'z', notifyZset},
+	{'x', notifyExpired},
+	{'e', notifyEvicted},
+	{'t', notifyStream},
+	{'K', notifyKeyspace},
+	{'E', notifyKeyevent},
+	{'m', notifyKeyMiss},
+	{'n', notifyNew},
+}
+
+func (srv *Server) notifyConfig() configParam {
+	return configParam{
+		get: func() string {
+			return notifyFlagsToString(int(srv.notifyKeyspaceEvents))
+		},
+		set: func(value string) error {
+			classes, err := notifyStringToFlags(value)
+			if err != nil {
+				return err
+			}
+
+			srv.notifyKeyspaceEvents = int64(classes)
+			return nil
+		},
+	}
+}
+
+func notifyStringToFlags(s string) (int, error) {
+	classes := 0
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == 'A' {
+			classes |= notifyAll
+			continue
+		}
+
+		found := false
+		for _, f := range notifyFlags {
+			if f.flag == s[i] {
+				classes |= f.class
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("Invalid event class character. Use 'Ag$lshzxeKEtmn'.")
+		}
+	}
+
+	return classes, nil
+}
+
+func notifyFlagsToString(classes int) string {
+	var sb strings.Builder
+
+	if classes&notifyAll == notifyAll {
+		sb.WriteString("A")
+	}
+	for _, f := range notifyFlags {
+		if f.class&notifyAll != 0 && classes&notifyAll == notifyAll {
+			continue
+		}
+		if classes&f.class != 0 {
+			sb.WriteByte(f.flag)
+		}
+	}
+
+	return sb.String()
+}
+
+// notifyKeyspaceEvent publishes event for key on the __keyspace@0__ and
+// __keyevent@0__ channels, if class is enabled.
+func (srv *Server) notifyKeyspaceEvent(class int, event, key string) {
+	classes := int(srv.configInt(&srv.notifyKeyspaceEvents))
+	if classes&class == 0 {
+		return
+	}
+
+	if classes&notifyKeyspace != 0 {
+		srv.publishMessage("__keyspace@0__:"+key, event)
+	}
+	if classes&notifyKeyevent != 0 {
+		srv.publishMessage("__keyevent@0__:"+event, key)
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"redisGo/resp"
+)
+
+func TestNotifyKeyspaceEventsFlags(t *testing.T) {
+	tests := []struct {
+		flags    string
+		expected string
+	}{
+		{"", ""},
+		{"KEA", "AKE"},
+		{"Kx$", "$xK"},
+		{"Eg$lshzxet", "AE"},
+		{"Kmn", "Kmn"},
+	}
+
+	for _, test := range tests {
+		classes, err := notifyStringToFlags(test.flags)
+		if err != nil {
+			t.Fatalf("Unexpected error for %q: %v", test.flags, err)
+		}
+		if got := notifyFlagsToString(classes); got != test.expected {
+			t.Errorf("Expected %q for %q, got %q", test.expected, test.flags, got)
+		}
+	}
+
+	if _, err := notifyStringToFlags("KQ"); err == nil {
+		t.Errorf("Expected an error for an unknown event class")
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	testServer.notifyKeyspaceEvents = notifyKeyevent | notifyAll
+	defer func() { testServer.notifyKeyspaceEvents = 0 }()
+
+	conn, peer := net.Pipe()
+	subscriber := testServer.newClient(conn)
+	defer testServer.unsubscribeAll(subscriber)
+
+	messages := make(chan Value, 16)
+	go func() {
+		reader := resp.NewResp(peer)
+		for {
+			value, err := reader.Read()
+			if err != nil {
+				return
+			}
+			messages <- value
+		}
+	}()
+
+	testServer.call(subscriber, command("SUBSCRIBE", "__keyevent@0__:set", "__keyevent@0__:expired"))
+	if reply := <-messages; reply.Array[0].Bulk != "subscribe" || reply.Array[2].Num != 1 {
+		t.Fatalf("Expected the first subscribe reply to be written directly, got %v", reply)
+	}
+
+	client := newTestClient()
+	testServer.call(client, command("SET", "notify:key", "v"))
+	testServer.call(client, command("PEXPIRE", "notify:key", "10"))
+	time.Sleep(20 * time.Millisecond)
+	testServer.call(client, command("GET", "notify:key"))
+
+	for _, expected := range []string{"__keyevent@0__:set", "__keyevent@0__:expired"} {
+		select {
+		case message := <-messages:
+			if message.Array[1].Bulk != expected || message.Array[2].Bulk != "notify:key" {
+				t.Errorf("Expected a message on %s, got %v", expected, message)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for a message on %s", expected)
+		}
+	}
+
+	result := testServer.call(subscriber, command("GET", "notify:key"))
+	if result.Type != "error" {
+		t.Errorf("Expected commands to be refused in subscribed mode, got %v", result)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	testServer.set(command("notify:ttl", "v").Array)
+
+	if result := testServer.expire(command("notify:ttl", "100", "XX").Array); result.Num != 0 {
+		t.Errorf("Expected XX to refuse a key without a TTL, got %v", result)
+	}
+	if result := testServer.expire(command("notify:ttl", "100").Array); result.Num != 1 {
+		t.Errorf("Expected EXPIRE to set a TTL, got %v", result)
+	}
+	if result := testServer.ttl(command("notify:ttl").Array); result.Num != 100 {
+		t.Errorf("Expected a TTL of 100, got %v", result)
+	}
+	if result := testServer.expire(command("notify:ttl", "50", "GT").Array); result.Num != 0 {
+		t.Errorf("Expected GT to refuse a shorter TTL, got %v", result)
+	}
+
+	testServer.set(command("notify:ttl", "v").Array)
+	if result := testServer.ttl(command("notify:ttl").Array); result.Num != -1 {
+		t.Errorf("Expected SET to discard the TTL, got %v", result)
+	}
+
+	testServer.expire(command("notify:ttl", "0").Array)
+	if result := testServer.ttl(command("notify:ttl").Array); result.Num != -2 {
+		t.Errorf("Expected a non-positive TTL to delete the key, got %v", result)
+	}
+}
+
+func TestExpireOutOfRange(t *testing.T) {
+	testServer.set(command("notify:range", "v").Array)
+	defer testServer.deleteKey("notify:range")
+
+	tests := []struct {
+		handler func([]Value) Value
+		name    string
+		n       string
+	}{
+		{testServer.expire, "expire", "9223372036854775"},
+		{testServer.expire, "expire", "-9223372036854775"},
+		{testServer.expire, "expire", "9223372036854775807"},
+		{testServer.pexpire, "pexpire", "9223372036854775807"},
+		{testServer.expireat, "expireat", "9223372036854776"},
+		{testServer.expireat, "expireat", "-9223372036854776"},
+	}
+	for _, test := range tests {
+		result := test.handler(command("notify:range", test.n).Array)
+		expected := "ERR invalid expire time in '" + test.name + "' command"
+		if result.Type != "error" || result.Str != expected {
+			t.Errorf("Expected %q for %s %s, got %v", expected, test.name, test.n, result)
+		}
+	}
+	if result := testServer.ttl(command("notify:range").Array); result.Num != -1 {
+		t.Errorf("Expected the key to keep no TTL, got %v", result)
+	}
+
+	// Large values that fit are stored as they are.
+	if result := testServer.expire(command("notify:range", "99999999999999").Array); result.Num != 1 {
+		t.Fatalf("Expected EXPIRE to set a TTL, got %v", result)
+	}
+	if result := testServer.ttl(command("notify:range").Array); result.Num < 99999999999990 {
+		t.Errorf("Expected a TTL of about 99999999999999, got %v", result)
+	}
+}
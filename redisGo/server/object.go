@@ -0,0 +1,430 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// keyAccess records when a key was last used, for OBJECT IDLETIME, and how
+// often, for OBJECT FREQ. The frequency is the logarithmic counter of the
+// LFU policy of Redis: it grows slower the larger it is, and decreases by
+// one every lfu-decay-time minutes without access. Both fields are atomic,
+// so that commands record their accesses with the shard read-locked.
+type keyAccess struct {
+	lastAccess atomic.Int64  // in Unix nanoseconds
+	lfu        atomic.Uint64 // minute of the last decrement << 8 | counter
+}
+
+// The counter of a new key, so that it is not the first to be evicted.
+const lfuInitVal = 5
+
+var maxmemoryPolicies = []string{
+	"volatile-lru", "volatile-lfu", "volatile-random", "volatile-ttl",
+	"allkeys-lru", "allkeys-lfu", "allkeys-random", "noeviction",
+}
+
+// lfuPolicy reports whether an LFU maxmemory-policy is selected.
+func (srv *Server) lfuPolicy() bool {
+	srv.configMu.RLock()
+	defer srv.configMu.RUnlock()
+	return strings.HasSuffix(srv.maxmemoryPolicy, "-lfu")
+}
+
+// Commands that do not count as an access to their keys: those inspecting
+// keys, and RESTORE, which sets the access time itself.
+var noTouchCommands = map[string]bool{
+	"OBJECT":  true,
+	"MEMORY":  true,
+	"DEBUG":   true,
+	"RESTORE": true,
+}
+
+func newKeyAccess(lastAccess time.Time, counter uint8) *keyAccess {
+	a := &keyAccess{}
+	a.lastAccess.Store(lastAccess.UnixNano())
+	a.lfu.Store(uint64(time.Now().Unix()/60)<<8 | uint64(counter))
+	return a
+}
+
+// lfuDecayed returns the counter of the packed lfu field, less one for every
+// decay period since the last decrement, along with the minute of the last
+// decrement.
+func (srv *Server) lfuDecayed(lfu uint64, now time.Time) (uint8, uint64) {
+	counter, lastDecr := uint8(lfu), lfu>>8
+	decayTime := srv.configInt(&srv.lfuDecayTime)
+	minute := uint64(now.Unix() / 60)
+	if decayTime == 0 || minute <= lastDecr {
+		return counter, lastDecr
+	}
+
+	periods := (minute - lastDecr) / uint64(decayTime)
+	if periods == 0 {
+		return counter, lastDecr
+	}
+	if periods >= uint64(counter) {
+		return 0, minute
+	}
+	return counter - uint8(periods), minute
+}
+
+// touch records an access: the counter is incremented with a probability
+// that falls as it grows.
+func (a *keyAccess) touch(srv *Server, now time.Time) {
+	a.lastAccess.Store(now.UnixNano())
+
+	for {
+		old := a.lfu.Load()
+		counter, lastDecr := srv.lfuDecayed(old, now)
+		if counter < 255 {
+			base := float64(counter) - lfuInitVal
+			if base < 0 {
+				base = 0
+			}
+			if rand.Float64() < 1/(base*float64(srv.configInt(&srv.lfuLogFactor))+1) {
+				counter++
+			}
+		}
+
+		lfu := lastDecr<<8 | uint64(counter)
+		if lfu == old || a.lfu.CompareAndSwap(old, lfu) {
+			return
+		}
+	}
+}
+
+// idle returns the time since the last access.
+func (a *keyAccess) idle() time.Duration {
+	return time.Since(time.Unix(0, a.lastAccess.Load()))
+}
+
+// freq returns the access frequency counter.
+func (a *keyAccess) freq(srv *Server) uint8 {
+	counter, _ := srv.lfuDecayed(a.lfu.Load(), time.Now())
+	return counter
+}
+
+// keyAccessOf returns the access record of key. Keys never touched since
+// the server started, like those loaded from the AOF, count as idle since
+// then. The caller must hold the shard lock.
+func (sh *Shard) keyAccessOf(srv *Server, key string) *keyAccess {
+	if a, ok := sh.access[key]; ok {
+		return a
+	}
+	return newKeyAccess(srv.startTime, lfuInitVal)
+}
+
+// touchKeys records an access to the keys of a command that ran. The
+// shard only needs to be locked for writing the first time a key is
+// accessed, and after it is deleted, to add or remove its record.
+func (srv *Server) touchKeys(command string, args []Value) {
+	if noTouchCommands[command] {
+		return
+	}
+
+	now := time.Now()
+	for _, key := range commandKeys(command, args) {
+		sh := srv.shardOf(key)
+		sh.mu.RLock()
+		exists := sh.exists(key)
+		a, tracked := sh.access[key]
+		if exists && tracked {
+			a.touch(srv, now)
+		}
+		sh.mu.RUnlock()
+		if exists == tracked {
+			continue
+		}
+
+		sh.mu.Lock()
+		if !sh.exists(key) {
+			delete(sh.access, key)
+		} else {
+			a, ok := sh.access[key]
+			if !ok {
+				a = newKeyAccess(now, lfuInitVal)
+				sh.access[key] = a
+			}
+			a.touch(srv, now)
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// objectEncoding names the representation of the value at key the way
+// Redis does. The caller must hold the shard lock.
+func objectEncoding(sh *Shard, key string) string {
+	switch sh.typeOf(key) {
+	case "string":
+		if _, ok := sh.ints[key]; ok {
+			return "int"
+		}
+		if len(sh.sets[key]) <= 44 {
+			return "embstr"
+		}
+		return "raw"
+	case "hash":
+		return sh.hsets[key].encoding()
+	case "set":
+		return sh.smembers[key].encoding()
+	case "list":
+		return sh.lists[key].encoding()
+	case "zset":
+		return "skiplist"
+	case "stream":
+		return "stream"
+	}
+	return ""
+}
+
+func (srv *Server) object(args []Value) Value {
+	subcommand := strings.ToUpper(args[0].Bulk)
+	if subcommand == "HELP" {
+		return helpReply("OBJECT",
+			"ENCODING <key>",
+			"    Return the kind of internal representation used in order to store the value",
+			"    associated with a <key>.",
+			"FREQ <key>",
+			"    Return the access frequency index of the <key>. The returned integer is",
+			"    proportional to the logarithm of the recent access frequency of the key.",
+			"IDLETIME <key>",
+			"    Return the idle time of the <key>, that is the approximated number of",
+			"    seconds elapsed since the last access to the key.",
+			"REFCOUNT <key>",
+			"    Return the number of references of the value associated with the specified",
+			"    <key>.",
+		)
+	}
+
+	if len(args) != 2 {
+		return Value{Type: "error", Str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try OBJECT HELP.", args[0].Bulk)}
+	}
+	key := args[1].Bulk
+
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	switch subcommand {
+	case "ENCODING", "FREQ", "IDLETIME", "REFCOUNT":
+		if !sh.exists(key) {
+			return Value{Type: "null"}
+		}
+	default:
+		return Value{Type: "error", Str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try OBJECT HELP.", args[0].Bulk)}
+	}
+
+	access := sh.keyAccessOf(srv, key)
+	switch subcommand {
+	case "ENCODING":
+		return Value{Type: "bulk", Bulk: objectEncoding(sh, key)}
+	case "FREQ":
+		if !srv.lfuPolicy() {
+			return Value{Type: "error", Str: "ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."}
+		}
+		return Value{Type: "integer", Num: int(access.freq(srv))}
+	case "IDLETIME":
+		if srv.lfuPolicy() {
+			return Value{Type: "error", Str: "ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."}
+		}
+		return Value{Type: "integer", Num: int(access.idle() / time.Second)}
+	}
+	// Values are never shared between keys.
+	return Value{Type: "integer", Num: 1}
+}
+
+// helpReply formats the reply of a HELP subcommand.
+func helpReply(command string, lines ...string) Value {
+	values := []Value{{Type: "string", Str: fmt.Sprintf("%s <subcommand> [<arg> [value] [opt] ...]. Subcommands are:", command)}}
+	for _, line := range lines {
+		values = append(values, Value{Type: "string", Str: line})
+	}
+	values = append(values,
+		Value{Type: "string", Str: "HELP"},
+		Value{Type: "string", Str: "    Print this help."},
+	)
+	return Value{Type: "array", Array: values}
+}
+
+// Rough sizes of the Go structures behind each value, in bytes.
+const (
+	stringOverhead = 16 // string header
+	mapOverhead    = 48 // map header
+	entryOverhead  = 24 // map bucket share, tophash and padding
+	keyOverhead    = 56 // shard map entry, string header and expiry
+	zsetNodeSize   = 88 // skip list node with its average 1.33 levels
+	objectOverhead = 40 // hash, set or list object and listpack header
+)
+
+// memoryUsage estimates the bytes used by key and its value. Aggregate
+// values are estimated from their first samples elements, or all of them if
+// samples is 0. The caller must hold the shard lock.
+func memoryUsage(sh *Shard, key string, samples int) int {
+	size := keyOverhead + len(key)
+
+	// estimate extrapolates the size of n elements from the first sampled.
+	estimate := func(n int, each func(yield func(size int) bool)) int {
+		total, seen := 0, 0
+		each(func(size int) bool {
+			total += size
+			seen++
+			return samples == 0 || seen < samples
+		})
+		if seen == 0 {
+			return 0
+		}
+		return total * n / seen
+	}
+
+	switch sh.typeOf(key) {
+	case "string":
+		if _, ok := sh.ints[key]; ok {
+			size += 8
+		} else {
+			size += stringOverhead + len(sh.sets[key])
+		}
+	case "hash":
+		hash := sh.hsets[key]
+		size += objectOverhead
+		if hash.lp != nil {
+			size += cap(hash.lp.buf)
+			break
+		}
+		size += mapOverhead + estimate(len(hash.dict), func(yield func(int) bool) {
+			for field, value := range hash.dict {
+				if !yield(entryOverhead + 2*stringOverhead + len(field) + len(value)) {
+					return
+				}
+			}
+		})
+	case "set":
+		set := sh.smembers[key]
+		size += objectOverhead
+		if set.lp != nil {
+			size += cap(set.lp.buf)
+			break
+		}
+		size += mapOverhead + estimate(len(set.dict), func(yield func(int) bool) {
+			for member := range set.dict {
+				if !yield(entryOverhead + stringOverhead + 1 + len(member)) {
+					return
+				}
+			}
+		})
+	case "list":
+		list := sh.lists[key]
+		size += objectOverhead
+		if list.lp != nil {
+			size += cap(list.lp.buf)
+			break
+		}
+		size += 32 + estimate(list.ql.nodes, func(yield func(int) bool) {
+			for node := list.ql.head; node != nil; node = node.next {
+				if !yield(32 + cap(node.lp.buf)) {
+					return
+				}
+			}
+		})
+	case "zset":
+		z := sh.zsets[key]
+		size += mapOverhead + estimate(z.length, func(yield func(int) bool) {
+			for member := range z.dict {
+				if !yield(zsetNodeSize + entryOverhead + stringOverhead + 8 + len(member)) {
+					return
+				}
+			}
+		})
+	case "stream":
+		s := sh.streams[key]
+		size += 64 + estimate(s.length, func(yield func(int) bool) {
+			for _, node := range s.nodes {
+				for _, entry := range node.entries {
+					entrySize := 16 + 24
+					for _, field := range entry.fields {
+						entrySize += stringOverhead + len(field)
+					}
+					if !yield(entrySize) {
+						return
+					}
+				}
+			}
+		})
+	}
+
+	return size
+}
+
+func (srv *Server) memory(args []Value) Value {
+	switch strings.ToUpper(args[0].Bulk) {
+	case "USAGE":
+		if len(args) != 2 && len(args) != 4 {
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+
+		samples := 5
+		if len(args) == 4 {
+			if strings.ToUpper(args[2].Bulk) != "SAMPLES" {
+				return Value{Type: "error", Str: "ERR syntax error"}
+			}
+			n, err := strconv.Atoi(args[3].Bulk)
+			if err != nil || n < 0 {
+				return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+			}
+			samples = n
+		}
+
+		key := args[1].Bulk
+		srv.expireIfNeeded(key)
+
+		sh := srv.shardOf(key)
+		sh.mu.RLock()
+		defer sh.mu.RUnlock()
+
+		if !sh.exists(key) {
+			return Value{Type: "null"}
+		}
+		return Value{Type: "integer", Num: memoryUsage(sh, key, samples)}
+	case "HELP":
+		return helpReply("MEMORY",
+			"USAGE <key> [SAMPLES <count>]",
+			"    Return memory in bytes used by <key> and its value. Nested values are",
+			"    sampled up to <count> times (default: 5, 0 means sample all).",
+		)
+	}
+	return Value{Type: "error", Str: fmt.Sprintf("ERR unknown subcommand '%s'. Try MEMORY HELP.", args[0].Bulk)}
+}
+
+func (srv *Server) debug(args []Value) Value {
+	switch strings.ToUpper(args[0].Bulk) {
+	case "OBJECT":
+		if len(args) != 2 {
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+		key := args[1].Bulk
+		srv.expireIfNeeded(key)
+
+		sh := srv.shardOf(key)
+		sh.mu.RLock()
+		defer sh.mu.RUnlock()
+
+		payload, ok := dumpValue(sh, key)
+		if !ok {
+			return Value{Type: "error", Str: "ERR no such key"}
+		}
+		idle := int(sh.keyAccessOf(srv, key).idle() / time.Second)
+
+		return Value{Type: "string", Str: fmt.Sprintf("refcount:1 encoding:%s serializedlength:%d lru_seconds_idle:%d",
+			objectEncoding(sh, key), len(payload), idle)}
+	case "HELP":
+		return helpReply("DEBUG",
+			"OBJECT <key>",
+			"    Show low level info about the <key> and associated value.",
+		)
+	}
+	return Value{Type: "error", Str: fmt.Sprintf("ERR unknown subcommand '%s'. Try DEBUG HELP.", args[0].Bulk)}
+}
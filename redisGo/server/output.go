@@ -0,0 +1,284 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Replies are queued on the client and written to its connection by a
+// goroutine of its own, so that a client slow to read them holds up
+// neither the server nor, for PUBLISH, the other subscribers. The queue is
+// bounded by client-output-buffer-limit: a client whose queue goes over
+// the hard limit of its class, or stays over the soft limit for longer
+// than the soft seconds, is disconnected. The size of each request is
+// bounded by client-query-buffer-limit.
+
+// outputLimit is the client-output-buffer-limit of a class of clients.
+// Limits of 0 are disabled.
+type outputLimit struct {
+	hard, soft  int64
+	softSeconds int64
+}
+
+// outputClasses are the classes of clients in the order CONFIG GET lists
+// them, under the name it gives them.
+var outputClasses = []struct{ class, name string }{
+	{"normal", "normal"},
+	{"replica", "slave"},
+	{"pubsub", "pubsub"},
+}
+
+func defaultOutputBufferLimits() map[string]outputLimit {
+	return map[string]outputLimit{
+		"normal":  {},
+		"replica": {hard: 256 << 20, soft: 64 << 20, softSeconds: 60},
+		"pubsub":  {hard: 32 << 20, soft: 8 << 20, softSeconds: 60},
+	}
+}
+
+func (srv *Server) outputLimitConfig() configParam {
+	return configParam{
+		get: func() string {
+			fields := []string{}
+			for _, class := range outputClasses {
+				limit := srv.clientOutputBufferLimits[class.class]
+				fields = append(fields, class.name,
+					strconv.FormatInt(limit.hard, 10), strconv.FormatInt(limit.soft, 10), strconv.FormatInt(limit.softSeconds, 10))
+			}
+			return strings.Join(fields, " ")
+		},
+		set: func(value string) error {
+			fields := strings.Fields(value)
+			if len(fields)%4 != 0 {
+				return fmt.Errorf("Wrong number of arguments in buffer limit configuration.")
+			}
+
+			// Only the classes given change.
+			limits := map[string]outputLimit{}
+			for i := 0; i < len(fields); i += 4 {
+				class := strings.ToLower(fields[i])
+				if class == "slave" {
+					class = "replica"
+				}
+				if _, ok := srv.clientOutputBufferLimits[class]; !ok {
+					return fmt.Errorf("Invalid client class specified in buffer limit configuration.")
+				}
+
+				hard, err1 := parseMemory(fields[i+1])
+				soft, err2 := parseMemory(fields[i+2])
+				seconds, err3 := strconv.ParseInt(fields[i+3], 10, 64)
+				if err1 != nil || err2 != nil || err3 != nil || seconds < 0 {
+					return fmt.Errorf("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+				}
+				limits[class] = outputLimit{hard: hard, soft: soft, softSeconds: seconds}
+			}
+
+			for class, limit := range limits {
+				srv.clientOutputBufferLimits[class] = limit
+			}
+			return nil
+		},
+	}
+}
+
+// memoryConfig is an integer parameter in bytes, which may be given with
+// a unit as in redis.conf.
+func memoryConfig(p *int64, min, max int64) configParam {
+	return configParam{
+		get: func() string {
+			return strconv.FormatInt(*p, 10)
+		},
+		set: func(value string) error {
+			n, err := parseMemory(value)
+			if err != nil {
+				return err
+			}
+			if n < min || n > max {
+				return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+			}
+
+			*p = n
+			return nil
+		},
+	}
+}
+
+var memoryUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"kb", 1 << 10},
+	{"mb", 1 << 20},
+	{"gb", 1 << 30},
+	{"k", 1000},
+	{"m", 1000 * 1000},
+	{"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// parseMemory parses an amount of memory the way redis.conf takes it: a
+// number of bytes, optionally followed by one of the units k, kb, m, mb, g
+// and gb, where k is 1000 bytes and kb 1024.
+func parseMemory(s string) (int64, error) {
+	s = strings.ToLower(s)
+	unit := int64(1)
+	for _, u := range memoryUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSuffix(s, u.suffix), u.bytes
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("argument must be a memory value")
+	}
+	return n * unit, nil
+}
+
+// outputClass returns the client-output-buffer-limit class of the client.
+func (c *Client) outputClass() string {
+	switch {
+	case c.replica.Load():
+		return "replica"
+	case c.subscribed.Load():
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// outputQueue is where the writer of a connected client puts the replies
+// it flushes. Its methods are called with writeMu held.
+type outputQueue struct {
+	c *Client
+}
+
+func (q outputQueue) Write(p []byte) (int, error) {
+	c := q.c
+	if c.isKilled() {
+		return 0, net.ErrClosed
+	}
+
+	c.out = append(c.out, p...)
+	if c.overOutputLimit() {
+		c.srv.stats.outputBufferLimitDisconnections.Add(1)
+		fmt.Printf("Client id=%d addr=%s closed for overcoming of output buffer limits.\n", c.id, c.remoteAddr())
+		c.kill()
+		return 0, net.ErrClosed
+	}
+	return len(p), nil
+}
+
+// overOutputLimit reports whether the replies not yet written to the
+// client are over the limits of its class.
+func (c *Client) overOutputLimit() bool {
+	c.srv.configMu.RLock()
+	limit := c.srv.clientOutputBufferLimits[c.outputClass()]
+	c.srv.configMu.RUnlock()
+
+	pending := int64(len(c.out) + c.sending)
+	if limit.hard > 0 && pending > limit.hard {
+		return true
+	}
+	if limit.soft == 0 || pending <= limit.soft {
+		c.softLimitSince = time.Time{}
+		return false
+	}
+
+	if c.softLimitSince.IsZero() {
+		c.softLimitSince = time.Now()
+		return false
+	}
+	return time.Since(c.softLimitSince) > time.Duration(limit.softSeconds)*time.Second
+}
+
+// spareOutputSize is the largest buffer kept for the next replies once
+// its own were written, so that large ones are not held on to.
+const spareOutputSize = 16 << 10
+
+// resetSoftLimit restarts the soft limit timer once the replies of a client
+// drained to the soft limit or below, so that the time it spends over the
+// limit is only counted while it stays there. It is called with writeMu
+// held.
+func (c *Client) resetSoftLimit() {
+	if c.softLimitSince.IsZero() {
+		return
+	}
+
+	c.srv.configMu.RLock()
+	limit := c.srv.clientOutputBufferLimits[c.outputClass()]
+	c.srv.configMu.RUnlock()
+
+	if int64(len(c.out)+c.sending) <= limit.soft {
+		c.softLimitSince = time.Time{}
+	}
+}
+
+// writeOut writes the queued replies to the connection until none are
+// left, unless another goroutine is already at it, which then writes
+// these too.
+func (c *Client) writeOut() error {
+	c.writeMu.Lock()
+	if c.writing {
+		c.writeMu.Unlock()
+		return nil
+	}
+	c.writing = true
+
+	for len(c.out) > 0 && !c.isKilled() {
+		out := c.out
+		c.out, c.spare = c.spare, nil
+		c.sending = len(out)
+		c.writeMu.Unlock()
+
+		_, err := c.conn.Write(out)
+
+		c.writeMu.Lock()
+		c.sending = 0
+		if cap(out) <= spareOutputSize {
+			c.spare = out[:0]
+		}
+		if err != nil {
+			c.writing = false
+			c.writeMu.Unlock()
+			c.kill()
+			return err
+		}
+		c.resetSoftLimit()
+	}
+
+	c.writing = false
+	c.writeMu.Unlock()
+	return nil
+}
+
+// send writes the replies flushed by Flush, such as the messages of
+// PUBLISH, until the client is killed.
+func (c *Client) send() {
+	for {
+		select {
+		case <-c.outReady:
+			c.writeOut()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// sendNow flushes the replies and writes them on the calling goroutine,
+// blocking until the client has taken them. The connection loop sends the
+// replies to its commands this way, sparing send the work.
+func (c *Client) sendNow() error {
+	c.writeMu.Lock()
+	err := c.writer.Flush()
+	c.writeMu.Unlock()
+
+	if err != nil || c.conn == nil {
+		return err
+	}
+	return c.writeOut()
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"redisGo/resp"
+)
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+	}{
+		{"100", 100},
+		{"1k", 1000},
+		{"1kb", 1024},
+		{"32MB", 32 << 20},
+		{"1gb", 1 << 30},
+		{"10b", 10},
+	}
+	for _, test := range tests {
+		if got, err := parseMemory(test.s); err != nil || got != test.want {
+			t.Errorf("Expected %d for %q, got %d, %v", test.want, test.s, got, err)
+		}
+	}
+
+	for _, s := range []string{"", "mb", "-1", "1tb", "99999999999gb"} {
+		if _, err := parseMemory(s); err == nil {
+			t.Errorf("Expected an error for %q", s)
+		}
+	}
+}
+
+func TestOutputBufferLimitConfig(t *testing.T) {
+	defer testServer.configSet([]Value{{Type: "bulk", Bulk: "client-output-buffer-limit"}, {Type: "bulk", Bulk: testServer.configParams["client-output-buffer-limit"].get()}})
+
+	// Only the classes given change.
+	testServer.configSet([]Value{{Type: "bulk", Bulk: "client-output-buffer-limit"}, {Type: "bulk", Bulk: "slave 1mb 512kb 10"}})
+	want := "normal 0 0 0 slave 1048576 524288 10 pubsub 33554432 8388608 60"
+	if got := testServer.configParams["client-output-buffer-limit"].get(); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	for _, value := range []string{"normal 0 0", "other 0 0 0", "pubsub 1x 0 0"} {
+		reply := testServer.configSet([]Value{{Type: "bulk", Bulk: "client-output-buffer-limit"}, {Type: "bulk", Bulk: value}})
+		if reply.Type != "error" {
+			t.Errorf("Expected an error setting %q, got %v", value, reply)
+		}
+	}
+}
+
+func TestOutputBufferLimit(t *testing.T) {
+	defer testServer.configSet([]Value{{Type: "bulk", Bulk: "client-output-buffer-limit"}, {Type: "bulk", Bulk: testServer.configParams["client-output-buffer-limit"].get()}})
+	testServer.configSet([]Value{{Type: "bulk", Bulk: "client-output-buffer-limit"}, {Type: "bulk", Bulk: "pubsub 1kb 0 0"}})
+	disconnections := testServer.stats.outputBufferLimitDisconnections.Load()
+
+	// Nobody reads from the other end of the pipe: the subscriber is as
+	// slow as can be.
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	subscriber := testServer.newClient(conn)
+	defer testServer.unsubscribeAll(subscriber)
+	testServer.call(subscriber, command("SUBSCRIBE", "obuf"))
+
+	// Publishing does not wait for the subscriber, which is disconnected
+	// once its replies are over the hard limit.
+	for i := 0; i < 4; i++ {
+		testServer.publishMessage("obuf", string(make([]byte, 600)))
+	}
+	if !subscriber.isKilled() {
+		t.Errorf("Expected the subscriber to be disconnected")
+	}
+	if got := testServer.stats.outputBufferLimitDisconnections.Load() - disconnections; got != 1 {
+		t.Errorf("Expected 1 disconnection, got %d", got)
+	}
+}
+
+func TestOutputSoftLimitReset(t *testing.T) {
+	defer testServer.configSet([]Value{{Type: "bulk", Bulk: "client-output-buffer-limit"}, {Type: "bulk", Bulk: testServer.configParams["client-output-buffer-limit"].get()}})
+	testServer.configSet([]Value{{Type: "bulk", Bulk: "client-output-buffer-limit"}, {Type: "bulk", Bulk: "pubsub 0 1kb 60"}})
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	subscriber := testServer.newClient(conn)
+	defer testServer.unsubscribeAll(subscriber)
+	testServer.call(subscriber, command("SUBSCRIBE", "obuf:soft"))
+
+	// The subscriber goes over the soft limit while nobody reads.
+	for i := 0; i < 2; i++ {
+		testServer.publishMessage("obuf:soft", string(make([]byte, 600)))
+	}
+	subscriber.writeMu.Lock()
+	over := !subscriber.softLimitSince.IsZero()
+	subscriber.writeMu.Unlock()
+	if !over {
+		t.Fatalf("Expected the subscriber to be over the soft limit")
+	}
+
+	// Once it reads its replies, the time it spent over the limit no
+	// longer counts.
+	go io.Copy(io.Discard, peer)
+	deadline := time.Now().Add(time.Second)
+	for {
+		subscriber.writeMu.Lock()
+		drained := len(subscriber.out)+subscriber.sending == 0 && !subscriber.writing
+		since := subscriber.softLimitSince
+		subscriber.writeMu.Unlock()
+
+		if drained {
+			if !since.IsZero() {
+				t.Errorf("Expected the soft limit timer to be reset, got %v", since)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the subscriber to drain its replies")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if subscriber.isKilled() {
+		t.Errorf("Expected the subscriber to stay connected")
+	}
+}
+
+func TestQueryBufferLimit(t *testing.T) {
+	disconnections := testServer.stats.queryBufferLimitDisconnections.Load()
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	go testServer.handleConnection(conn)
+
+	// A bulk string over the limit is refused before it is read.
+	go peer.Write([]byte("*2\r\n$3\r\nSET\r\n$2000000000\r\n"))
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := resp.NewResp(peer).Read(); err != io.EOF {
+		t.Errorf("Expected the connection to be closed, got %v", err)
+	}
+	if got := testServer.stats.queryBufferLimitDisconnections.Load() - disconnections; got != 1 {
+		t.Errorf("Expected 1 disconnection, got %d", got)
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"redisGo/resp"
+)
+
+// startTestServer serves connections on a loopback port the same way Serve
+// does, returning the address to dial.
+func startTestServer(tb testing.TB) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go testServer.handleConnection(conn)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// pipeline returns the encoding of n SET commands followed by a GET of the
+// last key.
+func pipeline(prefix string, n int) []byte {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.Write(command("SET", fmt.Sprintf("%s:%d", prefix, i), "v").Marshal())
+	}
+	b.Write(command("GET", fmt.Sprintf("%s:%d", prefix, n-1)).Marshal())
+	return []byte(b.String())
+}
+
+func TestPipelinedCommands(t *testing.T) {
+	conn, err := net.Dial("tcp", startTestServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Send the whole pipeline in a single write so that the server reads
+	// several commands at once.
+	if _, err := conn.Write(pipeline("pipeline", 500)); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := resp.NewResp(conn)
+	for i := 0; i < 500; i++ {
+		value, err := reader.Read()
+		if err != nil {
+			t.Fatalf("Reading reply %d: %v", i, err)
+		}
+		if value.Type != "string" || value.Str != "OK" {
+			t.Fatalf("Expected OK for command %d, got %v", i, value)
+		}
+	}
+
+	value, err := reader.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.Type != "bulk" || value.Bulk != "v" {
+		t.Errorf("Expected the reply to the last command, got %v", value)
+	}
+}
+
+func TestProtocolError(t *testing.T) {
+	conn, err := net.Dial("tcp", startTestServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The commands before the bad type byte are answered, then the
+	// connection is closed after the error.
+	conn.Write(append(command("PING").Marshal(), "?x\r\n"...))
+	reader := resp.NewResp(conn)
+	if value, err := reader.Read(); err != nil || value.Str != "PONG" {
+		t.Fatalf("Expected PONG, got %v, %v", value, err)
+	}
+	if value, err := reader.Read(); err != nil || value.Type != "error" || !strings.HasPrefix(value.Str, "ERR Protocol error") {
+		t.Errorf("Expected a protocol error, got %v, %v", value, err)
+	}
+	if _, err := reader.Read(); err == nil {
+		t.Errorf("Expected the connection to be closed")
+	}
+}
+
+func benchmarkPipeline(b *testing.B, depth int) {
+	conn, err := net.Dial("tcp", startTestServer(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	request := pipeline("bench", depth)
+	reader := bufio.NewReader(conn)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write(request); err != nil {
+			b.Fatal(err)
+		}
+
+		// Every SET answers +OK and the GET answers a bulk string spanning
+		// two lines.
+		for j := 0; j < depth+2; j++ {
+			if _, err := reader.ReadSlice('\n'); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.ReportMetric(float64(b.N*(depth+1))/b.Elapsed().Seconds(), "commands/s")
+}
+
+func BenchmarkPipeline1(b *testing.B)   { benchmarkPipeline(b, 1) }
+func BenchmarkPipeline16(b *testing.B)  { benchmarkPipeline(b, 16) }
+func BenchmarkPipeline100(b *testing.B) { benchmarkPipeline(b, 100) }
+func BenchmarkPipeline500(b *testing.B) { benchmarkPipeline(b, 500) }
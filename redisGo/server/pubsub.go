@@ -0,0 +1,261 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Commands a client may still issue once it has subscribed to something.
+var pubsubCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"PSUBSCRIBE":   true,
+	"UNSUBSCRIBE":  true,
+	"PUNSUBSCRIBE": true,
+	"PING":         true,
+	"QUIT":         true,
+	"RESET":        true,
+}
+
+func (c *Client) subscriptions() int {
+	c.srv.pubSubMu.RLock()
+	defer c.srv.pubSubMu.RUnlock()
+
+	return len(c.channels) + len(c.patterns)
+}
+
+func pubsubReply(kind, name string, count int) Value {
+	target := Value{Type: "bulk", Bulk: name}
+	if name == "" {
+		target = Value{Type: "null"}
+	}
+
+	return Value{Type: "push", Array: []Value{
+		{Type: "bulk", Bulk: kind},
+		target,
+		{Type: "integer", Num: count},
+	}}
+}
+
+// writeReplies sends every reply but the last one directly, leaving the last
+// one for the caller, since (un)subscribing to several channels answers
+// once per channel.
+func writeReplies(c *Client, replies []Value) Value {
+	for _, reply := range replies[:len(replies)-1] {
+		c.Write(reply)
+	}
+	return replies[len(replies)-1]
+}
+
+func (srv *Server) subscribe(c *Client, args []Value) Value {
+	srv.pubSubMu.Lock()
+	replies := []Value{}
+	for _, arg := range args {
+		channel := arg.Bulk
+		if !c.channels[channel] {
+			c.channels[channel] = true
+			if srv.pubSubChannels[channel] == nil {
+				srv.pubSubChannels[channel] = map[*Client]bool{}
+			}
+			srv.pubSubChannels[channel][c] = true
+		}
+		replies = append(replies, pubsubReply("subscribe", channel, len(c.channels)+len(c.patterns)))
+	}
+	c.subscribed.Store(len(c.channels)+len(c.patterns) > 0)
+	srv.pubSubMu.Unlock()
+
+	return writeReplies(c, replies)
+}
+
+func (srv *Server) psubscribe(c *Client, args []Value) Value {
+	srv.pubSubMu.Lock()
+	replies := []Value{}
+	for _, arg := range args {
+		pattern := arg.Bulk
+		if !c.patterns[pattern] {
+			c.patterns[pattern] = true
+			if srv.pubSubPatterns[pattern] == nil {
+				srv.pubSubPatterns[pattern] = map[*Client]bool{}
+			}
+			srv.pubSubPatterns[pattern][c] = true
+		}
+		replies = append(replies, pubsubReply("psubscribe", pattern, len(c.channels)+len(c.patterns)))
+	}
+	c.subscribed.Store(len(c.channels)+len(c.patterns) > 0)
+	srv.pubSubMu.Unlock()
+
+	return writeReplies(c, replies)
+}
+
+func (srv *Server) unsubscribe(c *Client, args []Value) Value {
+	srv.pubSubMu.Lock()
+	channels := []string{}
+	for _, arg := range args {
+		channels = append(channels, arg.Bulk)
+	}
+	if len(args) == 0 {
+		channels = sortedSet(c.channels)
+	}
+
+	replies := []Value{}
+	for _, channel := range channels {
+		delete(c.channels, channel)
+		delete(srv.pubSubChannels[channel], c)
+		if len(srv.pubSubChannels[channel]) == 0 {
+			delete(srv.pubSubChannels, channel)
+		}
+		replies = append(replies, pubsubReply("unsubscribe", channel, len(c.channels)+len(c.patterns)))
+	}
+	if len(replies) == 0 {
+		replies = append(replies, pubsubReply("unsubscribe", "", len(c.patterns)))
+	}
+	c.subscribed.Store(len(c.channels)+len(c.patterns) > 0)
+	srv.pubSubMu.Unlock()
+
+	return writeReplies(c, replies)
+}
+
+func (srv *Server) punsubscribe(c *Client, args []Value) Value {
+	srv.pubSubMu.Lock()
+	patterns := []string{}
+	for _, arg := range args {
+		patterns = append(patterns, arg.Bulk)
+	}
+	if len(args) == 0 {
+		patterns = sortedSet(c.patterns)
+	}
+
+	replies := []Value{}
+	for _, pattern := range patterns {
+		delete(c.patterns, pattern)
+		delete(srv.pubSubPatterns[pattern], c)
+		if len(srv.pubSubPatterns[pattern]) == 0 {
+			delete(srv.pubSubPatterns, pattern)
+		}
+		replies = append(replies, pubsubReply("punsubscribe", pattern, len(c.channels)+len(c.patterns)))
+	}
+	if len(replies) == 0 {
+		replies = append(replies, pubsubReply("punsubscribe", "", len(c.channels)))
+	}
+	c.subscribed.Store(len(c.channels)+len(c.patterns) > 0)
+	srv.pubSubMu.Unlock()
+
+	return writeReplies(c, replies)
+}
+
+// unsubscribeAll drops every subscription of a disconnecting client.
+func (srv *Server) unsubscribeAll(c *Client) {
+	srv.pubSubMu.Lock()
+	defer srv.pubSubMu.Unlock()
+
+	for channel := range c.channels {
+		delete(srv.pubSubChannels[channel], c)
+		if len(srv.pubSubChannels[channel]) == 0 {
+			delete(srv.pubSubChannels, channel)
+		}
+	}
+	for pattern := range c.patterns {
+		delete(srv.pubSubPatterns[pattern], c)
+		if len(srv.pubSubPatterns[pattern]) == 0 {
+			delete(srv.pubSubPatterns, pattern)
+		}
+	}
+
+	c.channels = map[string]bool{}
+	c.patterns = map[string]bool{}
+	c.subscribed.Store(false)
+}
+
+// publishMessage delivers message to the subscribers of channel and of
+// every matching pattern, returning the number of clients reached.
+func (srv *Server) publishMessage(channel, message string) int {
+	srv.pubSubMu.RLock()
+	defer srv.pubSubMu.RUnlock()
+
+	receivers := 0
+
+	for c := range srv.pubSubChannels[channel] {
+		c.Write(Value{Type: "push", Array: []Value{
+			{Type: "bulk", Bulk: "message"},
+			{Type: "bulk", Bulk: channel},
+			{Type: "bulk", Bulk: message},
+		}})
+		receivers++
+	}
+
+	for pattern, clients := range srv.pubSubPatterns {
+		if !stringMatch(pattern, channel, false) {
+			continue
+		}
+
+		for c := range clients {
+			c.Write(Value{Type: "push", Array: []Value{
+				{Type: "bulk", Bulk: "pmessage"},
+				{Type: "bulk", Bulk: pattern},
+				{Type: "bulk", Bulk: channel},
+				{Type: "bulk", Bulk: message},
+			}})
+			receivers++
+		}
+	}
+
+	return receivers
+}
+
+func (srv *Server) publish(args []Value) Value {
+	return Value{Type: "integer", Num: srv.publishMessage(args[0].Bulk, args[1].Bulk)}
+}
+
+func (srv *Server) pubsub(args []Value) Value {
+	srv.pubSubMu.RLock()
+	defer srv.pubSubMu.RUnlock()
+
+	switch strings.ToUpper(args[0].Bulk) {
+	case "CHANNELS":
+		if len(args) > 2 {
+			return Value{Type: "error", Str: "ERR wrong number of arguments for 'pubsub|channels' command"}
+		}
+
+		values := []Value{}
+		for _, channel := range sortedKeys(srv.pubSubChannels) {
+			if len(args) == 2 && !stringMatch(args[1].Bulk, channel, false) {
+				continue
+			}
+			values = append(values, Value{Type: "bulk", Bulk: channel})
+		}
+
+		return Value{Type: "array", Array: values}
+	case "NUMSUB":
+		values := []Value{}
+		for _, arg := range args[1:] {
+			values = append(values,
+				Value{Type: "bulk", Bulk: arg.Bulk},
+				Value{Type: "integer", Num: len(srv.pubSubChannels[arg.Bulk])},
+			)
+		}
+
+		return Value{Type: "array", Array: values}
+	case "NUMPAT":
+		return Value{Type: "integer", Num: len(srv.pubSubPatterns)}
+	default:
+		return Value{Type: "error", Str: fmt.Sprintf("ERR unknown subcommand '%s'", args[0].Bulk)}
+	}
+}
+
+func sortedSet(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedKeys(m map[string]map[*Client]bool) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
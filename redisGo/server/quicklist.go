@@ -0,0 +1,121 @@
+package server
+
+import "encoding/binary"
+
+// quicklist is the encoding of a list that outgrew a single listpack, as in
+// Redis: a doubly linked list of listpacks, each within
+// list-max-listpack-size, so that pushes and pops at either end only touch
+// the small listpack there, and popped elements release their node once it
+// is empty.
+type quicklist struct {
+	head, tail *quicklistNode
+	count      int
+	nodes      int
+}
+
+type quicklistNode struct {
+	prev, next *quicklistNode
+	lp         *listpack
+}
+
+// listpackFits reports whether a listpack of count entries in size bytes
+// is within list-max-listpack-size.
+func (srv *Server) listpackFits(count, size int) bool {
+	limit := srv.configInt(&srv.listMaxListpackSize)
+	if limit >= 0 {
+		return int64(count) <= limit
+	}
+	return size <= 4096<<min(-limit-1, 4)
+}
+
+// push adds value at the head or the tail, in the listpack there if it
+// still fits, in a new node otherwise.
+func (ql *quicklist) push(srv *Server, value string, head bool) {
+	node := ql.tail
+	if head {
+		node = ql.head
+	}
+
+	size := len(binary.AppendUvarint(nil, uint64(len(value)))) + len(value)
+	if node == nil || !srv.listpackFits(node.lp.count+1, len(node.lp.buf)+size) {
+		node = &quicklistNode{lp: &listpack{}}
+		ql.link(node, head)
+	}
+
+	if head {
+		node.lp.insert(0, value)
+	} else {
+		node.lp.append(value)
+	}
+	ql.count++
+}
+
+// link adds an empty node at the head or the tail.
+func (ql *quicklist) link(node *quicklistNode, head bool) {
+	if ql.head == nil {
+		ql.head, ql.tail = node, node
+	} else if head {
+		node.next = ql.head
+		ql.head.prev = node
+		ql.head = node
+	} else {
+		node.prev = ql.tail
+		ql.tail.next = node
+		ql.tail = node
+	}
+	ql.nodes++
+}
+
+// pop removes and returns the element at the head or the tail, which must
+// exist.
+func (ql *quicklist) pop(head bool) string {
+	node, i := ql.head, 0
+	if !head {
+		node, i = ql.tail, ql.tail.lp.count-1
+	}
+
+	value := node.lp.get(i)
+	node.lp.remove(i, 1)
+	ql.count--
+
+	if node.lp.count == 0 {
+		ql.unlink(node)
+	}
+	return value
+}
+
+func (ql *quicklist) unlink(node *quicklistNode) {
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		ql.head = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		ql.tail = node.prev
+	}
+	node.prev, node.next = nil, nil
+	ql.nodes--
+}
+
+// rangeElements returns the elements from start to stop, which must be
+// valid indexes, skipping the nodes before start as a whole.
+func (ql *quicklist) rangeElements(start, stop int) []string {
+	elements := make([]string, 0, stop-start+1)
+
+	i := 0
+	for node := ql.head; node != nil && i <= stop; node = node.next {
+		if i+node.lp.count <= start {
+			i += node.lp.count
+			continue
+		}
+		for _, entry := range node.lp.entries() {
+			if i >= start && i <= stop {
+				elements = append(elements, entry)
+			}
+			i++
+		}
+	}
+	return elements
+}
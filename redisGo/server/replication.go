@@ -0,0 +1,435 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"redisGo/resp"
+)
+
+// Replication. A replica connects to its primary and sends REPLCONF
+// listening-port and SYNC. The primary answers +FULLRESYNC with its
+// replication offset, followed by a bulk string holding the commands that
+// rebuild its dataset, and from then on streams every command it
+// propagates. The replica applies both as if a client had sent them.
+
+// A replica applies the stream of its primary through call, which looks
+// commands up in handlers, so REPLICAOF is registered at init time to avoid
+// an initialization cycle.
+func init() {
+	handlers["REPLICAOF"] = (*Server).replicaof
+	handlers["SLAVEOF"] = (*Server).replicaof
+}
+
+type replicaInfo struct {
+	port      int
+	ackOffset int64
+}
+
+func (srv *Server) isReplica() bool {
+	srv.replicationMu.Lock()
+	defer srv.replicationMu.Unlock()
+
+	return srv.masterHost != ""
+}
+
+// replicationFeed streams a propagated command to the replicas.
+func (srv *Server) replicationFeed(value Value) {
+	// A replica's offset follows the stream of its primary instead.
+	replica := srv.isReplica()
+
+	srv.replicasMu.Lock()
+	defer srv.replicasMu.Unlock()
+
+	if !replica {
+		srv.replOffset.Add(int64(len(value.Marshal())))
+	}
+	for c := range srv.replicas {
+		c.Write(value)
+	}
+}
+
+func (srv *Server) removeReplica(c *Client) {
+	srv.replicasMu.Lock()
+	delete(srv.replicas, c)
+	c.replica.Store(false)
+	srv.replicasMu.Unlock()
+}
+
+func (srv *Server) replconf(c *Client, args []Value) Value {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return Value{Type: "error", Str: "ERR wrong number of arguments for 'replconf' command"}
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		switch strings.ToLower(args[i].Bulk) {
+		case "listening-port":
+			port, err := strconv.Atoi(args[i+1].Bulk)
+			if err != nil {
+				return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+			}
+			c.mu.Lock()
+			c.replicaPort = port
+			c.mu.Unlock()
+		case "ack":
+			offset, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return Value{}
+			}
+			srv.replicasMu.Lock()
+			if info, ok := srv.replicas[c]; ok {
+				info.ackOffset = offset
+			}
+			srv.replicasMu.Unlock()
+
+			// Acknowledgements are not answered.
+			return Value{}
+		case "capa":
+		default:
+			return Value{Type: "error", Str: fmt.Sprintf("ERR Unrecognized REPLCONF option: %s", args[i].Bulk)}
+		}
+	}
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+// syncCommand starts streaming to a new replica. The dataset is copied with
+// every other command held off, so that each write reaches the replica
+// exactly once: either within the copy or streamed after it.
+func (srv *Server) syncCommand(c *Client, args []Value) Value {
+	srv.execMu.RUnlock()
+	srv.execMu.Lock()
+	defer func() {
+		srv.execMu.Unlock()
+		srv.execMu.RLock()
+	}()
+
+	var payload bytes.Buffer
+	srv.writeDataset(&payload)
+
+	c.writeBuffered(Value{Type: "string", Str: fmt.Sprintf("FULLRESYNC %d", srv.replOffset.Load())})
+	c.writeBuffered(Value{Type: "bulk", Bulk: payload.String()})
+	c.Flush()
+
+	c.mu.Lock()
+	port := c.replicaPort
+	c.mu.Unlock()
+
+	srv.replicasMu.Lock()
+	srv.replicas[c] = &replicaInfo{port: port, ackOffset: srv.replOffset.Load()}
+	c.replica.Store(true)
+	srv.replicasMu.Unlock()
+
+	// Everything has been written already.
+	return Value{}
+}
+
+// commandValue encodes a command the way clients send it.
+func commandValue(args ...string) Value {
+	value := Value{Type: "array"}
+	for _, arg := range args {
+		value.Array = append(value.Array, Value{Type: "bulk", Bulk: arg})
+	}
+	return value
+}
+
+func (srv *Server) replicaof(args []Value) Value {
+	if srv.clusterEnabled {
+		return Value{Type: "error", Str: "ERR REPLICAOF not allowed in cluster mode."}
+	}
+
+	srv.replicationMu.Lock()
+	defer srv.replicationMu.Unlock()
+
+	if strings.ToUpper(args[0].Bulk) == "NO" && strings.ToUpper(args[1].Bulk) == "ONE" {
+		if srv.masterHost != "" {
+			fmt.Println("MASTER MODE enabled")
+			srv.replicationStop()
+		}
+		return Value{Type: "string", Str: "OK"}
+	}
+
+	port, err := strconv.Atoi(args[1].Bulk)
+	if err != nil || port <= 0 || port > 65535 {
+		return Value{Type: "error", Str: "ERR Invalid master port"}
+	}
+	if srv.masterHost == args[0].Bulk && srv.masterPort == port {
+		return Value{Type: "string", Str: "OK Already connected to specified master"}
+	}
+
+	srv.replicationStop()
+	srv.masterHost, srv.masterPort = args[0].Bulk, port
+	fmt.Printf("REPLICAOF %s:%d enabled\n", srv.masterHost, srv.masterPort)
+	gen, host := srv.replicationGen, srv.masterHost
+	srv.goBackground(func() { srv.replicationLink(gen, host, port) })
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+// replicationStop drops the link to the current primary, if any. It must be
+// called with replicationMu held.
+func (srv *Server) replicationStop() {
+	srv.replicationGen++
+	srv.masterHost, srv.masterPort = "", 0
+	srv.masterLinkUp = false
+	if srv.masterConn != nil {
+		srv.masterConn.Close()
+		srv.masterConn = nil
+	}
+}
+
+// replicationLink keeps a replica connected to its primary, resyncing
+// every time the link is lost, until REPLICAOF points elsewhere or the
+// server is closed.
+func (srv *Server) replicationLink(gen int, host string, primaryPort int) {
+	for {
+		srv.replicationMu.Lock()
+		stale := gen != srv.replicationGen
+		srv.replicationMu.Unlock()
+		if stale {
+			return
+		}
+
+		if err := srv.replicationSync(gen, host, primaryPort); err != nil {
+			fmt.Printf("Replication link with %s:%d lost: %v\n", host, primaryPort, err)
+		}
+
+		srv.replicationMu.Lock()
+		if gen == srv.replicationGen {
+			srv.masterLinkUp = false
+			srv.masterConn = nil
+		}
+		srv.replicationMu.Unlock()
+
+		select {
+		case <-time.After(time.Second):
+		case <-srv.done:
+			return
+		}
+	}
+}
+
+func (srv *Server) replicationSync(gen int, host string, primaryPort int) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(primaryPort)), time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	srv.replicationMu.Lock()
+	if gen != srv.replicationGen {
+		srv.replicationMu.Unlock()
+		return nil
+	}
+	srv.masterConn = conn
+	srv.replicationMu.Unlock()
+
+	reader := resp.NewResp(conn)
+	request := func(args ...string) (Value, error) {
+		if _, err := conn.Write(commandValue(args...).Marshal()); err != nil {
+			return Value{}, err
+		}
+		return reader.Read()
+	}
+
+	if _, err := request("REPLCONF", "listening-port", strconv.Itoa(srv.port)); err != nil {
+		return err
+	}
+	reply, err := request("SYNC")
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(reply.Str)
+	if reply.Type != "string" || len(fields) != 2 || fields[0] != "FULLRESYNC" {
+		return fmt.Errorf("unexpected reply to SYNC: %v", reply)
+	}
+	offset, _ := strconv.ParseInt(fields[1], 10, 64)
+
+	payload, err := reader.Read()
+	if err != nil {
+		return err
+	}
+
+	master := srv.newClient(conn)
+	master.master = true
+	srv.addClient(master)
+	defer srv.removeClient(master)
+	defer master.kill()
+
+	// Replace our dataset with the primary's.
+	srv.execMu.Lock()
+	srv.flushKeyspace()
+	srv.execMu.Unlock()
+
+	commands := resp.NewResp(strings.NewReader(payload.Bulk))
+	for {
+		value, err := commands.Read()
+		if err != nil {
+			break
+		}
+		srv.call(master, value)
+	}
+
+	srv.replOffset.Store(offset)
+
+	srv.replicationMu.Lock()
+	srv.masterLinkUp = true
+	srv.masterLastIO = time.Now()
+	srv.replicationMu.Unlock()
+	fmt.Printf("MASTER <-> REPLICA sync with %s:%d succeeded\n", host, primaryPort)
+
+	done := make(chan struct{})
+	defer close(done)
+	applied := make(chan struct{}, 1)
+	srv.goBackground(func() { srv.replicationAck(conn, applied, done) })
+
+	for {
+		value, err := reader.Read()
+		if err != nil {
+			return err
+		}
+
+		srv.replicationMu.Lock()
+		stale := gen != srv.replicationGen
+		srv.masterLastIO = time.Now()
+		srv.replicationMu.Unlock()
+		if stale {
+			return nil
+		}
+
+		if value.Type != "array" || len(value.Array) == 0 {
+			continue
+		}
+		srv.call(master, value)
+		srv.replOffset.Add(int64(len(value.Marshal())))
+
+		// Acknowledge once everything received so far is applied.
+		if reader.Buffered() == 0 {
+			select {
+			case applied <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// replicationAck reports the replica's offset to the primary every second,
+// and as soon as applied signals the replica caught up with the stream, so
+// that ROLE and INFO on the primary show it without waiting for the next
+// second.
+func (srv *Server) replicationAck(conn net.Conn, applied, done chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		case <-applied:
+		}
+
+		ack := commandValue("REPLCONF", "ACK", strconv.FormatInt(srv.replOffset.Load(), 10))
+		if _, err := conn.Write(ack.Marshal()); err != nil {
+			return
+		}
+	}
+}
+
+// role answers ROLE: the replicas and their offsets for a primary, or the
+// primary and the link state for a replica.
+func (srv *Server) role(args []Value) Value {
+	if srv.sentinelMode {
+		return srv.sentinelRole()
+	}
+
+	srv.replicationMu.Lock()
+	host, port, up := srv.masterHost, srv.masterPort, srv.masterLinkUp
+	srv.replicationMu.Unlock()
+
+	if host != "" {
+		state := "connect"
+		if up {
+			state = "connected"
+		}
+		return Value{Type: "array", Array: []Value{
+			{Type: "bulk", Bulk: "slave"},
+			{Type: "bulk", Bulk: host},
+			{Type: "integer", Num: port},
+			{Type: "bulk", Bulk: state},
+			{Type: "integer", Num: int(srv.replOffset.Load())},
+		}}
+	}
+
+	replicas := []Value{}
+	for _, r := range srv.sortedReplicas() {
+		replicas = append(replicas, Value{Type: "array", Array: []Value{
+			{Type: "bulk", Bulk: r.ip},
+			{Type: "bulk", Bulk: strconv.Itoa(r.port)},
+			{Type: "bulk", Bulk: strconv.FormatInt(r.ackOffset, 10)},
+		}})
+	}
+
+	return Value{Type: "array", Array: []Value{
+		{Type: "bulk", Bulk: "master"},
+		{Type: "integer", Num: int(srv.replOffset.Load())},
+		{Type: "array", Array: replicas},
+	}}
+}
+
+type replicaStatus struct {
+	ip        string
+	port      int
+	ackOffset int64
+}
+
+func (srv *Server) sortedReplicas() []replicaStatus {
+	srv.replicasMu.Lock()
+	defer srv.replicasMu.Unlock()
+
+	replicas := []replicaStatus{}
+	for c, info := range srv.replicas {
+		ip, _, _ := net.SplitHostPort(c.remoteAddr())
+		replicas = append(replicas, replicaStatus{ip: ip, port: info.port, ackOffset: info.ackOffset})
+	}
+	sort.Slice(replicas, func(i, j int) bool {
+		return replicas[i].port < replicas[j].port
+	})
+	return replicas
+}
+
+func (srv *Server) infoReplication(sb *strings.Builder) {
+	srv.replicationMu.Lock()
+	host, port, up, lastIO := srv.masterHost, srv.masterPort, srv.masterLinkUp, srv.masterLastIO
+	srv.replicationMu.Unlock()
+
+	if host == "" {
+		fmt.Fprintf(sb, "role:master\r\n")
+	} else {
+		status := "down"
+		if up {
+			status = "up"
+		}
+		fmt.Fprintf(sb, "role:slave\r\n")
+		fmt.Fprintf(sb, "master_host:%s\r\n", host)
+		fmt.Fprintf(sb, "master_port:%d\r\n", port)
+		fmt.Fprintf(sb, "master_link_status:%s\r\n", status)
+		fmt.Fprintf(sb, "master_last_io_seconds_ago:%d\r\n", int(time.Since(lastIO).Seconds()))
+		fmt.Fprintf(sb, "master_sync_in_progress:0\r\n")
+		fmt.Fprintf(sb, "slave_repl_offset:%d\r\n", srv.replOffset.Load())
+		fmt.Fprintf(sb, "slave_priority:100\r\n")
+		fmt.Fprintf(sb, "slave_read_only:1\r\n")
+	}
+
+	replicas := srv.sortedReplicas()
+	fmt.Fprintf(sb, "connected_slaves:%d\r\n", len(replicas))
+	for i, r := range replicas {
+		fmt.Fprintf(sb, "slave%d:ip=%s,port=%d,state=online,offset=%d,lag=0\r\n", i, r.ip, r.port, r.ackOffset)
+	}
+	fmt.Fprintf(sb, "master_repl_offset:%d\r\n", srv.replOffset.Load())
+}
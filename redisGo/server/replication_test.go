@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"redisGo/resp"
+)
+
+func TestReplicaIsReadOnly(t *testing.T) {
+	testServer.replicationMu.Lock()
+	testServer.masterHost, testServer.masterPort = "127.0.0.1", 6379
+	testServer.replicationMu.Unlock()
+	defer func() {
+		testServer.replicationMu.Lock()
+		testServer.masterHost, testServer.masterPort = "", 0
+		testServer.replicationMu.Unlock()
+	}()
+
+	client := newTestClient()
+	if result := testServer.call(client, command("SET", "replica", "v")); result.Str != "READONLY You can't write against a read only replica." {
+		t.Errorf("Expected READONLY, got %v", result)
+	}
+	if result := testServer.call(client, command("GET", "replica")); result.Type != "null" {
+		t.Errorf("Expected reads to be served, got %v", result)
+	}
+
+	master := newTestClient()
+	master.master = true
+	if result := testServer.call(master, command("SET", "replica", "v")); result.Str != "OK" {
+		t.Errorf("Expected the primary's writes to be applied, got %v", result)
+	}
+	if result := testServer.call(master, command("DEL", "replica")); result.Num != 1 {
+		t.Errorf("Expected the key to be deleted, got %v", result)
+	}
+}
+
+func TestRoleReplicaOffsets(t *testing.T) {
+	replica := newTestClient()
+	testServer.replicasMu.Lock()
+	testServer.replicas[replica] = &replicaInfo{port: 7000}
+	testServer.replicasMu.Unlock()
+	defer testServer.removeReplica(replica)
+
+	testServer.call(replica, command("REPLCONF", "ACK", "113"))
+
+	result := testServer.role(nil)
+	if len(result.Array) != 3 || len(result.Array[2].Array) != 1 {
+		t.Fatalf("Expected a primary with one replica, got %v", result)
+	}
+	if offset := result.Array[2].Array[0].Array[2].Bulk; offset != "113" {
+		t.Errorf("Expected ROLE to show the acknowledged offset 113, got %q", offset)
+	}
+
+	var sb strings.Builder
+	testServer.infoReplication(&sb)
+	if !strings.Contains(sb.String(), ",port=7000,state=online,offset=113,") {
+		t.Errorf("Expected INFO to show the same offset, got %q", sb.String())
+	}
+}
+
+func TestReplicationAckOnceApplied(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	applied := make(chan struct{}, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go testServer.replicationAck(conn, applied, done)
+
+	// Well before the periodic acknowledgement.
+	applied <- struct{}{}
+	peer.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	value, err := resp.NewResp(peer).Read()
+	if err != nil || len(value.Array) != 3 || value.Array[1].Bulk != "ACK" {
+		t.Errorf("Expected an immediate REPLCONF ACK, got %v, %v", value, err)
+	}
+}
@@ -0,0 +1,545 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Commands that run with execMu held exclusively: the scripts and EXEC.
+var scriptCommands = map[string]bool{
+	"EVAL":       true,
+	"EVALSHA":    true,
+	"EVAL_RO":    true,
+	"EVALSHA_RO": true,
+	"SCRIPT":     true,
+	"EXEC":       true,
+}
+
+// exclusiveCommand reports whether a command runs with execMu held
+// exclusively: the script commands, and SORT when its patterns refer to
+// keys that cannot be locked up front.
+func exclusiveCommand(command string, args []Value) bool {
+	if command == "SORT" || command == "SORT_RO" {
+		return sortRefersToKeys(args)
+	}
+	return scriptCommands[command]
+}
+
+// The scripting commands call back into handlers, so they are registered at
+// init time to avoid an initialization cycle.
+func init() {
+	handlers["EVAL"] = (*Server).eval
+	handlers["EVALSHA"] = (*Server).evalsha
+	handlers["EVAL_RO"] = (*Server).evalRo
+	handlers["EVALSHA_RO"] = (*Server).evalshaRo
+	handlers["SCRIPT"] = (*Server).script
+}
+
+// scriptRun is the script being run. Scripts hold execMu exclusively, so
+// there is at most one, which is runningScript.
+type scriptRun struct {
+	srv    *Server
+	start  time.Time
+	limit  time.Duration
+	wrote  bool
+	killed bool
+	busy   bool
+}
+
+var busyError = Value{Type: "error", Str: "BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE."}
+
+// lockExec takes execMu for a command, exclusively if exclusive is set.
+// Rather than waiting for a script that is over lua-time-limit, it returns
+// false without the lock, for the command to fail with BUSY.
+func (srv *Server) lockExec(exclusive bool) bool {
+	lock, unlock, tryLock := srv.execMu.RLock, srv.execMu.RUnlock, srv.execMu.TryRLock
+	if exclusive {
+		lock, unlock, tryLock = srv.execMu.Lock, srv.execMu.Unlock, srv.execMu.TryLock
+	}
+	if tryLock() {
+		return true
+	}
+
+	srv.scriptMu.Lock()
+	busy := srv.runningScript != nil && srv.runningScript.busy
+	busyCh := srv.scriptBusyCh
+	srv.scriptMu.Unlock()
+	if busy {
+		return false
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		lock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return true
+	case <-busyCh:
+		// The lock is released as soon as it is taken.
+		go func() {
+			<-locked
+			unlock()
+		}()
+		return false
+	}
+}
+
+// hook runs periodically while the script runs: it marks the script busy
+// once over the time limit and stops it once killed.
+func (s *scriptRun) hook(L *luaState) {
+	s.srv.scriptMu.Lock()
+	defer s.srv.scriptMu.Unlock()
+
+	if !s.busy && s.limit > 0 && time.Since(s.start) > s.limit {
+		s.busy = true
+		close(s.srv.scriptBusyCh)
+		fmt.Printf("Slow script detected: still in execution after %d milliseconds. You can try killing the script using the SCRIPT KILL command.\n", time.Since(s.start).Milliseconds())
+	}
+
+	if s.killed {
+		L.killed = true
+		panic(&luaError{value: luaErrorTable("ERR Script killed by user with SCRIPT KILL...")})
+	}
+}
+
+// killScript stops the running script, if any, unless it already wrote to
+// the dataset and force is not set. Shutting down forces it: the writes
+// of the script are in the AOF one by one, so stopping it half way leaves
+// the AOF consistent with the dataset.
+func (srv *Server) killScript(force bool) Value {
+	srv.scriptMu.Lock()
+	defer srv.scriptMu.Unlock()
+
+	if srv.runningScript == nil {
+		return Value{Type: "error", Str: "NOTBUSY No scripts in execution right now."}
+	}
+	if srv.runningScript.wrote && !force {
+		return Value{Type: "error", Str: "UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command."}
+	}
+
+	srv.runningScript.killed = true
+	return Value{Type: "string", Str: "OK"}
+}
+
+func sha1hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadScript compiles body and adds it to the script cache.
+func (srv *Server) loadScript(body string) (string, *luaProto, error) {
+	sha := sha1hex(body)
+
+	srv.scriptsMu.RLock()
+	proto, ok := srv.scripts[sha]
+	srv.scriptsMu.RUnlock()
+	if ok {
+		return sha, proto, nil
+	}
+
+	proto, err := luaCompile("user_script", body)
+	if err != nil {
+		return "", nil, err
+	}
+
+	srv.scriptsMu.Lock()
+	srv.scripts[sha] = proto
+	srv.scriptsMu.Unlock()
+
+	return sha, proto, nil
+}
+
+func (srv *Server) eval(args []Value) Value {
+	return srv.evalGeneric(args, false, false)
+}
+
+func (srv *Server) evalsha(args []Value) Value {
+	return srv.evalGeneric(args, true, false)
+}
+
+func (srv *Server) evalRo(args []Value) Value {
+	return srv.evalGeneric(args, false, true)
+}
+
+func (srv *Server) evalshaRo(args []Value) Value {
+	return srv.evalGeneric(args, true, true)
+}
+
+func (srv *Server) evalGeneric(args []Value, isSha, readonly bool) Value {
+	numkeys, err := strconv.Atoi(args[1].Bulk)
+	if err != nil {
+		return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+	}
+	if numkeys < 0 {
+		return Value{Type: "error", Str: "ERR Number of keys can't be negative"}
+	}
+	if numkeys > len(args)-2 {
+		return Value{Type: "error", Str: "ERR Number of keys can't be greater than number of args"}
+	}
+
+	var sha string
+	var proto *luaProto
+
+	if isSha {
+		sha = strings.ToLower(args[0].Bulk)
+
+		srv.scriptsMu.RLock()
+		proto = srv.scripts[sha]
+		srv.scriptsMu.RUnlock()
+
+		if proto == nil {
+			return Value{Type: "error", Str: "NOSCRIPT No matching script. Please use EVAL."}
+		}
+	} else {
+		sha, proto, err = srv.loadScript(args[0].Bulk)
+		if err != nil {
+			return Value{Type: "error", Str: fmt.Sprintf("ERR Error compiling script (new function): %s", err)}
+		}
+	}
+
+	keys := args[2 : 2+numkeys]
+	argv := args[2+numkeys:]
+
+	return srv.runScript(sha, proto, keys, argv, readonly)
+}
+
+func (srv *Server) runScript(sha string, proto *luaProto, keys, argv []Value, readonly bool) Value {
+	L := newLuaState("user_script")
+	openLuaLibs(L)
+	L.globals.set("redis", srv.newRedisLib(readonly))
+
+	keysTable := newLuaTable()
+	for i, key := range keys {
+		keysTable.set(float64(i+1), key.Bulk)
+	}
+	L.globals.set("KEYS", keysTable)
+
+	argvTable := newLuaTable()
+	for i, arg := range argv {
+		argvTable.set(float64(i+1), arg.Bulk)
+	}
+	L.globals.set("ARGV", argvTable)
+
+	// Scripts may not create globals; everything they need is local.
+	L.globals.readonly = true
+
+	run := &scriptRun{srv: srv, start: time.Now(), limit: time.Duration(srv.configInt(&srv.luaTimeLimit)) * time.Millisecond}
+	L.hook = run.hook
+
+	srv.scriptMu.Lock()
+	srv.runningScript = run
+	srv.scriptMu.Unlock()
+
+	defer func() {
+		srv.scriptMu.Lock()
+		srv.runningScript = nil
+		if run.busy {
+			srv.scriptBusyCh = make(chan struct{})
+		}
+		srv.scriptMu.Unlock()
+	}()
+
+	results, err := L.run(proto)
+	if err != nil {
+		errValue := err.(*luaError).value
+		if t, ok := errValue.(*luaTable); ok {
+			if msg, ok := t.get("err").(string); ok {
+				return Value{Type: "error", Str: msg}
+			}
+		}
+
+		return Value{Type: "error", Str: fmt.Sprintf("ERR %s script: %s", err, sha)}
+	}
+
+	if len(results) == 0 {
+		return Value{Type: "null"}
+	}
+
+	return luaToReply(results[0])
+}
+
+func (srv *Server) script(args []Value) Value {
+	switch strings.ToUpper(args[0].Bulk) {
+	case "LOAD":
+		if len(args) != 2 {
+			return Value{Type: "error", Str: "ERR wrong number of arguments for 'script|load' command"}
+		}
+
+		sha, _, err := srv.loadScript(args[1].Bulk)
+		if err != nil {
+			return Value{Type: "error", Str: fmt.Sprintf("ERR Error compiling script (new function): %s", err)}
+		}
+
+		return Value{Type: "bulk", Bulk: sha}
+	case "EXISTS":
+		if len(args) < 2 {
+			return Value{Type: "error", Str: "ERR wrong number of arguments for 'script|exists' command"}
+		}
+
+		srv.scriptsMu.RLock()
+		defer srv.scriptsMu.RUnlock()
+
+		values := []Value{}
+		for _, arg := range args[1:] {
+			exists := 0
+			if _, ok := srv.scripts[strings.ToLower(arg.Bulk)]; ok {
+				exists = 1
+			}
+			values = append(values, Value{Type: "integer", Num: exists})
+		}
+
+		return Value{Type: "array", Array: values}
+	case "FLUSH":
+		if len(args) > 2 {
+			return Value{Type: "error", Str: "ERR wrong number of arguments for 'script|flush' command"}
+		}
+		if len(args) == 2 {
+			mode := strings.ToUpper(args[1].Bulk)
+			if mode != "ASYNC" && mode != "SYNC" {
+				return Value{Type: "error", Str: "ERR SCRIPT FLUSH only support SYNC|ASYNC option"}
+			}
+		}
+
+		srv.scriptsMu.Lock()
+		srv.scripts = map[string]*luaProto{}
+		srv.scriptsMu.Unlock()
+
+		return Value{Type: "string", Str: "OK"}
+	case "KILL":
+		if len(args) != 1 {
+			return Value{Type: "error", Str: "ERR wrong number of arguments for 'script|kill' command"}
+		}
+
+		return srv.killScript(false)
+	default:
+		return Value{Type: "error", Str: fmt.Sprintf("ERR unknown subcommand '%s'", args[0].Bulk)}
+	}
+}
+
+// The redis library
+
+const (
+	luaLogDebug = iota
+	luaLogVerbose
+	luaLogNotice
+	luaLogWarning
+)
+
+func (srv *Server) newRedisLib(readonly bool) *luaTable {
+	lib := newLuaTable()
+
+	luaRegister(lib, "call", func(L *luaState, args []luaValue) []luaValue {
+		reply := srv.redisCallFromScript(L, args, readonly)
+		if reply.Type == "error" {
+			panic(&luaError{value: replyToLua(reply)})
+		}
+		return []luaValue{replyToLua(reply)}
+	})
+
+	luaRegister(lib, "pcall", func(L *luaState, args []luaValue) []luaValue {
+		var reply Value
+
+		_, errValue, ok := L.pcall(&luaGoFunction{name: "call", fn: func(L *luaState, _ []luaValue) []luaValue {
+			reply = srv.redisCallFromScript(L, args, readonly)
+			return nil
+		}}, nil)
+		if !ok {
+			if msg, isString := errValue.(string); isString {
+				return []luaValue{luaErrorTable(msg)}
+			}
+			return []luaValue{errValue}
+		}
+
+		return []luaValue{replyToLua(reply)}
+	})
+
+	luaRegister(lib, "error_reply", func(L *luaState, args []luaValue) []luaValue {
+		msg := L.checkString(args, 0, "error_reply")
+		if !strings.HasPrefix(msg, "-") {
+			return []luaValue{luaErrorTable(msg)}
+		}
+		return []luaValue{luaErrorTable(msg[1:])}
+	})
+
+	luaRegister(lib, "status_reply", func(L *luaState, args []luaValue) []luaValue {
+		t := newLuaTable()
+		t.set("ok", L.checkString(args, 0, "status_reply"))
+		return []luaValue{t}
+	})
+
+	luaRegister(lib, "sha1hex", func(L *luaState, args []luaValue) []luaValue {
+		return []luaValue{sha1hex(L.checkString(args, 0, "sha1hex"))}
+	})
+
+	luaRegister(lib, "log", func(L *luaState, args []luaValue) []luaValue {
+		if len(args) < 2 {
+			L.errorf("redis.log() requires two arguments or more.")
+		}
+
+		level := L.checkInt(args, 0, "log")
+		if level < luaLogDebug || level > luaLogWarning {
+			L.errorf("Invalid debug level.")
+		}
+
+		parts := []string{}
+		for i := 1; i < len(args); i++ {
+			parts = append(parts, L.checkString(args, i, "log"))
+		}
+		fmt.Println(strings.Join(parts, " "))
+
+		return nil
+	})
+
+	lib.set("LOG_DEBUG", float64(luaLogDebug))
+	lib.set("LOG_VERBOSE", float64(luaLogVerbose))
+	lib.set("LOG_NOTICE", float64(luaLogNotice))
+	lib.set("LOG_WARNING", float64(luaLogWarning))
+
+	lib.readonly = true
+	return lib
+}
+
+func luaErrorTable(msg string) *luaTable {
+	t := newLuaTable()
+	t.set("err", msg)
+	return t
+}
+
+// redisCallFromScript runs a command issued through redis.call or
+// redis.pcall. Write commands are replicated to the AOF one by one, so the
+// log holds the effects of a script rather than the script itself.
+func (srv *Server) redisCallFromScript(L *luaState, args []luaValue, readonly bool) Value {
+	if len(args) == 0 {
+		L.errorf("Please specify at least one argument for this redis lib call")
+	}
+
+	value := Value{Type: "array"}
+	for _, arg := range args {
+		s, ok := luaToStringCoerce(arg)
+		if !ok {
+			L.errorf("Lua redis lib command arguments must be strings or integers")
+		}
+		value.Array = append(value.Array, Value{Type: "bulk", Bulk: s})
+	}
+
+	command := strings.ToUpper(value.Array[0].Bulk)
+
+	spec, ok := commandTable[command]
+	if !ok {
+		L.errorf("Unknown Redis command called from script")
+	}
+	if (spec.arity > 0 && len(value.Array) != spec.arity) || len(value.Array) < -spec.arity {
+		L.errorf("Wrong number of args calling Redis command from script")
+	}
+	handler, ok := handlers[command]
+	if !ok || spec.hasFlag("noscript") {
+		L.errorf("This Redis command is not allowed from script")
+	}
+	write := spec.hasFlag("write")
+	if readonly && write {
+		L.errorf("Write commands are not allowed from read-only scripts.")
+	}
+	if write && srv.isReplica() {
+		L.errorf("READONLY You can't write against a read only replica.")
+	}
+
+	if write {
+		srv.scriptMu.Lock()
+		if srv.runningScript != nil {
+			srv.runningScript.wrote = true
+		}
+		srv.scriptMu.Unlock()
+	}
+
+	srv.feedMonitors("lua", value)
+
+	start := time.Now()
+	result := handler(srv, value.Array[1:])
+	srv.recordCommand(command, time.Since(start), result)
+	srv.propagateCommand(command, value, result)
+	if write && result.Type != "error" {
+		srv.signalModifiedKeys(nil, commandKeys(command, value.Array[1:]))
+	}
+
+	return result
+}
+
+// replyToLua converts a command reply into a Lua value.
+func replyToLua(v Value) luaValue {
+	switch v.Type {
+	case "integer":
+		return float64(v.Num)
+	case "bulk":
+		return v.Bulk
+	case "string":
+		t := newLuaTable()
+		t.set("ok", v.Str)
+		return t
+	case "error":
+		return luaErrorTable(v.Str)
+	case "array":
+		t := newLuaTable()
+		for i, elem := range v.Array {
+			t.set(float64(i+1), replyToLua(elem))
+		}
+		return t
+	}
+
+	return false
+}
+
+// luaToReply converts the value returned by a script into a reply.
+// luaToInteger truncates a Lua number to an integer reply, as Redis does.
+// NaN converts to 0 and numbers out of range, infinities included, to the
+// nearest bound, rather than to whatever the conversion yields.
+func luaToInteger(n float64) int {
+	switch {
+	case math.IsNaN(n):
+		return 0
+	case n >= math.MaxInt64:
+		return math.MaxInt64
+	case n <= math.MinInt64:
+		return math.MinInt64
+	}
+	return int(n)
+}
+
+func luaToReply(v luaValue) Value {
+	switch x := v.(type) {
+	case string:
+		return Value{Type: "bulk", Bulk: x}
+	case float64:
+		return Value{Type: "integer", Num: luaToInteger(x)}
+	case bool:
+		if x {
+			return Value{Type: "integer", Num: 1}
+		}
+	case *luaTable:
+		if msg, ok := x.get("err").(string); ok {
+			return Value{Type: "error", Str: msg}
+		}
+		if msg, ok := x.get("ok").(string); ok {
+			return Value{Type: "string", Str: msg}
+		}
+
+		values := []Value{}
+		for i := 1; ; i++ {
+			elem := x.get(float64(i))
+			if elem == nil {
+				break
+			}
+			values = append(values, luaToReply(elem))
+		}
+		return Value{Type: "array", Array: values}
+	}
+
+	return Value{Type: "null"}
+}
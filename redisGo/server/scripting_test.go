@@ -0,0 +1,312 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"redisGo/aof"
+)
+
+func evalScript(body string, keysAndArgs ...string) Value {
+	args := []Value{{Type: "bulk", Bulk: body}}
+	for _, arg := range keysAndArgs {
+		args = append(args, Value{Type: "bulk", Bulk: arg})
+	}
+	return testServer.eval(args)
+}
+
+func TestLuaInterpreter(t *testing.T) {
+	tests := []struct {
+		script   string
+		expected string
+	}{
+		{"return 1 + 2 * 3 - 4 / 2", "5"},
+		{"return 2 ^ 3 ^ 2", "512"},
+		{"return 7 % 3 .. ''", "1"},
+		{"return 'a' .. 1 .. 'b'", "a1b"},
+		{"return tostring(10 / 4)", "2.5"},
+		{"return tostring(1 == 1.0) .. tostring('1' == 1)", "truefalse"},
+		{"local x = nil or false or 'fallback' return x", "fallback"},
+		{"local t = {1, 2, 3, n = 'x'} return #t .. t.n", "3x"},
+		{"local s = 0 for i = 10, 1, -2 do s = s + i end return s", "30"},
+		{"local s = 0 for _, v in ipairs({4, 5, 6}) do s = s + v end return s", "15"},
+		{"local i = 0 while true do i = i + 1 if i == 5 then break end end return i", "5"},
+		{"local i = 0 repeat local j = i i = i + 1 until j >= 3 return i", "4"},
+		{"local function f(...) return select('#', ...) end return f(1, nil, 3)", "3"},
+		{"local function f() return 1, 2, 3 end local t = {f()} return #t", "3"},
+		{"local function f() return 1, 2, 3 end local t = {(f())} return #t", "1"},
+		{`local fns = {}
+		  for i = 1, 3 do fns[i] = function() return i end end
+		  return fns[1]() + fns[2]() + fns[3]()`, "6"},
+		{`local function counter()
+		    local n = 0
+		    return function() n = n + 1 return n end
+		  end
+		  local c = counter() c() c()
+		  return c()`, "3"},
+		{"local obj = {v = 2} function obj:double() return self.v * 2 end return obj:double()", "4"},
+		{"local ok, err = pcall(function() error('boom') end) return tostring(ok) .. ' ' .. err", "false user_script:1: boom"},
+		{"local ok, err = pcall(function() error({code = 42}) end) return err.code", "42"},
+		{"return string.format('%05.1f|%-3s|%x', 3.14159, 'a', 255)", "003.1|a  |ff"},
+		{"return string.rep('ab', 3) .. string.sub('hello', 2, -2)", "abababell"},
+		{"return (string.gsub('a,b,,c', ',', ';'))", "a;b;;c"},
+		{"return (string.gsub('hello world', '%w+', '%0 %0', 1))", "hello hello world"},
+		{"return string.match('  trim  ', '^%s*(.-)%s*$')", "trim"},
+		{"return string.match('f(a(b)c)', '%b()')", "(a(b)c)"},
+		{"return string.find('a.b', '.', 1, true)", "2"},
+		{"local t = {3, 1, 2} table.sort(t) return table.concat(t, '-')", "1-2-3"},
+		{"local t = {1, 3} table.insert(t, 2, 2) table.insert(t, 4) return table.concat(t)", "1234"},
+		{"local t = {1, 2, 3} return table.remove(t, 1) .. #t", "12"},
+		{"return math.max(1, 5, 3) + math.floor(2.7) + math.abs(-1)", "8"},
+		{"return tonumber('  12  ') + tonumber('ff', 16) + tonumber('1e2')", "367"},
+		{"return tostring(tonumber('12abc'))", "nil"},
+		{"return type(nil) .. type({}) .. type(pairs)", "niltablefunction"},
+		{"--[[ long\ncomment ]] return [[long\nstring]]", "long\nstring"},
+		{"return '\\65\\x42\\n'", "AB\n"},
+		{"return ('abc'):upper() .. ('x'):rep(3)", "ABCxxx"},
+		{"local s = 'hello' return s:sub(2, 3) .. #s:upper()", "el5"},
+		{"return getmetatable('').__index == string", "1"},
+		{"local t = setmetatable({}, {__index = function(t, k) return k .. '!' end}) return t.foo", "foo!"},
+		{"local base = {v = 1} local t = setmetatable({}, {__index = base}) return t.v .. tostring(rawget(t, 'v'))", "1nil"},
+		{"local log = {} local t = setmetatable({}, {__newindex = function(t, k, v) rawset(log, k, v) end}) t.x = 2 return tostring(rawget(t, 'x')) .. log.x", "nil2"},
+		{"local t = setmetatable({}, {__call = function(self, a) return a * 2 end}) return t(21)", "42"},
+		{"local t = setmetatable({}, {__tostring = function() return 'T' end}) return tostring(t)", "T"},
+		{"local t = setmetatable({}, {__metatable = 'locked'}) return getmetatable(t)", "locked"},
+		{"local mt = {} local t = setmetatable({}, mt) return tostring(getmetatable(t) == mt) .. tostring(getmetatable({}))", "truenil"},
+		{"local ok, err = xpcall(function() error('boom', 0) end, function(e) return 'handled ' .. e end) return tostring(ok) .. ' ' .. err", "false handled boom"},
+		{"local a, b = xpcall(function() return 'fine' end, type) return tostring(a) .. ' ' .. b", "true fine"},
+		{"local r = math.random(10) local s = math.random(5, 6) return tostring(r >= 1 and r <= 10 and s >= 5 and s <= 6 and math.random() < 1)", "true"},
+		{"math.randomseed(7) local a = math.random(1000) math.randomseed(7) return tostring(a == math.random(1000))", "true"},
+		{`return cjson.encode({1, "two", true, {a = cjson.null}})`, `[1,"two",true,{"a":null}]`},
+		{`return cjson.encode({["a/b"] = "x\ny"})`, `{"a\/b":"x\ny"}`},
+		{`local t = cjson.decode('{"a": [1, 2.5, null], "b": "c"}') return t.a[2] .. t.b .. tostring(t.a[3] == cjson.null)`, "2.5ctrue"},
+		{"return bit.band(0xff, 0x0f) + bit.bor(1, 2) + bit.lshift(1, 4)", "34"},
+		{"return bit.tobit(0xffffffff) .. ' ' .. bit.tohex(255) .. ' ' .. bit.tohex(-1, -4) .. ' ' .. bit.bnot(0)", "-1 000000ff FFFF -1"},
+		{"return bit.arshift(-256, 4) .. ' ' .. bit.rshift(-256, 28) .. ' ' .. bit.rol(0x12345678, 8)", "-16 15 878082066"},
+		{"local s = struct.pack('>I2i4', 258, -2) return #s .. ' ' .. s:byte(1) .. s:byte(2)", "6 12"},
+		{"local a, b, c, next = struct.unpack('<hBs', struct.pack('<hBs', -3, 200, 'hi')) return a .. b .. c .. next", "-3200hi7"},
+		{"return struct.size('!4bi')", "8"},
+		{"local a, t = cmsgpack.unpack(cmsgpack.pack(-1, {1, 'x', {k = 300}})) return a .. t[2] .. t[3].k .. #t", "-1x3003"},
+		{"return cmsgpack.pack(1, 'ab', {}) == '\\1\\162ab\\144'", "1"},
+		{"local a, b = cmsgpack.unpack(cmsgpack.pack(1.5, 0.1)) return tostring(a) .. ' ' .. tostring(b)", "1.5 0.1"},
+	}
+
+	for _, test := range tests {
+		result := evalScript(test.script, "0")
+
+		var got string
+		switch result.Type {
+		case "bulk":
+			got = result.Bulk
+		case "integer":
+			got = strings.TrimSpace(strings.TrimPrefix(string(result.Marshal()), ":"))
+		default:
+			got = result.Type + ": " + result.Str
+		}
+
+		if got != test.expected {
+			t.Errorf("Expected %q for script %q, got %q", test.expected, test.script, got)
+		}
+	}
+}
+
+func TestLuaSyntaxErrors(t *testing.T) {
+	for _, script := range []string{"return (1", "x = = 1", "for i = 1 do end", "local 1 = 2", "return 'unfinished"} {
+		result := evalScript(script, "0")
+		if result.Type != "error" || !strings.HasPrefix(result.Str, "ERR Error compiling script") {
+			t.Errorf("Expected a compile error for %q, got %v", script, result)
+		}
+	}
+}
+
+func TestLuaRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		script   string
+		expected string
+	}{
+		{"return nosuchglobal", "Script attempted to access nonexistent global variable 'nosuchglobal'"},
+		{"newglobal = 1", "Script attempted to create global variable 'newglobal'"},
+		{"return {} + 1", "attempt to perform arithmetic on a value (a table value)"},
+		{"local t = nil return t.x", "attempt to index 't' (a nil value)"},
+		{"string.foo = 1", "Attempt to modify a readonly table"},
+		{"local function f() return f() + 1 end return f()", "stack overflow"},
+		{"local t = setmetatable({}, {__metatable = false}) setmetatable(t, {})", "cannot change a protected metatable"},
+		{"setmetatable(string, {})", "Attempt to modify a readonly table"},
+		{"getmetatable('').__index = {}", "Attempt to modify a readonly table"},
+		{"for i = 1, 10, 0 do end", "'for' step is zero"},
+		{"return cjson.encode({f = type})", "Cannot serialise function: type not supported"},
+		{"return cjson.encode(0 / 0)", "Cannot serialise number: must not be NaN or Inf"},
+		{"return cjson.decode('{')", "Expected value but found invalid token"},
+		{"return struct.unpack('i4', 'ab')", "data string too short"},
+		{"return cmsgpack.unpack('\\205')", "Missing bytes in input."},
+		{"return math.random(0)", "interval is empty"},
+	}
+
+	for _, test := range tests {
+		result := evalScript(test.script, "0")
+		if result.Type != "error" || !strings.Contains(result.Str, test.expected) {
+			t.Errorf("Expected error containing %q for %q, got %v", test.expected, test.script, result)
+		}
+	}
+}
+
+func TestEvalConversions(t *testing.T) {
+	result := evalScript("return {1, 'two', 3.99, true, false, {ok = 'fine'}, {err = 'bad'}}", "0")
+
+	expected := "*7\r\n:1\r\n$3\r\ntwo\r\n:3\r\n:1\r\n$-1\r\n+fine\r\n-bad\r\n"
+	if string(result.Marshal()) != expected {
+		t.Errorf("Expected reply %q, got %q", expected, result.Marshal())
+	}
+
+	// Numbers are truncated; NaN and the infinities get explicit values.
+	result = evalScript("return {-2.7, 0/0, 1/0, -1/0, 2^70}", "0")
+	expected = "*5\r\n:-2\r\n:0\r\n:9223372036854775807\r\n:-9223372036854775808\r\n:9223372036854775807\r\n"
+	if string(result.Marshal()) != expected {
+		t.Errorf("Expected reply %q, got %q", expected, result.Marshal())
+	}
+
+	result = evalScript("return redis.call('GET', KEYS[1]) == false", "1", "scripting:missing")
+	if result.Type != "integer" || result.Num != 1 {
+		t.Errorf("Expected a missing key to convert to false, got %v", result)
+	}
+}
+
+func TestEvalKeysAndArgv(t *testing.T) {
+	result := evalScript("return {KEYS[1], KEYS[2], ARGV[1], #ARGV}", "2", "k1", "k2", "a1", "a2")
+	if len(result.Array) != 4 || result.Array[0].Bulk != "k1" || result.Array[1].Bulk != "k2" ||
+		result.Array[2].Bulk != "a1" || result.Array[3].Num != 2 {
+		t.Errorf("Unexpected KEYS/ARGV binding: %v", result)
+	}
+
+	result = evalScript("return 1", "3", "k1")
+	if result.Type != "error" {
+		t.Errorf("Expected an error when numkeys exceeds the arguments, got %v", result)
+	}
+}
+
+func TestEvalShaAndScriptCache(t *testing.T) {
+	body := "return 'cached'"
+	sha := testServer.script([]Value{{Type: "bulk", Bulk: "LOAD"}, {Type: "bulk", Bulk: body}}).Bulk
+
+	if sha != sha1hex(body) {
+		t.Fatalf("Expected SCRIPT LOAD to return %s, got %s", sha1hex(body), sha)
+	}
+
+	result := testServer.evalsha([]Value{{Type: "bulk", Bulk: sha}, {Type: "bulk", Bulk: "0"}})
+	if result.Bulk != "cached" {
+		t.Errorf("Expected EVALSHA to run the cached script, got %v", result)
+	}
+
+	testServer.script([]Value{{Type: "bulk", Bulk: "FLUSH"}})
+
+	exists := testServer.script([]Value{{Type: "bulk", Bulk: "EXISTS"}, {Type: "bulk", Bulk: sha}})
+	if exists.Array[0].Num != 0 {
+		t.Errorf("Expected script to be gone after SCRIPT FLUSH")
+	}
+
+	result = testServer.evalsha([]Value{{Type: "bulk", Bulk: sha}, {Type: "bulk", Bulk: "0"}})
+	if result.Type != "error" || !strings.HasPrefix(result.Str, "NOSCRIPT") {
+		t.Errorf("Expected NOSCRIPT error, got %v", result)
+	}
+}
+
+func TestEvalReplicatesEffects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.aof")
+
+	var err error
+	testServer.aofFile, err = aof.New(path, nil)
+	if err != nil {
+		t.Fatalf("Failed to create AOF: %v", err)
+	}
+	defer func() {
+		testServer.aofFile.Close()
+		testServer.aofFile = nil
+	}()
+
+	result := evalScript(`
+		local current = redis.call('GET', KEYS[1])
+		if current == false then
+			redis.call('SET', KEYS[1], ARGV[1])
+			redis.pcall('SET', KEYS[1])
+			return 1
+		end
+		return 0`, "1", "scripting:cas", "v1")
+	if result.Num != 1 {
+		t.Fatalf("Expected script to set the key, got %v", result)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read AOF: %v", err)
+	}
+
+	expected := "*3\r\n$3\r\nSET\r\n$13\r\nscripting:cas\r\n$2\r\nv1\r\n"
+	if string(data) != expected {
+		t.Errorf("Expected AOF to hold only the successful SET, got %q", data)
+	}
+}
+
+func TestScriptTimeLimit(t *testing.T) {
+	testServer.luaTimeLimit = 50
+	defer func() { testServer.luaTimeLimit = 5000 }()
+
+	run := func(script string) chan Value {
+		done := make(chan Value, 1)
+		go func() {
+			done <- testServer.call(newTestClient(), command("EVAL", script, "0"))
+		}()
+		return done
+	}
+
+	client := newTestClient()
+	result := testServer.call(client, command("SCRIPT", "KILL"))
+	if !strings.HasPrefix(result.Str, "NOTBUSY") {
+		t.Errorf("Expected NOTBUSY without a script running, got %v", result)
+	}
+
+	// pcall does not keep the script from being killed.
+	done := run("while true do pcall(function() while true do end end) end")
+	time.Sleep(100 * time.Millisecond)
+
+	result = testServer.call(client, command("GET", "scripting:busy"))
+	if !strings.HasPrefix(result.Str, "BUSY") {
+		t.Errorf("Expected BUSY while the script runs, got %v", result)
+	}
+
+	result = testServer.call(client, command("SCRIPT", "KILL"))
+	if result.Str != "OK" {
+		t.Fatalf("Expected SCRIPT KILL to succeed, got %v", result)
+	}
+	select {
+	case result = <-done:
+		if result.Type != "error" || !strings.Contains(result.Str, "Script killed by user") {
+			t.Errorf("Expected the script to be killed, got %v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the script to stop after SCRIPT KILL")
+	}
+
+	result = testServer.call(client, command("GET", "scripting:busy"))
+	if result.Type == "error" {
+		t.Errorf("Expected commands to run once the script stopped, got %v", result)
+	}
+
+	// A script that wrote cannot be killed, short of shutting down.
+	done = run("redis.call('SET', 'scripting:busy', '1') while true do end")
+	time.Sleep(100 * time.Millisecond)
+
+	result = testServer.call(client, command("SCRIPT", "KILL"))
+	if !strings.HasPrefix(result.Str, "UNKILLABLE") {
+		t.Errorf("Expected UNKILLABLE for a script that wrote, got %v", result)
+	}
+
+	testServer.killScript(true)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the script to stop when forced")
+	}
+}
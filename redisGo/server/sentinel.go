@@ -0,0 +1,1173 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"redisGo/resp"
+)
+
+// Sentinel mode. Started with --sentinel, the server keeps no dataset and
+// instead monitors primaries named with SENTINEL MONITOR. Every second it
+// sends PING and INFO to each primary and to the replicas INFO lists, and
+// every two seconds it publishes a hello message on the instances, which is
+// how the Sentinels watching the same primary discover each other and learn
+// about configuration changes.
+//
+// A primary that does not answer for down-after-milliseconds is subjectively
+// down (SDOWN). Once quorum Sentinels agree it is down it is objectively
+// down (ODOWN), and a Sentinel starts a failover: it bumps the epoch and asks
+// the others for their vote. Elected by a majority, it promotes the best
+// replica with REPLICAOF NO ONE, points the other replicas at it, and
+// announces the new configuration, tagged with the epoch, in its hellos.
+
+const (
+	sentinelPort            = 26379
+	sentinelHelloChannel    = "__sentinel__:hello"
+	sentinelPeriod          = time.Second
+	sentinelHelloPeriod     = 2 * time.Second
+	sentinelTimeout         = 500 * time.Millisecond
+	sentinelReplyValidity   = 5 * time.Second
+	sentinelReconfigDelay   = 4 * time.Second
+	sentinelDownAfter       = 30 * time.Second
+	sentinelFailoverTimeout = 180 * time.Second
+)
+
+const (
+	failoverNone = iota
+	failoverWaitStart
+	failoverSelectReplica
+	failoverWaitPromotion
+	failoverReconfReplicas
+)
+
+var failoverStates = []string{"none", "wait_start", "select_slave", "wait_promotion", "reconf_slaves"}
+
+// sentinelInstance is a primary, replica or other Sentinel. Its fields are
+// guarded by sentinelMu, except for the connections, guarded by linkMu.
+type sentinelInstance struct {
+	host string
+	port int
+
+	runID    string
+	lastOK   time.Time
+	lastInfo time.Time
+	sdown    bool
+
+	// As reported by INFO. configSince is when role or the replica's
+	// primary last changed.
+	role        string
+	configSince time.Time
+	masterHost  string
+	masterPort  int
+	linkUp      bool
+	offset      int64
+	priority    int
+
+	// Other Sentinels: their last hello, and their last answer to
+	// SENTINEL IS-MASTER-DOWN-BY-ADDR.
+	lastHello   time.Time
+	masterDown  bool
+	lastReply   time.Time
+	leader      string
+	leaderEpoch int64
+
+	linkMu sync.Mutex
+	conn   net.Conn
+	reader *resp.Resp
+	sub    net.Conn
+	closed bool
+}
+
+type sentinelMaster struct {
+	name            string
+	instance        *sentinelInstance
+	replicas        map[string]*sentinelInstance
+	sentinels       map[string]*sentinelInstance
+	quorum          int
+	downAfter       time.Duration
+	failoverTimeout time.Duration
+	configEpoch     int64
+	odown           bool
+
+	// The vote this Sentinel gave for leading the failover of this primary.
+	leader      string
+	leaderEpoch int64
+
+	failoverState      int
+	failoverEpoch      int64
+	failoverStart      time.Time
+	failoverDelayUntil time.Time
+	promoted           *sentinelInstance
+}
+
+func (srv *Server) newSentinelInstance(host string, port int, subscribe bool) *sentinelInstance {
+	in := &sentinelInstance{host: host, port: port, lastOK: time.Now(), configSince: time.Now(), priority: 100}
+	if subscribe {
+		srv.goBackground(func() { srv.subscribeHello(in) })
+	}
+	return in
+}
+
+func (in *sentinelInstance) addr() string {
+	return net.JoinHostPort(in.host, strconv.Itoa(in.port))
+}
+
+// request sends a command on the instance's command connection, dialling
+// it if needed, and returns the reply.
+func (in *sentinelInstance) request(args ...string) (Value, error) {
+	in.linkMu.Lock()
+	defer in.linkMu.Unlock()
+
+	if in.closed {
+		return Value{}, fmt.Errorf("instance removed")
+	}
+	if in.conn == nil {
+		conn, err := net.DialTimeout("tcp", in.addr(), sentinelTimeout)
+		if err != nil {
+			return Value{}, err
+		}
+		in.conn, in.reader = conn, resp.NewResp(conn)
+	}
+
+	in.conn.SetDeadline(time.Now().Add(sentinelTimeout))
+	_, err := in.conn.Write(commandValue(args...).Marshal())
+	if err != nil {
+		in.disconnect()
+		return Value{}, err
+	}
+	reply, err := in.reader.Read()
+	if err != nil {
+		in.disconnect()
+		return Value{}, err
+	}
+
+	return reply, nil
+}
+
+// disconnect drops the command connection. The caller must hold linkMu.
+func (in *sentinelInstance) disconnect() {
+	if in.conn != nil {
+		in.conn.Close()
+		in.conn, in.reader = nil, nil
+	}
+}
+
+// localIP returns the address this Sentinel reaches the instance from,
+// which is the one it announces in its hellos.
+func (in *sentinelInstance) localIP() string {
+	in.linkMu.Lock()
+	defer in.linkMu.Unlock()
+
+	if in.conn == nil {
+		return ""
+	}
+	ip, _, _ := net.SplitHostPort(in.conn.LocalAddr().String())
+	return ip
+}
+
+// close drops both connections and stops the hello subscriber.
+func (in *sentinelInstance) close() {
+	in.linkMu.Lock()
+	defer in.linkMu.Unlock()
+
+	in.closed = true
+	in.disconnect()
+	if in.sub != nil {
+		in.sub.Close()
+	}
+}
+
+// subscribeHello listens to the hello channel of a primary or replica until
+// the instance is removed or the server is closed.
+func (srv *Server) subscribeHello(in *sentinelInstance) {
+	for {
+		conn, err := net.DialTimeout("tcp", in.addr(), sentinelTimeout)
+
+		in.linkMu.Lock()
+		if in.closed {
+			in.linkMu.Unlock()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		in.sub = conn
+		in.linkMu.Unlock()
+
+		if err == nil {
+			conn.Write(commandValue("SUBSCRIBE", sentinelHelloChannel).Marshal())
+			reader := resp.NewResp(conn)
+			for {
+				value, err := reader.Read()
+				if err != nil {
+					break
+				}
+				if value.Type == "array" && len(value.Array) == 3 && value.Array[0].Bulk == "message" {
+					srv.sentinelProcessHello(value.Array[2].Bulk)
+				}
+			}
+			conn.Close()
+		}
+
+		select {
+		case <-time.After(sentinelPeriod):
+		case <-srv.done:
+			return
+		}
+	}
+}
+
+// sentinelEvent logs an event and publishes it on the channel of the same
+// name, where clients of the Sentinel can follow it.
+func (srv *Server) sentinelEvent(event string, message string) {
+	fmt.Println(event, message)
+	srv.publishMessage(event, message)
+}
+
+// close drops the links to the primary, its replicas and the other
+// Sentinels.
+func (m *sentinelMaster) close() {
+	m.instance.close()
+	for _, r := range m.replicas {
+		r.close()
+	}
+	for _, s := range m.sentinels {
+		s.close()
+	}
+}
+
+// describe formats an instance the way events refer to it.
+func (m *sentinelMaster) describe(in *sentinelInstance) string {
+	if in == m.instance {
+		return fmt.Sprintf("master %s %s %d", m.name, in.host, in.port)
+	}
+	return fmt.Sprintf("slave %s %s %d @ %s %s %d", in.addr(), in.host, in.port, m.name, m.instance.host, m.instance.port)
+}
+
+// sentinelInit monitors the primaries given on the command line, each as
+// "<name> <ip> <port> <quorum>", and starts the periodic checks.
+func (srv *Server) sentinelInit(monitors []string) error {
+	for _, monitor := range monitors {
+		fields := strings.Fields(monitor)
+		if len(fields) != 4 {
+			return fmt.Errorf("invalid --sentinel-monitor %q", monitor)
+		}
+		result := srv.sentinel(commandValue(append([]string{"MONITOR"}, fields...)...).Array)
+		if result.Type == "error" {
+			return fmt.Errorf("%s", result.Str)
+		}
+	}
+
+	srv.goBackground(srv.sentinelCron)
+	return nil
+}
+
+// sentinelMonitor starts monitoring a primary. The caller must hold
+// sentinelMu.
+func (srv *Server) sentinelMonitor(name, host string, port, quorum int) error {
+	if _, ok := srv.sentinelMasters[name]; ok {
+		return fmt.Errorf("ERR Duplicated master name")
+	}
+	if quorum <= 0 {
+		return fmt.Errorf("ERR Quorum must be 1 or greater.")
+	}
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("ERR Invalid port number")
+	}
+
+	m := &sentinelMaster{
+		name:            name,
+		instance:        srv.newSentinelInstance(host, port, true),
+		replicas:        map[string]*sentinelInstance{},
+		sentinels:       map[string]*sentinelInstance{},
+		quorum:          quorum,
+		downAfter:       sentinelDownAfter,
+		failoverTimeout: sentinelFailoverTimeout,
+	}
+	srv.sentinelMasters[name] = m
+	srv.sentinelEvent("+monitor", fmt.Sprintf("%s quorum %d", m.describe(m.instance), quorum))
+
+	return nil
+}
+
+// sentinelRemove stops monitoring a primary. The caller must hold
+// sentinelMu.
+func (srv *Server) sentinelRemove(m *sentinelMaster) {
+	m.close()
+	delete(srv.sentinelMasters, m.name)
+	srv.sentinelEvent("-monitor", m.describe(m.instance))
+}
+
+// sentinelCron runs the periodic checks of every monitored primary, until
+// the server is closed. Network round trips are made without holding
+// sentinelMu, so that a Sentinel waiting on another never keeps it from
+// answering.
+func (srv *Server) sentinelCron() {
+	ticker := time.NewTicker(sentinelPeriod)
+	defer ticker.Stop()
+
+	lastHello := time.Time{}
+	for {
+		select {
+		case <-ticker.C:
+		case <-srv.done:
+			return
+		}
+
+		hello := time.Since(lastHello) >= sentinelHelloPeriod
+		if hello {
+			lastHello = time.Now()
+		}
+
+		srv.sentinelMu.Lock()
+		masters := make([]*sentinelMaster, 0, len(srv.sentinelMasters))
+		for _, m := range srv.sentinelMasters {
+			masters = append(masters, m)
+		}
+		srv.sentinelMu.Unlock()
+
+		for _, m := range masters {
+			srv.sentinelRefresh(m)
+			if hello {
+				srv.sentinelSendHellos(m)
+			}
+			srv.sentinelCheckDown(m)
+			srv.sentinelFailover(m)
+			srv.sentinelFixConfig(m)
+		}
+	}
+}
+
+// sentinelRefresh sends PING to every instance, and INFO to the primary and
+// its replicas.
+func (srv *Server) sentinelRefresh(m *sentinelMaster) {
+	srv.sentinelMu.Lock()
+	instances := []*sentinelInstance{m.instance}
+	for _, r := range m.replicas {
+		instances = append(instances, r)
+	}
+	sentinels := map[*sentinelInstance]bool{}
+	for _, s := range m.sentinels {
+		instances = append(instances, s)
+		sentinels[s] = true
+	}
+	srv.sentinelMu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, in := range instances {
+		wg.Add(1)
+		go func(in *sentinelInstance) {
+			defer wg.Done()
+
+			pong, err := in.request("PING")
+			ok := err == nil && (pong.Type == "string" ||
+				strings.HasPrefix(pong.Str, "LOADING") || strings.HasPrefix(pong.Str, "MASTERDOWN"))
+
+			var info Value
+			if err == nil && !sentinels[in] {
+				info, err = in.request("INFO")
+			}
+
+			srv.sentinelMu.Lock()
+			defer srv.sentinelMu.Unlock()
+
+			if ok {
+				in.lastOK = time.Now()
+			}
+			if err == nil && info.Type == "bulk" {
+				srv.sentinelRefreshFromInfo(m, in, info.Bulk)
+			}
+		}(in)
+	}
+	wg.Wait()
+}
+
+// sentinelRefreshFromInfo records what an instance reports in INFO. The
+// caller must hold sentinelMu.
+func (srv *Server) sentinelRefreshFromInfo(m *sentinelMaster, in *sentinelInstance, info string) {
+	fields := map[string]string{}
+	replicas := []string{}
+	for _, line := range strings.Split(info, "\r\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields[key] = value
+		if strings.HasPrefix(key, "slave") && strings.Contains(value, "ip=") {
+			replicas = append(replicas, value)
+		}
+	}
+
+	in.lastInfo = time.Now()
+	in.runID = fields["run_id"]
+
+	role := fields["role"]
+	masterHost := fields["master_host"]
+	masterPort, _ := strconv.Atoi(fields["master_port"])
+	if role != in.role || masterHost != in.masterHost || masterPort != in.masterPort {
+		in.configSince = time.Now()
+	}
+	in.role, in.masterHost, in.masterPort = role, masterHost, masterPort
+
+	if role == "slave" {
+		in.linkUp = fields["master_link_status"] == "up"
+		in.offset, _ = strconv.ParseInt(fields["slave_repl_offset"], 10, 64)
+		if priority, err := strconv.Atoi(fields["slave_priority"]); err == nil {
+			in.priority = priority
+		}
+	}
+
+	// Replicas are discovered from the primary's list.
+	if in == m.instance && role == "master" {
+		for _, line := range replicas {
+			attrs := map[string]string{}
+			for _, attr := range strings.Split(line, ",") {
+				key, value, _ := strings.Cut(attr, "=")
+				attrs[key] = value
+			}
+			port, err := strconv.Atoi(attrs["port"])
+			if err != nil {
+				continue
+			}
+			addr := net.JoinHostPort(attrs["ip"], attrs["port"])
+			if _, ok := m.replicas[addr]; !ok && addr != m.instance.addr() {
+				r := srv.newSentinelInstance(attrs["ip"], port, true)
+				m.replicas[addr] = r
+				srv.sentinelEvent("+slave", m.describe(r))
+			}
+		}
+	}
+
+	if in == m.promoted && m.failoverState == failoverWaitPromotion && role == "master" {
+		m.configEpoch = m.failoverEpoch
+		m.failoverState = failoverReconfReplicas
+		srv.sentinelEvent("+promoted-slave", m.describe(in))
+		srv.sentinelEvent("+failover-state-reconf-slaves", m.describe(m.instance))
+	}
+}
+
+// sentinelSendHellos publishes this Sentinel and its view of the primary on
+// the hello channel of every reachable instance.
+func (srv *Server) sentinelSendHellos(m *sentinelMaster) {
+	srv.sentinelMu.Lock()
+	master := m.instance
+	if m.failoverState == failoverReconfReplicas {
+		master = m.promoted
+	}
+	instances := []*sentinelInstance{}
+	for _, in := range append([]*sentinelInstance{m.instance}, sortedInstances(m.replicas)...) {
+		if !in.sdown {
+			instances = append(instances, in)
+		}
+	}
+	hello := fmt.Sprintf("%d,%s,%d,%s,%s,%d,%d", srv.port, srv.runID, srv.sentinelCurrentEpoch,
+		m.name, master.host, master.port, m.configEpoch)
+	srv.sentinelMu.Unlock()
+
+	for _, in := range instances {
+		if ip := in.localIP(); ip != "" {
+			in.request("PUBLISH", sentinelHelloChannel, ip+","+hello)
+		}
+	}
+}
+
+// sentinelProcessHello handles a hello published by another Sentinel:
+// ip,port,runid,epoch,master name,master ip,master port,master epoch.
+func (srv *Server) sentinelProcessHello(hello string) {
+	fields := strings.Split(hello, ",")
+	if len(fields) != 8 || fields[2] == srv.runID {
+		return
+	}
+
+	port, err1 := strconv.Atoi(fields[1])
+	epoch, err2 := strconv.ParseInt(fields[3], 10, 64)
+	masterPort, err3 := strconv.Atoi(fields[6])
+	masterEpoch, err4 := strconv.ParseInt(fields[7], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return
+	}
+
+	srv.sentinelMu.Lock()
+	defer srv.sentinelMu.Unlock()
+
+	m, ok := srv.sentinelMasters[fields[4]]
+	if !ok {
+		return
+	}
+
+	if epoch > srv.sentinelCurrentEpoch {
+		srv.sentinelCurrentEpoch = epoch
+		srv.sentinelEvent("+new-epoch", strconv.FormatInt(epoch, 10))
+	}
+
+	s, ok := m.sentinels[fields[2]]
+	if !ok {
+		// A Sentinel restarted with a new ID replaces its old entry.
+		for id, other := range m.sentinels {
+			if other.host == fields[0] && other.port == port {
+				other.close()
+				delete(m.sentinels, id)
+			}
+		}
+		s = srv.newSentinelInstance(fields[0], port, false)
+		s.runID = fields[2]
+		m.sentinels[s.runID] = s
+		srv.sentinelEvent("+sentinel", fmt.Sprintf("sentinel %s %s %d @ %s %s %d",
+			s.runID, s.host, s.port, m.name, m.instance.host, m.instance.port))
+	}
+	s.lastHello = time.Now()
+
+	// A newer configuration wins, even over a failover we are attempting.
+	masterAddr := net.JoinHostPort(fields[5], fields[6])
+	if masterEpoch > m.configEpoch && masterAddr != m.instance.addr() {
+		m.configEpoch = masterEpoch
+		srv.sentinelEvent("+config-update-from", fmt.Sprintf("sentinel %s %s %d @ %s %s %d",
+			s.runID, s.host, s.port, m.name, m.instance.host, m.instance.port))
+		srv.sentinelSwitchMaster(m, fields[5], masterPort)
+	}
+}
+
+// sentinelCheckDown flags instances that stopped answering and, when the
+// primary is one of them, asks the other Sentinels whether they agree. The
+// question doubles as a vote request once a failover is starting.
+func (srv *Server) sentinelCheckDown(m *sentinelMaster) {
+	srv.sentinelMu.Lock()
+	now := time.Now()
+	for _, in := range append([]*sentinelInstance{m.instance}, sortedInstances(m.replicas)...) {
+		sdown := now.Sub(in.lastOK) > m.downAfter
+		if sdown != in.sdown {
+			in.sdown = sdown
+			if sdown {
+				srv.sentinelEvent("+sdown", m.describe(in))
+			} else {
+				srv.sentinelEvent("-sdown", m.describe(in))
+			}
+		}
+	}
+	for _, s := range m.sentinels {
+		s.sdown = now.Sub(s.lastOK) > m.downAfter
+	}
+
+	master := m.instance
+	sdown := master.sdown
+	candidate := "*"
+	if m.failoverState == failoverWaitStart {
+		candidate = srv.runID
+	}
+	sentinels := sortedInstances(m.sentinels)
+	if !sdown {
+		for _, s := range sentinels {
+			s.masterDown = false
+		}
+	}
+	epoch := strconv.FormatInt(srv.sentinelCurrentEpoch, 10)
+	srv.sentinelMu.Unlock()
+
+	if sdown {
+		var wg sync.WaitGroup
+		for _, s := range sentinels {
+			wg.Add(1)
+			go func(s *sentinelInstance) {
+				defer wg.Done()
+
+				reply, err := s.request("SENTINEL", "IS-MASTER-DOWN-BY-ADDR",
+					master.host, strconv.Itoa(master.port), epoch, candidate)
+				if err != nil || reply.Type != "array" || len(reply.Array) != 3 {
+					return
+				}
+
+				srv.sentinelMu.Lock()
+				defer srv.sentinelMu.Unlock()
+
+				s.lastOK = time.Now()
+				s.lastReply = s.lastOK
+				s.masterDown = reply.Array[0].Num == 1
+				if reply.Array[1].Bulk != "*" {
+					s.leader = reply.Array[1].Bulk
+					s.leaderEpoch = int64(reply.Array[2].Num)
+				}
+			}(s)
+		}
+		wg.Wait()
+	}
+
+	srv.sentinelMu.Lock()
+	defer srv.sentinelMu.Unlock()
+
+	agreeing := 0
+	if master.sdown {
+		agreeing++
+		for _, s := range m.sentinels {
+			if s.masterDown && time.Since(s.lastReply) < sentinelReplyValidity {
+				agreeing++
+			}
+		}
+	}
+
+	odown := agreeing >= m.quorum
+	if odown != m.odown {
+		m.odown = odown
+		if odown {
+			srv.sentinelEvent("+odown", fmt.Sprintf("%s #quorum %d/%d", m.describe(master), agreeing, m.quorum))
+		} else {
+			srv.sentinelEvent("-odown", m.describe(master))
+		}
+	}
+}
+
+// sentinelVoteLeader grants this Sentinel's vote for the given epoch to
+// candidate, unless it already voted in that epoch, and returns the vote.
+// The caller must hold sentinelMu.
+func (srv *Server) sentinelVoteLeader(m *sentinelMaster, epoch int64, candidate string) (string, int64) {
+	if epoch > srv.sentinelCurrentEpoch {
+		srv.sentinelCurrentEpoch = epoch
+		srv.sentinelEvent("+new-epoch", strconv.FormatInt(epoch, 10))
+	}
+
+	if m.leaderEpoch < epoch && srv.sentinelCurrentEpoch <= epoch {
+		m.leader, m.leaderEpoch = candidate, epoch
+		srv.sentinelEvent("+vote-for-leader", fmt.Sprintf("%s %d", candidate, epoch))
+
+		// Give the Sentinel we voted for time to complete the failover
+		// before trying ourselves.
+		if candidate != srv.runID {
+			m.failoverDelayUntil = time.Now().Add(2 * m.failoverTimeout)
+		}
+	}
+
+	return m.leader, m.leaderEpoch
+}
+
+// sentinelElectedLeader counts the votes of the current failover epoch and
+// returns the winner, if it has both the quorum and a majority of the known
+// Sentinels. The caller must hold sentinelMu.
+func (srv *Server) sentinelElectedLeader(m *sentinelMaster) string {
+	votes := map[string]int{}
+	for _, s := range m.sentinels {
+		if s.leader != "" && s.leaderEpoch == m.failoverEpoch {
+			votes[s.leader]++
+		}
+	}
+	leader, _ := srv.sentinelVoteLeader(m, m.failoverEpoch, srv.runID)
+	votes[leader]++
+
+	winner, most := "", 0
+	for candidate, n := range votes {
+		if n > most || (n == most && candidate < winner) {
+			winner, most = candidate, n
+		}
+	}
+
+	voters := len(m.sentinels) + 1
+	if most < voters/2+1 || most < m.quorum {
+		return ""
+	}
+	return winner
+}
+
+// sentinelSelectReplica picks the replica to promote: a reachable one with
+// a non-zero priority, preferring the lowest priority, then the most data.
+// The caller must hold sentinelMu.
+func sentinelSelectReplica(m *sentinelMaster) *sentinelInstance {
+	candidates := []*sentinelInstance{}
+	for _, r := range m.replicas {
+		if r.sdown || r.priority == 0 || r.role != "slave" ||
+			time.Since(r.lastOK) > sentinelReplyValidity || time.Since(r.lastInfo) > sentinelReplyValidity {
+			continue
+		}
+		candidates = append(candidates, r)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+		if a.offset != b.offset {
+			return a.offset > b.offset
+		}
+		return a.runID < b.runID
+	})
+	return candidates[0]
+}
+
+// sentinelFailover advances the failover state machine of a primary.
+func (srv *Server) sentinelFailover(m *sentinelMaster) {
+	srv.sentinelMu.Lock()
+	defer srv.sentinelMu.Unlock()
+
+	switch m.failoverState {
+	case failoverNone:
+		if !m.odown || time.Now().Before(m.failoverDelayUntil) {
+			return
+		}
+		srv.sentinelStartFailover(m)
+		srv.sentinelEvent("+try-failover", m.describe(m.instance))
+
+	case failoverWaitStart:
+		leader := srv.sentinelElectedLeader(m)
+		if leader == srv.runID {
+			m.failoverState = failoverSelectReplica
+			srv.sentinelEvent("+elected-leader", m.describe(m.instance))
+			srv.sentinelEvent("+failover-state-select-slave", m.describe(m.instance))
+		} else if time.Since(m.failoverStart) > m.failoverTimeout {
+			srv.sentinelAbortFailover(m, "-failover-abort-not-elected")
+		}
+
+	case failoverSelectReplica:
+		r := sentinelSelectReplica(m)
+		if r == nil {
+			srv.sentinelAbortFailover(m, "-failover-abort-no-good-slave")
+			return
+		}
+		m.promoted = r
+		m.failoverState = failoverWaitPromotion
+		srv.sentinelEvent("+selected-slave", m.describe(r))
+		srv.sentinelEvent("+failover-state-send-slaveof-noone", m.describe(r))
+
+		srv.sentinelMu.Unlock()
+		r.request("REPLICAOF", "NO", "ONE")
+		srv.sentinelMu.Lock()
+
+	case failoverWaitPromotion:
+		if time.Since(m.failoverStart) > m.failoverTimeout {
+			srv.sentinelAbortFailover(m, "-failover-abort-slave-timeout")
+		}
+
+	case failoverReconfReplicas:
+		promoted := m.promoted
+		others := []*sentinelInstance{}
+		for _, r := range sortedInstances(m.replicas) {
+			if r != promoted && !r.sdown {
+				others = append(others, r)
+			}
+		}
+
+		srv.sentinelMu.Unlock()
+		for _, r := range others {
+			r.request("REPLICAOF", promoted.host, strconv.Itoa(promoted.port))
+		}
+		srv.sentinelMu.Lock()
+
+		for _, r := range others {
+			srv.sentinelEvent("+slave-reconf-sent", m.describe(r))
+		}
+		srv.sentinelEvent("+failover-end", m.describe(m.instance))
+		srv.sentinelSwitchMaster(m, promoted.host, promoted.port)
+	}
+}
+
+// sentinelStartFailover opens a new epoch for a failover of m. The caller
+// must hold sentinelMu.
+func (srv *Server) sentinelStartFailover(m *sentinelMaster) {
+	srv.sentinelCurrentEpoch++
+	srv.sentinelEvent("+new-epoch", strconv.FormatInt(srv.sentinelCurrentEpoch, 10))
+
+	m.failoverState = failoverWaitStart
+	m.failoverEpoch = srv.sentinelCurrentEpoch
+	m.failoverStart = time.Now()
+	m.failoverDelayUntil = m.failoverStart.Add(2 * m.failoverTimeout)
+}
+
+func (srv *Server) sentinelAbortFailover(m *sentinelMaster, event string) {
+	srv.sentinelEvent(event, m.describe(m.instance))
+	m.failoverState = failoverNone
+	m.promoted = nil
+}
+
+// sentinelSwitchMaster makes the instance at host:port the primary of m,
+// turning the previous primary into one of its replicas. The caller must
+// hold sentinelMu.
+func (srv *Server) sentinelSwitchMaster(m *sentinelMaster, host string, port int) {
+	old := m.instance
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+	promoted, ok := m.replicas[addr]
+	if !ok {
+		promoted = srv.newSentinelInstance(host, port, true)
+	}
+	delete(m.replicas, addr)
+	m.replicas[old.addr()] = old
+
+	m.instance = promoted
+	m.odown = false
+	m.failoverState = failoverNone
+	m.promoted = nil
+	for _, s := range m.sentinels {
+		s.masterDown = false
+	}
+
+	// Give the replicas time to follow before judging their configuration.
+	now := time.Now()
+	for _, r := range m.replicas {
+		r.configSince = now
+	}
+	promoted.configSince = now
+
+	srv.sentinelEvent("+switch-master", fmt.Sprintf("%s %s %d %s %d", m.name, old.host, old.port, host, port))
+}
+
+// sentinelFixConfig points instances that disagree with the current
+// configuration at the primary: a former primary that came back, or a
+// replica following another one. Only done while the primary is up and no
+// failover is running, and once the instance kept its view for a while, so
+// that a configuration still being propagated is not undone.
+func (srv *Server) sentinelFixConfig(m *sentinelMaster) {
+	srv.sentinelMu.Lock()
+	master := m.instance
+	if m.failoverState != failoverNone || master.sdown || master.role != "master" {
+		srv.sentinelMu.Unlock()
+		return
+	}
+
+	type fix struct {
+		in    *sentinelInstance
+		event string
+	}
+	fixes := []fix{}
+	for _, r := range sortedInstances(m.replicas) {
+		if r.sdown || time.Since(r.configSince) < sentinelReconfigDelay || time.Since(r.lastInfo) > sentinelReplyValidity {
+			continue
+		}
+		if r.role == "master" {
+			fixes = append(fixes, fix{r, "+convert-to-slave"})
+		} else if r.role == "slave" && (r.masterHost != master.host || r.masterPort != master.port) {
+			fixes = append(fixes, fix{r, "+fix-slave-config"})
+		}
+	}
+	for _, f := range fixes {
+		srv.sentinelEvent(f.event, m.describe(f.in))
+		f.in.configSince = time.Now()
+	}
+	srv.sentinelMu.Unlock()
+
+	for _, f := range fixes {
+		f.in.request("REPLICAOF", master.host, strconv.Itoa(master.port))
+	}
+}
+
+// sortedInstances returns instances ordered by address, for stable replies.
+func sortedInstances(instances map[string]*sentinelInstance) []*sentinelInstance {
+	sorted := make([]*sentinelInstance, 0, len(instances))
+	for _, in := range instances {
+		sorted = append(sorted, in)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].addr() < sorted[j].addr()
+	})
+	return sorted
+}
+
+func (srv *Server) sentinel(args []Value) Value {
+	if !srv.sentinelMode {
+		return Value{Type: "error", Str: "ERR unknown command 'sentinel'"}
+	}
+	srv.sentinelMu.Lock()
+	defer srv.sentinelMu.Unlock()
+
+	subcommand := strings.ToUpper(args[0].Bulk)
+	args = args[1:]
+
+	if subcommand == "MYID" {
+		return Value{Type: "bulk", Bulk: srv.runID}
+	}
+	if subcommand == "MASTERS" {
+		masters := []Value{}
+		for _, name := range srv.sortedMasterNames() {
+			masters = append(masters, sentinelMasterValue(srv.sentinelMasters[name]))
+		}
+		return Value{Type: "array", Array: masters}
+	}
+	if subcommand == "MONITOR" {
+		if len(args) != 4 {
+			return Value{Type: "error", Str: "ERR wrong number of arguments for 'sentinel|monitor' command"}
+		}
+		port, err1 := strconv.Atoi(args[2].Bulk)
+		quorum, err2 := strconv.Atoi(args[3].Bulk)
+		if err1 != nil || err2 != nil {
+			return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+		}
+		if err := srv.sentinelMonitor(args[0].Bulk, args[1].Bulk, port, quorum); err != nil {
+			return Value{Type: "error", Str: err.Error()}
+		}
+		return Value{Type: "string", Str: "OK"}
+	}
+	if subcommand == "IS-MASTER-DOWN-BY-ADDR" {
+		return srv.sentinelIsMasterDownByAddr(args)
+	}
+
+	if len(args) == 0 {
+		return Value{Type: "error", Str: fmt.Sprintf("ERR wrong number of arguments for 'sentinel|%s' command", strings.ToLower(subcommand))}
+	}
+	m, ok := srv.sentinelMasters[args[0].Bulk]
+	if !ok {
+		if subcommand == "GET-MASTER-ADDR-BY-NAME" {
+			return Value{Type: "null"}
+		}
+		return Value{Type: "error", Str: "ERR No such master with that name"}
+	}
+
+	switch subcommand {
+	case "GET-MASTER-ADDR-BY-NAME":
+		master := m.instance
+		if m.failoverState == failoverReconfReplicas {
+			master = m.promoted
+		}
+		return Value{Type: "array", Array: []Value{
+			{Type: "bulk", Bulk: master.host},
+			{Type: "bulk", Bulk: strconv.Itoa(master.port)},
+		}}
+	case "MASTER":
+		return sentinelMasterValue(m)
+	case "REPLICAS", "SLAVES":
+		replicas := []Value{}
+		for _, r := range sortedInstances(m.replicas) {
+			replicas = append(replicas, sentinelReplicaValue(m, r))
+		}
+		return Value{Type: "array", Array: replicas}
+	case "SENTINELS":
+		sentinels := []Value{}
+		for _, s := range sortedInstances(m.sentinels) {
+			sentinels = append(sentinels, sentinelSentinelValue(s))
+		}
+		return Value{Type: "array", Array: sentinels}
+	case "REMOVE":
+		srv.sentinelRemove(m)
+		return Value{Type: "string", Str: "OK"}
+	case "SET":
+		return srv.sentinelSet(m, args[1:])
+	case "CKQUORUM":
+		usable := 1
+		for _, s := range m.sentinels {
+			if !s.sdown {
+				usable++
+			}
+		}
+		voters := len(m.sentinels) + 1
+		if usable < m.quorum {
+			return Value{Type: "error", Str: fmt.Sprintf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the specified quorum for this master", usable)}
+		}
+		if usable < voters/2+1 {
+			return Value{Type: "error", Str: fmt.Sprintf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the majority and authorize a failover", usable)}
+		}
+		return Value{Type: "string", Str: fmt.Sprintf("OK %d usable Sentinels. Quorum and failover authorization can be reached", usable)}
+	case "FAILOVER":
+		// A forced failover needs no agreement from the other Sentinels.
+		if m.failoverState != failoverNone {
+			return Value{Type: "error", Str: "INPROGRESS Failover already in progress"}
+		}
+		if sentinelSelectReplica(m) == nil {
+			return Value{Type: "error", Str: "NOGOODSLAVE No suitable replica to promote"}
+		}
+		srv.sentinelStartFailover(m)
+		m.failoverState = failoverSelectReplica
+		srv.sentinelEvent("+failover-state-select-slave", m.describe(m.instance))
+		return Value{Type: "string", Str: "OK"}
+	}
+
+	return Value{Type: "error", Str: fmt.Sprintf("ERR unknown subcommand '%s'. Try SENTINEL HELP.", strings.ToLower(subcommand))}
+}
+
+func (srv *Server) sentinelSet(m *sentinelMaster, args []Value) Value {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return Value{Type: "error", Str: "ERR wrong number of arguments for 'sentinel|set' command"}
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		n, err := strconv.Atoi(args[i+1].Bulk)
+		if err != nil || n <= 0 {
+			return Value{Type: "error", Str: fmt.Sprintf("ERR Invalid argument '%s' for SENTINEL SET '%s'", args[i+1].Bulk, args[i].Bulk)}
+		}
+
+		switch strings.ToLower(args[i].Bulk) {
+		case "down-after-milliseconds":
+			m.downAfter = time.Duration(n) * time.Millisecond
+		case "failover-timeout":
+			m.failoverTimeout = time.Duration(n) * time.Millisecond
+		case "quorum":
+			m.quorum = n
+		default:
+			return Value{Type: "error", Str: fmt.Sprintf("ERR Invalid argument '%s' to SENTINEL SET", args[i].Bulk)}
+		}
+		srv.sentinelEvent("+set", fmt.Sprintf("%s %s %s", m.describe(m.instance), args[i].Bulk, args[i+1].Bulk))
+	}
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+// sentinelIsMasterDownByAddr answers another Sentinel asking whether we
+// see a primary as down and, when it names itself, for our vote.
+func (srv *Server) sentinelIsMasterDownByAddr(args []Value) Value {
+	if len(args) != 4 {
+		return Value{Type: "error", Str: "ERR wrong number of arguments for 'sentinel|is-master-down-by-addr' command"}
+	}
+	epoch, err := strconv.ParseInt(args[2].Bulk, 10, 64)
+	if err != nil {
+		return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+	}
+
+	addr := net.JoinHostPort(args[0].Bulk, args[1].Bulk)
+	var m *sentinelMaster
+	for _, candidate := range srv.sentinelMasters {
+		if candidate.instance.addr() == addr {
+			m = candidate
+		}
+	}
+
+	down := 0
+	leader, leaderEpoch := "*", int64(0)
+	if m != nil {
+		if m.instance.sdown {
+			down = 1
+		}
+		if args[3].Bulk != "*" {
+			leader, leaderEpoch = srv.sentinelVoteLeader(m, epoch, args[3].Bulk)
+		}
+	}
+
+	return Value{Type: "array", Array: []Value{
+		{Type: "integer", Num: down},
+		{Type: "bulk", Bulk: leader},
+		{Type: "integer", Num: int(leaderEpoch)},
+	}}
+}
+
+func (srv *Server) sortedMasterNames() []string {
+	names := make([]string, 0, len(srv.sentinelMasters))
+	for name := range srv.sentinelMasters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fieldsValue builds the flat field/value array SENTINEL MASTER and friends
+// reply with.
+func fieldsValue(fields ...string) Value {
+	value := Value{Type: "array"}
+	for _, field := range fields {
+		value.Array = append(value.Array, Value{Type: "bulk", Bulk: field})
+	}
+	return value
+}
+
+func (in *sentinelInstance) flags(kind string) string {
+	flags := kind
+	if in.sdown {
+		flags += ",s_down"
+	}
+	if time.Since(in.lastOK) > sentinelReplyValidity {
+		flags += ",disconnected"
+	}
+	return flags
+}
+
+func sentinelMasterValue(m *sentinelMaster) Value {
+	in := m.instance
+	flags := in.flags("master")
+	if m.odown {
+		flags += ",o_down"
+	}
+	if m.failoverState != failoverNone {
+		flags += ",failover_in_progress"
+	}
+
+	return fieldsValue(
+		"name", m.name,
+		"ip", in.host,
+		"port", strconv.Itoa(in.port),
+		"runid", in.runID,
+		"flags", flags,
+		"last-ok-ping-reply", strconv.FormatInt(time.Since(in.lastOK).Milliseconds(), 10),
+		"role-reported", in.role,
+		"num-slaves", strconv.Itoa(len(m.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(m.sentinels)),
+		"quorum", strconv.Itoa(m.quorum),
+		"down-after-milliseconds", strconv.FormatInt(m.downAfter.Milliseconds(), 10),
+		"failover-timeout", strconv.FormatInt(m.failoverTimeout.Milliseconds(), 10),
+		"config-epoch", strconv.FormatInt(m.configEpoch, 10),
+		"failover-state", failoverStates[m.failoverState],
+	)
+}
+
+func sentinelReplicaValue(m *sentinelMaster, r *sentinelInstance) Value {
+	flags := r.flags("slave")
+	if r == m.promoted {
+		flags += ",promoted"
+	}
+	status := "err"
+	if r.linkUp {
+		status = "ok"
+	}
+
+	return fieldsValue(
+		"name", r.addr(),
+		"ip", r.host,
+		"port", strconv.Itoa(r.port),
+		"runid", r.runID,
+		"flags", flags,
+		"last-ok-ping-reply", strconv.FormatInt(time.Since(r.lastOK).Milliseconds(), 10),
+		"role-reported", r.role,
+		"master-host", r.masterHost,
+		"master-port", strconv.Itoa(r.masterPort),
+		"master-link-status", status,
+		"slave-priority", strconv.Itoa(r.priority),
+		"slave-repl-offset", strconv.FormatInt(r.offset, 10),
+	)
+}
+
+func sentinelSentinelValue(s *sentinelInstance) Value {
+	return fieldsValue(
+		"name", s.runID,
+		"ip", s.host,
+		"port", strconv.Itoa(s.port),
+		"runid", s.runID,
+		"flags", s.flags("sentinel"),
+		"last-hello-message", strconv.FormatInt(time.Since(s.lastHello).Milliseconds(), 10),
+		"voted-leader", s.leader,
+		"voted-leader-epoch", strconv.FormatInt(s.leaderEpoch, 10),
+	)
+}
+
+// sentinelRole answers ROLE in Sentinel mode with the monitored primaries.
+func (srv *Server) sentinelRole() Value {
+	srv.sentinelMu.Lock()
+	defer srv.sentinelMu.Unlock()
+
+	names := []Value{}
+	for _, name := range srv.sortedMasterNames() {
+		names = append(names, Value{Type: "bulk", Bulk: name})
+	}
+	return Value{Type: "array", Array: []Value{
+		{Type: "bulk", Bulk: "sentinel"},
+		{Type: "array", Array: names},
+	}}
+}
+
+func (srv *Server) infoSentinel(sb *strings.Builder) {
+	srv.sentinelMu.Lock()
+	defer srv.sentinelMu.Unlock()
+
+	fmt.Fprintf(sb, "sentinel_masters:%d\r\n", len(srv.sentinelMasters))
+	fmt.Fprintf(sb, "sentinel_tilt:0\r\n")
+	for i, name := range srv.sortedMasterNames() {
+		m := srv.sentinelMasters[name]
+		status := "ok"
+		if m.odown {
+			status = "odown"
+		} else if m.instance.sdown {
+			status = "sdown"
+		}
+		fmt.Fprintf(sb, "master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d\r\n",
+			i, name, status, m.instance.addr(), len(m.replicas), len(m.sentinels)+1)
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestMaster() *sentinelMaster {
+	return &sentinelMaster{
+		name:            "mymaster",
+		instance:        &sentinelInstance{host: "127.0.0.1", port: 6379},
+		replicas:        map[string]*sentinelInstance{},
+		sentinels:       map[string]*sentinelInstance{},
+		quorum:          2,
+		failoverTimeout: time.Minute,
+	}
+}
+
+func TestSentinelVoteLeader(t *testing.T) {
+	testServer.sentinelMu.Lock()
+	defer testServer.sentinelMu.Unlock()
+
+	saved := testServer.sentinelCurrentEpoch
+	defer func() { testServer.sentinelCurrentEpoch = saved }()
+	testServer.sentinelCurrentEpoch = 0
+
+	m := newTestMaster()
+
+	if leader, epoch := testServer.sentinelVoteLeader(m, 1, "a"); leader != "a" || epoch != 1 {
+		t.Errorf("Expected vote for a in epoch 1, got %s in %d", leader, epoch)
+	}
+	if leader, _ := testServer.sentinelVoteLeader(m, 1, "b"); leader != "a" {
+		t.Errorf("Expected a single vote per epoch, got %s", leader)
+	}
+	if !m.failoverDelayUntil.After(time.Now()) {
+		t.Errorf("Expected voting for another Sentinel to delay our own failover")
+	}
+	if leader, epoch := testServer.sentinelVoteLeader(m, 2, "b"); leader != "b" || epoch != 2 {
+		t.Errorf("Expected vote for b in epoch 2, got %s in %d", leader, epoch)
+	}
+	if testServer.sentinelCurrentEpoch != 2 {
+		t.Errorf("Expected current epoch 2, got %d", testServer.sentinelCurrentEpoch)
+	}
+}
+
+func TestSentinelElectedLeader(t *testing.T) {
+	testServer.sentinelMu.Lock()
+	defer testServer.sentinelMu.Unlock()
+
+	saved := testServer.sentinelCurrentEpoch
+	defer func() { testServer.sentinelCurrentEpoch = saved }()
+	testServer.sentinelCurrentEpoch = 3
+
+	m := newTestMaster()
+	m.failoverEpoch = 3
+	m.sentinels["s1"] = &sentinelInstance{leader: testServer.runID, leaderEpoch: 3}
+	m.sentinels["s2"] = &sentinelInstance{leader: "s2", leaderEpoch: 3}
+	m.sentinels["s3"] = &sentinelInstance{leader: testServer.runID, leaderEpoch: 2}
+	m.sentinels["s4"] = &sentinelInstance{}
+
+	// Two votes out of five Sentinels: the quorum but not a majority.
+	if leader := testServer.sentinelElectedLeader(m); leader != "" {
+		t.Errorf("Expected no leader, got %s", leader)
+	}
+
+	m.sentinels["s3"].leaderEpoch = 3
+	if leader := testServer.sentinelElectedLeader(m); leader != testServer.runID {
+		t.Errorf("Expected to be elected, got %q", leader)
+	}
+}
+
+func TestSentinelSelectReplica(t *testing.T) {
+	now := time.Now()
+	replica := func(priority int, offset int64, runID string) *sentinelInstance {
+		return &sentinelInstance{role: "slave", priority: priority, offset: offset, runID: runID, lastOK: now, lastInfo: now}
+	}
+
+	m := newTestMaster()
+	m.replicas["a"] = replica(100, 10, "a")
+	m.replicas["b"] = replica(100, 20, "b")
+	m.replicas["c"] = replica(100, 20, "c")
+	m.replicas["d"] = replica(0, 50, "d")
+	m.replicas["e"] = replica(100, 90, "e")
+	m.replicas["e"].sdown = true
+
+	if r := sentinelSelectReplica(m); r != m.replicas["b"] {
+		t.Errorf("Expected replica b, got %+v", r)
+	}
+
+	m.replicas["a"].priority = 10
+	if r := sentinelSelectReplica(m); r != m.replicas["a"] {
+		t.Errorf("Expected replica a, got %+v", r)
+	}
+
+	for _, r := range m.replicas {
+		r.priority = 0
+	}
+	if r := sentinelSelectReplica(m); r != nil {
+		t.Errorf("Expected no replica, got %+v", r)
+	}
+}
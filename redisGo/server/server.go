@@ -0,0 +1,693 @@
+// Package server is the redisGo storage engine: the keyspace, the commands
+// and their dispatcher, along with replication, cluster and Sentinel
+// support. Each Server holds a dataset, which it serves to clients over RESP
+// and its DB runs commands against in-process.
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"redisGo/aof"
+	"redisGo/resp"
+)
+
+// Value is the RESP value commands take as arguments and return.
+type Value = resp.Value
+
+// Options configure a Server. They follow the redis.conf directives of
+// the same names.
+type Options struct {
+	// AppendFilename is the path of the AOF persisting the dataset. The
+	// dataset is kept in memory only if it is empty.
+	AppendFilename string
+
+	// Port is the port clients reach the server on, 6379 by default, or
+	// 26379 for a Sentinel. The server does not listen on it itself.
+	Port int
+
+	// ClusterEnabled makes the server a Redis Cluster node, which saves
+	// its view of the cluster in ClusterConfigFile, nodes.conf by default.
+	ClusterEnabled    bool
+	ClusterConfigFile string
+
+	// Sentinel runs a Sentinel instead of a data server, monitoring the
+	// primaries of SentinelMonitors, given as "<name> <ip> <port> <quorum>".
+	Sentinel         bool
+	SentinelMonitors []string
+}
+
+// Server serves a dataset to the clients of the listeners passed to Serve,
+// and to the in-process calls of its DB. Each Server holds its own
+// keyspace, configuration, clients and statistics, so that several can run
+// side by side in a process.
+type Server struct {
+	closed atomic.Bool
+
+	// done is closed once the server is closed, which stops the goroutines
+	// the server started with goBackground; Close waits for them in
+	// background.
+	done         chan struct{}
+	backgroundMu sync.Mutex
+	background   sync.WaitGroup
+
+	// port is the port clients reach the server on, as announced to the
+	// cluster and reported by INFO.
+	port int
+
+	// The keyspace, split in shards.
+	shards []*Shard
+
+	// execMu makes scripts atomic: every command holds it for reading while it
+	// runs and scripts hold it exclusively.
+	execMu sync.RWMutex
+
+	// The configuration, as CONFIG GET and SET see it, and the values its
+	// parameters point to.
+	configMu                 sync.RWMutex
+	configParams             map[string]configParam
+	notifyKeyspaceEvents     int64
+	lfuLogFactor             int64
+	lfuDecayTime             int64
+	clientOutputBufferLimits map[string]outputLimit
+	clientQueryBufferLimit   int64
+	luaTimeLimit             int64
+	slowlogLogSlowerThan     int64
+	slowlogMaxLen            int64
+	latencyMonitorThreshold  int64
+
+	// maxmemoryPolicy is maxmemory-policy. Keys are never evicted, as there is
+	// no maxmemory, but like in Redis the policy decides whether OBJECT tells
+	// the idle time of keys or their access frequency.
+	maxmemoryPolicy string
+
+	// The listpack conversion thresholds. Negative list sizes are limits in
+	// bytes: -1 for 4 KB, -2 for 8 KB, up to -5 for 64 KB.
+	hashMaxListpackEntries int64
+	hashMaxListpackValue   int64
+	setMaxListpackEntries  int64
+	setMaxListpackValue    int64
+	listMaxListpackSize    int64
+
+	// The AOF, if any. loading is set while it is replayed, so that commands
+	// which log their own effects do not write them back into the file
+	// being read, and aofRewriting while BGREWRITEAOF runs.
+	aofFile      *aof.Aof
+	loading      bool
+	aofRewriting atomic.Bool
+
+	// listeners accept client connections; SHUTDOWN closes them.
+	listenersMu sync.Mutex
+	listeners   map[net.Listener]bool
+
+	// The connected clients, and those running MONITOR.
+	nextClientID atomic.Int64
+	clientsMu    sync.RWMutex
+	clients      map[int64]*Client
+	monitorsMu   sync.RWMutex
+	monitors     map[int64]*Client
+
+	// CLIENT PAUSE holds commands until pauseEnd, or only writes unless
+	// pauseAll, and closes pauseCh when it is lifted.
+	pauseMu  sync.Mutex
+	pauseEnd time.Time
+	pauseAll bool
+	pauseCh  chan struct{}
+
+	// Clients blocked on a key register a channel here; commands that add data
+	// to the key signal it so the blocked command can retry.
+	keyWaitersMu sync.Mutex
+	keyWaiters   map[string]map[chan struct{}]bool
+
+	// watchedKeys maps each key to the clients that WATCH it. Changing the
+	// key fails their next EXEC. The client side, Client.watched and
+	// Client.watchDirty, is guarded by watchedKeysMu too.
+	watchedKeysMu sync.Mutex
+	watchedKeys   map[string]map[*Client]bool
+
+	// Subscribers by channel and by pattern. The channels and patterns sets on
+	// each Client mirror these and are also guarded by pubSubMu.
+	pubSubMu       sync.RWMutex
+	pubSubChannels map[string]map[*Client]bool
+	pubSubPatterns map[string]map[*Client]bool
+
+	// Clients that read each key, and clients subscribed to each BCAST prefix.
+	trackingMu       sync.Mutex
+	trackingTable    map[string]map[*Client]bool
+	trackingPrefixes map[string]map[*Client]bool
+
+	// scriptMu guards runningScript and scriptBusyCh, which is closed when the
+	// running script goes over lua-time-limit to wake up the commands waiting
+	// for it. scripts caches the scripts by SHA1.
+	scriptMu      sync.Mutex
+	runningScript *scriptRun
+	scriptBusyCh  chan struct{}
+	scriptsMu     sync.RWMutex
+	scripts       map[string]*luaProto
+
+	// Replicas connected to this server, by their connection.
+	replicasMu sync.Mutex
+	replicas   map[*Client]*replicaInfo
+
+	// replOffset counts the bytes of replication stream produced by a primary,
+	// or applied by a replica.
+	replOffset atomic.Int64
+
+	// The primary this server replicates, if any. replicationGen is bumped on
+	// every REPLICAOF so that the goroutine serving an older link stops.
+	replicationMu  sync.Mutex
+	masterHost     string
+	masterPort     int
+	masterLinkUp   bool
+	masterLastIO   time.Time
+	masterConn     net.Conn
+	replicationGen int
+
+	// The cluster, when clusterEnabled: this node, the nodes it knows, and
+	// who serves each slot.
+	clusterEnabled    bool
+	clusterConfigFile string
+	clusterMu         sync.RWMutex
+	myself            *clusterNode
+	clusterNodes      map[string]*clusterNode
+	clusterSlots      [clusterSlots]*clusterNode
+	clusterMigrating  map[int]*clusterNode
+	clusterImporting  map[int]*clusterNode
+
+	// The primaries a Sentinel monitors, when sentinelMode.
+	sentinelMode         bool
+	sentinelMu           sync.Mutex
+	sentinelMasters      map[string]*sentinelMaster
+	sentinelCurrentEpoch int64
+
+	// shutdownMu serializes shutdowns; shuttingDown is set while one runs, so
+	// that a second SIGINT or SIGTERM can force the exit.
+	shutdownMu   sync.Mutex
+	shuttingDown atomic.Bool
+
+	// startTime and runID identify this run, so that Sentinel can tell a
+	// restarted instance from the one it knew.
+	startTime time.Time
+	runID     string
+
+	// The statistics INFO and the metrics report.
+	stats struct {
+		totalConnections atomic.Int64
+		totalCommands    atomic.Int64
+		expiredKeys      atomic.Int64
+
+		queryBufferLimitDisconnections  atomic.Int64
+		outputBufferLimitDisconnections atomic.Int64
+	}
+	commandStatsMu  sync.Mutex
+	commandStats    map[string]*commandStat
+	latencyEventsMu sync.Mutex
+	latencyEvents   map[string]*latencyEvent
+
+	// slowlogEntries holds the most recent entries first.
+	slowlogMu      sync.Mutex
+	slowlogEntries []slowlogEntry
+	slowlogNextID  int64
+}
+
+// newServer returns a server with an empty dataset and the default
+// configuration, which starts no goroutine.
+func newServer() *Server {
+	srv := &Server{
+		done:      make(chan struct{}),
+		port:      6379,
+		shards:    newShards(keyspaceShards),
+		listeners: map[net.Listener]bool{},
+
+		lfuLogFactor:             10,
+		lfuDecayTime:             1,
+		clientOutputBufferLimits: defaultOutputBufferLimits(),
+		clientQueryBufferLimit:   1 << 30,
+		luaTimeLimit:             5000,
+		slowlogLogSlowerThan:     10000,
+		slowlogMaxLen:            128,
+		maxmemoryPolicy:          "noeviction",
+		hashMaxListpackEntries:   128,
+		hashMaxListpackValue:     64,
+		setMaxListpackEntries:    128,
+		setMaxListpackValue:      64,
+		listMaxListpackSize:      -2,
+
+		clients:          map[int64]*Client{},
+		monitors:         map[int64]*Client{},
+		pauseCh:          make(chan struct{}),
+		keyWaiters:       map[string]map[chan struct{}]bool{},
+		watchedKeys:      map[string]map[*Client]bool{},
+		pubSubChannels:   map[string]map[*Client]bool{},
+		pubSubPatterns:   map[string]map[*Client]bool{},
+		trackingTable:    map[string]map[*Client]bool{},
+		trackingPrefixes: map[string]map[*Client]bool{},
+		scriptBusyCh:     make(chan struct{}),
+		scripts:          map[string]*luaProto{},
+		replicas:         map[*Client]*replicaInfo{},
+
+		clusterConfigFile: "nodes.conf",
+		clusterNodes:      map[string]*clusterNode{},
+		clusterMigrating:  map[int]*clusterNode{},
+		clusterImporting:  map[int]*clusterNode{},
+		sentinelMasters:   map[string]*sentinelMaster{},
+
+		startTime:      time.Now(),
+		runID:          randomID(),
+		commandStats:   map[string]*commandStat{},
+		latencyEvents:  map[string]*latencyEvent{},
+		slowlogEntries: []slowlogEntry{},
+	}
+	srv.configParams = srv.newConfigParams()
+	return srv
+}
+
+// ErrServerClosed is returned by Serve once the server is closed.
+var ErrServerClosed = errors.New("redisGo: server closed")
+
+// New starts a server from an empty dataset, which it loads from the AOF.
+// Servers share nothing, so several can run in a process, as long as they
+// use different AOFs and cluster config files.
+func New(opts Options) (*Server, error) {
+	if opts.ClusterEnabled && opts.Sentinel {
+		return nil, errors.New("redisGo: a Sentinel cannot be a cluster node")
+	}
+
+	srv := newServer()
+	srv.clusterEnabled = opts.ClusterEnabled
+	srv.sentinelMode = opts.Sentinel
+	if opts.ClusterConfigFile != "" {
+		srv.clusterConfigFile = opts.ClusterConfigFile
+	}
+
+	if opts.Port != 0 {
+		srv.port = opts.Port
+	} else if srv.sentinelMode {
+		srv.port = sentinelPort
+	}
+
+	// A Sentinel keeps no dataset: it only answers about the primaries it
+	// monitors.
+	if srv.sentinelMode {
+		if err := srv.sentinelInit(opts.SentinelMonitors); err != nil {
+			srv.stopBackground()
+			return nil, err
+		}
+		return srv, nil
+	}
+
+	if opts.AppendFilename != "" {
+		if err := srv.loadAof(opts.AppendFilename); err != nil {
+			return nil, err
+		}
+	}
+
+	if srv.clusterEnabled {
+		if err := srv.clusterInit(); err != nil {
+			if srv.aofFile != nil {
+				srv.aofFile.Close()
+				srv.aofFile = nil
+			}
+			return nil, err
+		}
+	}
+
+	srv.goBackground(srv.activeExpireCycle)
+
+	return srv, nil
+}
+
+// goBackground runs fn in a goroutine that Close waits for, unless the
+// server is closed already. fn returns once done is closed.
+func (srv *Server) goBackground(fn func()) {
+	srv.backgroundMu.Lock()
+	defer srv.backgroundMu.Unlock()
+
+	select {
+	case <-srv.done:
+		return
+	default:
+	}
+
+	srv.background.Add(1)
+	go func() {
+		defer srv.background.Done()
+		fn()
+	}()
+}
+
+// stopBackground stops the goroutines the server started: it closes done,
+// drops the links to the primary and to the instances a Sentinel monitors,
+// then waits for the goroutines to return.
+func (srv *Server) stopBackground() {
+	srv.backgroundMu.Lock()
+	close(srv.done)
+	srv.backgroundMu.Unlock()
+
+	srv.replicationMu.Lock()
+	srv.replicationStop()
+	srv.replicationMu.Unlock()
+
+	srv.sentinelMu.Lock()
+	for _, m := range srv.sentinelMasters {
+		m.close()
+	}
+	srv.sentinelMu.Unlock()
+
+	srv.background.Wait()
+}
+
+// loadAof opens the AOF at path and replays its commands.
+func (srv *Server) loadAof(path string) error {
+	f, err := aof.New(path, srv.latencyAddSampleIfNeeded)
+	if err != nil {
+		return err
+	}
+	srv.aofFile = f
+
+	srv.loading = true
+	defer func() { srv.loading = false }()
+
+	err = srv.aofFile.Read(func(value Value) error {
+		command := strings.ToUpper(value.Array[0].Bulk)
+		args := value.Array[1:]
+
+		handler, ok := handlers[command]
+		if !ok {
+			fmt.Println("Invalid command: ", command)
+			return nil
+		}
+		if _, bad := commandTable[command].arityError(command, len(value.Array)); bad {
+			fmt.Println("Invalid number of arguments: ", command)
+			return nil
+		}
+
+		handler(srv, args)
+		return nil
+	})
+	if err != nil {
+		srv.aofFile.Close()
+		srv.aofFile = nil
+		return fmt.Errorf("Bad file format reading the append only file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Serve accepts the connections of l and serves each in its own goroutine,
+// until l or the server is closed, by Close, SHUTDOWN or a signal. In the
+// latter case it returns ErrServerClosed once the server is done closing.
+func (srv *Server) Serve(l net.Listener) error {
+	srv.listenersMu.Lock()
+	if srv.closed.Load() {
+		srv.listenersMu.Unlock()
+		return ErrServerClosed
+	}
+	srv.listeners[l] = true
+	srv.listenersMu.Unlock()
+
+	defer func() {
+		srv.listenersMu.Lock()
+		delete(srv.listeners, l)
+		srv.listenersMu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if srv.closed.Load() {
+				<-srv.done
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			fmt.Println(err)
+			continue
+		}
+
+		go srv.handleConnection(conn)
+	}
+}
+
+// ListenUnix listens on the Unix domain socket at path, replacing the
+// socket a previous run left behind, and sets its permissions to perm
+// unless perm is 0. Serving it along with a TCP listener spares local
+// clients the TCP overhead. The socket file is removed once the listener
+// is closed.
+func ListenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Close stops the server: it closes the listeners and the clients, syncs
+// and closes the AOF once the commands in flight are done, then stops the
+// goroutines the server started, such as the active expiry, the
+// replication link, the cluster gossip and the Sentinel checks, and waits
+// for them. The dataset stays in memory, for DB to keep reading it.
+func (srv *Server) Close() error {
+	if !srv.closed.CompareAndSwap(false, true) {
+		return ErrServerClosed
+	}
+
+	srv.closeListeners()
+	for _, c := range srv.sortedClients() {
+		c.kill()
+	}
+
+	srv.killScript(true)
+	srv.execMu.Lock()
+	var err error
+	if srv.aofFile != nil {
+		err = srv.aofFile.Sync()
+		srv.aofFile.Close()
+		srv.aofFile = nil
+	}
+	srv.execMu.Unlock()
+
+	srv.stopBackground()
+	return err
+}
+
+// closeListeners stops accepting connections.
+func (srv *Server) closeListeners() {
+	srv.listenersMu.Lock()
+	defer srv.listenersMu.Unlock()
+
+	for l := range srv.listeners {
+		l.Close()
+	}
+}
+
+func (srv *Server) handleConnection(conn net.Conn) {
+	client := srv.newClient(conn)
+	srv.addClient(client)
+
+	// Replies still queued are sent before the connection is closed, as
+	// long as the client takes them.
+	defer func() {
+		conn.SetWriteDeadline(time.Now().Add(closeFlushTimeout))
+		client.sendNow()
+		client.kill()
+	}()
+	defer srv.removeClient(client)
+
+	for {
+		client.reader.SetLimit(int(srv.configInt(&srv.clientQueryBufferLimit)))
+		value, err := client.reader.Read()
+		if errors.Is(err, resp.ErrTooLarge) {
+			srv.stats.queryBufferLimitDisconnections.Add(1)
+			fmt.Printf("Closing client id=%d addr=%s that reached max query buffer length.\n", client.id, client.remoteAddr())
+			return
+		}
+		if errors.Is(err, resp.ErrProtocol) {
+			client.writeBuffered(Value{Type: "error", Str: "ERR Protocol error: unknown type byte"})
+			return
+		}
+		if err != nil {
+			if err != io.EOF && !client.isKilled() {
+				fmt.Println(err)
+			}
+			return
+		}
+
+		if value.Type != "array" {
+			fmt.Println("Invalid request, expected array")
+			continue
+		}
+
+		if len(value.Array) == 0 {
+			fmt.Println("Invalid request, expected array length > 0")
+			continue
+		}
+
+		result := srv.call(client, value)
+		client.writeBuffered(result)
+
+		// Keep replies to a pipeline buffered until every command already
+		// received has been answered.
+		if client.reader.Buffered() == 0 {
+			if err := client.sendNow(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// call runs a single command on behalf of client, feeding MONITOR clients
+// and timing the handler for the command statistics, the slow log and the
+// latency monitor.
+func (srv *Server) call(client *Client, value Value) Value {
+	command := strings.ToUpper(value.Array[0].Bulk)
+	args := value.Array[1:]
+
+	client.touch(command)
+	srv.waitForPause(command)
+
+	spec, ok := commandTable[command]
+	if !ok || (srv.sentinelMode && !spec.hasFlag("sentinel")) {
+		client.flagTransaction()
+		return unknownCommandError(value.Array[0].Bulk, args)
+	}
+	if err, bad := spec.arityError(command, len(value.Array)); bad {
+		client.flagTransaction()
+		return err
+	}
+
+	// RESP3 clients can mix pub/sub messages with regular replies.
+	subscribed := client.resp() < 3 && client.subscriptions() > 0
+
+	if subscribed && command == "PING" {
+		message := ""
+		if len(args) > 0 {
+			message = args[0].Bulk
+		}
+		return Value{Type: "array", Array: []Value{
+			{Type: "bulk", Bulk: "pong"},
+			{Type: "bulk", Bulk: message},
+		}}
+	}
+	if subscribed && !pubsubCommands[command] {
+		return Value{Type: "error", Str: fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(command))}
+	}
+
+	// Replicas only change through the stream of their primary.
+	if spec.hasFlag("write") && !client.master && srv.isReplica() {
+		client.flagTransaction()
+		return Value{Type: "error", Str: "READONLY You can't write against a read only replica."}
+	}
+
+	// Rejected commands never reach MONITOR clients.
+	srv.feedMonitors(client.remoteAddr(), value)
+
+	if client.multi.active && !transactionCommands[command] {
+		return queueMultiCommand(client, spec, value)
+	}
+
+	// SHUTDOWN and SCRIPT KILL have to get through while a script runs.
+	if !spec.hasFlag("allow_busy") && !(command == "SCRIPT" && len(args) > 0 && strings.EqualFold(args[0].Bulk, "KILL")) {
+		exclusive := exclusiveCommand(command, args)
+		if !srv.lockExec(exclusive) {
+			client.flagTransaction()
+			return busyError
+		}
+		if exclusive {
+			defer srv.execMu.Unlock()
+		} else {
+			defer srv.execMu.RUnlock()
+		}
+	}
+
+	return srv.runCommand(client, value)
+}
+
+// runCommand runs a command that passed the checks of call, with execMu
+// held, then propagates it and records its statistics.
+func (srv *Server) runCommand(client *Client, value Value) Value {
+	command := strings.ToUpper(value.Array[0].Bulk)
+	args := value.Array[1:]
+
+	if redirect, ok := srv.clusterRedirect(client, command, args); ok {
+		return redirect
+	}
+
+	handler, ok := clientHandlers[command]
+	if !ok {
+		h := handlers[command]
+		handler = func(srv *Server, _ *Client, args []Value) Value {
+			return h(srv, args)
+		}
+	}
+
+	start := time.Now()
+	result := handler(srv, client, args)
+	duration := time.Since(start)
+
+	srv.propagateCommand(command, value, result)
+	srv.trackingAfterCommand(client, command, args, result)
+	srv.touchKeys(command, args)
+
+	srv.recordCommand(command, duration, result)
+	// EXEC is timed through the commands it runs.
+	if !commandHasFlag(command, "skip_slowlog") {
+		srv.slowlogPushIfNeeded(client, value, duration)
+	}
+	srv.latencyAddSampleIfNeeded("command", duration)
+
+	return result
+}
+
+// propagateCommand logs a successful write command to the AOF, unless the
+// command logs its own effects.
+func (srv *Server) propagateCommand(command string, value Value, result Value) {
+	spec, ok := commandTable[command]
+	if ok && spec.hasFlag("write") && !spec.selfPropagating && result.Type != "error" {
+		srv.propagate(value.Array...)
+	}
+}
+
+// propagate writes a command to the AOF and streams it to the replicas.
+func (srv *Server) propagate(args ...Value) {
+	if srv.loading {
+		return
+	}
+
+	value := Value{Type: "array", Array: args}
+	if srv.aofFile != nil {
+		srv.aofFile.Write(value)
+	}
+	srv.replicationFeed(value)
+}
+
+// writeDataset writes the commands rebuilding every key, for the AOF
+// rewrite.
+func (srv *Server) writeDataset(w io.Writer) {
+	for _, sh := range srv.shards {
+		sh.mu.RLock()
+		for _, key := range sh.keys() {
+			for _, command := range keyRestoreCommands(sh, key) {
+				w.Write(commandValue(command...).Marshal())
+			}
+		}
+		sh.mu.RUnlock()
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"sort"
+)
+
+// lookupSet returns the set at key. The caller must hold the shard lock.
+func lookupSet(sh *Shard, key string) (*setObject, *Value) {
+	if t := sh.typeOf(key); t != "none" && t != "set" {
+		return nil, &Value{Type: "error", Str: wrongTypeError}
+	}
+	return sh.smembers[key], nil
+}
+
+// sortedMembers returns the members of a set in lexicographic order, for
+// the replies and the AOF to be deterministic.
+func (s *setObject) sortedMembers() []string {
+	members := s.members()
+	sort.Strings(members)
+	return members
+}
+
+func (srv *Server) sadd(args []Value) Value {
+	key := args[0].Bulk
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+
+	set, errValue := lookupSet(sh, key)
+	if errValue != nil {
+		sh.mu.Unlock()
+		return *errValue
+	}
+	if set == nil {
+		set = newSetObject()
+		sh.smembers[key] = set
+	}
+
+	added := 0
+	for _, arg := range args[1:] {
+		if set.add(srv, arg.Bulk) {
+			added++
+		}
+	}
+	sh.mu.Unlock()
+
+	if added > 0 {
+		srv.notifyKeyspaceEvent(notifySet, "sadd", key)
+	}
+
+	return Value{Type: "integer", Num: added}
+}
+
+func (srv *Server) srem(args []Value) Value {
+	key := args[0].Bulk
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+
+	set, errValue := lookupSet(sh, key)
+	if errValue != nil {
+		sh.mu.Unlock()
+		return *errValue
+	}
+
+	if set == nil {
+		sh.mu.Unlock()
+		return Value{Type: "integer", Num: 0}
+	}
+
+	removed := 0
+	for _, arg := range args[1:] {
+		if set.remove(arg.Bulk) {
+			removed++
+		}
+	}
+	emptied := set.length() == 0
+	if emptied {
+		delete(sh.smembers, key)
+	}
+	sh.mu.Unlock()
+
+	if removed > 0 {
+		srv.notifyKeyspaceEvent(notifySet, "srem", key)
+	}
+	if emptied {
+		srv.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	}
+
+	return Value{Type: "integer", Num: removed}
+}
+
+func (srv *Server) smembers(args []Value) Value {
+	key := args[0].Bulk
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	set, errValue := lookupSet(sh, key)
+	if errValue != nil {
+		return *errValue
+	}
+
+	values := []Value{}
+	if set == nil {
+		return Value{Type: "array", Array: values}
+	}
+	for _, member := range set.sortedMembers() {
+		values = append(values, Value{Type: "bulk", Bulk: member})
+	}
+
+	return Value{Type: "array", Array: values}
+}
+
+func (srv *Server) scard(args []Value) Value {
+	key := args[0].Bulk
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	set, errValue := lookupSet(sh, key)
+	if errValue != nil {
+		return *errValue
+	}
+
+	if set == nil {
+		return Value{Type: "integer", Num: 0}
+	}
+	return Value{Type: "integer", Num: set.length()}
+}
+
+func (srv *Server) sismember(args []Value) Value {
+	key := args[0].Bulk
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	set, errValue := lookupSet(sh, key)
+	if errValue != nil {
+		return *errValue
+	}
+
+	if set != nil && set.has(args[1].Bulk) {
+		return Value{Type: "integer", Num: 1}
+	}
+	return Value{Type: "integer", Num: 0}
+}
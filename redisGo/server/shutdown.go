@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How long each client gets to take the replies still buffered for it,
+// when the server shuts down or the connection is closed.
+const closeFlushTimeout = time.Second
+
+// shutdownServer closes the server: it waits for the commands in flight to
+// complete and holds off any other, makes the AOF durable, either with a
+// final fsync or, with save, by rewriting it as a snapshot of the dataset,
+// then stops accepting connections, closes the clients, which makes Serve
+// return, and stops the goroutines of the server. It fails, leaving the
+// server open, if the AOF could not be saved, unless force is set.
+func (srv *Server) shutdownServer(save, force bool) error {
+	srv.shutdownMu.Lock()
+	defer srv.shutdownMu.Unlock()
+
+	srv.shuttingDown.Store(true)
+	defer srv.shuttingDown.Store(false)
+
+	// Every command holds execMu for reading while it runs, except for the
+	// blocked ones, which are waiting without touching the dataset. A
+	// script would hold it until it ends, so it is stopped.
+	srv.killScript(true)
+	srv.execMu.Lock()
+
+	if srv.aofFile != nil {
+		var err error
+		if save {
+			fmt.Println("Rewriting the AOF before exiting.")
+			err = srv.aofFile.Rewrite(srv.writeDataset)
+		} else {
+			err = srv.aofFile.Sync()
+		}
+		if err != nil {
+			fmt.Printf("Error trying to save the AOF: %v\n", err)
+			if !force {
+				srv.execMu.Unlock()
+				return err
+			}
+		}
+		srv.aofFile.Close()
+		srv.aofFile = nil
+	}
+
+	if !srv.closed.CompareAndSwap(false, true) {
+		// Closed meanwhile by Close.
+		srv.execMu.Unlock()
+		return nil
+	}
+
+	srv.closeListeners()
+
+	for _, c := range srv.sortedClients() {
+		if c.conn != nil {
+			c.conn.SetWriteDeadline(time.Now().Add(closeFlushTimeout))
+		}
+		c.sendNow()
+		c.kill()
+	}
+	srv.execMu.Unlock()
+
+	srv.stopBackground()
+
+	fmt.Println("redisGo is now ready to exit, bye bye...")
+	return nil
+}
+
+func (srv *Server) shutdown(c *Client, args []Value) Value {
+	save, force := false, false
+	for _, arg := range args {
+		switch strings.ToUpper(arg.Bulk) {
+		case "SAVE":
+			save = true
+		case "NOSAVE":
+			save = false
+		case "FORCE":
+			force = true
+		default:
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+	}
+
+	fmt.Println("User requested shutdown...")
+
+	if err := srv.shutdownServer(save, force); err != nil {
+		return Value{Type: "error", Str: "ERR Errors trying to SHUTDOWN. Check logs."}
+	}
+	return Value{}
+}
+
+// HandleSignals shuts the server down on SIGINT or SIGTERM, as SHUTDOWN
+// does. It returns if a second signal arrives while the shutdown is
+// underway, for the caller to exit at once.
+func (srv *Server) HandleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	for sig := range signals {
+		if srv.shuttingDown.Load() {
+			fmt.Println("You insist... exiting now.")
+			return
+		}
+
+		fmt.Printf("Received %s, scheduling shutdown...\n", sig)
+		go func() {
+			if err := srv.shutdownServer(false, false); err != nil {
+				fmt.Println("Errors trying to shut down the server, check the logs.")
+			}
+		}()
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	slowlogEntryMaxArgc = 32
+	slowlogEntryMaxLen  = 128
+)
+
+type slowlogEntry struct {
+	id         int64
+	time       time.Time
+	duration   time.Duration
+	args       []string
+	clientAddr string
+	clientName string
+}
+
+// slowlogPushIfNeeded records the command in the slow log when it ran for
+// longer than slowlog-log-slower-than.
+func (srv *Server) slowlogPushIfNeeded(client *Client, value Value, duration time.Duration) {
+	srv.configMu.RLock()
+	threshold := srv.slowlogLogSlowerThan
+	maxLen := srv.slowlogMaxLen
+	srv.configMu.RUnlock()
+
+	if threshold < 0 || duration.Microseconds() < threshold {
+		return
+	}
+
+	args := []string{}
+	for i, arg := range value.Array {
+		if i == slowlogEntryMaxArgc-1 && len(value.Array) > slowlogEntryMaxArgc {
+			args = append(args, fmt.Sprintf("... (%d more arguments)", len(value.Array)-i))
+			break
+		}
+
+		s := arg.Bulk
+		if len(s) > slowlogEntryMaxLen {
+			s = fmt.Sprintf("%s... (%d more bytes)", s[:slowlogEntryMaxLen], len(s)-slowlogEntryMaxLen)
+		}
+		args = append(args, s)
+	}
+
+	client.mu.Lock()
+	name := client.name
+	client.mu.Unlock()
+
+	srv.slowlogMu.Lock()
+	defer srv.slowlogMu.Unlock()
+
+	entry := slowlogEntry{
+		id:         srv.slowlogNextID,
+		time:       time.Now(),
+		duration:   duration,
+		args:       args,
+		clientAddr: client.remoteAddr(),
+		clientName: name,
+	}
+	srv.slowlogNextID++
+
+	srv.slowlogEntries = append([]slowlogEntry{entry}, srv.slowlogEntries...)
+	if int64(len(srv.slowlogEntries)) > maxLen {
+		srv.slowlogEntries = srv.slowlogEntries[:maxLen]
+	}
+}
+
+func (srv *Server) slowlog(args []Value) Value {
+	srv.slowlogMu.Lock()
+	defer srv.slowlogMu.Unlock()
+
+	switch strings.ToUpper(args[0].Bulk) {
+	case "LEN":
+		return Value{Type: "integer", Num: len(srv.slowlogEntries)}
+	case "RESET":
+		srv.slowlogEntries = []slowlogEntry{}
+		return Value{Type: "string", Str: "OK"}
+	case "GET":
+		count := 10
+		if len(args) > 2 {
+			return Value{Type: "error", Str: "ERR wrong number of arguments for 'slowlog|get' command"}
+		}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1].Bulk)
+			if err != nil || n < -1 {
+				return Value{Type: "error", Str: "ERR count should be greater than or equal to -1"}
+			}
+			count = n
+		}
+		if count == -1 || count > len(srv.slowlogEntries) {
+			count = len(srv.slowlogEntries)
+		}
+
+		entries := []Value{}
+		for _, entry := range srv.slowlogEntries[:count] {
+			entries = append(entries, entry.value())
+		}
+
+		return Value{Type: "array", Array: entries}
+	default:
+		return Value{Type: "error", Str: fmt.Sprintf("ERR unknown subcommand '%s'", args[0].Bulk)}
+	}
+}
+
+func (e slowlogEntry) value() Value {
+	args := []Value{}
+	for _, arg := range e.args {
+		args = append(args, Value{Type: "bulk", Bulk: arg})
+	}
+
+	return Value{Type: "array", Array: []Value{
+		{Type: "integer", Num: int(e.id)},
+		{Type: "integer", Num: int(e.time.Unix())},
+		{Type: "integer", Num: int(e.duration.Microseconds())},
+		{Type: "array", Array: args},
+		{Type: "bulk", Bulk: e.clientAddr},
+		{Type: "bulk", Bulk: e.clientName},
+	}}
+}
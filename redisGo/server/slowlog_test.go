@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// testServer is the server the tests run commands against, unless they
+// start one of their own.
+var testServer = newServer()
+
+func newTestClient() *Client {
+	conn, _ := net.Pipe()
+	return testServer.newClient(conn)
+}
+
+func command(args ...string) Value {
+	values := []Value{}
+	for _, arg := range args {
+		values = append(values, Value{Type: "bulk", Bulk: arg})
+	}
+	return Value{Type: "array", Array: values}
+}
+
+func TestSlowlogThreshold(t *testing.T) {
+	testServer.slowlogLogSlowerThan = 1000
+	defer func() { testServer.slowlogLogSlowerThan = 10000 }()
+	testServer.slowlog([]Value{{Type: "bulk", Bulk: "RESET"}})
+
+	client := newTestClient()
+	testServer.slowlogPushIfNeeded(client, command("GET", "fast"), 10*time.Microsecond)
+	testServer.slowlogPushIfNeeded(client, command("HGETALL", "huge"), 5*time.Millisecond)
+
+	length := testServer.slowlog([]Value{{Type: "bulk", Bulk: "LEN"}})
+	if length.Num != 1 {
+		t.Fatalf("Expected 1 slow log entry, got %d", length.Num)
+	}
+
+	entries := testServer.slowlog([]Value{{Type: "bulk", Bulk: "GET"}})
+	entry := entries.Array[0]
+	if entry.Array[2].Num != 5000 {
+		t.Errorf("Expected duration of 5000 microseconds, got %d", entry.Array[2].Num)
+	}
+	if args := entry.Array[3].Array; args[0].Bulk != "HGETALL" || args[1].Bulk != "huge" {
+		t.Errorf("Expected arguments HGETALL huge, got %v", args)
+	}
+}
+
+func TestSlowlogMaxLen(t *testing.T) {
+	testServer.slowlogLogSlowerThan = 0
+	testServer.slowlogMaxLen = 3
+	defer func() {
+		testServer.slowlogLogSlowerThan = 10000
+		testServer.slowlogMaxLen = 128
+	}()
+	testServer.slowlog([]Value{{Type: "bulk", Bulk: "RESET"}})
+
+	client := newTestClient()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		testServer.slowlogPushIfNeeded(client, command("GET", key), time.Millisecond)
+	}
+
+	entries := testServer.slowlog([]Value{{Type: "bulk", Bulk: "GET"}, {Type: "bulk", Bulk: "-1"}})
+	if len(entries.Array) != 3 {
+		t.Fatalf("Expected the slow log to be capped at 3 entries, got %d", len(entries.Array))
+	}
+
+	// Newest entries come first.
+	if key := entries.Array[0].Array[3].Array[1].Bulk; key != "e" {
+		t.Errorf("Expected newest entry to be for key 'e', got '%s'", key)
+	}
+
+	testServer.slowlog([]Value{{Type: "bulk", Bulk: "RESET"}})
+	if length := testServer.slowlog([]Value{{Type: "bulk", Bulk: "LEN"}}); length.Num != 0 {
+		t.Errorf("Expected empty slow log after RESET, got %d entries", length.Num)
+	}
+}
+
+func TestSlowlogTruncatesArguments(t *testing.T) {
+	testServer.slowlogLogSlowerThan = 0
+	defer func() { testServer.slowlogLogSlowerThan = 10000 }()
+	testServer.slowlog([]Value{{Type: "bulk", Bulk: "RESET"}})
+
+	long := make([]byte, 200)
+	for i := range long {
+		long[i] = 'x'
+	}
+
+	testServer.slowlogPushIfNeeded(newTestClient(), command("SET", "key", string(long)), time.Millisecond)
+
+	entries := testServer.slowlog([]Value{{Type: "bulk", Bulk: "GET"}})
+	arg := entries.Array[0].Array[3].Array[2].Bulk
+	expected := string(long[:128]) + "... (72 more bytes)"
+	if arg != expected {
+		t.Errorf("Expected truncated argument '%s', got '%s'", expected, arg)
+	}
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sortLookup returns the value a BY or GET pattern of SORT refers to for an
+// element. The first * of the pattern is replaced with the element to form
+// a key, whose string value is returned, unless a -> follows the * to name
+// a field of the hash at the key instead. The GET pattern # stands for the
+// element itself.
+func (srv *Server) sortLookup(pattern, element string) (string, bool) {
+	if pattern == "#" {
+		return element, true
+	}
+
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return "", false
+	}
+
+	keyPattern, field := pattern, ""
+	if arrow := strings.Index(pattern[star+1:], "->"); arrow >= 0 && star+1+arrow+2 < len(pattern) {
+		keyPattern = pattern[:star+1+arrow]
+		field = pattern[star+1+arrow+2:]
+	}
+	key := keyPattern[:star] + element + keyPattern[star+1:]
+
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	if field != "" {
+		if hash, ok := sh.hsets[key]; ok {
+			return hash.get(field)
+		}
+		return "", false
+	}
+	return sh.getString(key)
+}
+
+// sortRefersToKeys reports whether a BY or GET pattern of SORT refers to
+// other keys than the one sorted, which is when it has a *.
+func sortRefersToKeys(args []Value) bool {
+	for i := 1; i < len(args)-1; i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "LIMIT":
+			i += 2
+		case "STORE":
+			i++
+		case "BY", "GET":
+			if strings.Contains(args[i+1].Bulk, "*") {
+				return true
+			}
+			i++
+		}
+	}
+	return false
+}
+
+type sortItem struct {
+	element string
+	score   float64
+	value   string
+	found   bool
+}
+
+func (srv *Server) sortCommand(args []Value) Value {
+	return srv.sortGeneric(args, false)
+}
+
+func (srv *Server) sortRO(args []Value) Value {
+	return srv.sortGeneric(args, true)
+}
+
+// sortGeneric implements SORT and SORT_RO. When its patterns refer to
+// other keys, which are not known up front, it runs with execMu held
+// exclusively, so that no other command runs meanwhile; otherwise it locks
+// the key sorted and the one it stores to.
+func (srv *Server) sortGeneric(args []Value, readonly bool) Value {
+	key := args[0].Bulk
+
+	desc, alpha := false, false
+	offset, count := 0, -1
+	sortBy, dontsort := "", false
+	getPatterns := []string{}
+	storeKey := ""
+
+	for i := 1; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch option := strings.ToUpper(args[i].Bulk); {
+		case option == "ASC":
+			desc = false
+		case option == "DESC":
+			desc = true
+		case option == "ALPHA":
+			alpha = true
+		case option == "LIMIT" && remaining >= 2:
+			var err1, err2 error
+			offset, err1 = strconv.Atoi(args[i+1].Bulk)
+			count, err2 = strconv.Atoi(args[i+2].Bulk)
+			if err1 != nil || err2 != nil {
+				return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+			}
+			i += 2
+		case option == "STORE" && remaining >= 1 && !readonly:
+			storeKey = args[i+1].Bulk
+			i++
+		case option == "BY" && remaining >= 1:
+			if srv.clusterEnabled {
+				return Value{Type: "error", Str: "ERR BY option of SORT denied in Cluster mode."}
+			}
+			sortBy = args[i+1].Bulk
+			// A pattern without a * leaves the elements in their order.
+			dontsort = !strings.Contains(sortBy, "*")
+			i++
+		case option == "GET" && remaining >= 1:
+			if srv.clusterEnabled {
+				return Value{Type: "error", Str: "ERR GET option of SORT denied in Cluster mode."}
+			}
+			getPatterns = append(getPatterns, args[i+1].Bulk)
+			i++
+		default:
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+	}
+
+	keys := []string{key}
+	if storeKey != "" {
+		keys = append(keys, storeKey)
+	}
+	for _, k := range keys {
+		srv.expireIfNeeded(k)
+	}
+
+	unlock := func() {}
+	switch {
+	case sortRefersToKeys(args):
+		// execMu is held exclusively, and sortLookup locks the shards
+		// of the keys it reads, which may be these.
+	case storeKey != "":
+		unlock = srv.lockKeys(keys)
+	default:
+		unlock = srv.rlockKeys(keys)
+	}
+
+	sh := srv.shardOf(key)
+	elements := []string{}
+	switch sh.typeOf(key) {
+	case "list":
+		elements = sh.lists[key].all()
+	case "set":
+		// Sorted, so that even unsorted results are deterministic.
+		elements = sh.smembers[key].sortedMembers()
+	case "zset":
+		z := sh.zsets[key]
+		for _, entry := range z.rangeByRank(0, z.length-1) {
+			elements = append(elements, entry.member)
+		}
+		if dontsort && desc {
+			for i, j := 0, len(elements)-1; i < j; i, j = i+1, j-1 {
+				elements[i], elements[j] = elements[j], elements[i]
+			}
+		}
+	case "none":
+	default:
+		unlock()
+		return Value{Type: "error", Str: wrongTypeError}
+	}
+
+	items := make([]sortItem, len(elements))
+	for i, element := range elements {
+		items[i].element = element
+	}
+
+	if !dontsort {
+		for i := range items {
+			item := &items[i]
+			if sortBy != "" {
+				item.value, item.found = srv.sortLookup(sortBy, item.element)
+			} else {
+				item.value, item.found = item.element, true
+			}
+
+			// Numeric sorts count missing weights as 0.
+			if !alpha && item.found {
+				score, err := strconv.ParseFloat(item.value, 64)
+				if err != nil {
+					unlock()
+					return Value{Type: "error", Str: "ERR One or more scores can't be converted into double"}
+				}
+				item.score = score
+			}
+		}
+
+		sort.SliceStable(items, func(i, j int) bool {
+			cmp := sortCompare(&items[i], &items[j], alpha)
+			if desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		})
+	}
+
+	start := max(offset, 0)
+	end := len(items) - 1
+	if count >= 0 {
+		end = start + count - 1
+	}
+	if start >= len(items) {
+		start, end = len(items)-1, len(items)-2
+	}
+	end = min(end, len(items)-1)
+
+	values := []Value{}
+	for i := start; i <= end; i++ {
+		if len(getPatterns) == 0 {
+			values = append(values, Value{Type: "bulk", Bulk: items[i].element})
+			continue
+		}
+		for _, pattern := range getPatterns {
+			if value, ok := srv.sortLookup(pattern, items[i].element); ok {
+				values = append(values, Value{Type: "bulk", Bulk: value})
+			} else {
+				values = append(values, Value{Type: "null"})
+			}
+		}
+	}
+
+	if storeKey == "" {
+		unlock()
+		return Value{Type: "array", Array: values}
+	}
+
+	// Missing values are stored as empty strings.
+	list := make([]string, len(values))
+	for i, value := range values {
+		list[i] = value.Bulk
+	}
+
+	dest := srv.shardOf(storeKey)
+	existed := dest.delete(storeKey)
+	if len(list) > 0 {
+		dest.lists[storeKey] = newListObject()
+		dest.lists[storeKey].push(srv, list, false)
+	}
+	unlock()
+
+	srv.propagate(append([]Value{{Type: "bulk", Bulk: "SORT"}}, args...)...)
+	if len(list) > 0 {
+		srv.notifyKeyspaceEvent(notifyList, "sortstore", storeKey)
+	} else if existed {
+		srv.notifyKeyspaceEvent(notifyGeneric, "del", storeKey)
+	}
+
+	return Value{Type: "integer", Num: len(list)}
+}
+
+// sortCompare orders two items by weight, then by element. Alphabetic sorts
+// put the items without a weight first.
+func sortCompare(a, b *sortItem, alpha bool) int {
+	cmp := 0
+	switch {
+	case alpha && a.found != b.found:
+		if a.found {
+			cmp = 1
+		} else {
+			cmp = -1
+		}
+	case alpha:
+		cmp = strings.Compare(a.value, b.value)
+	case a.score < b.score:
+		cmp = -1
+	case a.score > b.score:
+		cmp = 1
+	}
+
+	if cmp == 0 {
+		cmp = strings.Compare(a.element, b.element)
+	}
+	return cmp
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func bulks(v Value) []string {
+	result := []string{}
+	for _, elem := range v.Array {
+		if elem.Type == "null" {
+			result = append(result, "(nil)")
+		} else {
+			result = append(result, elem.Bulk)
+		}
+	}
+	return result
+}
+
+func expectBulks(t *testing.T, name string, got Value, want ...string) {
+	t.Helper()
+	values := bulks(got)
+	if len(values) != len(want) {
+		t.Errorf("%s: expected %v, got %v", name, want, got)
+		return
+	}
+	for i := range want {
+		if values[i] != want[i] {
+			t.Errorf("%s: expected %v, got %v", name, want, values)
+			return
+		}
+	}
+}
+
+func TestListsAndSets(t *testing.T) {
+	testServer.rpush(command("list:a", "b", "c").Array)
+	if result := testServer.lpush(command("list:a", "a", "z").Array); result.Num != 4 {
+		t.Errorf("Expected a length of 4, got %v", result)
+	}
+	expectBulks(t, "LRANGE", testServer.lrange(command("list:a", "0", "-1").Array), "z", "a", "b", "c")
+	if result := testServer.rpop(command("list:a", "2").Array); len(result.Array) != 2 || result.Array[0].Bulk != "c" {
+		t.Errorf("Expected c and b, got %v", result)
+	}
+	testServer.lpop(command("list:a").Array)
+	testServer.lpop(command("list:a").Array)
+	if testServer.keyExists("list:a") {
+		t.Errorf("Expected the empty list to be deleted")
+	}
+
+	if result := testServer.sadd(command("set:a", "x", "y", "x").Array); result.Num != 2 {
+		t.Errorf("Expected 2 members added, got %v", result)
+	}
+	if result := testServer.sismember(command("set:a", "y").Array); result.Num != 1 {
+		t.Errorf("Expected y to be a member, got %v", result)
+	}
+	expectBulks(t, "SMEMBERS", testServer.smembers(command("set:a").Array), "x", "y")
+	if result := testServer.lpush(command("set:a", "x").Array); result.Str != wrongTypeError {
+		t.Errorf("Expected WRONGTYPE, got %v", result)
+	}
+}
+
+func TestSort(t *testing.T) {
+	testServer.rpush(command("sort:ids", "3", "1", "2", "10").Array)
+	testServer.hset(command("weight_1", "w", "30").Array)
+	testServer.hset(command("weight_2", "w", "10").Array)
+	testServer.hset(command("weight_3", "w", "20").Array)
+	testServer.set(command("name_1", "one").Array)
+	testServer.set(command("name_2", "two").Array)
+	testServer.set(command("name_3", "three").Array)
+
+	expectBulks(t, "numeric", testServer.sortCommand(command("sort:ids").Array), "1", "2", "3", "10")
+	expectBulks(t, "DESC", testServer.sortCommand(command("sort:ids", "DESC").Array), "10", "3", "2", "1")
+	expectBulks(t, "ALPHA", testServer.sortCommand(command("sort:ids", "ALPHA").Array), "1", "10", "2", "3")
+	expectBulks(t, "LIMIT", testServer.sortCommand(command("sort:ids", "LIMIT", "1", "2").Array), "2", "3")
+	expectBulks(t, "LIMIT past the end", testServer.sortCommand(command("sort:ids", "LIMIT", "10", "2").Array))
+
+	// A missing weight counts as 0.
+	expectBulks(t, "BY hash field", testServer.sortCommand(command("sort:ids", "BY", "weight_*->w").Array), "10", "2", "3", "1")
+	expectBulks(t, "BY nosort", testServer.sortCommand(command("sort:ids", "BY", "nosort").Array), "3", "1", "2", "10")
+	expectBulks(t, "GET", testServer.sortCommand(command("sort:ids", "BY", "weight_*->w", "GET", "#", "GET", "name_*", "GET", "weight_*->w", "LIMIT", "0", "2").Array),
+		"10", "(nil)", "(nil)", "2", "two", "10")
+	expectBulks(t, "BY string ALPHA", testServer.sortCommand(command("sort:ids", "BY", "name_*", "ALPHA", "GET", "name_*").Array),
+		"(nil)", "one", "three", "two")
+
+	if result := testServer.sortCommand(command("sort:ids", "BY", "name_*").Array); result.Type != "error" {
+		t.Errorf("Expected an error sorting by non numeric weights, got %v", result)
+	}
+	if result := testServer.sortRO(command("sort:ids", "STORE", "sort:dest").Array); result.Type != "error" {
+		t.Errorf("Expected SORT_RO to refuse STORE, got %v", result)
+	}
+
+	if result := testServer.sortCommand(command("sort:ids", "DESC", "STORE", "sort:dest").Array); result.Num != 4 {
+		t.Errorf("Expected 4 elements stored, got %v", result)
+	}
+	expectBulks(t, "STORE", testServer.lrange(command("sort:dest", "0", "-1").Array), "10", "3", "2", "1")
+
+	testServer.sadd(command("sort:set", "b", "c", "a").Array)
+	expectBulks(t, "set", testServer.sortCommand(command("sort:set", "ALPHA", "DESC").Array), "c", "b", "a")
+
+	testServer.set(command("sort:string", "x").Array)
+	if result := testServer.sortCommand(command("sort:string").Array); result.Str != wrongTypeError {
+		t.Errorf("Expected WRONGTYPE, got %v", result)
+	}
+}
+
+func TestSortLocking(t *testing.T) {
+	tests := []struct {
+		args      []string
+		exclusive bool
+	}{
+		{[]string{"SORT", "k"}, false},
+		{[]string{"SORT", "k", "ALPHA", "LIMIT", "0", "10", "STORE", "dest"}, false},
+		{[]string{"SORT", "k", "BY", "nosort", "GET", "#"}, false},
+		{[]string{"SORT", "k", "BY", "weight_*"}, true},
+		{[]string{"SORT_RO", "k", "GET", "#", "GET", "data_*->field"}, true},
+		{[]string{"SORT", "k", "STORE", "by_*"}, false},
+	}
+	for _, test := range tests {
+		value := command(test.args...)
+		if got := exclusiveCommand(test.args[0], value.Array[1:]); got != test.exclusive {
+			t.Errorf("Expected exclusive %v for %v, got %v", test.exclusive, test.args, got)
+		}
+	}
+
+	// A command holding execMu does not keep SORT without patterns from
+	// running.
+	testServer.rpush(command("sortlock:list", "3", "1", "2").Array)
+	testServer.execMu.RLock()
+	done := make(chan Value, 1)
+	go func() {
+		done <- testServer.call(newTestClient(), command("SORT", "sortlock:list", "STORE", "sortlock:dest"))
+	}()
+	select {
+	case result := <-done:
+		if result.Num != 3 {
+			t.Errorf("Expected 3 stored elements, got %v", result)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected SORT not to wait for execMu")
+	}
+	testServer.execMu.RUnlock()
+
+	expectBulks(t, "SORT STORE", testServer.lrange(command("sortlock:dest", "0", "-1").Array), "1", "2", "3")
+}
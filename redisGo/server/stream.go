@@ -0,0 +1,1514 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Streams are stored as a sorted list of nodes holding up to
+// streamNodeMaxEntries entries each, indexed by the ID of their first
+// entry. It is a flat stand-in for the radix tree of listpacks used by
+// Redis: appends only touch the last node, lookups binary search the node
+// index and then the node, and approximate trimming drops whole nodes.
+const streamNodeMaxEntries = 100
+
+type streamID struct {
+	ms  uint64
+	seq uint64
+}
+
+var (
+	streamMinID = streamID{0, 0}
+	streamMaxID = streamID{math.MaxUint64, math.MaxUint64}
+)
+
+func (id streamID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+func (id streamID) less(other streamID) bool {
+	return id.ms < other.ms || (id.ms == other.ms && id.seq < other.seq)
+}
+
+func (id streamID) incr() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+func (id streamID) decr() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+type streamEntry struct {
+	id     streamID
+	fields []string // field, value, field, value...
+}
+
+type streamNode struct {
+	entries []streamEntry
+}
+
+type stream struct {
+	nodes        []*streamNode
+	length       int
+	lastID       streamID
+	maxDeletedID streamID
+	entriesAdded int64
+	groups       map[string]*streamGroup
+}
+
+type streamGroup struct {
+	lastID      streamID
+	entriesRead int64
+	pel         map[streamID]*streamNACK
+	consumers   map[string]*streamConsumer
+}
+
+type streamConsumer struct {
+	name       string
+	seenTime   time.Time
+	activeTime time.Time
+	pel        map[streamID]*streamNACK
+}
+
+// streamNACK is an entry delivered to a consumer but not yet acknowledged.
+type streamNACK struct {
+	consumer      *streamConsumer
+	deliveryTime  time.Time
+	deliveryCount int64
+}
+
+func newStream() *stream {
+	return &stream{groups: map[string]*streamGroup{}}
+}
+
+func (s *stream) append(id streamID, fields []string) {
+	if len(s.nodes) == 0 || len(s.nodes[len(s.nodes)-1].entries) >= streamNodeMaxEntries {
+		s.nodes = append(s.nodes, &streamNode{})
+	}
+
+	node := s.nodes[len(s.nodes)-1]
+	node.entries = append(node.entries, streamEntry{id: id, fields: fields})
+
+	s.length++
+	s.lastID = id
+	s.entriesAdded++
+}
+
+// seek returns the position of the first entry with an ID >= id.
+func (s *stream) seek(id streamID) (int, int) {
+	n := sort.Search(len(s.nodes), func(i int) bool {
+		entries := s.nodes[i].entries
+		return !entries[len(entries)-1].id.less(id)
+	})
+	if n == len(s.nodes) {
+		return n, 0
+	}
+
+	entries := s.nodes[n].entries
+	e := sort.Search(len(entries), func(i int) bool {
+		return !entries[i].id.less(id)
+	})
+	return n, e
+}
+
+func (s *stream) get(id streamID) (streamEntry, bool) {
+	n, e := s.seek(id)
+	if n < len(s.nodes) && s.nodes[n].entries[e].id == id {
+		return s.nodes[n].entries[e], true
+	}
+	return streamEntry{}, false
+}
+
+// rangeEntries returns up to count entries between start and end, both
+// inclusive. A count of 0 means no limit.
+func (s *stream) rangeEntries(start, end streamID, count int, rev bool) []streamEntry {
+	entries := []streamEntry{}
+	if end.less(start) {
+		return entries
+	}
+
+	if !rev {
+		n, e := s.seek(start)
+		for ; n < len(s.nodes); n, e = n+1, 0 {
+			for ; e < len(s.nodes[n].entries); e++ {
+				entry := s.nodes[n].entries[e]
+				if end.less(entry.id) || (count > 0 && len(entries) == count) {
+					return entries
+				}
+				entries = append(entries, entry)
+			}
+		}
+		return entries
+	}
+
+	// Start right after the last entry <= end and walk backwards.
+	n, e := len(s.nodes), 0
+	if after, ok := end.incr(); ok {
+		n, e = s.seek(after)
+	}
+
+	for {
+		if e > 0 {
+			e--
+		} else if n > 0 {
+			n--
+			e = len(s.nodes[n].entries) - 1
+		} else {
+			return entries
+		}
+
+		entry := s.nodes[n].entries[e]
+		if entry.id.less(start) || (count > 0 && len(entries) == count) {
+			return entries
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func (s *stream) delete(id streamID) bool {
+	n, e := s.seek(id)
+	if n == len(s.nodes) || s.nodes[n].entries[e].id != id {
+		return false
+	}
+
+	node := s.nodes[n]
+	node.entries = append(node.entries[:e], node.entries[e+1:]...)
+	if len(node.entries) == 0 {
+		s.nodes = append(s.nodes[:n], s.nodes[n+1:]...)
+	}
+
+	s.length--
+	if s.maxDeletedID.less(id) {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+type streamTrimArgs struct {
+	strategy string // "", "MAXLEN" or "MINID"
+	approx   bool
+	maxLen   int64
+	minID    streamID
+	limit    int64
+}
+
+// trim removes entries from the head of the stream. Approximate trimming
+// only ever removes whole nodes, which is much cheaper than touching them.
+func (s *stream) trim(args streamTrimArgs) int64 {
+	limit := args.limit
+	if args.approx && limit == 0 {
+		limit = 100 * streamNodeMaxEntries
+	}
+
+	var removed int64
+	shouldTrim := func(entry streamEntry, left int64) bool {
+		if args.strategy == "MAXLEN" {
+			return left > args.maxLen
+		}
+		return entry.id.less(args.minID)
+	}
+
+	for len(s.nodes) > 0 {
+		node := s.nodes[0]
+		left := int64(s.length)
+
+		if args.approx {
+			last := node.entries[len(node.entries)-1]
+			if args.strategy == "MAXLEN" && left-int64(len(node.entries)) < args.maxLen {
+				break
+			}
+			if args.strategy == "MINID" && !last.id.less(args.minID) {
+				break
+			}
+			if limit > 0 && removed+int64(len(node.entries)) > limit {
+				break
+			}
+
+			for _, entry := range node.entries {
+				if s.maxDeletedID.less(entry.id) {
+					s.maxDeletedID = entry.id
+				}
+			}
+			removed += int64(len(node.entries))
+			s.length -= len(node.entries)
+			s.nodes = s.nodes[1:]
+			continue
+		}
+
+		entry := node.entries[0]
+		if !shouldTrim(entry, left) {
+			break
+		}
+		s.delete(entry.id)
+		removed++
+	}
+
+	return removed
+}
+
+// Argument parsing
+
+var errInvalidStreamID = errors.New("ERR Invalid stream ID specified as stream command argument")
+
+// parseStreamID parses "ms-seq" or "ms", using missingSeq for the latter.
+func parseStreamID(s string, missingSeq uint64) (streamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	if !hasSeq {
+		return streamID{ms, missingSeq}, nil
+	}
+
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	return streamID{ms, seq}, nil
+}
+
+// parseRangeID parses an XRANGE style bound, including "-", "+" and
+// exclusive "(" bounds.
+func parseRangeID(s string, isStart bool) (streamID, error) {
+	switch s {
+	case "-":
+		return streamMinID, nil
+	case "+":
+		return streamMaxID, nil
+	}
+
+	missingSeq := uint64(0)
+	if !isStart {
+		missingSeq = math.MaxUint64
+	}
+
+	if strings.HasPrefix(s, "(") {
+		id, err := parseStreamID(s[1:], missingSeq)
+		if err != nil {
+			return id, err
+		}
+
+		var ok bool
+		if isStart {
+			id, ok = id.incr()
+		} else {
+			id, ok = id.decr()
+		}
+		if !ok {
+			return id, errors.New("ERR invalid start ID for the interval")
+		}
+		return id, nil
+	}
+
+	return parseStreamID(s, missingSeq)
+}
+
+func parseTrimArgs(args []Value, i int) (streamTrimArgs, int, error) {
+	trim := streamTrimArgs{strategy: strings.ToUpper(args[i].Bulk)}
+	i++
+
+	if i < len(args) && (args[i].Bulk == "~" || args[i].Bulk == "=") {
+		trim.approx = args[i].Bulk == "~"
+		i++
+	}
+	if i >= len(args) {
+		return trim, i, errors.New("ERR syntax error")
+	}
+
+	if trim.strategy == "MAXLEN" {
+		n, err := strconv.ParseInt(args[i].Bulk, 10, 64)
+		if err != nil {
+			return trim, i, errors.New("ERR value is not an integer or out of range")
+		}
+		if n < 0 {
+			return trim, i, errors.New("ERR The MAXLEN argument must be >= 0.")
+		}
+		trim.maxLen = n
+	} else {
+		id, err := parseStreamID(args[i].Bulk, 0)
+		if err != nil {
+			return trim, i, err
+		}
+		trim.minID = id
+	}
+	i++
+
+	if i+1 < len(args) && strings.ToUpper(args[i].Bulk) == "LIMIT" {
+		n, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+		if err != nil || n < 0 {
+			return trim, i, errors.New("ERR The LIMIT argument must be >= 0.")
+		}
+		if !trim.approx {
+			return trim, i, errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		trim.limit = n
+		i += 2
+	}
+
+	return trim, i, nil
+}
+
+// Replies
+
+func streamEntryValue(entry streamEntry) Value {
+	fields := []Value{}
+	for _, f := range entry.fields {
+		fields = append(fields, Value{Type: "bulk", Bulk: f})
+	}
+
+	return Value{Type: "array", Array: []Value{
+		{Type: "bulk", Bulk: entry.id.String()},
+		{Type: "array", Array: fields},
+	}}
+}
+
+func streamEntriesValue(entries []streamEntry) Value {
+	values := []Value{}
+	for _, entry := range entries {
+		values = append(values, streamEntryValue(entry))
+	}
+	return Value{Type: "array", Array: values}
+}
+
+func noGroupError(key, group string) Value {
+	return Value{Type: "error", Str: fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", group, key)}
+}
+
+// Commands
+
+// lookupStream returns the stream at key. The caller must hold the shard
+// lock.
+func lookupStream(sh *Shard, key string) (*stream, *Value) {
+	if t := sh.typeOf(key); t != "none" && t != "stream" {
+		return nil, &Value{Type: "error", Str: wrongTypeError}
+	}
+	return sh.streams[key], nil
+}
+
+func (srv *Server) xadd(args []Value) Value {
+	key := args[0].Bulk
+	noMkStream := false
+	trim := streamTrimArgs{}
+
+	i := 1
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i].Bulk)
+
+		if option == "NOMKSTREAM" {
+			noMkStream = true
+			continue
+		}
+		if option == "MAXLEN" || option == "MINID" {
+			t, next, err := parseTrimArgs(args, i)
+			if err != nil {
+				return Value{Type: "error", Str: err.Error()}
+			}
+			trim = t
+			i = next - 1
+			continue
+		}
+		break
+	}
+
+	if i >= len(args) || (len(args)-i-1)%2 != 0 || len(args)-i-1 == 0 {
+		return Value{Type: "error", Str: "ERR wrong number of arguments for 'xadd' command"}
+	}
+
+	idArg := args[i].Bulk
+	fields := []string{}
+	for _, arg := range args[i+1:] {
+		fields = append(fields, arg.Bulk)
+	}
+
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	s, errValue := lookupStream(sh, key)
+	if errValue != nil {
+		return *errValue
+	}
+	if s == nil {
+		if noMkStream {
+			return Value{Type: "null"}
+		}
+		s = newStream()
+	}
+
+	var id streamID
+	switch {
+	case idArg == "*":
+		now := uint64(time.Now().UnixMilli())
+		if now > s.lastID.ms {
+			id = streamID{now, 0}
+		} else {
+			next, ok := s.lastID.incr()
+			if !ok {
+				return Value{Type: "error", Str: "ERR The stream has exhausted the last possible ID, unable to add more items"}
+			}
+			id = next
+		}
+	case strings.HasSuffix(idArg, "-*"):
+		ms, err := strconv.ParseUint(strings.TrimSuffix(idArg, "-*"), 10, 64)
+		if err != nil {
+			return Value{Type: "error", Str: errInvalidStreamID.Error()}
+		}
+		id = streamID{ms, 0}
+		if ms == s.lastID.ms {
+			id.seq = s.lastID.seq + 1
+		}
+		if ms == 0 && id.seq == 0 {
+			id.seq = 1
+		}
+	default:
+		parsed, err := parseStreamID(idArg, 0)
+		if err != nil {
+			return Value{Type: "error", Str: err.Error()}
+		}
+		id = parsed
+	}
+
+	if id == streamMinID {
+		return Value{Type: "error", Str: "ERR The ID specified in XADD must be greater than 0-0"}
+	}
+	if !s.lastID.less(id) {
+		return Value{Type: "error", Str: "ERR The ID specified in XADD is equal or smaller than the target stream top item"}
+	}
+
+	sh.streams[key] = s
+	s.append(id, fields)
+	trimmed := trim.strategy != "" && s.trim(trim) > 0
+
+	// Log the generated ID so that replaying the AOF rebuilds the same
+	// stream; trimming is deterministic given the same entries.
+	propagated := append([]Value{}, args...)
+	propagated[i] = Value{Type: "bulk", Bulk: id.String()}
+	srv.propagate(append([]Value{{Type: "bulk", Bulk: "XADD"}}, propagated...)...)
+
+	srv.signalKeyAsReady(key)
+
+	srv.notifyKeyspaceEvent(notifyStream, "xadd", key)
+	if trimmed {
+		srv.notifyKeyspaceEvent(notifyStream, "xtrim", key)
+	}
+
+	return Value{Type: "bulk", Bulk: id.String()}
+}
+
+func (srv *Server) xlen(args []Value) Value {
+	srv.expireIfNeeded(args[0].Bulk)
+
+	sh := srv.shardOf(args[0].Bulk)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	s, errValue := lookupStream(sh, args[0].Bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	length := 0
+	if s != nil {
+		length = s.length
+	}
+
+	return Value{Type: "integer", Num: length}
+}
+
+func (srv *Server) xrange(args []Value) Value {
+	return srv.xrangeGeneric("xrange", args, false)
+}
+
+func (srv *Server) xrevrange(args []Value) Value {
+	return srv.xrangeGeneric("xrevrange", args, true)
+}
+
+func (srv *Server) xrangeGeneric(name string, args []Value, rev bool) Value {
+	if len(args) != 3 && len(args) != 5 {
+		return Value{Type: "error", Str: fmt.Sprintf("ERR wrong number of arguments for '%s' command", name)}
+	}
+
+	startArg, endArg := args[1].Bulk, args[2].Bulk
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+
+	start, err := parseRangeID(startArg, true)
+	if err != nil {
+		return Value{Type: "error", Str: err.Error()}
+	}
+	end, err := parseRangeID(endArg, false)
+	if err != nil {
+		return Value{Type: "error", Str: err.Error()}
+	}
+
+	count := 0
+	if len(args) == 5 {
+		if strings.ToUpper(args[3].Bulk) != "COUNT" {
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+		n, err := strconv.Atoi(args[4].Bulk)
+		if err != nil {
+			return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+		}
+		if n <= 0 {
+			return Value{Type: "array", Array: []Value{}}
+		}
+		count = n
+	}
+
+	srv.expireIfNeeded(args[0].Bulk)
+
+	sh := srv.shardOf(args[0].Bulk)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	s, errValue := lookupStream(sh, args[0].Bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if s == nil {
+		return Value{Type: "array", Array: []Value{}}
+	}
+
+	return streamEntriesValue(s.rangeEntries(start, end, count, rev))
+}
+
+func (srv *Server) xdel(args []Value) Value {
+	ids := []streamID{}
+	for _, arg := range args[1:] {
+		id, err := parseStreamID(arg.Bulk, 0)
+		if err != nil {
+			return Value{Type: "error", Str: err.Error()}
+		}
+		ids = append(ids, id)
+	}
+
+	srv.expireIfNeeded(args[0].Bulk)
+
+	sh := srv.shardOf(args[0].Bulk)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	s, errValue := lookupStream(sh, args[0].Bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if s == nil {
+		return Value{Type: "integer", Num: 0}
+	}
+
+	deleted := 0
+	for _, id := range ids {
+		if s.delete(id) {
+			deleted++
+		}
+	}
+
+	if deleted > 0 {
+		srv.notifyKeyspaceEvent(notifyStream, "xdel", args[0].Bulk)
+	}
+
+	return Value{Type: "integer", Num: deleted}
+}
+
+func (srv *Server) xtrim(args []Value) Value {
+	strategy := strings.ToUpper(args[1].Bulk)
+	if strategy != "MAXLEN" && strategy != "MINID" {
+		return Value{Type: "error", Str: "ERR syntax error"}
+	}
+
+	trim, next, err := parseTrimArgs(args, 1)
+	if err != nil {
+		return Value{Type: "error", Str: err.Error()}
+	}
+	if next != len(args) {
+		return Value{Type: "error", Str: "ERR syntax error"}
+	}
+
+	srv.expireIfNeeded(args[0].Bulk)
+
+	sh := srv.shardOf(args[0].Bulk)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	s, errValue := lookupStream(sh, args[0].Bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if s == nil {
+		return Value{Type: "integer", Num: 0}
+	}
+
+	removed := s.trim(trim)
+	if removed > 0 {
+		srv.notifyKeyspaceEvent(notifyStream, "xtrim", args[0].Bulk)
+	}
+
+	return Value{Type: "integer", Num: int(removed)}
+}
+
+// XREAD and XREADGROUP
+
+type streamReadArgs struct {
+	count    int
+	block    time.Duration
+	blocking bool
+	noAck    bool
+	group    string
+	consumer string
+	keys     []string
+	ids      []string
+}
+
+func parseStreamReadArgs(name string, args []Value, withGroup bool) (streamReadArgs, error) {
+	read := streamReadArgs{}
+
+	i := 0
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i].Bulk)
+
+		switch {
+		case option == "COUNT" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1].Bulk)
+			if err != nil {
+				return read, errors.New("ERR value is not an integer or out of range")
+			}
+			if n < 0 {
+				n = 0
+			}
+			read.count = n
+			i++
+		case option == "BLOCK" && i+1 < len(args):
+			ms, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return read, errors.New("ERR timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return read, errors.New("ERR timeout is negative")
+			}
+			read.block = time.Duration(ms) * time.Millisecond
+			read.blocking = true
+			i++
+		case option == "GROUP" && withGroup && i+2 < len(args):
+			read.group = args[i+1].Bulk
+			read.consumer = args[i+2].Bulk
+			i += 2
+		case option == "NOACK" && withGroup:
+			read.noAck = true
+		case option == "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return read, errors.New("ERR Unbalanced '" + name + "' list of streams: for each stream key an ID or '$' must be specified.")
+			}
+			for _, key := range rest[:len(rest)/2] {
+				read.keys = append(read.keys, key.Bulk)
+			}
+			for _, id := range rest[len(rest)/2:] {
+				read.ids = append(read.ids, id.Bulk)
+			}
+			i = len(args)
+		default:
+			return read, errors.New("ERR syntax error")
+		}
+	}
+
+	if len(read.keys) == 0 {
+		return read, errors.New("ERR syntax error")
+	}
+	if withGroup && read.group == "" {
+		return read, errors.New("ERR Missing GROUP option for XREADGROUP")
+	}
+
+	return read, nil
+}
+
+func (srv *Server) xread(c *Client, args []Value) Value {
+	read, err := parseStreamReadArgs("xread", args, false)
+	if err != nil {
+		return Value{Type: "error", Str: err.Error()}
+	}
+
+	// "$" means entries added after this call, so it is resolved once up
+	// front rather than on every wake up.
+	ids := make([]streamID, len(read.keys))
+
+	for _, key := range read.keys {
+		srv.expireIfNeeded(key)
+	}
+
+	unlock := srv.rlockKeys(read.keys)
+	for i, arg := range read.ids {
+		switch arg {
+		case "$":
+			if s, ok := srv.shardOf(read.keys[i]).streams[read.keys[i]]; ok {
+				ids[i] = s.lastID
+			}
+		case "+":
+			if s, ok := srv.shardOf(read.keys[i]).streams[read.keys[i]]; ok && s.length > 0 {
+				last := s.rangeEntries(streamMinID, streamMaxID, 1, true)[0].id
+				ids[i], _ = last.decr()
+			}
+		default:
+			id, err := parseStreamID(arg, 0)
+			if err != nil {
+				unlock()
+				return Value{Type: "error", Str: err.Error()}
+			}
+			ids[i] = id
+		}
+	}
+	unlock()
+
+	return srv.blockingRead(c, read, func() (Value, bool) {
+		unlock := srv.rlockKeys(read.keys)
+		defer unlock()
+
+		results := []Value{}
+		for i, key := range read.keys {
+			s, ok := srv.shardOf(key).streams[key]
+			if !ok {
+				continue
+			}
+
+			start, ok := ids[i].incr()
+			if !ok {
+				continue
+			}
+
+			entries := s.rangeEntries(start, streamMaxID, read.count, false)
+			if len(entries) == 0 {
+				continue
+			}
+
+			results = append(results, Value{Type: "array", Array: []Value{
+				{Type: "bulk", Bulk: key},
+				streamEntriesValue(entries),
+			}})
+		}
+
+		return Value{Type: "array", Array: results}, len(results) > 0
+	})
+}
+
+// blockingRead runs try and, when it finds nothing and BLOCK was given,
+// parks the client until one of the keys is signalled or the timeout
+// expires.
+func (srv *Server) blockingRead(c *Client, read streamReadArgs, try func() (Value, bool)) Value {
+	if !read.blocking {
+		result, ok := try()
+		if !ok && result.Type != "error" {
+			return Value{Type: "null"}
+		}
+		return result
+	}
+
+	watch := srv.watchKeys(read.keys)
+	defer watch.stop()
+
+	var deadline time.Time
+	if read.block > 0 {
+		deadline = time.Now().Add(read.block)
+	}
+
+	for {
+		result, ok := try()
+		if ok || result.Type == "error" {
+			return result
+		}
+
+		if !watch.wait(c, deadline) {
+			return Value{Type: "null"}
+		}
+	}
+}
+
+func (srv *Server) xreadgroup(c *Client, args []Value) Value {
+	read, err := parseStreamReadArgs("xreadgroup", args, true)
+	if err != nil {
+		return Value{Type: "error", Str: err.Error()}
+	}
+
+	for _, arg := range read.ids {
+		if arg != ">" {
+			if _, err := parseStreamID(arg, 0); err != nil {
+				return Value{Type: "error", Str: err.Error()}
+			}
+			// Reading history never blocks.
+			read.blocking = false
+		}
+	}
+
+	for _, key := range read.keys {
+		srv.expireIfNeeded(key)
+	}
+
+	return srv.blockingRead(c, read, func() (Value, bool) {
+		unlock := srv.lockKeys(read.keys)
+		defer unlock()
+
+		results := []Value{}
+		for i, key := range read.keys {
+			s, ok := srv.shardOf(key).streams[key]
+			var group *streamGroup
+			if ok {
+				group = s.groups[read.group]
+			}
+			if group == nil {
+				return Value{Type: "error", Str: fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, read.group)}, false
+			}
+
+			consumer := group.consumer(read.consumer)
+			consumer.seenTime = time.Now()
+
+			var reply Value
+			if read.ids[i] == ">" {
+				entries := s.rangeEntries(streamID{}, streamMaxID, 0, false)
+				entries = entriesAfter(entries, group.lastID, read.count)
+				if len(entries) == 0 {
+					continue
+				}
+
+				consumer.activeTime = consumer.seenTime
+				srv.deliverToConsumer(key, read.group, s, group, consumer, entries, read.noAck)
+				reply = streamEntriesValue(entries)
+			} else {
+				start, _ := parseStreamID(read.ids[i], 0)
+				reply = consumerHistory(s, consumer, start, read.count)
+			}
+
+			results = append(results, Value{Type: "array", Array: []Value{
+				{Type: "bulk", Bulk: key},
+				reply,
+			}})
+		}
+
+		return Value{Type: "array", Array: results}, len(results) > 0
+	})
+}
+
+// entriesAfter returns up to count entries with an ID greater than id.
+func entriesAfter(entries []streamEntry, id streamID, count int) []streamEntry {
+	i := sort.Search(len(entries), func(i int) bool {
+		return id.less(entries[i].id)
+	})
+	entries = entries[i:]
+	if count > 0 && len(entries) > count {
+		entries = entries[:count]
+	}
+	return entries
+}
+
+func (srv *Server) deliverToConsumer(key, groupName string, s *stream, group *streamGroup, consumer *streamConsumer, entries []streamEntry, noAck bool) {
+	now := time.Now()
+
+	for _, entry := range entries {
+		group.lastID = entry.id
+		group.entriesRead++
+
+		if noAck {
+			continue
+		}
+
+		nack, ok := group.pel[entry.id]
+		if ok {
+			delete(nack.consumer.pel, entry.id)
+		} else {
+			nack = &streamNACK{}
+			group.pel[entry.id] = nack
+		}
+		nack.consumer = consumer
+		nack.deliveryTime = now
+		nack.deliveryCount = 1
+		consumer.pel[entry.id] = nack
+
+		srv.propagateClaim(key, groupName, consumer.name, entry.id, nack, group.lastID)
+	}
+
+	srv.propagate(
+		Value{Type: "bulk", Bulk: "XGROUP"},
+		Value{Type: "bulk", Bulk: "SETID"},
+		Value{Type: "bulk", Bulk: key},
+		Value{Type: "bulk", Bulk: groupName},
+		Value{Type: "bulk", Bulk: group.lastID.String()},
+		Value{Type: "bulk", Bulk: "ENTRIESREAD"},
+		Value{Type: "bulk", Bulk: strconv.FormatInt(group.entriesRead, 10)},
+	)
+}
+
+// propagateClaim logs the delivery of an entry as an XCLAIM with an
+// absolute delivery time, which replays identically.
+func (srv *Server) propagateClaim(key, group, consumer string, id streamID, nack *streamNACK, lastID streamID) {
+	srv.propagate(
+		Value{Type: "bulk", Bulk: "XCLAIM"},
+		Value{Type: "bulk", Bulk: key},
+		Value{Type: "bulk", Bulk: group},
+		Value{Type: "bulk", Bulk: consumer},
+		Value{Type: "bulk", Bulk: "0"},
+		Value{Type: "bulk", Bulk: id.String()},
+		Value{Type: "bulk", Bulk: "TIME"},
+		Value{Type: "bulk", Bulk: strconv.FormatInt(nack.deliveryTime.UnixMilli(), 10)},
+		Value{Type: "bulk", Bulk: "RETRYCOUNT"},
+		Value{Type: "bulk", Bulk: strconv.FormatInt(nack.deliveryCount, 10)},
+		Value{Type: "bulk", Bulk: "FORCE"},
+		Value{Type: "bulk", Bulk: "JUSTID"},
+		Value{Type: "bulk", Bulk: "LASTID"},
+		Value{Type: "bulk", Bulk: lastID.String()},
+	)
+}
+
+func consumerHistory(s *stream, consumer *streamConsumer, start streamID, count int) Value {
+	ids := sortedPendingIDs(consumer.pel)
+
+	values := []Value{}
+	for _, id := range ids {
+		if id.less(start) {
+			continue
+		}
+		if count > 0 && len(values) == count {
+			break
+		}
+
+		entry, ok := s.get(id)
+		if !ok {
+			// Deleted entries are still reported, with a null body.
+			values = append(values, Value{Type: "array", Array: []Value{
+				{Type: "bulk", Bulk: id.String()},
+				{Type: "null"},
+			}})
+			continue
+		}
+		values = append(values, streamEntryValue(entry))
+	}
+
+	return Value{Type: "array", Array: values}
+}
+
+func sortedPendingIDs(pel map[streamID]*streamNACK) []streamID {
+	ids := make([]streamID, 0, len(pel))
+	for id := range pel {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].less(ids[j])
+	})
+	return ids
+}
+
+func (g *streamGroup) consumer(name string) *streamConsumer {
+	c, ok := g.consumers[name]
+	if !ok {
+		now := time.Now()
+		c = &streamConsumer{name: name, seenTime: now, activeTime: now, pel: map[streamID]*streamNACK{}}
+		g.consumers[name] = c
+	}
+	return c
+}
+
+// Consumer groups
+
+func (srv *Server) xgroup(args []Value) Value {
+	subcommand := strings.ToUpper(args[0].Bulk)
+	args = args[1:]
+
+	key := ""
+	if len(args) > 0 {
+		key = args[0].Bulk
+		srv.expireIfNeeded(key)
+	}
+
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	switch subcommand {
+	case "CREATE":
+		return srv.xgroupCreate(args)
+	case "SETID":
+		if len(args) != 3 && len(args) != 5 {
+			return Value{Type: "error", Str: "ERR wrong number of arguments for 'xgroup|setid' command"}
+		}
+
+		s, ok := sh.streams[args[0].Bulk]
+		if !ok || s.groups[args[1].Bulk] == nil {
+			return noGroupError(args[0].Bulk, args[1].Bulk)
+		}
+		group := s.groups[args[1].Bulk]
+
+		id, err := groupStartID(s, args[2].Bulk)
+		if err != nil {
+			return Value{Type: "error", Str: err.Error()}
+		}
+		group.lastID = id
+
+		if len(args) == 5 {
+			n, err := parseEntriesRead(args[3:])
+			if err != nil {
+				return Value{Type: "error", Str: err.Error()}
+			}
+			group.entriesRead = n
+		}
+
+		srv.notifyKeyspaceEvent(notifyStream, "xgroup-setid", args[0].Bulk)
+
+		return Value{Type: "string", Str: "OK"}
+	case "DESTROY":
+		if len(args) != 2 {
+			return Value{Type: "error", Str: "ERR wrong number of arguments for 'xgroup|destroy' command"}
+		}
+
+		s, ok := sh.streams[args[0].Bulk]
+		if !ok {
+			return Value{Type: "error", Str: "ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."}
+		}
+		if _, ok := s.groups[args[1].Bulk]; !ok {
+			return Value{Type: "integer", Num: 0}
+		}
+
+		delete(s.groups, args[1].Bulk)
+		srv.signalKeyAsReady(args[0].Bulk)
+		srv.notifyKeyspaceEvent(notifyStream, "xgroup-destroy", args[0].Bulk)
+
+		return Value{Type: "integer", Num: 1}
+	case "CREATECONSUMER":
+		if len(args) != 3 {
+			return Value{Type: "error", Str: "ERR wrong number of arguments for 'xgroup|createconsumer' command"}
+		}
+
+		s, ok := sh.streams[args[0].Bulk]
+		if !ok || s.groups[args[1].Bulk] == nil {
+			return noGroupError(args[0].Bulk, args[1].Bulk)
+		}
+		group := s.groups[args[1].Bulk]
+
+		if _, ok := group.consumers[args[2].Bulk]; ok {
+			return Value{Type: "integer", Num: 0}
+		}
+		group.consumer(args[2].Bulk)
+		srv.notifyKeyspaceEvent(notifyStream, "xgroup-createconsumer", args[0].Bulk)
+
+		return Value{Type: "integer", Num: 1}
+	case "DELCONSUMER":
+		if len(args) != 3 {
+			return Value{Type: "error", Str: "ERR wrong number of arguments for 'xgroup|delconsumer' command"}
+		}
+
+		s, ok := sh.streams[args[0].Bulk]
+		if !ok || s.groups[args[1].Bulk] == nil {
+			return noGroupError(args[0].Bulk, args[1].Bulk)
+		}
+		group := s.groups[args[1].Bulk]
+
+		consumer, ok := group.consumers[args[2].Bulk]
+		if !ok {
+			return Value{Type: "integer", Num: 0}
+		}
+
+		pending := len(consumer.pel)
+		for id := range consumer.pel {
+			delete(group.pel, id)
+		}
+		delete(group.consumers, args[2].Bulk)
+		srv.notifyKeyspaceEvent(notifyStream, "xgroup-delconsumer", args[0].Bulk)
+
+		return Value{Type: "integer", Num: pending}
+	default:
+		return Value{Type: "error", Str: fmt.Sprintf("ERR unknown subcommand '%s'", subcommand)}
+	}
+}
+
+func (srv *Server) xgroupCreate(args []Value) Value {
+	if len(args) < 3 {
+		return Value{Type: "error", Str: "ERR wrong number of arguments for 'xgroup|create' command"}
+	}
+
+	key, name := args[0].Bulk, args[1].Bulk
+	mkStream := false
+	entriesRead := int64(-1)
+
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "MKSTREAM":
+			mkStream = true
+		case "ENTRIESREAD":
+			if i+1 >= len(args) {
+				return Value{Type: "error", Str: "ERR syntax error"}
+			}
+			n, err := parseEntriesRead(args[i : i+2])
+			if err != nil {
+				return Value{Type: "error", Str: err.Error()}
+			}
+			entriesRead = n
+			i++
+		default:
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+	}
+
+	// The caller holds the shard lock.
+	sh := srv.shardOf(key)
+	s, errValue := lookupStream(sh, key)
+	if errValue != nil {
+		return *errValue
+	}
+	if s == nil {
+		if !mkStream {
+			return Value{Type: "error", Str: "ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."}
+		}
+		s = newStream()
+		sh.streams[key] = s
+	}
+
+	if _, ok := s.groups[name]; ok {
+		return Value{Type: "error", Str: "BUSYGROUP Consumer Group name already exists"}
+	}
+
+	id, err := groupStartID(s, args[2].Bulk)
+	if err != nil {
+		return Value{Type: "error", Str: err.Error()}
+	}
+
+	if entriesRead < 0 && id == s.lastID {
+		entriesRead = s.entriesAdded
+	}
+	if entriesRead < 0 {
+		entriesRead = 0
+	}
+
+	s.groups[name] = &streamGroup{
+		lastID:      id,
+		entriesRead: entriesRead,
+		pel:         map[streamID]*streamNACK{},
+		consumers:   map[string]*streamConsumer{},
+	}
+
+	srv.notifyKeyspaceEvent(notifyStream, "xgroup-create", key)
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+func groupStartID(s *stream, arg string) (streamID, error) {
+	if arg == "$" {
+		return s.lastID, nil
+	}
+	return parseStreamID(arg, 0)
+}
+
+func parseEntriesRead(args []Value) (int64, error) {
+	if strings.ToUpper(args[0].Bulk) != "ENTRIESREAD" {
+		return 0, errors.New("ERR syntax error")
+	}
+
+	n, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil || n < -1 {
+		return 0, errors.New("ERR value for ENTRIESREAD must be positive or -1")
+	}
+	return n, nil
+}
+
+func (srv *Server) xack(args []Value) Value {
+	ids := []streamID{}
+	for _, arg := range args[2:] {
+		id, err := parseStreamID(arg.Bulk, 0)
+		if err != nil {
+			return Value{Type: "error", Str: err.Error()}
+		}
+		ids = append(ids, id)
+	}
+
+	srv.expireIfNeeded(args[0].Bulk)
+
+	sh := srv.shardOf(args[0].Bulk)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	s, ok := sh.streams[args[0].Bulk]
+	if !ok || s.groups[args[1].Bulk] == nil {
+		return Value{Type: "integer", Num: 0}
+	}
+	group := s.groups[args[1].Bulk]
+
+	acked := 0
+	for _, id := range ids {
+		nack, ok := group.pel[id]
+		if !ok {
+			continue
+		}
+
+		delete(group.pel, id)
+		delete(nack.consumer.pel, id)
+		acked++
+	}
+
+	return Value{Type: "integer", Num: acked}
+}
+
+func (srv *Server) xpending(args []Value) Value {
+	key, name := args[0].Bulk, args[1].Bulk
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	s, ok := sh.streams[key]
+	if !ok || s.groups[name] == nil {
+		return noGroupError(key, name)
+	}
+	group := s.groups[name]
+
+	// Summary form.
+	if len(args) == 2 {
+		if len(group.pel) == 0 {
+			return Value{Type: "array", Array: []Value{
+				{Type: "integer", Num: 0},
+				{Type: "null"},
+				{Type: "null"},
+				{Type: "null"},
+			}}
+		}
+
+		ids := sortedPendingIDs(group.pel)
+
+		names := []string{}
+		for consumerName, c := range group.consumers {
+			if len(c.pel) > 0 {
+				names = append(names, consumerName)
+			}
+		}
+		sort.Strings(names)
+
+		consumers := []Value{}
+		for _, consumerName := range names {
+			consumers = append(consumers, Value{Type: "array", Array: []Value{
+				{Type: "bulk", Bulk: consumerName},
+				{Type: "bulk", Bulk: strconv.Itoa(len(group.consumers[consumerName].pel))},
+			}})
+		}
+
+		return Value{Type: "array", Array: []Value{
+			{Type: "integer", Num: len(ids)},
+			{Type: "bulk", Bulk: ids[0].String()},
+			{Type: "bulk", Bulk: ids[len(ids)-1].String()},
+			{Type: "array", Array: consumers},
+		}}
+	}
+
+	// Extended form: [IDLE min-idle-time] start end count [consumer]
+	rest := args[2:]
+	var minIdle time.Duration
+	if strings.ToUpper(rest[0].Bulk) == "IDLE" {
+		if len(rest) < 2 {
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+		ms, err := strconv.ParseInt(rest[1].Bulk, 10, 64)
+		if err != nil {
+			return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+		}
+		minIdle = time.Duration(ms) * time.Millisecond
+		rest = rest[2:]
+	}
+
+	if len(rest) != 3 && len(rest) != 4 {
+		return Value{Type: "error", Str: "ERR syntax error"}
+	}
+
+	start, err := parseRangeID(rest[0].Bulk, true)
+	if err != nil {
+		return Value{Type: "error", Str: err.Error()}
+	}
+	end, err := parseRangeID(rest[1].Bulk, false)
+	if err != nil {
+		return Value{Type: "error", Str: err.Error()}
+	}
+	count, err := strconv.Atoi(rest[2].Bulk)
+	if err != nil {
+		return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+	}
+
+	pel := group.pel
+	if len(rest) == 4 {
+		c, ok := group.consumers[rest[3].Bulk]
+		if !ok {
+			return Value{Type: "array", Array: []Value{}}
+		}
+		pel = c.pel
+	}
+
+	now := time.Now()
+	values := []Value{}
+	for _, id := range sortedPendingIDs(pel) {
+		if id.less(start) || end.less(id) {
+			continue
+		}
+		if len(values) >= count {
+			break
+		}
+
+		nack := pel[id]
+		idle := now.Sub(nack.deliveryTime)
+		if idle < minIdle {
+			continue
+		}
+
+		values = append(values, Value{Type: "array", Array: []Value{
+			{Type: "bulk", Bulk: id.String()},
+			{Type: "bulk", Bulk: nack.consumer.name},
+			{Type: "integer", Num: int(idle.Milliseconds())},
+			{Type: "integer", Num: int(nack.deliveryCount)},
+		}})
+	}
+
+	return Value{Type: "array", Array: values}
+}
+
+func (srv *Server) xclaim(args []Value) Value {
+	key, name, consumerName := args[0].Bulk, args[1].Bulk, args[2].Bulk
+
+	minIdleMs, err := strconv.ParseInt(args[3].Bulk, 10, 64)
+	if err != nil {
+		return Value{Type: "error", Str: "ERR Invalid min-idle-time argument for XCLAIM"}
+	}
+	minIdle := time.Duration(max(minIdleMs, 0)) * time.Millisecond
+
+	ids := []streamID{}
+	i := 4
+	for ; i < len(args); i++ {
+		id, err := parseStreamID(args[i].Bulk, 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	now := time.Now()
+	deliveryTime := now
+	retryCount := int64(-1)
+	force, justID := false, false
+	var lastID *streamID
+
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i].Bulk)
+
+		switch {
+		case option == "FORCE":
+			force = true
+		case option == "JUSTID":
+			justID = true
+		case (option == "IDLE" || option == "TIME" || option == "RETRYCOUNT") && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return Value{Type: "error", Str: fmt.Sprintf("ERR Invalid %s option argument for XCLAIM", option)}
+			}
+			switch option {
+			case "IDLE":
+				deliveryTime = now.Add(-time.Duration(n) * time.Millisecond)
+			case "TIME":
+				deliveryTime = time.UnixMilli(n)
+			case "RETRYCOUNT":
+				retryCount = n
+			}
+			i++
+		case option == "LASTID" && i+1 < len(args):
+			id, err := parseStreamID(args[i+1].Bulk, 0)
+			if err != nil {
+				return Value{Type: "error", Str: err.Error()}
+			}
+			lastID = &id
+			i++
+		default:
+			return Value{Type: "error", Str: fmt.Sprintf("ERR Unrecognized XCLAIM option '%s'", args[i].Bulk)}
+		}
+	}
+
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	s, ok := sh.streams[key]
+	if !ok || s.groups[name] == nil {
+		return noGroupError(key, name)
+	}
+	group := s.groups[name]
+
+	if lastID != nil && group.lastID.less(*lastID) {
+		group.lastID = *lastID
+	}
+
+	consumer := group.consumer(consumerName)
+	consumer.seenTime = now
+
+	values := []Value{}
+	for _, id := range ids {
+		entry, exists := s.get(id)
+
+		nack, ok := group.pel[id]
+		if !ok {
+			if !force || !exists {
+				continue
+			}
+			nack = &streamNACK{}
+			group.pel[id] = nack
+		}
+
+		if !exists {
+			// The entry was deleted while pending; drop it from the PEL.
+			delete(group.pel, id)
+			if nack.consumer != nil {
+				delete(nack.consumer.pel, id)
+			}
+			continue
+		}
+
+		if minIdle > 0 && now.Sub(nack.deliveryTime) < minIdle {
+			continue
+		}
+
+		if nack.consumer != nil {
+			delete(nack.consumer.pel, id)
+		}
+		nack.consumer = consumer
+		nack.deliveryTime = deliveryTime
+		consumer.pel[id] = nack
+		consumer.activeTime = now
+
+		switch {
+		case retryCount >= 0:
+			nack.deliveryCount = retryCount
+		case !justID:
+			nack.deliveryCount++
+		}
+
+		srv.propagateClaim(key, name, consumerName, id, nack, group.lastID)
+
+		if justID {
+			values = append(values, Value{Type: "bulk", Bulk: id.String()})
+		} else {
+			values = append(values, streamEntryValue(entry))
+		}
+	}
+
+	return Value{Type: "array", Array: values}
+}
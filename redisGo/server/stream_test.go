@@ -0,0 +1,174 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"redisGo/aof"
+)
+
+func streamCommand(args ...string) Value {
+	value := command(args...)
+	return handlers[strings.ToUpper(args[0])](testServer, value.Array[1:])
+}
+
+func TestStreamRanges(t *testing.T) {
+	// Enough entries to span several nodes.
+	for i := 1; i <= 3*streamNodeMaxEntries; i++ {
+		id := streamID{uint64(i), 0}.String()
+		if result := streamCommand("XADD", "stream:ranges", id, "n", id); result.Bulk != id {
+			t.Fatalf("Expected XADD to return %s, got %v", id, result)
+		}
+	}
+
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"XRANGE", "stream:ranges", "-", "+", "COUNT", "2"}, []string{"1-0", "2-0"}},
+		{[]string{"XRANGE", "stream:ranges", "(99", "101"}, []string{"100-0", "101-0"}},
+		{[]string{"XRANGE", "stream:ranges", "299", "+"}, []string{"299-0", "300-0"}},
+		{[]string{"XREVRANGE", "stream:ranges", "+", "-", "COUNT", "2"}, []string{"300-0", "299-0"}},
+		{[]string{"XREVRANGE", "stream:ranges", "201", "(199"}, []string{"201-0", "200-0"}},
+		{[]string{"XREVRANGE", "stream:ranges", "2", "-"}, []string{"2-0", "1-0"}},
+	}
+
+	for _, test := range tests {
+		result := streamCommand(test.args...)
+
+		got := []string{}
+		for _, entry := range result.Array {
+			got = append(got, entry.Array[0].Bulk)
+		}
+		if strings.Join(got, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("Expected %v for %v, got %v", test.expected, test.args, got)
+		}
+	}
+}
+
+func TestStreamAddIDs(t *testing.T) {
+	if result := streamCommand("XADD", "stream:ids", "0-0", "f", "v"); result.Type != "error" {
+		t.Errorf("Expected 0-0 to be rejected, got %v", result)
+	}
+
+	streamCommand("XADD", "stream:ids", "5-1", "f", "v")
+	if result := streamCommand("XADD", "stream:ids", "5-*", "f", "v"); result.Bulk != "5-2" {
+		t.Errorf("Expected 5-2, got %v", result)
+	}
+	if result := streamCommand("XADD", "stream:ids", "4-9", "f", "v"); result.Type != "error" {
+		t.Errorf("Expected a smaller ID to be rejected, got %v", result)
+	}
+	if result := streamCommand("XADD", "stream:missing", "NOMKSTREAM", "*", "f", "v"); result.Type != "null" {
+		t.Errorf("Expected NOMKSTREAM to return null, got %v", result)
+	}
+}
+
+func TestStreamTrim(t *testing.T) {
+	for i := 1; i <= 250; i++ {
+		streamCommand("XADD", "stream:trim", streamID{uint64(i), 0}.String(), "f", "v")
+	}
+
+	// Approximate trimming only removes whole nodes.
+	if result := streamCommand("XTRIM", "stream:trim", "MAXLEN", "~", "120"); result.Num != 100 {
+		t.Errorf("Expected one node to be trimmed, got %v", result)
+	}
+	if result := streamCommand("XTRIM", "stream:trim", "MAXLEN", "100"); result.Num != 50 {
+		t.Errorf("Expected 50 entries to be trimmed, got %v", result)
+	}
+	if result := streamCommand("XTRIM", "stream:trim", "MINID", "201"); result.Num != 50 {
+		t.Errorf("Expected 50 entries to be trimmed, got %v", result)
+	}
+	if result := streamCommand("XLEN", "stream:trim"); result.Num != 50 {
+		t.Errorf("Expected 50 entries left, got %v", result)
+	}
+}
+
+func TestStreamConsumerGroups(t *testing.T) {
+	client := newTestClient()
+
+	testServer.call(client, command("XGROUP", "CREATE", "stream:groups", "workers", "$", "MKSTREAM"))
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		testServer.call(client, command("XADD", "stream:groups", id, "job", id))
+	}
+
+	first := testServer.call(client, command("XREADGROUP", "GROUP", "workers", "alice", "COUNT", "2", "STREAMS", "stream:groups", ">"))
+	second := testServer.call(client, command("XREADGROUP", "GROUP", "workers", "bob", "STREAMS", "stream:groups", ">"))
+	if len(first.Array[0].Array[1].Array) != 2 || len(second.Array[0].Array[1].Array) != 1 {
+		t.Fatalf("Expected entries to be split between consumers, got %v and %v", first, second)
+	}
+
+	if result := testServer.call(client, command("XACK", "stream:groups", "workers", "1-0", "1-0")); result.Num != 1 {
+		t.Errorf("Expected one entry to be acknowledged, got %v", result)
+	}
+
+	summary := testServer.call(client, command("XPENDING", "stream:groups", "workers"))
+	if summary.Array[0].Num != 2 || summary.Array[1].Bulk != "2-0" || summary.Array[2].Bulk != "3-0" {
+		t.Errorf("Unexpected XPENDING summary: %v", summary)
+	}
+
+	claimed := testServer.call(client, command("XCLAIM", "stream:groups", "workers", "bob", "0", "2-0", "JUSTID"))
+	if len(claimed.Array) != 1 || claimed.Array[0].Bulk != "2-0" {
+		t.Errorf("Expected 2-0 to be claimed, got %v", claimed)
+	}
+
+	history := testServer.call(client, command("XREADGROUP", "GROUP", "workers", "bob", "STREAMS", "stream:groups", "0"))
+	if len(history.Array[0].Array[1].Array) != 2 {
+		t.Errorf("Expected bob to own two pending entries, got %v", history)
+	}
+}
+
+func TestStreamBlockingRead(t *testing.T) {
+	reader, writer := newTestClient(), newTestClient()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		testServer.call(writer, command("XADD", "stream:blocking", "1-0", "f", "v"))
+	}()
+
+	start := time.Now()
+	result := testServer.call(reader, command("XREAD", "BLOCK", "1000", "STREAMS", "stream:blocking", "$"))
+	if len(result.Array) != 1 || time.Since(start) >= time.Second {
+		t.Errorf("Expected XREAD to be woken up by XADD, got %v", result)
+	}
+
+	result = testServer.call(reader, command("XREAD", "BLOCK", "10", "STREAMS", "stream:blocking", "$"))
+	if result.Type != "null" {
+		t.Errorf("Expected XREAD to time out, got %v", result)
+	}
+}
+
+func TestStreamPropagation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.aof")
+
+	var err error
+	testServer.aofFile, err = aof.New(path, nil)
+	if err != nil {
+		t.Fatalf("Failed to create AOF: %v", err)
+	}
+	defer func() {
+		testServer.aofFile.Close()
+		testServer.aofFile = nil
+	}()
+
+	client := newTestClient()
+	id := testServer.call(client, command("XADD", "stream:aof", "*", "f", "v")).Bulk
+	testServer.call(client, command("XGROUP", "CREATE", "stream:aof", "g", "0"))
+	testServer.call(client, command("XREADGROUP", "GROUP", "g", "c", "STREAMS", "stream:aof", ">"))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read AOF: %v", err)
+	}
+
+	log := string(data)
+	if !strings.Contains(log, "XADD\r\n$10\r\nstream:aof\r\n$"+strconv.Itoa(len(id))+"\r\n"+id+"\r\n") {
+		t.Errorf("Expected XADD to be logged with the generated ID %s, got %q", id, log)
+	}
+	if !strings.Contains(log, "XCLAIM") || strings.Contains(log, "XREADGROUP") {
+		t.Errorf("Expected XREADGROUP to be logged as XCLAIM, got %q", log)
+	}
+}
@@ -0,0 +1,370 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Client side caching. In the default mode the server remembers which
+// clients read which keys and sends each of them a single invalidation
+// message the next time the key changes. In BCAST mode clients instead
+// subscribe to key prefixes and are told about every change under them.
+//
+// Invalidations are RESP3 push messages, or pub/sub messages on
+// __redis__:invalidate sent to another connection with REDIRECT, which is
+// how RESP2 clients use tracking.
+
+type trackingState struct {
+	enabled  bool
+	bcast    bool
+	optin    bool
+	optout   bool
+	noloop   bool
+	redirect int64
+	prefixes []string
+
+	// Set by CLIENT CACHING yes|no for the next command only.
+	caching string
+}
+
+const trackingChannel = "__redis__:invalidate"
+
+// trackingAfterCommand updates the tracking state once c ran a command:
+// keys read are remembered and keys written are invalidated.
+func (srv *Server) trackingAfterCommand(c *Client, command string, args []Value, result Value) {
+	if result.Type == "error" {
+		return
+	}
+
+	if commandHasFlag(command, "write") {
+		srv.signalModifiedKeys(c, commandKeys(command, args))
+		return
+	}
+
+	c.mu.Lock()
+	t := c.tracking
+	if command != "CLIENT" {
+		c.tracking.caching = ""
+	}
+	c.mu.Unlock()
+
+	if !t.enabled || t.bcast {
+		return
+	}
+	if t.optin && t.caching != "yes" {
+		return
+	}
+	if t.optout && t.caching == "no" {
+		return
+	}
+
+	keys := commandKeys(command, args)
+	if len(keys) == 0 {
+		return
+	}
+
+	srv.trackingMu.Lock()
+	for _, key := range keys {
+		if srv.trackingTable[key] == nil {
+			srv.trackingTable[key] = map[*Client]bool{}
+		}
+		srv.trackingTable[key][c] = true
+	}
+	srv.trackingMu.Unlock()
+}
+
+// trackingInvalidateKeys tells every client caching keys that they
+// changed. modifier is the client that changed them, or nil.
+func (srv *Server) trackingInvalidateKeys(modifier *Client, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	srv.trackingMu.Lock()
+	if len(srv.trackingTable) == 0 && len(srv.trackingPrefixes) == 0 {
+		srv.trackingMu.Unlock()
+		return
+	}
+
+	targets := map[*Client][]string{}
+	for _, key := range keys {
+		for c := range srv.trackingTable[key] {
+			targets[c] = append(targets[c], key)
+		}
+		delete(srv.trackingTable, key)
+
+		for prefix, clients := range srv.trackingPrefixes {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			for c := range clients {
+				targets[c] = append(targets[c], key)
+			}
+		}
+	}
+	srv.trackingMu.Unlock()
+
+	for c, keys := range targets {
+		c.mu.Lock()
+		t := c.tracking
+		c.mu.Unlock()
+
+		// The client may have turned tracking off since it read the key.
+		if !t.enabled || (t.noloop && c == modifier) {
+			continue
+		}
+		srv.sendInvalidation(c, t.redirect, keys)
+	}
+}
+
+func (srv *Server) sendInvalidation(c *Client, redirect int64, keys []string) {
+	values := []Value{}
+	for _, key := range keys {
+		values = append(values, Value{Type: "bulk", Bulk: key})
+	}
+	payload := Value{Type: "array", Array: values}
+
+	target := c
+	if redirect != 0 {
+		srv.clientsMu.RLock()
+		target = srv.clients[redirect]
+		srv.clientsMu.RUnlock()
+
+		if target == nil {
+			if c.resp() == 3 {
+				c.Write(Value{Type: "push", Array: []Value{{Type: "bulk", Bulk: "tracking-redir-broken"}, {Type: "integer", Num: int(redirect)}}})
+			}
+			return
+		}
+	}
+
+	if target.resp() == 3 {
+		target.Write(Value{Type: "push", Array: []Value{{Type: "bulk", Bulk: "invalidate"}, payload}})
+		return
+	}
+
+	// A RESP2 connection can only receive invalidations as pub/sub messages.
+	srv.pubSubMu.RLock()
+	subscribed := target.channels[trackingChannel]
+	srv.pubSubMu.RUnlock()
+
+	if subscribed {
+		target.Write(Value{Type: "push", Array: []Value{
+			{Type: "bulk", Bulk: "message"},
+			{Type: "bulk", Bulk: trackingChannel},
+			payload,
+		}})
+	}
+}
+
+// disableTracking turns tracking off for c. Keys it read are dropped from
+// the table lazily, the next time they change.
+func (srv *Server) disableTracking(c *Client) {
+	c.mu.Lock()
+	prefixes := c.tracking.prefixes
+	c.tracking = trackingState{}
+	c.mu.Unlock()
+
+	srv.trackingMu.Lock()
+	for _, prefix := range prefixes {
+		delete(srv.trackingPrefixes[prefix], c)
+		if len(srv.trackingPrefixes[prefix]) == 0 {
+			delete(srv.trackingPrefixes, prefix)
+		}
+	}
+	srv.trackingMu.Unlock()
+}
+
+// CLIENT TRACKING ON|OFF [REDIRECT id] [PREFIX prefix ...] [BCAST] [OPTIN]
+// [OPTOUT] [NOLOOP]
+func (srv *Server) clientTracking(c *Client, args []Value) Value {
+	if len(args) == 0 {
+		return Value{Type: "error", Str: "ERR wrong number of arguments for 'client|tracking' command"}
+	}
+
+	t := trackingState{enabled: true}
+
+	for i := 1; i < len(args); i++ {
+		option := strings.ToUpper(args[i].Bulk)
+
+		switch {
+		case option == "REDIRECT" && i+1 < len(args):
+			id, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+			}
+
+			srv.clientsMu.RLock()
+			_, ok := srv.clients[id]
+			srv.clientsMu.RUnlock()
+			if !ok {
+				return Value{Type: "error", Str: "ERR The client ID you want redirect to does not exist"}
+			}
+
+			t.redirect = id
+			i++
+		case option == "PREFIX" && i+1 < len(args):
+			t.prefixes = append(t.prefixes, args[i+1].Bulk)
+			i++
+		case option == "BCAST":
+			t.bcast = true
+		case option == "OPTIN":
+			t.optin = true
+		case option == "OPTOUT":
+			t.optout = true
+		case option == "NOLOOP":
+			t.noloop = true
+		default:
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+	}
+
+	switch strings.ToUpper(args[0].Bulk) {
+	case "ON":
+	case "OFF":
+		srv.disableTracking(c)
+		return Value{Type: "string", Str: "OK"}
+	default:
+		return Value{Type: "error", Str: "ERR syntax error"}
+	}
+
+	if len(t.prefixes) > 0 && !t.bcast {
+		return Value{Type: "error", Str: "ERR PREFIX option requires BCAST mode to be enabled"}
+	}
+	if t.optin && t.optout {
+		return Value{Type: "error", Str: "ERR You can't use OPTIN and OPTOUT at the same time"}
+	}
+	if t.bcast && (t.optin || t.optout) {
+		return Value{Type: "error", Str: "ERR OPTIN and OPTOUT are not compatible with BCAST"}
+	}
+
+	c.mu.Lock()
+	current := c.tracking
+	c.mu.Unlock()
+
+	if current.enabled && (current.bcast != t.bcast || current.optin != t.optin || current.optout != t.optout) {
+		return Value{Type: "error", Str: "ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode."}
+	}
+
+	if t.bcast {
+		if len(t.prefixes) == 0 {
+			t.prefixes = []string{""}
+		}
+
+		all := append(append([]string{}, current.prefixes...), t.prefixes...)
+		for i, a := range all {
+			for _, b := range all[i+1:] {
+				if a != b && (strings.HasPrefix(a, b) || strings.HasPrefix(b, a)) {
+					return Value{Type: "error", Str: fmt.Sprintf("ERR Prefix '%s' overlaps with an existing prefix '%s'. Prefixes for a single client must not overlap.", b, a)}
+				}
+			}
+		}
+
+		srv.trackingMu.Lock()
+		for _, prefix := range t.prefixes {
+			if srv.trackingPrefixes[prefix] == nil {
+				srv.trackingPrefixes[prefix] = map[*Client]bool{}
+			}
+			srv.trackingPrefixes[prefix][c] = true
+		}
+		srv.trackingMu.Unlock()
+
+		t.prefixes = uniqueStrings(all)
+	}
+
+	c.mu.Lock()
+	c.tracking = t
+	c.mu.Unlock()
+
+	return Value{Type: "string", Str: "OK"}
+}
+
+func clientCaching(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{Type: "error", Str: "ERR wrong number of arguments for 'client|caching' command"}
+	}
+
+	mode := strings.ToLower(args[0].Bulk)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &c.tracking
+	switch {
+	case mode == "yes" && t.enabled && t.optin, mode == "no" && t.enabled && t.optout:
+		t.caching = mode
+		return Value{Type: "string", Str: "OK"}
+	case mode == "yes":
+		return Value{Type: "error", Str: "ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode."}
+	case mode == "no":
+		return Value{Type: "error", Str: "ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode."}
+	default:
+		return Value{Type: "error", Str: "ERR syntax error"}
+	}
+}
+
+func clientGetRedir(c *Client) Value {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.tracking.enabled {
+		return Value{Type: "integer", Num: -1}
+	}
+	return Value{Type: "integer", Num: int(c.tracking.redirect)}
+}
+
+func clientTrackingInfo(c *Client) Value {
+	c.mu.Lock()
+	t := c.tracking
+	c.mu.Unlock()
+
+	flags := []Value{}
+	if !t.enabled {
+		flags = append(flags, Value{Type: "bulk", Bulk: "off"})
+	} else {
+		flags = append(flags, Value{Type: "bulk", Bulk: "on"})
+	}
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{{t.bcast, "bcast"}, {t.optin, "optin"}, {t.optout, "optout"}, {t.noloop, "noloop"}} {
+		if f.set {
+			flags = append(flags, Value{Type: "bulk", Bulk: f.name})
+		}
+	}
+	if t.caching == "yes" {
+		flags = append(flags, Value{Type: "bulk", Bulk: "caching-yes"})
+	}
+	if t.caching == "no" {
+		flags = append(flags, Value{Type: "bulk", Bulk: "caching-no"})
+	}
+
+	redirect := -1
+	if t.enabled {
+		redirect = int(t.redirect)
+	}
+
+	prefixes := []Value{}
+	for _, prefix := range t.prefixes {
+		prefixes = append(prefixes, Value{Type: "bulk", Bulk: prefix})
+	}
+
+	return Value{Type: "map", Array: []Value{
+		{Type: "bulk", Bulk: "flags"}, {Type: "array", Array: flags},
+		{Type: "bulk", Bulk: "redirect"}, {Type: "integer", Num: redirect},
+		{Type: "bulk", Bulk: "prefixes"}, {Type: "array", Array: prefixes},
+	}}
+}
+
+func uniqueStrings(list []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"redisGo/resp"
+)
+
+// pipeClient returns a client whose output is parsed into the returned
+// channel, so pushed messages can be inspected.
+func pipeClient() (*Client, chan Value) {
+	conn, peer := net.Pipe()
+	client := testServer.newClient(conn)
+
+	messages := make(chan Value, 16)
+	go func() {
+		reader := resp.NewResp(peer)
+		for {
+			value, err := reader.Read()
+			if err != nil {
+				return
+			}
+			messages <- value
+		}
+	}()
+
+	return client, messages
+}
+
+func expectInvalidation(t *testing.T, messages chan Value, key string) {
+	t.Helper()
+
+	select {
+	case message := <-messages:
+		if message.Array[0].Bulk != "invalidate" || message.Array[1].Array[0].Bulk != key {
+			t.Errorf("Expected an invalidation of %s, got %v", key, message)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for an invalidation of %s", key)
+	}
+}
+
+func expectNoMessage(t *testing.T, messages chan Value) {
+	t.Helper()
+
+	select {
+	case message := <-messages:
+		t.Errorf("Expected no message, got %v", message)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCommandKeys(t *testing.T) {
+	tests := []struct {
+		command  Value
+		expected []string
+	}{
+		{command("GET", "a"), []string{"a"}},
+		{command("DEL", "a", "b", "c"), []string{"a", "b", "c"}},
+		{command("XREAD", "COUNT", "1", "STREAMS", "s1", "s2", "0", "0"), []string{"s1", "s2"}},
+		{command("PING"), nil},
+	}
+
+	for _, test := range tests {
+		got := commandKeys(test.command.Array[0].Bulk, test.command.Array[1:])
+		if len(got) == 0 && len(test.expected) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Expected keys %v for %v, got %v", test.expected, test.command.Array[0].Bulk, got)
+		}
+	}
+}
+
+func TestTrackingDefaultMode(t *testing.T) {
+	reader, messages := pipeClient()
+	defer testServer.disableTracking(reader)
+	writer := newTestClient()
+
+	testServer.call(reader, command("HELLO", "3"))
+	if result := testServer.call(reader, command("CLIENT", "TRACKING", "on")); result.Str != "OK" {
+		t.Fatalf("Expected OK, got %v", result)
+	}
+
+	testServer.call(reader, command("GET", "tracking:key"))
+	testServer.call(writer, command("SET", "tracking:key", "v"))
+	expectInvalidation(t, messages, "tracking:key")
+
+	// Keys are forgotten once invalidated, until they are read again.
+	testServer.call(writer, command("SET", "tracking:key", "v"))
+	expectNoMessage(t, messages)
+}
+
+func TestTrackingBroadcast(t *testing.T) {
+	reader, messages := pipeClient()
+	defer testServer.disableTracking(reader)
+
+	testServer.call(reader, command("HELLO", "3"))
+	testServer.call(reader, command("CLIENT", "TRACKING", "on", "BCAST", "PREFIX", "user:", "NOLOOP"))
+
+	testServer.call(newTestClient(), command("SET", "user:1", "v"))
+	expectInvalidation(t, messages, "user:1")
+
+	testServer.call(newTestClient(), command("SET", "other:1", "v"))
+	testServer.call(reader, command("SET", "user:2", "v"))
+	expectNoMessage(t, messages)
+}
+
+func TestTrackingOptions(t *testing.T) {
+	client := newTestClient()
+	defer testServer.disableTracking(client)
+
+	if result := testServer.call(client, command("CLIENT", "TRACKING", "on", "PREFIX", "a")); result.Type != "error" {
+		t.Errorf("Expected PREFIX without BCAST to be refused, got %v", result)
+	}
+	if result := testServer.call(client, command("CLIENT", "TRACKING", "on", "OPTIN", "OPTOUT")); result.Type != "error" {
+		t.Errorf("Expected OPTIN with OPTOUT to be refused, got %v", result)
+	}
+	if result := testServer.call(client, command("CLIENT", "CACHING", "yes")); result.Type != "error" {
+		t.Errorf("Expected CACHING to be refused without tracking, got %v", result)
+	}
+	if result := testServer.call(client, command("CLIENT", "GETREDIR")); result.Num != -1 {
+		t.Errorf("Expected GETREDIR to return -1 without tracking, got %v", result)
+	}
+
+	testServer.call(client, command("CLIENT", "TRACKING", "on", "BCAST", "PREFIX", "abc"))
+	if result := testServer.call(client, command("CLIENT", "TRACKING", "on", "BCAST", "PREFIX", "ab")); result.Type != "error" {
+		t.Errorf("Expected overlapping prefixes to be refused, got %v", result)
+	}
+}
@@ -0,0 +1,475 @@
+package server
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// A sorted set keeps its members both in a map, for lookups by member, and
+// in a skip list ordered by score then member, for ranges. Each link of the
+// skip list records how many nodes it spans, so that ranks can be found in
+// logarithmic time as well.
+const (
+	zskiplistMaxLevel = 32
+	zskiplistP        = 0.25
+)
+
+type zskiplistNode struct {
+	member   string
+	score    float64
+	backward *zskiplistNode
+	level    []zskiplistLevel
+}
+
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	span    int
+}
+
+type zset struct {
+	dict   map[string]float64
+	header *zskiplistNode
+	tail   *zskiplistNode
+	length int
+	level  int
+}
+
+type zsetEntry struct {
+	member string
+	score  float64
+}
+
+func newZset() *zset {
+	return &zset{
+		dict:   map[string]float64{},
+		header: &zskiplistNode{level: make([]zskiplistLevel, zskiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+// before reports whether node sorts before score and member.
+func (node *zskiplistNode) before(score float64, member string) bool {
+	return node.score < score || (node.score == score && node.member < member)
+}
+
+func (z *zset) insert(score float64, member string) {
+	update := [zskiplistMaxLevel]*zskiplistNode{}
+	rank := [zskiplistMaxLevel]int{}
+
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		if i < z.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > z.level {
+		for i := z.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = z.header
+			update[i].level[i].span = z.length
+		}
+		z.level = level
+	}
+
+	x = &zskiplistNode{member: member, score: score, level: make([]zskiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < z.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != z.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		z.tail = x
+	}
+	z.length++
+}
+
+func (z *zset) deleteNode(score float64, member string) {
+	update := [zskiplistMaxLevel]*zskiplistNode{}
+
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return
+	}
+
+	for i := 0; i < z.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		z.tail = x.backward
+	}
+	for z.level > 1 && z.header.level[z.level-1].forward == nil {
+		z.level--
+	}
+	z.length--
+}
+
+// add sets the score of member and reports whether it is new.
+func (z *zset) add(member string, score float64) bool {
+	current, ok := z.dict[member]
+	if ok {
+		if current == score {
+			return false
+		}
+		z.deleteNode(current, member)
+	}
+	z.insert(score, member)
+	z.dict[member] = score
+	return !ok
+}
+
+func (z *zset) remove(member string) bool {
+	score, ok := z.dict[member]
+	if !ok {
+		return false
+	}
+	z.deleteNode(score, member)
+	delete(z.dict, member)
+	return true
+}
+
+// byRank returns the node at the 0 based rank, or nil.
+func (z *zset) byRank(rank int) *zskiplistNode {
+	traversed := 0
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank+1 {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// rangeByRank returns the members between the 0 based ranks start and stop,
+// both included.
+func (z *zset) rangeByRank(start, stop int) []zsetEntry {
+	entries := []zsetEntry{}
+	for x := z.byRank(start); x != nil && start <= stop; x = x.level[0].forward {
+		entries = append(entries, zsetEntry{member: x.member, score: x.score})
+		start++
+	}
+	return entries
+}
+
+// each calls fn on the members with a score of at least min, in order, until
+// fn returns false.
+func (z *zset) each(min float64, fn func(member string, score float64) bool) {
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.score < min {
+			x = x.level[i].forward
+		}
+	}
+
+	for x = x.level[0].forward; x != nil; x = x.level[0].forward {
+		if !fn(x.member, x.score) {
+			return
+		}
+	}
+}
+
+func parseScore(s string) (float64, bool) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+	return score, true
+}
+
+// formatScore formats a score the way Redis replies with it: integral
+// scores without a fractional part or exponent, the others as short as
+// possible.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	case score == math.Trunc(score) && math.Abs(score) < 1e17:
+		return strconv.FormatFloat(score, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// lookupZset returns the sorted set at key, creating it if create is set.
+// The caller must hold the shard lock.
+func lookupZset(sh *Shard, key string, create bool) (*zset, *Value) {
+	if t := sh.typeOf(key); t != "none" && t != "zset" {
+		return nil, &Value{Type: "error", Str: wrongTypeError}
+	}
+	z, ok := sh.zsets[key]
+	if !ok && create {
+		z = newZset()
+		sh.zsets[key] = z
+	}
+	return z, nil
+}
+
+func (srv *Server) zadd(args []Value) Value {
+	key := args[0].Bulk
+
+	nx, xx, ch := false, false, false
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "NX":
+			nx = true
+			continue
+		case "XX":
+			xx = true
+			continue
+		case "CH":
+			ch = true
+			continue
+		}
+		break
+	}
+	if nx && xx {
+		return Value{Type: "error", Str: "ERR XX and NX options at the same time are not compatible"}
+	}
+
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return Value{Type: "error", Str: "ERR syntax error"}
+	}
+	entries := []zsetEntry{}
+	for j := 0; j < len(pairs); j += 2 {
+		score, ok := parseScore(pairs[j].Bulk)
+		if !ok {
+			return Value{Type: "error", Str: "ERR value is not a valid float"}
+		}
+		entries = append(entries, zsetEntry{member: pairs[j+1].Bulk, score: score})
+	}
+
+	return srv.zaddEntries(key, entries, nx, xx, ch)
+}
+
+// zaddEntries adds entries to the sorted set at key. With nx only new
+// members are added, with xx only existing ones updated. It replies with the
+// number of members added, or with ch, added or updated.
+func (srv *Server) zaddEntries(key string, entries []zsetEntry, nx, xx, ch bool) Value {
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+
+	z, errValue := lookupZset(sh, key, !xx)
+	if errValue != nil {
+		sh.mu.Unlock()
+		return *errValue
+	}
+	if z == nil {
+		sh.mu.Unlock()
+		return Value{Type: "integer", Num: 0}
+	}
+
+	added, updated := 0, 0
+	for _, entry := range entries {
+		current, exists := z.dict[entry.member]
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		if z.add(entry.member, entry.score) {
+			added++
+		} else if current != entry.score {
+			updated++
+		}
+	}
+	if z.length == 0 {
+		delete(sh.zsets, key)
+	}
+	sh.mu.Unlock()
+
+	if added+updated > 0 {
+		srv.notifyKeyspaceEvent(notifyZset, "zadd", key)
+	}
+
+	if ch {
+		return Value{Type: "integer", Num: added + updated}
+	}
+	return Value{Type: "integer", Num: added}
+}
+
+func (srv *Server) zrem(args []Value) Value {
+	key := args[0].Bulk
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.Lock()
+
+	z, errValue := lookupZset(sh, key, false)
+	if errValue != nil {
+		sh.mu.Unlock()
+		return *errValue
+	}
+	if z == nil {
+		sh.mu.Unlock()
+		return Value{Type: "integer", Num: 0}
+	}
+
+	removed := 0
+	for _, arg := range args[1:] {
+		if z.remove(arg.Bulk) {
+			removed++
+		}
+	}
+	emptied := z.length == 0
+	if emptied {
+		delete(sh.zsets, key)
+	}
+	sh.mu.Unlock()
+
+	if removed > 0 {
+		srv.notifyKeyspaceEvent(notifyZset, "zrem", key)
+	}
+	if emptied {
+		srv.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	}
+
+	return Value{Type: "integer", Num: removed}
+}
+
+func (srv *Server) zcard(args []Value) Value {
+	key := args[0].Bulk
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	z, errValue := lookupZset(sh, key, false)
+	if errValue != nil {
+		return *errValue
+	}
+	if z == nil {
+		return Value{Type: "integer", Num: 0}
+	}
+
+	return Value{Type: "integer", Num: z.length}
+}
+
+func (srv *Server) zscore(args []Value) Value {
+	key := args[0].Bulk
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	z, errValue := lookupZset(sh, key, false)
+	if errValue != nil {
+		return *errValue
+	}
+	if z == nil {
+		return Value{Type: "null"}
+	}
+	score, ok := z.dict[args[1].Bulk]
+	if !ok {
+		return Value{Type: "null"}
+	}
+
+	return Value{Type: "bulk", Bulk: formatScore(score)}
+}
+
+func (srv *Server) zrange(args []Value) Value {
+	key := args[0].Bulk
+
+	start, err1 := strconv.Atoi(args[1].Bulk)
+	stop, err2 := strconv.Atoi(args[2].Bulk)
+	if err1 != nil || err2 != nil {
+		return Value{Type: "error", Str: "ERR value is not an integer or out of range"}
+	}
+	withScores := false
+	if len(args) == 4 {
+		if strings.ToUpper(args[3].Bulk) != "WITHSCORES" {
+			return Value{Type: "error", Str: "ERR syntax error"}
+		}
+		withScores = true
+	} else if len(args) > 4 {
+		return Value{Type: "error", Str: "ERR syntax error"}
+	}
+
+	srv.expireIfNeeded(key)
+
+	sh := srv.shardOf(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	z, errValue := lookupZset(sh, key, false)
+	if errValue != nil {
+		return *errValue
+	}
+	if z == nil {
+		return Value{Type: "array", Array: []Value{}}
+	}
+
+	if start < 0 {
+		start += z.length
+	}
+	if stop < 0 {
+		stop += z.length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= z.length {
+		stop = z.length - 1
+	}
+
+	values := []Value{}
+	if start <= stop {
+		for _, entry := range z.rangeByRank(start, stop) {
+			values = append(values, Value{Type: "bulk", Bulk: entry.member})
+			if withScores {
+				values = append(values, Value{Type: "bulk", Bulk: formatScore(entry.score)})
+			}
+		}
+	}
+
+	return Value{Type: "array", Array: values}
+}
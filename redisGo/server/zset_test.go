@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestZsetSkiplist(t *testing.T) {
+	z := newZset()
+	want := map[string]float64{}
+
+	for i := 0; i < 5000; i++ {
+		member := fmt.Sprintf("m%d", rand.Intn(1000))
+		if rand.Intn(3) == 0 {
+			_, ok := want[member]
+			if z.remove(member) != ok {
+				t.Fatalf("Expected remove of %s to return %v", member, ok)
+			}
+			delete(want, member)
+			continue
+		}
+		score := float64(rand.Intn(100))
+		_, ok := want[member]
+		if z.add(member, score) == ok {
+			t.Fatalf("Expected add of %s to return %v", member, !ok)
+		}
+		want[member] = score
+	}
+
+	sorted := []zsetEntry{}
+	for member, score := range want {
+		sorted = append(sorted, zsetEntry{member: member, score: score})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].score != sorted[j].score {
+			return sorted[i].score < sorted[j].score
+		}
+		return sorted[i].member < sorted[j].member
+	})
+
+	if z.length != len(sorted) {
+		t.Fatalf("Expected %d members, got %d", len(sorted), z.length)
+	}
+	got := z.rangeByRank(0, z.length-1)
+	for i := range sorted {
+		if got[i] != sorted[i] {
+			t.Fatalf("Expected %v at rank %d, got %v", sorted[i], i, got[i])
+		}
+	}
+
+	for _, rank := range []int{0, len(sorted) / 2, len(sorted) - 1} {
+		if node := z.byRank(rank); node == nil || node.member != sorted[rank].member {
+			t.Errorf("Expected %s at rank %d, got %v", sorted[rank].member, rank, node)
+		}
+	}
+
+	count := 0
+	z.each(50, func(member string, score float64) bool {
+		if score < 50 {
+			t.Errorf("Expected scores of at least 50, got %v", score)
+		}
+		count++
+		return true
+	})
+	wantCount := 0
+	for _, entry := range sorted {
+		if entry.score >= 50 {
+			wantCount++
+		}
+	}
+	if count != wantCount {
+		t.Errorf("Expected %d members from 50 on, got %d", wantCount, count)
+	}
+}
+
+func TestZaddOptions(t *testing.T) {
+	if result := testServer.zadd(command("zset:a", "1", "one", "2", "two").Array); result.Num != 2 {
+		t.Errorf("Expected 2 members added, got %v", result)
+	}
+	if result := testServer.zadd(command("zset:a", "NX", "5", "one", "3", "three").Array); result.Num != 1 {
+		t.Errorf("Expected NX to add three only, got %v", result)
+	}
+	if result := testServer.zadd(command("zset:a", "XX", "CH", "5", "one", "4", "four").Array); result.Num != 1 {
+		t.Errorf("Expected XX CH to update one only, got %v", result)
+	}
+	if result := testServer.zadd(command("zset:a", "NX", "XX", "1", "one").Array); result.Type != "error" {
+		t.Errorf("Expected an error for NX and XX, got %v", result)
+	}
+	if result := testServer.zadd(command("zset:a", "x", "one").Array); result.Type != "error" {
+		t.Errorf("Expected an error for a bad score, got %v", result)
+	}
+
+	result := testServer.zrange(command("zset:a", "0", "-1", "WITHSCORES").Array)
+	want := []string{"two", "2", "three", "3", "one", "5"}
+	if len(result.Array) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, result)
+	}
+	for i := range want {
+		if result.Array[i].Bulk != want[i] {
+			t.Errorf("Expected %v, got %v", want, result)
+			break
+		}
+	}
+
+	testServer.zrem(command("zset:a", "one", "two", "three").Array)
+	if testServer.keyExists("zset:a") {
+		t.Errorf("Expected the empty sorted set to be deleted")
+	}
+}